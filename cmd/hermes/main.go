@@ -4,9 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/hermes-proxy/hermes/internal/core"
+	"github.com/hermes-proxy/hermes/internal/logging"
 )
 
 var (
@@ -17,6 +20,7 @@ func main() {
 	// Command line flags
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
 	showVersion := flag.Bool("version", false, "Show version and exit")
+	check := flag.Bool("check", false, "Validate the configuration and exit (also: hermes validate)")
 	flag.Parse()
 
 	if *showVersion {
@@ -24,7 +28,11 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Setup logging
+	if *check || flag.Arg(0) == "validate" {
+		os.Exit(checkConfig(*configPath))
+	}
+
+	// Setup logging until the configured logger is installed
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	log.SetPrefix("")
 
@@ -47,13 +55,77 @@ func main() {
 		log.Fatalf("[HERMES] Failed to load config: %v", err)
 	}
 
+	logger, err := logging.New(os.Stderr, config.Logging.Level, config.Logging.Format)
+	if err != nil {
+		log.Fatalf("[HERMES] Invalid logging config: %v", err)
+	}
+	slog.SetDefault(logger)
+
 	// Create and run the server
 	server, err := core.NewServer(config)
 	if err != nil {
 		log.Fatalf("[HERMES] Failed to create server: %v", err)
 	}
+	server.SetConfigPath(*configPath)
 
 	if err := server.Run(); err != nil {
 		log.Fatalf("[HERMES] Server error: %v", err)
 	}
 }
+
+// checkConfig loads and validates the configuration the same way the server
+// does, including includes and environment expansion, without starting it.
+// It returns the process exit code.
+func checkConfig(path string) int {
+	config, err := core.LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config invalid: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("config OK: %s\n", path)
+	fmt.Printf("  Listen:       %s", config.Server.Listen)
+	if config.Server.TLS.Enabled() {
+		fmt.Print(" (TLS)")
+	}
+	fmt.Println()
+	if addrs := config.Server.AdminAddresses(); len(addrs) > 0 {
+		fmt.Printf("  Admin:        %s\n", strings.Join(addrs, ", "))
+	}
+	if config.Discovery.Provider == "consul" {
+		fmt.Printf("  Backends:     consul service %s\n", config.Discovery.Consul.Service)
+	} else {
+		fmt.Printf("  Backends:     %d\n", len(config.Backends))
+	}
+	if len(config.Regions.Pools) > 0 {
+		fmt.Printf("  Regions:      %d", len(config.Regions.Pools))
+		if config.Regions.Local != "" {
+			fmt.Printf(" (local %s)", config.Regions.Local)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("  Algorithm:    %s", config.LoadBalancing.Algorithm)
+	var overrides []string
+	for _, pool := range config.Regions.Pools {
+		if pool.Algorithm != "" {
+			overrides = append(overrides, fmt.Sprintf("region %s: %s", pool.Name, pool.Algorithm))
+		}
+	}
+	if config.BodyRouting.Enabled && config.BodyRouting.Algorithm != "" {
+		overrides = append(overrides, "body routes: "+config.BodyRouting.Algorithm)
+	}
+	if len(overrides) > 0 {
+		fmt.Printf(" (%s)", strings.Join(overrides, ", "))
+	}
+	fmt.Println()
+	fmt.Printf("  Health check: %s\n", enabled(config.HealthCheck.Enabled))
+	fmt.Printf("  Breaker:      %s\n", enabled(config.CircuitBreaker.Enabled))
+	return 0
+}
+
+func enabled(on bool) string {
+	if on {
+		return "enabled"
+	}
+	return "disabled"
+}
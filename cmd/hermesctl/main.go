@@ -1,26 +1,47 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	version   = "1.0.0"
-	adminAddr = "http://localhost:8081"
+	version    = "1.0.0"
+	adminAddr  = "http://localhost:8081"
+	adminToken = os.Getenv("HERMES_ADMIN_TOKEN")
+	adminUser  = os.Getenv("HERMES_ADMIN_USER")
+	adminPass  = os.Getenv("HERMES_ADMIN_PASSWORD")
+	jsonOutput bool
 )
 
 func main() {
 	// Global flags
 	flag.StringVar(&adminAddr, "admin", adminAddr, "Admin API address")
+	flag.StringVar(&adminToken, "token", adminToken, "Admin API bearer token")
+	flag.StringVar(&adminUser, "user", adminUser, "Admin API basic-auth username")
+	flag.StringVar(&adminPass, "password", adminPass, "Admin API basic-auth password")
+	flag.BoolVar(&jsonOutput, "json", false, "Print JSON instead of formatted output")
 	flag.Parse()
 
 	args := flag.Args()
 	if len(args) == 0 {
+		if jsonOutput {
+			fatalf("no command given")
+		}
 		printUsage()
 		os.Exit(1)
 	}
@@ -36,9 +57,32 @@ func main() {
 		doStats()
 	case "circuits":
 		doCircuits()
+	case "drain":
+		doDrain(command, args[1:])
+	case "undrain":
+		doDrain(command, args[1:])
+	case "maintenance":
+		doMaintenance(args[1:])
+	case "watch":
+		doWatch(args[1:])
+	case "config":
+		doConfig(args[1:])
+	case "export":
+		doExport(args[1:])
+	case "import":
+		doImport(args[1:])
+	case "reload":
+		doReload()
 	case "version":
+		if jsonOutput {
+			printJSON(map[string]string{"version": version})
+			return
+		}
 		fmt.Printf("hermesctl v%s\n", version)
 	default:
+		if jsonOutput {
+			fatalf("Unknown command: %s", command)
+		}
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
 		printUsage()
 		os.Exit(1)
@@ -52,30 +96,131 @@ Usage:
   hermesctl [flags] <command>
 
 Commands:
-  status    Show proxy health status
-  backends  List all backends and their status
-  stats     Show request statistics
-  circuits  Show circuit breaker states
-  version   Show version
+  status       Show proxy health status
+  backends     List all backends and their status
+  stats        Show request statistics
+  circuits     Show circuit breaker states
+  watch        Refresh status, backends and circuits every N seconds: watch [N]
+  drain        Stop sending new requests to a backend: drain <address>
+  undrain      Resume sending requests to a backend: undrain <address>
+  maintenance  Show or toggle maintenance mode: maintenance [on|off]
+  config       Show the effective running configuration (or YAML with "config yaml")
+  export       Print the backend set as JSON (or YAML with "export yaml")
+  import       Replace the backend set from a JSON/YAML file: import <file>
+  reload       Re-read the configuration file and apply it, as SIGHUP does
+  version      Show version
 
 Flags:
-  -admin string   Admin API address (default "http://localhost:8081")`)
+  -admin string     Admin API address (default "http://localhost:8081")
+  -token string     Admin API bearer token (env HERMES_ADMIN_TOKEN)
+  -user string      Admin API basic-auth username (env HERMES_ADMIN_USER)
+  -password string  Admin API basic-auth password (env HERMES_ADMIN_PASSWORD)
+  -json             Print the admin API's JSON, and errors as {"error": "..."}`)
 }
 
-func doStatus() {
-	resp, err := http.Get(adminAddr + "/health")
+// fatalf reports an error and exits: as plain text on stderr, or as a JSON
+// error object on stdout when -json is set so scripts can parse it
+func fatalf(format string, args ...interface{}) {
+	msg := strings.TrimSpace(fmt.Sprintf(format, args...))
+	if jsonOutput {
+		printJSON(map[string]string{"error": strings.TrimPrefix(msg, "Error: ")})
+	} else {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	os.Exit(1)
+}
+
+// printJSON writes v to stdout as indented JSON; raw JSON bytes are
+// re-indented as they are
+func printJSON(v interface{}) {
+	if raw, ok := v.([]byte); ok {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, raw, "", "  "); err != nil {
+			os.Stdout.Write(raw)
+			return
+		}
+		pretty.WriteByte('\n')
+		pretty.WriteTo(os.Stdout)
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// fetch GETs an admin API path and returns the body, exiting on failure
+func fetch(path string) []byte {
+	body, err := get(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatalf("Error: %v", err)
+	}
+	return body
+}
+
+// get GETs an admin API path and returns the body. /health answers 503
+// while Hermes is unhealthy; that is a status to show, not a failure.
+func get(path string) ([]byte, error) {
+	resp, err := adminRequest(http.MethodGet, path, nil, "")
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	unhealthy := path == "/health" && resp.StatusCode == http.StatusServiceUnavailable
+	if resp.StatusCode != http.StatusOK && !unhealthy {
+		return nil, fmt.Errorf("request failed: %s", bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// adminRequest sends a request to the admin API with any configured credentials
+func adminRequest(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, adminAddr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	switch {
+	case adminToken != "":
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+	case adminUser != "":
+		req.SetBasicAuth(adminUser, adminPass)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("admin API rejected credentials (401); set -token or -user/-password")
+	}
+	return resp, nil
+}
+
+func doStatus() {
+	body := fetch("/health")
+	if jsonOutput {
+		printJSON(body)
+		return
+	}
+	printStatus(body)
+}
+
+func printStatus(body []byte) {
 	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
+	json.Unmarshal(body, &result)
 
-	status := result["status"].(string)
-	healthy := int(result["healthy_backends"].(float64))
-	total := int(result["total_backends"].(float64))
+	status, _ := result["status"].(string)
+	healthy, _ := result["healthy_backends"].(float64)
+	total, _ := result["total_backends"].(float64)
 
 	statusSymbol := "✓"
 	if status == "unhealthy" {
@@ -85,27 +230,32 @@ func doStatus() {
 	}
 
 	fmt.Printf("%s Hermes Status: %s\n", statusSymbol, status)
-	fmt.Printf("  Healthy backends: %d/%d\n", healthy, total)
+	fmt.Printf("  Healthy backends: %d/%d\n", int(healthy), int(total))
+	if maintenance, _ := result["maintenance"].(bool); maintenance {
+		fmt.Println("  Maintenance mode: on")
+	}
+	if draining, _ := result["draining"].(bool); draining {
+		fmt.Println("  Draining: yes")
+	}
 }
 
 func doBackends() {
-	resp, err := http.Get(adminAddr + "/backends")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	body := fetch("/backends")
+	if jsonOutput {
+		printJSON(body)
+		return
 	}
-	defer resp.Body.Close()
+	printBackends(body)
+}
 
+func printBackends(body []byte) {
 	var backends []map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&backends)
+	json.Unmarshal(body, &backends)
 
 	fmt.Println("BACKEND              HEALTH    CONNECTIONS  WEIGHT")
 	fmt.Println("---------------------------------------------------")
 	for _, b := range backends {
-		health := "healthy"
-		if !b["healthy"].(bool) {
-			health = "unhealthy"
-		}
+		health, _ := b["status"].(string)
 		fmt.Printf("%-20s %-9s %-12.0f %v\n",
 			b["address"],
 			health,
@@ -116,32 +266,54 @@ func doBackends() {
 }
 
 func doStats() {
-	resp, err := http.Get(adminAddr + "/stats")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	body := fetch("/stats")
+	if jsonOutput {
+		printJSON(body)
+		return
 	}
-	defer resp.Body.Close()
 
 	var stats map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&stats)
+	json.Unmarshal(body, &stats)
 
 	fmt.Println("Request Statistics")
 	fmt.Println("------------------")
 	fmt.Printf("Total Requests:  %.0f\n", stats["total_requests"])
 	fmt.Printf("Active Requests: %.0f\n", stats["active_requests"])
 	fmt.Printf("Failed Requests: %.0f\n", stats["failed_requests"])
+	fmt.Printf("Rate Limited:    %.0f\n", stats["rate_limited"])
+	fmt.Printf("Maintenance:     %.0f\n", stats["maintenance_rejected"])
+	fmt.Printf("Access Denied:   %.0f\n", stats["access_denied"])
+	fmt.Printf("Responses:       2xx %.0f  3xx %.0f  4xx %.0f  5xx %.0f\n",
+		stats["responses_2xx"], stats["responses_3xx"], stats["responses_4xx"], stats["responses_5xx"])
+
+	var outcomes []string
+	for key := range stats {
+		if strings.HasPrefix(key, "outcome_") {
+			outcomes = append(outcomes, key)
+		}
+	}
+	sort.Strings(outcomes)
+
+	if len(outcomes) > 0 {
+		fmt.Println()
+		fmt.Println("Outcomes")
+		fmt.Println("--------")
+		for _, key := range outcomes {
+			fmt.Printf("%-20s %.0f\n", strings.TrimPrefix(key, "outcome_")+":", stats[key])
+		}
+	}
 }
 
 func doCircuits() {
-	resp, err := http.Get(adminAddr + "/circuits")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	body := fetch("/circuits")
+	if jsonOutput {
+		printJSON(body)
+		return
 	}
-	defer resp.Body.Close()
+	printCircuits(body)
+}
 
-	body, _ := io.ReadAll(resp.Body)
+func printCircuits(body []byte) {
 	var circuits map[string]string
 	json.Unmarshal(body, &circuits)
 
@@ -150,9 +322,282 @@ func doCircuits() {
 		return
 	}
 
+	addrs := make([]string, 0, len(circuits))
+	for addr := range circuits {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
 	fmt.Println("BACKEND              CIRCUIT STATE")
 	fmt.Println("-----------------------------------")
-	for addr, state := range circuits {
-		fmt.Printf("%-20s %s\n", addr, state)
+	for _, addr := range addrs {
+		fmt.Printf("%-20s %s\n", addr, circuits[addr])
+	}
+}
+
+// doWatch redraws status, backends and circuits every interval until
+// interrupted. With -json it prints one JSON object per poll instead.
+func doWatch(args []string) {
+	interval := 2 * time.Second
+	if len(args) > 0 {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil || seconds <= 0 {
+			fatalf("Usage: hermesctl watch [seconds]")
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	// A hung admin API should show up as a failed poll, not stall the view
+	http.DefaultClient.Timeout = interval
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pollOnce()
+		select {
+		case <-ctx.Done():
+			if !jsonOutput {
+				fmt.Println()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce fetches and prints one watch frame. An unreachable admin API, such
+// as during a Hermes restart, is reported rather than treated as fatal.
+func pollOnce() {
+	paths := []string{"/health", "/backends", "/circuits"}
+	bodies := make([][]byte, len(paths))
+	var err error
+	for i, path := range paths {
+		if bodies[i], err = get(path); err != nil {
+			break
+		}
+	}
+
+	if jsonOutput {
+		// One compact object per line, so the stream can be piped to jq
+		var frame interface{} = map[string]json.RawMessage{
+			"status":   bodies[0],
+			"backends": bodies[1],
+			"circuits": bodies[2],
+		}
+		if err != nil {
+			frame = map[string]string{"error": err.Error()}
+		}
+		json.NewEncoder(os.Stdout).Encode(frame)
+		return
+	}
+
+	// Clear the screen and move the cursor home
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("%s    %s (Ctrl-C to exit)\n\n", adminAddr, time.Now().Format("15:04:05"))
+	if err != nil {
+		fmt.Printf("Admin API unreachable, reconnecting... (%v)\n", err)
+		return
+	}
+	printStatus(bodies[0])
+	fmt.Println()
+	printBackends(bodies[1])
+	fmt.Println()
+	printCircuits(bodies[2])
+}
+
+func doConfig(args []string) {
+	yamlFormat := len(args) > 0 && args[0] == "yaml"
+	path := "/config"
+	if yamlFormat {
+		path += "?format=yaml"
+	}
+
+	body := fetch(path)
+	if yamlFormat {
+		os.Stdout.Write(body)
+		return
+	}
+	printJSON(body)
+}
+
+func doExport(args []string) {
+	path := "/backends/export"
+	if len(args) > 0 && args[0] == "yaml" {
+		path += "?format=yaml"
+	}
+
+	resp, err := adminRequest(http.MethodGet, path, nil, "")
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func doImport(args []string) {
+	if len(args) == 0 {
+		fatalf("Usage: hermesctl import <file>")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	defer file.Close()
+
+	contentType := "application/json"
+	if ext := strings.ToLower(filepath.Ext(args[0])); ext == ".yaml" || ext == ".yml" {
+		contentType = "application/yaml"
+	}
+
+	resp, err := adminRequest(http.MethodPost, "/backends/import", file, contentType)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fatalf("Import failed: %s", body)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if jsonOutput {
+		printJSON(body)
+		return
+	}
+
+	var result map[string]int
+	json.Unmarshal(body, &result)
+	fmt.Printf("Imported %d backends\n", result["backends"])
+}
+
+func doReload() {
+	resp, err := adminRequest(http.MethodPost, "/reload", nil, "")
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &result) == nil && result.Error != "" {
+			fatalf("Reload failed: %s", result.Error)
+		}
+		fatalf("Reload failed: %s", body)
+	}
+	if jsonOutput {
+		printJSON(body)
+		return
+	}
+
+	var result struct {
+		Added        []string `json:"backends_added"`
+		Removed      []string `json:"backends_removed"`
+		Reweighted   []string `json:"backends_reweighted"`
+		Updated      []string `json:"backends_updated"`
+		Certificates bool     `json:"certificates_reloaded"`
+		Restart      []string `json:"restart_required"`
+	}
+	json.Unmarshal(body, &result)
+
+	fmt.Println("Configuration reloaded")
+	for _, change := range []struct {
+		label     string
+		addresses []string
+	}{{"Added", result.Added}, {"Removed", result.Removed}, {"Reweighted", result.Reweighted}, {"Updated", result.Updated}} {
+		if len(change.addresses) > 0 {
+			fmt.Printf("  %-12s %s\n", change.label+":", strings.Join(change.addresses, ", "))
+		}
+	}
+	if result.Certificates {
+		fmt.Println("  Certificates reloaded")
+	}
+	if len(result.Restart) > 0 {
+		fmt.Printf("  Restart needed to apply: %s\n", strings.Join(result.Restart, ", "))
+	}
+}
+
+func doDrain(command string, args []string) {
+	if len(args) == 0 {
+		fatalf("Usage: hermesctl %s <address>", command)
+	}
+
+	method := http.MethodPost
+	if command == "undrain" {
+		method = http.MethodDelete
+	}
+
+	resp, err := adminRequest(method, "/backends/"+url.PathEscape(args[0])+"/drain", nil, "")
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fatalf("Request failed: %s", body)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if jsonOutput {
+		printJSON(body)
+		return
+	}
+
+	var result map[string]interface{}
+	json.Unmarshal(body, &result)
+
+	if draining, _ := result["draining"].(bool); draining {
+		connections, _ := result["connections"].(float64)
+		fmt.Printf("Draining %s (%.0f in-flight connections)\n", args[0], connections)
+	} else {
+		fmt.Printf("Resumed %s\n", args[0])
+	}
+}
+
+func doMaintenance(args []string) {
+	method := http.MethodGet
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			method = http.MethodPost
+		case "off":
+			method = http.MethodDelete
+		default:
+			fatalf("Usage: hermesctl maintenance [on|off]")
+		}
+	}
+
+	resp, err := adminRequest(method, "/maintenance", nil, "")
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Request failed: %s", body)
+	}
+	if jsonOutput {
+		printJSON(body)
+		return
+	}
+
+	var result map[string]bool
+	json.Unmarshal(body, &result)
+
+	if result["maintenance"] {
+		fmt.Println("Maintenance mode: on")
+	} else {
+		fmt.Println("Maintenance mode: off")
 	}
 }
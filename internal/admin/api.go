@@ -1,8 +1,16 @@
 package admin
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
 
 	"github.com/hermes-proxy/hermes/internal/balancer"
 	"github.com/hermes-proxy/hermes/internal/circuit"
@@ -14,6 +22,26 @@ type API struct {
 	balancer    balancer.Balancer
 	breakerPool *circuit.BreakerPool
 	handler     *proxy.Handler
+
+	// Effective configuration served by /config, already redacted; replaced
+	// on reload
+	config   interface{}
+	configMu sync.RWMutex
+
+	// Re-reads and applies the configuration for /reload; nil disables it
+	reload func() (any, error)
+
+	// Cross-origin access for browser dashboards; nil disables CORS
+	cors *corsPolicy
+
+	// Start time reported as uptime, and whether pprof handlers are served
+	started time.Time
+	pprof   bool
+
+	// Optional credentials; when neither is set the API is unauthenticated
+	token    string
+	username string
+	password string
 }
 
 // NewAPI creates a new admin API
@@ -22,27 +50,119 @@ func NewAPI(b balancer.Balancer, breakerPool *circuit.BreakerPool, handler *prox
 		balancer:    b,
 		breakerPool: breakerPool,
 		handler:     handler,
+		started:     time.Now(),
 	}
 }
 
+// SetAuth requires either a bearer token or basic-auth credentials on every
+// admin request. Empty values leave that method disabled.
+func (a *API) SetAuth(token, username, password string) {
+	a.token = token
+	a.username = username
+	a.password = password
+}
+
+// SetConfig sets the configuration returned by /config. It is marshaled as
+// is, so secrets must be redacted by the caller. It may be called while the
+// API is serving.
+func (a *API) SetConfig(config interface{}) {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	a.config = config
+}
+
+// SetReloader enables POST /reload. reload re-reads the configuration and
+// returns a summary of what changed, or an error if it was rejected.
+func (a *API) SetReloader(reload func() (any, error)) {
+	a.reload = reload
+}
+
+// AuthEnabled reports whether any authentication method is configured
+func (a *API) AuthEnabled() bool {
+	return a.token != "" || a.username != ""
+}
+
 // Handler returns an http.Handler for the admin API
 func (a *API) Handler() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/health", a.healthHandler)
+	mux.HandleFunc("/livez", a.livezHandler)
+	mux.HandleFunc("/readyz", a.readyzHandler)
 	mux.HandleFunc("/backends", a.backendsHandler)
+	mux.HandleFunc("/backends/export", a.exportHandler)
+	mux.HandleFunc("/backends/import", a.importHandler)
+	mux.HandleFunc("/backends/{address}/drain", a.drainHandler)
 	mux.HandleFunc("/stats", a.statsHandler)
 	mux.HandleFunc("/circuits", a.circuitsHandler)
+	mux.HandleFunc("/maintenance", a.maintenanceHandler)
+	mux.HandleFunc("/drain", a.proxyDrainHandler)
+	mux.HandleFunc("/undrain", a.proxyDrainHandler)
+	mux.HandleFunc("/config", a.configHandler)
+	mux.HandleFunc("/reload", a.reloadHandler)
+	mux.HandleFunc("/debug/runtime", a.runtimeHandler)
+	if a.pprof {
+		registerPprof(mux)
+	}
 
-	return mux
+	return a.corsMiddleware(a.authMiddleware(mux))
+}
+
+// authMiddleware rejects requests without valid credentials with 401. The
+// liveness and readiness probes stay open so orchestrators need no secrets.
+func (a *API) authMiddleware(next http.Handler) http.Handler {
+	if !a.AuthEnabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/livez" || r.URL.Path == "/readyz" || a.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hermes-admin"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hermes-admin"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// authorized checks the request against the configured credentials
+func (a *API) authorized(r *http.Request) bool {
+	if a.token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+			return true
+		}
+	}
+
+	if a.username != "" {
+		if user, pass, ok := r.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(a.username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(a.password)) == 1 {
+			return true
+		}
+	}
+
+	return false
 }
 
 // BackendInfo represents backend status information
 type BackendInfo struct {
 	Address     string `json:"address"`
 	Healthy     bool   `json:"healthy"`
+	Draining    bool   `json:"draining"`
+	Status      string `json:"status"`
 	Connections int64  `json:"connections"`
 	Weight      int    `json:"weight"`
+
+	// Responses received from the backend, keyed by status code and by
+	// class ("2xx", "5xx", ...)
+	Statuses      map[int]int64    `json:"statuses,omitempty"`
+	StatusClasses map[string]int64 `json:"status_classes,omitempty"`
 }
 
 // healthHandler returns the proxy health status
@@ -53,12 +173,7 @@ func (a *API) healthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	backends := a.balancer.Backends()
-	healthyCount := 0
-	for _, b := range backends {
-		if b.IsHealthy() {
-			healthyCount++
-		}
-	}
+	healthyCount := countHealthy(backends)
 
 	status := "healthy"
 	httpStatus := http.StatusOK
@@ -73,6 +188,8 @@ func (a *API) healthHandler(w http.ResponseWriter, r *http.Request) {
 		"status":           status,
 		"healthy_backends": healthyCount,
 		"total_backends":   len(backends),
+		"maintenance":      a.handler.InMaintenance(),
+		"draining":         a.handler.IsDraining(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -80,6 +197,61 @@ func (a *API) healthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// countHealthy returns how many of backends are healthy
+func countHealthy(backends []*balancer.Backend) int {
+	n := 0
+	for _, b := range backends {
+		if b.IsHealthy() {
+			n++
+		}
+	}
+	return n
+}
+
+// livezHandler reports that the process is up, whatever the backend state
+func (a *API) livezHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "alive"})
+}
+
+// readyzHandler reports whether the proxy can serve traffic: it needs a
+// healthy backend and must not be in maintenance, draining or shutting down.
+// Otherwise it returns 503 listing the reasons.
+func (a *API) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reasons := []string{}
+	if countHealthy(a.balancer.Backends()) == 0 {
+		reasons = append(reasons, "no healthy backends")
+	}
+	if a.handler.InMaintenance() {
+		reasons = append(reasons, "maintenance mode")
+	}
+	if a.handler.IsDraining() {
+		reasons = append(reasons, "draining")
+	}
+	if a.handler.Stopping() || a.handler.ShuttingDown() {
+		reasons = append(reasons, "shutting down")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":   len(reasons) == 0,
+		"reasons": reasons,
+	})
+}
+
 // backendsHandler returns information about all backends
 func (a *API) backendsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -91,11 +263,17 @@ func (a *API) backendsHandler(w http.ResponseWriter, r *http.Request) {
 	infos := make([]BackendInfo, len(backends))
 
 	for i, b := range backends {
+		statuses := b.StatusCounts()
 		infos[i] = BackendInfo{
 			Address:     b.Address,
 			Healthy:     b.IsHealthy(),
+			Draining:    b.IsDraining(),
+			Status:      backendStatus(b),
 			Connections: b.GetConnections(),
-			Weight:      b.Weight,
+			Weight:      b.GetWeight(),
+			Statuses:    statuses,
+
+			StatusClasses: statusClasses(statuses),
 		}
 	}
 
@@ -103,6 +281,127 @@ func (a *API) backendsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(infos)
 }
 
+// statusClasses groups per-code response counts by class, e.g. "5xx"
+func statusClasses(statuses map[int]int64) map[string]int64 {
+	if len(statuses) == 0 {
+		return nil
+	}
+	classes := make(map[string]int64)
+	for code, n := range statuses {
+		classes[fmt.Sprintf("%dxx", code/100)] += n
+	}
+	return classes
+}
+
+// backendStatus summarizes a backend as "draining", "ejected", "healthy" or
+// "unhealthy"
+func backendStatus(b *balancer.Backend) string {
+	switch {
+	case b.IsDraining():
+		return "draining"
+	case b.IsEjected():
+		return "ejected"
+	case b.IsHealthy() && b.IsBackingOff():
+		return "backing-off"
+	case b.IsHealthy():
+		return "healthy"
+	default:
+		return "unhealthy"
+	}
+}
+
+// drainHandler starts (POST) or cancels (DELETE) draining a backend
+func (a *API) drainHandler(w http.ResponseWriter, r *http.Request) {
+	var draining bool
+	switch r.Method {
+	case http.MethodPost:
+		draining = true
+	case http.MethodDelete:
+		draining = false
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	address := r.PathValue("address")
+	for _, b := range a.balancer.Backends() {
+		if b.Address != address {
+			continue
+		}
+
+		b.SetDraining(draining)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"address":     b.Address,
+			"draining":    draining,
+			"connections": b.GetConnections(),
+		})
+		return
+	}
+
+	http.Error(w, "Backend not found", http.StatusNotFound)
+}
+
+// maintenanceHandler reports (GET), enables (POST) or disables (DELETE)
+// maintenance mode
+func (a *API) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		a.handler.SetMaintenance(true)
+	case http.MethodDelete:
+		a.handler.SetMaintenance(false)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"maintenance": a.handler.InMaintenance()})
+}
+
+// proxyDrainHandler starts (POST /drain) or stops (POST /undrain) draining
+// the whole proxy; GET /drain reports the current state
+func (a *API) proxyDrainHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/drain":
+	case r.Method == http.MethodPost:
+		a.handler.SetDraining(r.URL.Path == "/drain")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"draining":        a.handler.IsDraining(),
+		"active_requests": a.handler.GetStats()["active_requests"],
+	})
+}
+
+// reloadHandler re-reads the configuration file and applies it, answering
+// with a summary of the changes or, for a rejected configuration, a 400
+func (a *API) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.reload == nil {
+		http.Error(w, "Reload not available", http.StatusNotFound)
+		return
+	}
+
+	summary, err := a.reload()
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(summary)
+}
+
 // statsHandler returns request statistics
 func (a *API) statsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -133,3 +432,169 @@ func (a *API) circuitsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// configHandler returns the effective configuration as JSON, or YAML with
+// ?format=yaml. Keys and durations follow the config file format.
+func (a *API) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a.configMu.RLock()
+	config := a.config
+	a.configMu.RUnlock()
+	if config == nil {
+		http.Error(w, "Configuration not available", http.StatusNotFound)
+		return
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+		return
+	}
+
+	// Round-trip through YAML so JSON uses the same keys as the config file
+	var generic map[string]interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(generic)
+}
+
+// maxImportBytes bounds the size of an imported backend snapshot
+const maxImportBytes = 1 << 20
+
+// BackendSnapshot is the portable form of a backend used for export/import.
+// Its YAML shape matches the backends section of the config file.
+type BackendSnapshot struct {
+	Address  string `json:"address" yaml:"address"`
+	Weight   int    `json:"weight" yaml:"weight"`
+	Scheme   string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+
+	HostHeader string `json:"host_header,omitempty" yaml:"host_header,omitempty"`
+}
+
+// exportHandler returns the current backend set as JSON, or YAML with ?format=yaml
+func (a *API) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backends := a.balancer.Backends()
+	snapshot := make([]BackendSnapshot, len(backends))
+	for i, b := range backends {
+		snapshot[i] = BackendSnapshot{
+			Address:  b.Address,
+			Weight:   b.GetWeight(),
+			Scheme:   b.Scheme,
+			Protocol: b.Protocol,
+
+			HostHeader: b.HostHeader,
+		}
+	}
+
+	if r.URL.Query().Get("format") == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+		yaml.NewEncoder(w).Encode(snapshot)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// importHandler replaces the backend set with the posted snapshot. Backends
+// that remain keep their health and connection state; removed backends stop
+// receiving new requests while their in-flight requests complete.
+func (a *API) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	var snapshot []BackendSnapshot
+	if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		err = yaml.Unmarshal(data, &snapshot)
+	} else {
+		err = json.Unmarshal(data, &snapshot)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid snapshot: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateSnapshot(snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	desired := make([]*balancer.Backend, len(snapshot))
+	for i, s := range snapshot {
+		desired[i] = balancer.NewBackend(s.Address, s.Weight)
+		if s.Scheme != "" {
+			desired[i].Scheme = s.Scheme
+		}
+		if s.Protocol != "" {
+			desired[i].Protocol = s.Protocol
+		}
+		desired[i].HostHeader = s.HostHeader
+	}
+	backends := balancer.Reconcile(a.balancer.Backends(), desired)
+	a.balancer.SetBackends(backends)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"backends": len(backends)})
+}
+
+// validateSnapshot checks an imported backend set before it is applied
+func validateSnapshot(snapshot []BackendSnapshot) error {
+	if len(snapshot) == 0 {
+		return fmt.Errorf("at least one backend is required")
+	}
+
+	seen := make(map[string]bool)
+	for i, s := range snapshot {
+		if s.Address == "" {
+			return fmt.Errorf("backend[%d].address is required", i)
+		}
+		if err := balancer.ValidateAddress(s.Address); err != nil {
+			return fmt.Errorf("backend[%d]: %w", i, err)
+		}
+		if s.Weight < 0 {
+			return fmt.Errorf("backend[%d].weight must be non-negative", i)
+		}
+		switch s.Scheme {
+		case "", "http", "https":
+		default:
+			return fmt.Errorf("backend[%d].scheme is invalid: %s", i, s.Scheme)
+		}
+		switch s.Protocol {
+		case "", balancer.ProtocolAuto, balancer.ProtocolHTTP1, balancer.ProtocolH2C:
+		default:
+			return fmt.Errorf("backend[%d].protocol is invalid: %s", i, s.Protocol)
+		}
+		if seen[s.Address] {
+			return fmt.Errorf("duplicate backend address: %s", s.Address)
+		}
+		seen[s.Address] = true
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/circuit"
+	"github.com/hermes-proxy/hermes/internal/health"
+	"github.com/hermes-proxy/hermes/internal/proxy"
+)
+
+func newTestAPI(addrs ...string) (*API, balancer.Balancer) {
+	backends := make([]*balancer.Backend, len(addrs))
+	for i, addr := range addrs {
+		backends[i] = balancer.NewBackend(addr, 1)
+	}
+	lb := balancer.NewRoundRobin(backends)
+	breakerPool := circuit.NewBreakerPool(5, 3, 30)
+	passiveMonitor := health.NewPassiveMonitor(lb, 3)
+	handler := proxy.NewHandler(lb, breakerPool, passiveMonitor, 1024)
+	return NewAPI(lb, breakerPool, handler), lb
+}
+
+func TestAPI_ExportImportRoundTrip(t *testing.T) {
+	source, sourceLB := newTestAPI("server1:8080", "server2:8080")
+
+	// Modify the running set before exporting
+	sourceLB.Backends()[1].SetWeight(5)
+	sourceLB.SetBackends(append(sourceLB.Backends(), balancer.NewBackend("server3:8080", 2)))
+
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/backends/export", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Export failed with status %d", rec.Code)
+	}
+	exported := rec.Body.String()
+
+	target, targetLB := newTestAPI("other:8080")
+
+	req := httptest.NewRequest("POST", "/backends/import", strings.NewReader(exported))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	target.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Import failed with status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var got []BackendSnapshot
+	for _, b := range targetLB.Backends() {
+		got = append(got, BackendSnapshot{
+			Address:  b.Address,
+			Weight:   b.GetWeight(),
+			Scheme:   b.Scheme,
+			Protocol: b.Protocol,
+		})
+	}
+
+	var want []BackendSnapshot
+	json.Unmarshal([]byte(exported), &want)
+
+	if len(got) != 3 || len(got) != len(want) {
+		t.Fatalf("Expected 3 backends, got %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Backend %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestAPI_ImportYAML(t *testing.T) {
+	api, lb := newTestAPI("server1:8080")
+
+	body := "- address: server2:8080\n  weight: 3\n"
+	req := httptest.NewRequest("POST", "/backends/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/yaml")
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Import failed with status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	backends := lb.Backends()
+	if len(backends) != 1 || backends[0].Address != "server2:8080" || backends[0].GetWeight() != 3 {
+		t.Errorf("Unexpected backend set after import: %+v", backends)
+	}
+}
+
+func TestAPI_ImportRejectsInvalid(t *testing.T) {
+	api, lb := newTestAPI("server1:8080")
+
+	invalid := []string{
+		`[]`,
+		`[{"address": ""}]`,
+		`[{"address": "a:1"}, {"address": "a:1"}]`,
+		`not json`,
+	}
+
+	for _, body := range invalid {
+		req := httptest.NewRequest("POST", "/backends/import", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Body %q: expected 400, got %d", body, rec.Code)
+		}
+	}
+
+	if backends := lb.Backends(); len(backends) != 1 || backends[0].Address != "server1:8080" {
+		t.Error("Invalid import should leave the backend set unchanged")
+	}
+}
+
+func TestAPI_DrainBackend(t *testing.T) {
+	api, lb := newTestAPI("server1:8080", "server2:8080")
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/backends/server1:8080/drain", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Drain failed with status %d", rec.Code)
+	}
+
+	if !lb.Backends()[0].IsDraining() {
+		t.Error("Backend should be draining")
+	}
+
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/backends", nil))
+
+	var infos []BackendInfo
+	json.NewDecoder(rec.Body).Decode(&infos)
+	if infos[0].Status != "draining" || infos[1].Status != "healthy" {
+		t.Errorf("Unexpected statuses: %s, %s", infos[0].Status, infos[1].Status)
+	}
+
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/backends/server1:8080/drain", nil))
+	if lb.Backends()[0].IsDraining() {
+		t.Error("Backend should no longer be draining")
+	}
+
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/backends/unknown:1/drain", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown backend, got %d", rec.Code)
+	}
+}
+
+func TestAPI_AuthRequired(t *testing.T) {
+	api, _ := newTestAPI("server1:8080")
+	api.SetAuth("secret-token", "admin", "hunter2")
+	handler := api.Handler()
+
+	tests := []struct {
+		name      string
+		configure func(r *http.Request)
+		want      int
+	}{
+		{"no credentials", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"valid token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-token") }, http.StatusOK},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized},
+		{"valid basic auth", func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") }, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/backends", nil)
+		tt.configure(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestAPI_NoAuthByDefault(t *testing.T) {
+	api, _ := newTestAPI("server1:8080")
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/backends", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 without auth configured, got %d", rec.Code)
+	}
+}
+
+func TestAPI_CORS(t *testing.T) {
+	api, _ := newTestAPI("server1:8080")
+	api.SetAuth("secret-token", "", "")
+	api.SetCORS([]string{"https://dash.example.com"}, []string{"GET", "POST"}, []string{"Authorization"})
+	handler := api.Handler()
+
+	// Preflights carry no credentials and must not hit authentication
+	req := httptest.NewRequest("OPTIONS", "/backends", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 for preflight, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+		t.Errorf("Unexpected Allow-Origin %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("Unexpected Allow-Methods %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Authorization" {
+		t.Errorf("Unexpected Allow-Headers %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/backends", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" {
+		t.Errorf("Expected CORS headers on cross-origin GET, got %d %v", rec.Code, rec.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/backends", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Disallowed origin must not receive CORS headers")
+	}
+}
+
+func TestAPI_MaintenanceToggle(t *testing.T) {
+	api, _ := newTestAPI("server1:8080")
+	h := api.Handler()
+
+	health := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+		var result map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return result
+	}
+
+	if health()["maintenance"] != false {
+		t.Fatal("Expected maintenance off by default")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/maintenance", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"maintenance":true`) {
+		t.Fatalf("Enable failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if !api.handler.InMaintenance() || health()["maintenance"] != true {
+		t.Error("Expected maintenance on after POST")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("DELETE", "/maintenance", nil))
+	if rec.Code != http.StatusOK || api.handler.InMaintenance() {
+		t.Errorf("Expected maintenance off after DELETE, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/maintenance", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for PUT, got %d", rec.Code)
+	}
+}
+
+func TestAPI_LivenessAndReadiness(t *testing.T) {
+	api, lb := newTestAPI("server1:8080")
+	api.SetAuth("secret", "", "")
+	h := api.Handler()
+
+	probe := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var result map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec.Code, result
+	}
+
+	if code, _ := probe("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected ready with a healthy backend, got %d", code)
+	}
+
+	lb.Backends()[0].SetHealthy(false)
+	api.handler.SetMaintenance(true)
+
+	code, result := probe("/readyz")
+	if code != http.StatusServiceUnavailable || result["ready"] != false {
+		t.Errorf("Expected not ready, got %d %v", code, result)
+	}
+	if reasons, _ := result["reasons"].([]interface{}); len(reasons) != 2 {
+		t.Errorf("Expected no-backend and maintenance reasons, got %v", result["reasons"])
+	}
+
+	if code, _ := probe("/livez"); code != http.StatusOK {
+		t.Errorf("Expected live regardless of backends, got %d", code)
+	}
+	if code, _ := probe("/health"); code != http.StatusUnauthorized {
+		t.Errorf("Expected /health to still require auth, got %d", code)
+	}
+}
+
+func TestAPI_ProxyDrain(t *testing.T) {
+	api, _ := newTestAPI("server1:8080")
+	h := api.Handler()
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := send("POST", "/drain"); rec.Code != http.StatusOK || !api.handler.IsDraining() {
+		t.Fatalf("Expected draining after POST /drain, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("GET", "/readyz"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "draining") {
+		t.Errorf("Expected not ready while draining, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("GET", "/livez"); rec.Code != http.StatusOK {
+		t.Errorf("Expected live while draining, got %d", rec.Code)
+	}
+
+	if rec := send("POST", "/undrain"); rec.Code != http.StatusOK || api.handler.IsDraining() {
+		t.Errorf("Expected drain cancelled after POST /undrain, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("GET", "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("Expected ready after undrain, got %d", rec.Code)
+	}
+	if rec := send("GET", "/undrain"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET /undrain, got %d", rec.Code)
+	}
+}
+
+func TestAPI_RuntimeStatsAndOptInPprof(t *testing.T) {
+	api, _ := newTestAPI("server1:8080")
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/runtime", nil))
+	var info RuntimeInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode runtime stats: %v", err)
+	}
+	if rec.Code != http.StatusOK || info.Goroutines == 0 || info.HeapAlloc == 0 || info.GoVersion == "" {
+		t.Errorf("Expected runtime stats, got %d %+v", rec.Code, info)
+	}
+
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected pprof disabled by default, got %d", rec.Code)
+	}
+
+	api.SetPprof(true)
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine profile") {
+		t.Errorf("Expected a goroutine profile once enabled, got %d", rec.Code)
+	}
+}
+
+func TestAPI_Reload(t *testing.T) {
+	api, _ := newTestAPI("server1:8080")
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/reload", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a reloader, got %d", rec.Code)
+	}
+
+	fail := true
+	api.SetReloader(func() (any, error) {
+		if fail {
+			return nil, fmt.Errorf("invalid configuration: at least one backend is required")
+		}
+		return map[string][]string{"backends_added": {"server2:8080"}}, nil
+	})
+
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/reload", nil))
+	var failure map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &failure)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(failure["error"], "at least one backend") {
+		t.Errorf("Expected 400 with the validation error, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	fail = false
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/reload", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "server2:8080") {
+		t.Errorf("Expected 200 with the summary, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+}
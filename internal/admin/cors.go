@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsPolicy describes which browser origins may call the admin API
+type corsPolicy struct {
+	origins []string // "*" allows any origin
+	methods string
+	headers string
+}
+
+// SetCORS allows browser pages served from origins to call the admin API.
+// An empty origins list disables CORS handling.
+func (a *API) SetCORS(origins, methods, headers []string) {
+	if len(origins) == 0 {
+		a.cors = nil
+		return
+	}
+	a.cors = &corsPolicy{
+		origins: origins,
+		methods: strings.Join(methods, ", "),
+		headers: strings.Join(headers, ", "),
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if the origin is not allowed
+func (c *corsPolicy) allowOrigin(origin string) string {
+	if slices.Contains(c.origins, "*") {
+		return "*"
+	}
+	for _, o := range c.origins {
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers preflight
+// requests itself, ahead of authentication, since browsers send preflights
+// without credentials
+func (a *API) corsMiddleware(next http.Handler) http.Handler {
+	if a.cors == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := a.cors.allowOrigin(origin)
+		if allowed == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", a.cors.methods)
+			w.Header().Set("Access-Control-Allow-Headers", a.cors.headers)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// RuntimeInfo reports Go runtime and process statistics
+type RuntimeInfo struct {
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	GoVersion     string  `json:"go_version"`
+	Goroutines    int     `json:"goroutines"`
+	CPUs          int     `json:"cpus"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+
+	// Memory in bytes, from runtime.MemStats
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	StackInuse   uint64 `json:"stack_inuse"`
+	NextGCTarget uint64 `json:"next_gc"`
+
+	// Garbage collection; pauses in nanoseconds
+	NumGC         uint32  `json:"num_gc"`
+	PauseTotalNs  uint64  `json:"gc_pause_total_ns"`
+	LastPauseNs   uint64  `json:"gc_last_pause_ns"`
+	LastGC        string  `json:"last_gc,omitempty"`
+	GCCPUFraction float64 `json:"gc_cpu_fraction"`
+}
+
+// SetPprof exposes the net/http/pprof profiling handlers under
+// /debug/pprof/. Profiles reveal internals and cost CPU, so this is opt-in.
+func (a *API) SetPprof(enabled bool) {
+	a.pprof = enabled
+}
+
+// registerPprof adds the profiling handlers to mux
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// runtimeHandler returns Go runtime statistics for diagnosing memory growth
+// and goroutine leaks
+func (a *API) runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	uptime := time.Since(a.started)
+
+	info := RuntimeInfo{
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: uptime.Seconds(),
+		GoVersion:     runtime.Version(),
+		Goroutines:    runtime.NumGoroutine(),
+		CPUs:          runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapObjects:   mem.HeapObjects,
+		Sys:           mem.Sys,
+		TotalAlloc:    mem.TotalAlloc,
+		StackInuse:    mem.StackInuse,
+		NextGCTarget:  mem.NextGC,
+		NumGC:         mem.NumGC,
+		PauseTotalNs:  mem.PauseTotalNs,
+		GCCPUFraction: mem.GCCPUFraction,
+	}
+	if mem.NumGC > 0 {
+		info.LastPauseNs = mem.PauseNs[(mem.NumGC+255)%256]
+		info.LastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
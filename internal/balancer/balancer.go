@@ -1,15 +1,65 @@
 package balancer
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Backend represents a backend server in the pool
+// slowStartFloor is the fraction of weight a backend receives at the very
+// start of its slow-start window
+const slowStartFloor = 0.05
+
+// latencyDecay is the weight given to each new latency sample in the EWMA
+const latencyDecay = 0.3
+
+// failureLatency is the least latency recorded for a failed request
+const failureLatency = time.Second
+
+// Upstream protocols a backend can be configured to speak
+const (
+	// ProtocolAuto negotiates HTTP/2 via ALPN over TLS, HTTP/1.1 otherwise
+	ProtocolAuto = "auto"
+	// ProtocolHTTP1 always uses HTTP/1.1
+	ProtocolHTTP1 = "http1"
+	// ProtocolH2C uses cleartext HTTP/2 with prior knowledge
+	ProtocolH2C = "h2c"
+)
+
+// Backend represents a backend server in the pool. State read on every
+// request (health, draining, connections) is atomic; the rest is guarded by mu.
 type Backend struct {
-	Address     string
-	Weight      int
-	Healthy     bool
-	Connections int64
+	Address  string
+	Scheme   string // "http" or "https"
+	Protocol string // one of the Protocol* constants
+	Weight   int
+
+	// Host header sent to this backend, overriding the proxy-wide choice
+	HostHeader string
+
+	healthy     atomic.Bool
+	draining    atomic.Bool
+	ejected     atomic.Bool // taken out of rotation by outlier detection
+	connections atomic.Int64
+	openConns   atomic.Int64
+	inflight    atomic.Int64
+	maxInflight atomic.Int64  // 0 = unlimited
+	reported    atomic.Uint64 // float64 bits of the last backend-reported load
+	backoff     atomic.Int64  // unix nanos until which the backend asked to be spared
+	queueWait   atomic.Int64  // time.Duration a request may wait for a free slot
+	waiters     atomic.Int64  // requests blocked in WaitInflight
+	gzipBodies  atomic.Bool   // backend advertised gzip request bodies
+
+	slotFreed   chan struct{} // closed to wake WaitInflight when a slot frees up
+	recoveredAt time.Time
+	latency     float64 // EWMA of response latency in nanoseconds; 0 until measured
+	statuses    map[int]int64
 	mu          sync.RWMutex
 }
 
@@ -18,48 +68,356 @@ func NewBackend(address string, weight int) *Backend {
 	if weight <= 0 {
 		weight = 1
 	}
-	return &Backend{
-		Address: address,
-		Weight:  weight,
-		Healthy: true,
+	b := &Backend{
+		Address:  address,
+		Scheme:   "http",
+		Protocol: ProtocolAuto,
+		Weight:   weight,
 	}
+	b.healthy.Store(true)
+	return b
+}
+
+// ValidateAddress checks that a backend address is host:port, with IPv6
+// hosts in brackets. Schemes belong in the backend's scheme setting.
+func ValidateAddress(address string) error {
+	if strings.Contains(address, "://") {
+		return fmt.Errorf("address %q must not include a scheme; use host:port", address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("address %q must be host:port: %w", address, err)
+	}
+	if host == "" {
+		return fmt.Errorf("address %q is missing a host", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("address %q has an invalid port", address)
+	}
+	return nil
+}
+
+// URL returns the absolute URL for a request URI on this backend
+func (b *Backend) URL(requestURI string) string {
+	return b.Scheme + "://" + b.Address + requestURI
+}
+
+// SameEndpoint reports whether d reaches its address the way b does: with
+// the same scheme, protocol and Host header
+func (b *Backend) SameEndpoint(d *Backend) bool {
+	return b.Scheme == d.Scheme && b.Protocol == d.Protocol && b.HostHeader == d.HostHeader
 }
 
 // IsHealthy returns the health status of the backend
 func (b *Backend) IsHealthy() bool {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	return b.Healthy
+	return b.healthy.Load()
 }
 
 // SetHealthy updates the health status of the backend
 func (b *Backend) SetHealthy(healthy bool) {
+	if !healthy {
+		b.healthy.Store(false)
+		return
+	}
+
+	// Record the recovery time before the backend becomes selectable so slow
+	// start never sees a healthy backend with a stale timestamp
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.Healthy = healthy
+	if !b.healthy.Load() {
+		b.recoveredAt = time.Now()
+		b.healthy.Store(true)
+	}
 }
 
-// GetConnections returns the current connection count
-func (b *Backend) GetConnections() int64 {
+// RecoveredAt returns when the backend last went from unhealthy to healthy
+// or was returned from an ejection, or the zero time if it never has
+func (b *Backend) RecoveredAt() time.Time {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.recoveredAt
+}
+
+// IsDraining reports whether the backend is refusing new requests
+func (b *Backend) IsDraining() bool {
+	return b.draining.Load()
+}
+
+// SetDraining stops (or resumes) sending new requests to the backend while
+// letting in-flight requests complete
+func (b *Backend) SetDraining(draining bool) {
+	b.draining.Store(draining)
+}
+
+// IsEjected reports whether outlier detection has taken the backend out of
+// rotation. Ejection is kept apart from health so that health checks passing
+// during an ejection do not end it early.
+func (b *Backend) IsEjected() bool {
+	return b.ejected.Load()
+}
+
+// SetEjected takes the backend out of rotation (or returns it) for outlier
+// detection. Returning it counts as a recovery for slow start.
+func (b *Backend) SetEjected(ejected bool) {
+	if ejected {
+		b.ejected.Store(true)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ejected.Load() {
+		b.recoveredAt = time.Now()
+		b.ejected.Store(false)
+	}
+}
+
+// BackOff deprioritizes the backend until the given time, as requested by
+// its Retry-After header. It stays healthy; balancers only route to it while
+// every other backend is unavailable or backing off too. An earlier deadline
+// than the current one is ignored.
+func (b *Backend) BackOff(until time.Time) {
+	deadline := until.UnixNano()
+	for {
+		current := b.backoff.Load()
+		if deadline <= current || b.backoff.CompareAndSwap(current, deadline) {
+			return
+		}
+	}
+}
+
+// IsBackingOff reports whether a Retry-After backoff is still in effect
+func (b *Backend) IsBackingOff() bool {
+	return time.Now().UnixNano() < b.backoff.Load()
+}
+
+// BackoffUntil returns when the current backoff expires, or the zero time
+// if the backend is not backing off
+func (b *Backend) BackoffUntil() time.Time {
+	if !b.IsBackingOff() {
+		return time.Time{}
+	}
+	return time.Unix(0, b.backoff.Load())
+}
+
+// IsAvailable reports whether the backend can accept new requests
+func (b *Backend) IsAvailable() bool {
+	return b.healthy.Load() && !b.draining.Load() && !b.ejected.Load()
+}
+
+// GetWeight returns the backend weight
+func (b *Backend) GetWeight() int {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	return b.Connections
+	return b.Weight
+}
+
+// SetWeight updates the backend weight
+func (b *Backend) SetWeight(weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Weight = weight
+}
+
+// GetConnections returns the current connection count
+func (b *Backend) GetConnections() int64 {
+	return b.connections.Load()
 }
 
 // IncrementConnections atomically increments the connection count
 func (b *Backend) IncrementConnections() {
+	b.connections.Add(1)
+}
+
+// DecrementConnections atomically decrements the connection count, never
+// going below zero
+func (b *Backend) DecrementConnections() {
+	for {
+		n := b.connections.Load()
+		if n <= 0 || b.connections.CompareAndSwap(n, n-1) {
+			return
+		}
+	}
+}
+
+// SetMaxInflight caps the requests in flight to the backend at once,
+// regardless of how many connections carry them; 0 removes the cap
+func (b *Backend) SetMaxInflight(n int) {
+	b.maxInflight.Store(int64(n))
+}
+
+// MaxInflight returns the cap on requests in flight at once, 0 = unlimited
+func (b *Backend) MaxInflight() int {
+	return int(b.maxInflight.Load())
+}
+
+// AcquireInflight reserves a slot for a request, reporting false when the
+// backend is already at its in-flight limit
+func (b *Backend) AcquireInflight() bool {
+	limit := b.maxInflight.Load()
+	for {
+		n := b.inflight.Load()
+		if limit > 0 && n >= limit {
+			return false
+		}
+		if b.inflight.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// ReleaseInflight frees a slot reserved by AcquireInflight, waking any
+// requests queued in WaitInflight
+func (b *Backend) ReleaseInflight() {
+	b.inflight.Add(-1)
+	if b.waiters.Load() > 0 {
+		b.mu.Lock()
+		if b.slotFreed != nil {
+			close(b.slotFreed)
+			b.slotFreed = nil
+		}
+		b.mu.Unlock()
+	}
+}
+
+// SetQueueTimeout lets requests wait up to d for an in-flight slot when the
+// backend is at its limit; 0 fails at once
+func (b *Backend) SetQueueTimeout(d time.Duration) {
+	b.queueWait.Store(int64(d))
+}
+
+// QueueTimeout returns how long a request may wait for an in-flight slot
+func (b *Backend) QueueTimeout() time.Duration {
+	return time.Duration(b.queueWait.Load())
+}
+
+// WaitInflight reserves a slot like AcquireInflight, but when the backend is
+// at its limit waits up to the queue timeout for one to be released. It
+// gives up early if ctx ends.
+func (b *Backend) WaitInflight(ctx context.Context) bool {
+	if b.AcquireInflight() {
+		return true
+	}
+	timeout := b.QueueTimeout()
+	if timeout <= 0 {
+		return false
+	}
+
+	b.waiters.Add(1)
+	defer b.waiters.Add(-1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		// Take the channel before retrying, so a release in between still
+		// closes the channel waited on
+		b.mu.Lock()
+		if b.slotFreed == nil {
+			b.slotFreed = make(chan struct{})
+		}
+		freed := b.slotFreed
+		b.mu.Unlock()
+
+		if b.AcquireInflight() {
+			return true
+		}
+		select {
+		case <-freed:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// ConnOpened counts a new upstream TCP connection to the backend
+func (b *Backend) ConnOpened() {
+	b.openConns.Add(1)
+}
+
+// ConnClosed counts an upstream TCP connection to the backend being closed
+func (b *Backend) ConnClosed() {
+	b.openConns.Add(-1)
+}
+
+// OpenConnections returns the number of open upstream TCP connections,
+// including idle pooled ones
+func (b *Backend) OpenConnections() int64 {
+	return b.openConns.Load()
+}
+
+// SetReportedLoad stores a load value reported by the backend itself
+func (b *Backend) SetReportedLoad(load float64) {
+	b.reported.Store(math.Float64bits(load))
+}
+
+// ReportedLoad returns the load the backend last reported, or zero
+func (b *Backend) ReportedLoad() float64 {
+	return math.Float64frombits(b.reported.Load())
+}
+
+// SetAcceptsGzip records whether the backend accepts gzip-encoded request
+// bodies, as advertised by the Accept-Encoding header of its responses
+func (b *Backend) SetAcceptsGzip(accepts bool) {
+	b.gzipBodies.Store(accepts)
+}
+
+// AcceptsGzip reports whether the backend last advertised accepting
+// gzip-encoded request bodies
+func (b *Backend) AcceptsGzip() bool {
+	return b.gzipBodies.Load()
+}
+
+// RecordLatency folds an observed response latency into the backend's
+// exponentially-weighted moving average
+func (b *Backend) RecordLatency(d time.Duration) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.Connections++
+	if b.latency == 0 {
+		b.latency = float64(d)
+		return
+	}
+	b.latency = latencyDecay*float64(d) + (1-latencyDecay)*b.latency
 }
 
-// DecrementConnections atomically decrements the connection count
-func (b *Backend) DecrementConnections() {
+// RecordFailedLatency folds a failed request into the latency average as
+// taking at least failureLatency, so that a backend failing fast, which
+// would otherwise look fastest or stay unmeasured, stops winning selection
+func (b *Backend) RecordFailedLatency(d time.Duration) {
+	b.RecordLatency(max(d, failureLatency))
+}
+
+// Latency returns the backend's average response latency, or zero if no
+// request has been measured yet
+func (b *Backend) Latency() time.Duration {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return time.Duration(b.latency)
+}
+
+// RecordStatus counts a response status code returned by the backend
+func (b *Backend) RecordStatus(code int) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if b.Connections > 0 {
-		b.Connections--
+	if b.statuses == nil {
+		b.statuses = make(map[int]int64)
 	}
+	b.statuses[code]++
+}
+
+// StatusCounts returns a copy of the per-status response counters
+func (b *Backend) StatusCounts() map[int]int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	counts := make(map[int]int64, len(b.statuses))
+	for code, n := range b.statuses {
+		counts[code] = n
+	}
+	return counts
 }
 
 // Balancer interface defines the load balancing contract
@@ -68,16 +426,32 @@ type Balancer interface {
 	Next() *Backend
 	// Backends returns all backends in the pool
 	Backends() []*Backend
+	// SetBackends replaces the backends in the pool
+	SetBackends(backends []*Backend)
+	// SetSlowStart sets the ramp-up window for recovered backends
+	SetSlowStart(d time.Duration)
+	// SetLoadMetric sets the load minimized by connection-aware algorithms
+	SetLoadMetric(m LoadMetric)
 	// MarkHealthy marks a backend as healthy
 	MarkHealthy(address string)
 	// MarkUnhealthy marks a backend as unhealthy
 	MarkUnhealthy(address string)
 }
 
+// KeyedBalancer is implemented by balancers that pin requests with the same
+// key (e.g. client IP) to the same backend
+type KeyedBalancer interface {
+	Balancer
+	// NextForKey returns the backend for a request key
+	NextForKey(key string) *Backend
+}
+
 // BaseBalancer provides common functionality for all balancers
 type BaseBalancer struct {
-	backends []*Backend
-	mu       sync.RWMutex
+	backends   []*Backend
+	slowStart  time.Duration
+	loadMetric LoadMetric
+	mu         sync.RWMutex
 }
 
 // NewBaseBalancer creates a new base balancer with the given backends
@@ -87,11 +461,75 @@ func NewBaseBalancer(backends []*Backend) *BaseBalancer {
 	}
 }
 
-// Backends returns all backends in the pool
+// Backends returns a copy of the backend list. The *Backend values are shared
+// since Backend synchronizes its own state.
 func (b *BaseBalancer) Backends() []*Backend {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	return b.backends
+	return append([]*Backend(nil), b.backends...)
+}
+
+// SetBackends replaces the backends in the pool. The slice is copied so the
+// caller may keep modifying its own.
+func (b *BaseBalancer) SetBackends(backends []*Backend) {
+	backends = append([]*Backend(nil), backends...)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backends = backends
+}
+
+// SetSlowStart sets how long a recovered backend takes to ramp from a small
+// fraction of its weight up to full weight. Zero disables slow start.
+func (b *BaseBalancer) SetSlowStart(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.slowStart = d
+}
+
+// SetLoadMetric sets the load that connection-aware algorithms minimize.
+// Nil restores the default of in-flight requests.
+func (b *BaseBalancer) SetLoadMetric(m LoadMetric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.loadMetric = m
+}
+
+// load returns the backend's current load under the configured metric
+func (b *BaseBalancer) load(backend *Backend) float64 {
+	b.mu.RLock()
+	metric := b.loadMetric
+	b.mu.RUnlock()
+
+	if metric == nil {
+		return float64(backend.GetConnections())
+	}
+	return metric(backend)
+}
+
+// effectiveWeight returns the backend weight scaled down while it is within
+// its slow-start window
+func (b *BaseBalancer) effectiveWeight(backend *Backend) float64 {
+	weight := float64(backend.GetWeight())
+
+	b.mu.RLock()
+	slowStart := b.slowStart
+	b.mu.RUnlock()
+
+	recoveredAt := backend.RecoveredAt()
+	if slowStart <= 0 || recoveredAt.IsZero() {
+		return weight
+	}
+
+	elapsed := time.Since(recoveredAt)
+	if elapsed >= slowStart {
+		return weight
+	}
+
+	fraction := float64(elapsed) / float64(slowStart)
+	if fraction < slowStartFloor {
+		fraction = slowStartFloor
+	}
+	return weight * fraction
 }
 
 // MarkHealthy marks a backend as healthy by address
@@ -118,16 +556,64 @@ func (b *BaseBalancer) MarkUnhealthy(address string) {
 	}
 }
 
-// healthyBackends returns a list of healthy backends
+// healthyBackends returns a list of healthy backends that are not draining
+// or ejected.
+// Backends backing off after a Retry-After are left out unless every
+// available backend is backing off.
 func (b *BaseBalancer) healthyBackends() []*Backend {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	var healthy []*Backend
+	var healthy, backingOff []*Backend
 	for _, backend := range b.backends {
-		if backend.IsHealthy() {
+		switch {
+		case !backend.IsAvailable():
+		case backend.IsBackingOff():
+			backingOff = append(backingOff, backend)
+		default:
 			healthy = append(healthy, backend)
 		}
 	}
+	if len(healthy) == 0 {
+		return backingOff
+	}
 	return healthy
 }
+
+// Reconcile returns the desired backend set, reusing existing instances (and
+// their health and connection state) for addresses already present. A reused
+// instance takes the desired weight. One whose scheme, protocol or Host
+// header changed is replaced instead, since requests read those without
+// locking; the replacement keeps its health and ejection. Backends that are
+// dropped are marked draining so their in-flight requests finish.
+func Reconcile(current, desired []*Backend) []*Backend {
+	existing := make(map[string]*Backend, len(current))
+	for _, b := range current {
+		existing[b.Address] = b
+	}
+
+	result := make([]*Backend, len(desired))
+	kept := make(map[string]bool, len(desired))
+	for i, d := range desired {
+		kept[d.Address] = true
+		b, ok := existing[d.Address]
+		switch {
+		case !ok:
+			result[i] = d
+		case !b.SameEndpoint(d):
+			d.SetHealthy(b.IsHealthy())
+			d.SetEjected(b.IsEjected())
+			result[i] = d
+		default:
+			b.SetWeight(d.GetWeight())
+			result[i] = b
+		}
+	}
+
+	for _, b := range current {
+		if !kept[b.Address] {
+			b.SetDraining(true)
+		}
+	}
+	return result
+}
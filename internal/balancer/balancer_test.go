@@ -1,7 +1,11 @@
 package balancer
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestRoundRobin_Next(t *testing.T) {
@@ -124,3 +128,573 @@ func TestBackend_ConnectionTracking(t *testing.T) {
 		t.Errorf("Expected 1 connection, got %d", backend.GetConnections())
 	}
 }
+
+func TestBackend_WaitInflightWokenByRelease(t *testing.T) {
+	backend := NewBackend("test:8080", 1)
+	backend.SetMaxInflight(1)
+	backend.SetQueueTimeout(5 * time.Second)
+	if !backend.AcquireInflight() {
+		t.Fatal("Expected the first slot to be free")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- backend.WaitInflight(context.Background())
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	backend.ReleaseInflight()
+
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("Expected the waiter to get the released slot")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Releasing a slot did not wake the waiter")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the waiter woken promptly, took %v", elapsed)
+	}
+	if backend.AcquireInflight() {
+		t.Error("Expected the released slot to be held by the waiter")
+	}
+}
+
+func TestBackend_WaitInflightTimesOut(t *testing.T) {
+	backend := NewBackend("test:8080", 1)
+	backend.SetMaxInflight(1)
+	backend.AcquireInflight()
+
+	// Without a queue timeout a full backend fails at once
+	if backend.WaitInflight(context.Background()) {
+		t.Fatal("Expected no slot without a queue timeout")
+	}
+
+	backend.SetQueueTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if backend.WaitInflight(context.Background()) {
+		t.Fatal("Expected no slot while the backend stays full")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected to wait out the queue timeout, gave up after %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	backend.SetQueueTimeout(time.Minute)
+	if backend.WaitInflight(ctx) {
+		t.Error("Expected a cancelled request to stop waiting")
+	}
+}
+
+func TestWeightedLeastConnections_PrefersLessLoaded(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("server1:8080", 1),
+		NewBackend("server2:8080", 1),
+	}
+
+	backends[0].IncrementConnections()
+
+	wlc := NewWeightedLeastConnections(backends)
+
+	for i := 0; i < 4; i++ {
+		backend := wlc.Next()
+		if backend.Address != "server2:8080" {
+			t.Errorf("Request %d: expected server2 (less loaded), got %s", i, backend.Address)
+		}
+	}
+}
+
+func TestWeightedLeastConnections_HigherWeightAbsorbsMore(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("heavy:8080", 4),
+		NewBackend("light:8080", 1),
+	}
+
+	// heavy: (2+1)/4 beats light: (0+1)/1 despite having more connections
+	backends[0].IncrementConnections()
+	backends[0].IncrementConnections()
+
+	wlc := NewWeightedLeastConnections(backends)
+
+	if backend := wlc.Next(); backend.Address != "heavy:8080" {
+		t.Errorf("Expected heavy backend to absorb load, got %s", backend.Address)
+	}
+
+	// heavy: (4+1)/4 now loses to light: (0+1)/1
+	backends[0].IncrementConnections()
+	backends[0].IncrementConnections()
+
+	if backend := wlc.Next(); backend.Address != "light:8080" {
+		t.Errorf("Expected heavy backend to be deprioritized, got %s", backend.Address)
+	}
+}
+
+func TestWeightedLeastConnections_RotatesTies(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("server1:8080", 1),
+		NewBackend("server2:8080", 1),
+		NewBackend("server3:8080", 1),
+	}
+
+	wlc := NewWeightedLeastConnections(backends)
+
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		seen[wlc.Next().Address]++
+	}
+
+	for _, b := range backends {
+		if seen[b.Address] != 2 {
+			t.Errorf("Expected %s selected twice, got %d", b.Address, seen[b.Address])
+		}
+	}
+}
+
+func TestNew_UnknownAlgorithm(t *testing.T) {
+	if _, err := New("random-guess", nil); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+	if !IsValidAlgorithm("weighted-least-connections") {
+		t.Error("weighted-least-connections should be registered")
+	}
+}
+
+func TestRoundRobin_SkipsDraining(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("server1:8080", 1),
+		NewBackend("server2:8080", 1),
+	}
+
+	backends[0].IncrementConnections()
+	backends[0].SetDraining(true)
+
+	rr := NewRoundRobin(backends)
+
+	for i := 0; i < 4; i++ {
+		if backend := rr.Next(); backend.Address != "server2:8080" {
+			t.Errorf("Request %d: draining backend was selected", i)
+		}
+	}
+
+	// In-flight connections are still tracked while draining
+	if backends[0].GetConnections() != 1 {
+		t.Errorf("Expected draining backend to keep 1 connection, got %d", backends[0].GetConnections())
+	}
+	if !backends[0].IsHealthy() {
+		t.Error("Draining should not mark the backend unhealthy")
+	}
+}
+
+func TestWeightedLeastConnections_SlowStartReducesSelection(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("steady:8080", 1),
+		NewBackend("recovered:8080", 1),
+	}
+
+	// Simulate a recovery that just happened
+	backends[1].SetHealthy(false)
+	backends[1].SetHealthy(true)
+
+	wlc := NewWeightedLeastConnections(backends)
+	wlc.SetSlowStart(time.Minute)
+
+	seen := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		backend := wlc.Next()
+		backend.IncrementConnections()
+		seen[backend.Address]++
+	}
+
+	if seen["recovered:8080"] >= 10 {
+		t.Errorf("Recovered backend received %d/100 requests during slow start", seen["recovered:8080"])
+	}
+	if seen["recovered:8080"] == 0 {
+		t.Error("Recovered backend should still receive some traffic during slow start")
+	}
+}
+
+func TestBaseBalancer_SlowStartEndsAtFullWeight(t *testing.T) {
+	backend := NewBackend("server1:8080", 4)
+	base := NewBaseBalancer([]*Backend{backend})
+	base.SetSlowStart(time.Millisecond)
+
+	backend.SetHealthy(false)
+	backend.SetHealthy(true)
+	if w := base.effectiveWeight(backend); w >= 4 {
+		t.Errorf("Expected reduced weight right after recovery, got %v", w)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if w := base.effectiveWeight(backend); w != 4 {
+		t.Errorf("Expected full weight after slow start, got %v", w)
+	}
+}
+
+func TestFailover_PrefersLocalRegion(t *testing.T) {
+	local := []*Backend{
+		NewBackend("local1:8080", 1),
+		NewBackend("local2:8080", 1),
+	}
+	remote := []*Backend{
+		NewBackend("remote1:8080", 1),
+	}
+
+	f := NewFailover([]*Region{
+		{Name: "local", Balancer: NewRoundRobin(local)},
+		{Name: "remote", Balancer: NewRoundRobin(remote)},
+	})
+
+	for i := 0; i < 4; i++ {
+		if backend := f.Next(); backend.Address == "remote1:8080" {
+			t.Fatalf("Request %d went to remote region while local is healthy", i)
+		}
+	}
+
+	// Local region goes fully unhealthy
+	f.MarkUnhealthy("local1:8080")
+	f.MarkUnhealthy("local2:8080")
+
+	if backend := f.Next(); backend == nil || backend.Address != "remote1:8080" {
+		t.Fatalf("Expected failover to remote region, got %v", backend)
+	}
+
+	// Local region recovers
+	f.MarkHealthy("local2:8080")
+
+	if backend := f.Next(); backend.Address != "local2:8080" {
+		t.Errorf("Expected traffic to return to local region, got %s", backend.Address)
+	}
+
+	if len(f.Backends()) != 3 {
+		t.Errorf("Expected 3 backends across regions, got %d", len(f.Backends()))
+	}
+}
+
+func TestLeastTime_PrefersLowerLatency(t *testing.T) {
+	fast := NewBackend("fast:8080", 1)
+	slow := NewBackend("slow:8080", 1)
+	fast.RecordLatency(10 * time.Millisecond)
+	slow.RecordLatency(100 * time.Millisecond)
+
+	lt := NewLeastTime([]*Backend{fast, slow}, false)
+	for i := 0; i < 4; i++ {
+		if b := lt.Next(); b != fast {
+			t.Fatalf("Expected fast backend, got %s", b.Address)
+		}
+	}
+
+	// Peak-EWMA backs off a fast backend once it has many requests in flight
+	peak := NewLeastTime([]*Backend{fast, slow}, true)
+	for i := 0; i < 20; i++ {
+		fast.IncrementConnections()
+	}
+	if b := peak.Next(); b != slow {
+		t.Errorf("Expected slow backend while fast is saturated, got %s", b.Address)
+	}
+}
+
+func TestBackend_LatencyEWMA(t *testing.T) {
+	b := NewBackend("server1:8080", 1)
+	if b.Latency() != 0 {
+		t.Fatal("Expected zero latency before any measurement")
+	}
+
+	b.RecordLatency(100 * time.Millisecond)
+	b.RecordLatency(0)
+	if got := b.Latency(); got != 70*time.Millisecond {
+		t.Errorf("Expected 70ms after decay, got %v", got)
+	}
+}
+
+func TestPowerOfTwoChoices_AvoidsBusiestBackend(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("server1:8080", 1),
+		NewBackend("server2:8080", 1),
+		NewBackend("server3:8080", 1),
+		NewBackend("server4:8080", 1),
+	}
+	for i := 0; i < 50; i++ {
+		backends[0].IncrementConnections()
+	}
+
+	p2c := NewPowerOfTwoChoices(backends)
+	busy := 0
+	for i := 0; i < 1000; i++ {
+		if p2c.Next() == backends[0] {
+			busy++
+		}
+	}
+
+	// Every pair containing the busiest backend compares it to an idle one
+	if busy != 0 {
+		t.Errorf("Expected busiest backend to be avoided, selected %d times", busy)
+	}
+}
+
+func TestPowerOfTwoChoices_SamplesWithoutScanning(t *testing.T) {
+	backends := make([]*Backend, 20)
+	for i := range backends {
+		backends[i] = NewBackend(fmt.Sprintf("server%d:8080", i+1), 1)
+	}
+	backends[3].SetHealthy(false)
+	backends[7].SetDraining(true)
+	p2c := NewPowerOfTwoChoices(backends)
+
+	if allocs := testing.AllocsPerRun(100, func() { p2c.Next() }); allocs != 0 {
+		t.Errorf("Expected selection without allocating, got %v allocations", allocs)
+	}
+	for i := 0; i < 1000; i++ {
+		if b := p2c.Next(); b == backends[3] || b == backends[7] {
+			t.Fatalf("Selected unavailable backend %s", b.Address)
+		}
+	}
+
+	// With nearly every backend down, the fallback scan still finds the last one
+	for _, b := range backends[1:] {
+		b.SetHealthy(false)
+	}
+	for i := 0; i < 100; i++ {
+		if b := p2c.Next(); b != backends[0] {
+			t.Fatalf("Expected the only available backend, got %v", b)
+		}
+	}
+}
+
+func TestWeightedRandom_DistributionFollowsWeights(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("server1:8080", 1),
+		NewBackend("server2:8080", 2),
+		NewBackend("server3:8080", 5),
+		NewBackend("server4:8080", 4),
+	}
+	backends[3].SetHealthy(false)
+
+	wr := NewWeightedRandom(backends)
+	const draws = 80000
+	counts := make(map[*Backend]int)
+	for i := 0; i < draws; i++ {
+		counts[wr.Next()]++
+	}
+
+	if counts[backends[3]] != 0 {
+		t.Errorf("Expected unhealthy backend never selected, got %d", counts[backends[3]])
+	}
+	// Standard error is under 0.2% at this sample size, so 2% never flakes
+	const totalWeight = 8.0
+	for _, backend := range backends[:3] {
+		want := float64(backend.GetWeight()) / totalWeight
+		got := float64(counts[backend]) / draws
+		if math.Abs(got-want) > 0.02 {
+			t.Errorf("%s: expected share %.3f, got %.3f", backend.Address, want, got)
+		}
+	}
+}
+
+func TestRoundRobin_BackingOffUsedOnlyAsLastResort(t *testing.T) {
+	backends := []*Backend{
+		NewBackend("server1:8080", 1),
+		NewBackend("server2:8080", 1),
+	}
+	rr := NewRoundRobin(backends)
+
+	backends[0].BackOff(time.Now().Add(time.Minute))
+	for i := 0; i < 4; i++ {
+		if next := rr.Next(); next != backends[1] {
+			t.Fatalf("Expected the backing off backend skipped, got %s", next.Address)
+		}
+	}
+
+	// With every backend backing off, traffic still flows
+	backends[1].BackOff(time.Now().Add(time.Minute))
+	if rr.Next() == nil {
+		t.Error("Expected a backing off backend rather than none")
+	}
+
+	// Backoff expires on its own, and a shorter request does not cut it short
+	backends[0].BackOff(time.Now().Add(-time.Second))
+	if !backends[0].IsBackingOff() {
+		t.Error("Expected an earlier deadline not to shorten the backoff")
+	}
+	expired := NewBackend("server3:8080", 1)
+	expired.BackOff(time.Now().Add(-time.Second))
+	if expired.IsBackingOff() || !expired.BackoffUntil().IsZero() {
+		t.Error("Expected an expired backoff to have no effect")
+	}
+}
+
+func TestLoadMetric_DrivesSelection(t *testing.T) {
+	// Each backend is least loaded under exactly one metric
+	newBackends := func() []*Backend {
+		byRequests := NewBackend("requests:8080", 1)
+		byRequests.ConnOpened()
+		byRequests.ConnOpened()
+		byRequests.SetReportedLoad(0.9)
+
+		byConns := NewBackend("connections:8080", 1)
+		byConns.IncrementConnections()
+		byConns.IncrementConnections()
+		byConns.SetReportedLoad(0.8)
+
+		byHeader := NewBackend("header:8080", 1)
+		byHeader.IncrementConnections()
+		byHeader.ConnOpened()
+		byHeader.SetReportedLoad(0.1)
+
+		return []*Backend{byRequests, byConns, byHeader}
+	}
+
+	for _, name := range []string{LoadRequests, LoadConnections, LoadHeader} {
+		metric, err := ParseLoadMetric(name)
+		if err != nil {
+			t.Fatalf("ParseLoadMetric(%q) failed: %v", name, err)
+		}
+
+		for _, algorithm := range []string{"least-connections", "weighted-least-connections", "p2c"} {
+			lb, _ := New(algorithm, newBackends())
+			lb.SetLoadMetric(metric)
+
+			// p2c samples two of three backends, so take the most frequent pick
+			picks := make(map[string]int)
+			for i := 0; i < 100; i++ {
+				picks[lb.Next().Address]++
+			}
+			want := name + ":8080"
+			for addr, n := range picks {
+				if addr != want && n >= picks[want] {
+					t.Errorf("%s with %s metric: expected %s to be preferred, got %v", algorithm, name, want, picks)
+					break
+				}
+			}
+		}
+	}
+
+	if _, err := ParseLoadMetric("cpu"); err == nil {
+		t.Error("Expected error for unknown load metric")
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"localhost:8080", false},
+		{"10.0.0.1:80", false},
+		{"[::1]:8080", false},
+		{"[2001:db8::1]:443", false},
+		{"http://host:8080", true},
+		{"host", true},
+		{"::1:8080", true},
+		{"[::1]", true},
+		{":8080", true},
+		{"host:http", true},
+		{"host:0", true},
+		{"host:70000", true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateAddress(tt.address)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateAddress(%q): expected error=%v, got %v", tt.address, tt.wantErr, err)
+		}
+	}
+}
+
+func TestBaseBalancer_BackendsConcurrentWithMutation(t *testing.T) {
+	pool := []*Backend{NewBackend("server1:8080", 1), NewBackend("server2:8080", 1)}
+	rr := NewRoundRobin(pool)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			rr.SetBackends(append(pool, NewBackend("server3:8080", 1)))
+			rr.SetBackends(pool)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		backends := rr.Backends()
+		// Writing into the returned slice must not disturb the pool
+		backends[0] = nil
+		for _, b := range backends[1:] {
+			b.IsAvailable()
+		}
+	}
+	<-done
+
+	if rr.Backends()[0] == nil {
+		t.Error("Modifying the returned slice changed the pool")
+	}
+}
+
+func BenchmarkBackend_ConnectionTrackingParallel(b *testing.B) {
+	backend := NewBackend("server1:8080", 1)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			backend.IncrementConnections()
+			backend.IsAvailable()
+			backend.DecrementConnections()
+		}
+	})
+}
+
+func TestMaglev_EvenSpreadAndMinimalDisruption(t *testing.T) {
+	const n, keys = 10, 100000
+	backends := make([]*Backend, n)
+	for i := range backends {
+		backends[i] = NewBackend(fmt.Sprintf("10.0.0.%d:8080", i+1), 1)
+	}
+	m := NewMaglev(backends)
+
+	before := make([]*Backend, keys)
+	counts := make(map[*Backend]int)
+	for i := range before {
+		before[i] = m.NextForKey(fmt.Sprintf("client-%d", i))
+		counts[before[i]]++
+	}
+	for _, b := range backends {
+		if share := float64(counts[b]) / keys; math.Abs(share-1.0/n) > 0.01 {
+			t.Errorf("Backend %s got %.3f of keys, expected about %.3f", b.Address, share, 1.0/n)
+		}
+	}
+
+	// The same key keeps mapping to the same backend
+	if m.NextForKey("client-42") != before[42] {
+		t.Error("Expected a stable mapping for an unchanged backend set")
+	}
+
+	removed := backends[3]
+	removed.SetHealthy(false)
+
+	moved, movedElsewhere := 0, 0
+	for i := range before {
+		after := m.NextForKey(fmt.Sprintf("client-%d", i))
+		if after == removed {
+			t.Fatalf("Key %d still maps to the unhealthy backend", i)
+		}
+		if after != before[i] {
+			moved++
+			if before[i] != removed {
+				movedElsewhere++
+			}
+		}
+	}
+
+	// Only the removed backend's keys (about 1/N) should move
+	if share := float64(moved) / keys; share > 1.0/n*1.2 {
+		t.Errorf("Expected about %.2f of keys to move, got %.3f", 1.0/n, share)
+	}
+	if share := float64(movedElsewhere) / keys; share > 0.02 {
+		t.Errorf("Expected few keys of remaining backends to move, got %.3f", share)
+	}
+
+	// Recovery restores the original mapping
+	removed.SetHealthy(true)
+	if m.NextForKey("client-42") != before[42] {
+		t.Error("Expected the original mapping after the backend recovered")
+	}
+}
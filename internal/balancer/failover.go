@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"time"
+)
+
+// Region is a named pool of backends with its own balancer
+type Region struct {
+	Name     string
+	Balancer Balancer
+}
+
+// Failover routes to the first region (in priority order) that has a healthy
+// backend, so remote regions only receive traffic while preferred regions
+// are entirely unavailable
+type Failover struct {
+	regions []*Region
+}
+
+// NewFailover creates a failover balancer over regions in priority order
+func NewFailover(regions []*Region) *Failover {
+	return &Failover{regions: regions}
+}
+
+// Regions returns the regions in priority order
+func (f *Failover) Regions() []*Region {
+	return f.regions
+}
+
+// Next returns a backend from the highest-priority region that has one
+func (f *Failover) Next() *Backend {
+	for _, region := range f.regions {
+		if backend := region.Balancer.Next(); backend != nil {
+			return backend
+		}
+	}
+	return nil
+}
+
+// NextForKey returns a backend for key from the highest-priority region that
+// has one, hashing the key in regions whose balancer supports it
+func (f *Failover) NextForKey(key string) *Backend {
+	for _, region := range f.regions {
+		var backend *Backend
+		if keyed, ok := region.Balancer.(KeyedBalancer); ok {
+			backend = keyed.NextForKey(key)
+		} else {
+			backend = region.Balancer.Next()
+		}
+		if backend != nil {
+			return backend
+		}
+	}
+	return nil
+}
+
+// Backends returns the backends of every region
+func (f *Failover) Backends() []*Backend {
+	var all []*Backend
+	for _, region := range f.regions {
+		all = append(all, region.Balancer.Backends()...)
+	}
+	return all
+}
+
+// SetBackends replaces the backends of the primary region
+func (f *Failover) SetBackends(backends []*Backend) {
+	if len(f.regions) > 0 {
+		f.regions[0].Balancer.SetBackends(backends)
+	}
+}
+
+// SetSlowStart sets the ramp-up window in every region
+func (f *Failover) SetSlowStart(d time.Duration) {
+	for _, region := range f.regions {
+		region.Balancer.SetSlowStart(d)
+	}
+}
+
+// SetLoadMetric sets the load metric in every region
+func (f *Failover) SetLoadMetric(m LoadMetric) {
+	for _, region := range f.regions {
+		region.Balancer.SetLoadMetric(m)
+	}
+}
+
+// MarkHealthy marks a backend as healthy in whichever region holds it
+func (f *Failover) MarkHealthy(address string) {
+	for _, region := range f.regions {
+		region.Balancer.MarkHealthy(address)
+	}
+}
+
+// MarkUnhealthy marks a backend as unhealthy in whichever region holds it
+func (f *Failover) MarkUnhealthy(address string) {
+	for _, region := range f.regions {
+		region.Balancer.MarkUnhealthy(address)
+	}
+}
@@ -12,7 +12,8 @@ func NewLeastConnections(backends []*Backend) *LeastConnections {
 	}
 }
 
-// Next returns the healthy backend with the fewest active connections
+// Next returns the healthy backend with the lowest load, by default the
+// fewest active connections
 func (l *LeastConnections) Next() *Backend {
 	healthy := l.healthyBackends()
 	if len(healthy) == 0 {
@@ -20,12 +21,12 @@ func (l *LeastConnections) Next() *Backend {
 	}
 
 	var selected *Backend
-	minConns := int64(-1)
+	var minLoad float64
 
 	for _, backend := range healthy {
-		conns := backend.GetConnections()
-		if minConns == -1 || conns < minConns {
-			minConns = conns
+		load := l.load(backend)
+		if selected == nil || load < minLoad {
+			minLoad = load
 			selected = backend
 		}
 	}
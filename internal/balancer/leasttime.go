@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"sync/atomic"
+)
+
+// LeastTime picks the backend with the lowest average response latency. With
+// peak enabled the latency is multiplied by the in-flight request count
+// (peak-EWMA), so a fast backend stops attracting traffic once it backs up.
+type LeastTime struct {
+	*BaseBalancer
+	peak    bool
+	current uint64
+}
+
+// NewLeastTime creates a new least-response-time balancer
+func NewLeastTime(backends []*Backend, peak bool) *LeastTime {
+	return &LeastTime{
+		BaseBalancer: NewBaseBalancer(backends),
+		peak:         peak,
+	}
+}
+
+// Next returns the healthy backend with the lowest latency score. Backends
+// without a measurement yet score zero so they are probed first.
+func (l *LeastTime) Next() *Backend {
+	healthy := l.healthyBackends()
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	// Start the scan at a rotating offset so ties are spread round-robin
+	start := int((atomic.AddUint64(&l.current, 1) - 1) % uint64(len(healthy)))
+
+	var selected *Backend
+	var bestScore float64
+
+	for i := 0; i < len(healthy); i++ {
+		backend := healthy[(start+i)%len(healthy)]
+		score := float64(backend.Latency())
+		if l.peak {
+			score *= float64(backend.GetConnections() + 1)
+		}
+		score /= l.effectiveWeight(backend)
+
+		if selected == nil || score < bestScore {
+			selected = backend
+			bestScore = score
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,39 @@
+package balancer
+
+import (
+	"fmt"
+)
+
+// Load metrics the connection-aware balancers (least-connections,
+// weighted-least-connections, p2c) can minimize
+const (
+	// LoadRequests counts requests in flight to the backend
+	LoadRequests = "requests"
+	// LoadConnections counts open upstream TCP connections, idle or busy
+	LoadConnections = "connections"
+	// LoadHeader uses the value the backend last reported in a response header
+	LoadHeader = "header"
+)
+
+// LoadMetric reads the current load of a backend; lower is less loaded
+type LoadMetric func(b *Backend) float64
+
+// loadMetrics maps metric names to their readers
+var loadMetrics = map[string]LoadMetric{
+	LoadRequests:    func(b *Backend) float64 { return float64(b.GetConnections()) },
+	LoadConnections: func(b *Backend) float64 { return float64(b.OpenConnections()) },
+	LoadHeader:      func(b *Backend) float64 { return b.ReportedLoad() },
+}
+
+// ParseLoadMetric returns the named load metric; an empty name selects
+// in-flight requests
+func ParseLoadMetric(name string) (LoadMetric, error) {
+	if name == "" {
+		name = LoadRequests
+	}
+	metric, ok := loadMetrics[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown load metric: %s", name)
+	}
+	return metric, nil
+}
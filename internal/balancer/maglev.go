@@ -0,0 +1,125 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// maglevTableSize is the lookup table size. It must be prime and much larger
+// than the number of backends for an even spread; 65537 keeps every
+// backend's share within about 1% of the mean for pools of a few hundred.
+const maglevTableSize = 65537
+
+// Maglev maps request keys to backends with Maglev consistent hashing. Each
+// backend fills the slots of a prime-sized lookup table in its own pseudo-
+// random order, so load is spread evenly and a change in the healthy set
+// only moves about 1/N of the keys. The table is rebuilt whenever the set of
+// healthy backends changes.
+type Maglev struct {
+	*BaseBalancer
+
+	tableMu sync.Mutex
+	members []*Backend // healthy set the table was built from
+	table   []*Backend
+}
+
+// NewMaglev creates a new Maglev consistent hash balancer
+func NewMaglev(backends []*Backend) *Maglev {
+	return &Maglev{
+		BaseBalancer: NewBaseBalancer(backends),
+	}
+}
+
+// NextForKey returns the backend owning key's slot in the lookup table
+func (m *Maglev) NextForKey(key string) *Backend {
+	table := m.lookupTable()
+	if table == nil {
+		return nil
+	}
+	return table[hashKey(key, "")%maglevTableSize]
+}
+
+// Next returns the backend of a random slot, for requests without a key
+func (m *Maglev) Next() *Backend {
+	table := m.lookupTable()
+	if table == nil {
+		return nil
+	}
+	return table[rand.IntN(maglevTableSize)]
+}
+
+// lookupTable returns the table for the current healthy set, rebuilding it
+// if the set has changed since the last build
+func (m *Maglev) lookupTable() []*Backend {
+	healthy := m.healthyBackends()
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	m.tableMu.Lock()
+	defer m.tableMu.Unlock()
+	if !sameBackends(m.members, healthy) {
+		m.members = healthy
+		m.table = buildMaglevTable(healthy)
+	}
+	return m.table
+}
+
+// sameBackends reports whether a and b hold the same backends in order
+func sameBackends(a, b []*Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// buildMaglevTable fills the lookup table by letting each backend in turn
+// claim the next free slot of its permutation, as described in the Maglev
+// paper. Backends are ordered by address so every proxy instance builds the
+// same table from the same set.
+func buildMaglevTable(backends []*Backend) []*Backend {
+	backends = append([]*Backend(nil), backends...)
+	sort.Slice(backends, func(i, j int) bool { return backends[i].Address < backends[j].Address })
+
+	offsets := make([]uint64, len(backends))
+	skips := make([]uint64, len(backends))
+	next := make([]uint64, len(backends))
+	for i, b := range backends {
+		offsets[i] = hashKey(b.Address, "offset") % maglevTableSize
+		skips[i] = hashKey(b.Address, "skip")%(maglevTableSize-1) + 1
+	}
+
+	table := make([]*Backend, maglevTableSize)
+	filled := 0
+	for {
+		for i, b := range backends {
+			slot := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+			for table[slot] != nil {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+			}
+			table[slot] = b
+			next[i]++
+			filled++
+			if filled == maglevTableSize {
+				return table
+			}
+		}
+	}
+}
+
+// hashKey returns the 64-bit FNV-1a hash of key, salted to derive
+// independent hashes from one input
+func hashKey(key, salt string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	h.Write([]byte(key))
+	return h.Sum64()
+}
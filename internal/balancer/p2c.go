@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"math/rand/v2"
+)
+
+// p2cAttempts bounds the random picks Next makes before falling back to
+// scanning the pool for available backends
+const p2cAttempts = 8
+
+// PowerOfTwoChoices samples two random backends and routes to the less
+// loaded one, approximating least-connections without scoring every backend
+type PowerOfTwoChoices struct {
+	*BaseBalancer
+}
+
+// NewPowerOfTwoChoices creates a new power-of-two-choices balancer
+func NewPowerOfTwoChoices(backends []*Backend) *PowerOfTwoChoices {
+	return &PowerOfTwoChoices{
+		BaseBalancer: NewBaseBalancer(backends),
+	}
+}
+
+// Next picks two distinct healthy backends at random and returns the one with
+// the lower load (by default fewer connections), breaking ties by lower
+// average latency. Picks are drawn from the pool directly; only when they
+// keep landing on unavailable backends is the pool scanned.
+func (p *PowerOfTwoChoices) Next() *Backend {
+	if a, b, ok := p.sample(); ok {
+		return p.lessLoaded(a, b)
+	}
+
+	healthy := p.healthyBackends()
+	switch len(healthy) {
+	case 0:
+		return nil
+	case 1:
+		return healthy[0]
+	}
+
+	i := rand.IntN(len(healthy))
+	j := rand.IntN(len(healthy) - 1)
+	if j >= i {
+		j++
+	}
+	return p.lessLoaded(healthy[i], healthy[j])
+}
+
+// sample draws two distinct backends that are available and not backing
+// off, giving up after p2cAttempts picks
+func (p *PowerOfTwoChoices) sample() (a, b *Backend, ok bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := len(p.backends)
+	if n < 2 {
+		return nil, nil, false
+	}
+	for attempt := 0; attempt < p2cAttempts; attempt++ {
+		c := p.backends[rand.IntN(n)]
+		switch {
+		case !c.IsAvailable() || c.IsBackingOff():
+		case a == nil:
+			a = c
+		case c != a:
+			return a, c, true
+		}
+	}
+	return nil, nil, false
+}
+
+// lessLoaded returns whichever of a and b has the lower load, then the
+// lower average latency
+func (p *PowerOfTwoChoices) lessLoaded(a, b *Backend) *Backend {
+	loadA, loadB := p.load(a), p.load(b)
+	if loadA != loadB {
+		if loadA < loadB {
+			return a
+		}
+		return b
+	}
+	if b.Latency() < a.Latency() {
+		return b
+	}
+	return a
+}
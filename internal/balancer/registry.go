@@ -0,0 +1,46 @@
+package balancer
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Factory constructs a balancer over the given backends
+type Factory func(backends []*Backend) Balancer
+
+// registry maps algorithm names to their constructors
+var registry = map[string]Factory{
+	"round-robin":                func(b []*Backend) Balancer { return NewRoundRobin(b) },
+	"least-connections":          func(b []*Backend) Balancer { return NewLeastConnections(b) },
+	"weighted-least-connections": func(b []*Backend) Balancer { return NewWeightedLeastConnections(b) },
+	"least-time":                 func(b []*Backend) Balancer { return NewLeastTime(b, false) },
+	"peak-ewma":                  func(b []*Backend) Balancer { return NewLeastTime(b, true) },
+	"p2c":                        func(b []*Backend) Balancer { return NewPowerOfTwoChoices(b) },
+	"weighted-random":            func(b []*Backend) Balancer { return NewWeightedRandom(b) },
+	"maglev":                     func(b []*Backend) Balancer { return NewMaglev(b) },
+}
+
+// New creates a balancer for the named algorithm
+func New(algorithm string, backends []*Backend) (Balancer, error) {
+	factory, ok := registry[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown load balancing algorithm: %s", algorithm)
+	}
+	return factory(backends), nil
+}
+
+// IsValidAlgorithm reports whether an algorithm name is registered
+func IsValidAlgorithm(algorithm string) bool {
+	_, ok := registry[algorithm]
+	return ok
+}
+
+// Algorithms returns the sorted names of all registered algorithms
+func Algorithms() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,47 @@
+package balancer
+
+import (
+	"sync/atomic"
+)
+
+// WeightedLeastConnections picks the backend with the lowest load relative to
+// its weight, rotating between equally scored backends in round-robin order
+type WeightedLeastConnections struct {
+	*BaseBalancer
+	current uint64
+}
+
+// NewWeightedLeastConnections creates a new weighted least-connections balancer
+func NewWeightedLeastConnections(backends []*Backend) *WeightedLeastConnections {
+	return &WeightedLeastConnections{
+		BaseBalancer: NewBaseBalancer(backends),
+	}
+}
+
+// Next returns the healthy backend with the lowest (load+1)/weight score,
+// using the slow-start adjusted weight
+func (w *WeightedLeastConnections) Next() *Backend {
+	healthy := w.healthyBackends()
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	// Start the scan at a rotating offset so ties are spread round-robin
+	start := int((atomic.AddUint64(&w.current, 1) - 1) % uint64(len(healthy)))
+
+	var selected *Backend
+	var bestScore float64
+
+	for i := 0; i < len(healthy); i++ {
+		backend := healthy[(start+i)%len(healthy)]
+		// Counting the prospective request lets weight matter on idle backends
+		score := (w.load(backend) + 1) / w.effectiveWeight(backend)
+
+		if selected == nil || score < bestScore {
+			selected = backend
+			bestScore = score
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,51 @@
+package balancer
+
+import (
+	"math/rand/v2"
+	"sort"
+)
+
+// WeightedRandom picks a healthy backend at random with probability
+// proportional to its weight. It keeps no rotation state, so independent
+// workers honour the weights without coordinating.
+type WeightedRandom struct {
+	*BaseBalancer
+}
+
+// NewWeightedRandom creates a new weighted random balancer
+func NewWeightedRandom(backends []*Backend) *WeightedRandom {
+	return &WeightedRandom{
+		BaseBalancer: NewBaseBalancer(backends),
+	}
+}
+
+// Next draws a point in the total slow-start adjusted weight and binary
+// searches the cumulative weights for the backend that owns it
+func (w *WeightedRandom) Next() *Backend {
+	healthy := w.healthyBackends()
+	switch len(healthy) {
+	case 0:
+		return nil
+	case 1:
+		return healthy[0]
+	}
+
+	cumulative := make([]float64, len(healthy))
+	var total float64
+	for i, backend := range healthy {
+		if weight := w.effectiveWeight(backend); weight > 0 {
+			total += weight
+		}
+		cumulative[i] = total
+	}
+	if total <= 0 {
+		return healthy[rand.IntN(len(healthy))]
+	}
+
+	point := rand.Float64() * total
+	i := sort.Search(len(cumulative), func(i int) bool { return cumulative[i] > point })
+	if i == len(cumulative) {
+		i--
+	}
+	return healthy[i]
+}
@@ -0,0 +1,72 @@
+package circuit
+
+import (
+	"time"
+)
+
+// AdaptivePolicy scales a breaker's failure threshold with the traffic it
+// sees, so a busy backend does not trip on a few noisy errors while a quiet
+// one still trips quickly
+type AdaptivePolicy struct {
+	Window              time.Duration // interval over which traffic is counted
+	FailureRatio        float64       // threshold as a fraction of the last window's requests
+	MinFailureThreshold int
+	MaxFailureThreshold int
+}
+
+// threshold returns the failure threshold for a window that saw requests
+func (p *AdaptivePolicy) threshold(requests int) int {
+	return p.clamp(int(float64(requests) * p.FailureRatio))
+}
+
+func (p *AdaptivePolicy) clamp(threshold int) int {
+	return max(p.MinFailureThreshold, min(threshold, p.MaxFailureThreshold))
+}
+
+// SetAdaptive enables adaptive thresholds, or restores the fixed threshold
+// when policy is nil
+func (b *Breaker) SetAdaptive(policy *AdaptivePolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.adaptive = policy
+	b.windowStart = time.Now()
+	b.windowRequests = 0
+	if policy == nil {
+		b.failureThreshold = b.baseFailureThreshold
+		return
+	}
+	// Until a full window has been observed, start from the configured value
+	b.failureThreshold = policy.clamp(b.baseFailureThreshold)
+}
+
+// FailureThreshold returns the number of consecutive failures that opens the circuit
+func (b *Breaker) FailureThreshold() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.failureThreshold
+}
+
+// observe counts a request toward the traffic window, re-deriving the failure
+// threshold from the previous window once it has elapsed. Callers must hold b.mu.
+func (b *Breaker) observe() {
+	if b.adaptive == nil {
+		return
+	}
+
+	if elapsed := time.Since(b.windowStart); elapsed >= b.adaptive.Window {
+		requests := b.windowRequests
+		if elapsed >= 2*b.adaptive.Window {
+			// A whole window passed without traffic
+			requests = 0
+		}
+		if threshold := b.adaptive.threshold(requests); threshold != b.failureThreshold {
+			b.logger.Debug("failure threshold adapted", "backend", b.address,
+				"from", b.failureThreshold, "to", threshold, "window_requests", requests)
+			b.failureThreshold = threshold
+		}
+		b.windowStart = time.Now()
+		b.windowRequests = 0
+	}
+	b.windowRequests++
+}
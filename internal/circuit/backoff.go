@@ -0,0 +1,49 @@
+package circuit
+
+import (
+	"time"
+)
+
+// BackoffPolicy lengthens the open period each time a half-open probe fails,
+// so a backend that stays broken is probed less and less often
+type BackoffPolicy struct {
+	Multiplier float64       // factor applied to the open timeout per failed probe
+	MaxTimeout time.Duration // upper bound on the open timeout
+}
+
+// next returns the open timeout that follows current; a timeout already
+// above the maximum is left as is
+func (p *BackoffPolicy) next(current time.Duration) time.Duration {
+	if current >= p.MaxTimeout {
+		return current
+	}
+	return min(time.Duration(float64(current)*p.Multiplier), p.MaxTimeout)
+}
+
+// SetBackoff enables exponential backoff of the open timeout, or restores
+// the fixed timeout when policy is nil
+func (b *Breaker) SetBackoff(policy *BackoffPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.backoff = policy
+	b.openTimeout = b.timeout
+}
+
+// OpenTimeout returns how long the circuit stays open before the next probe
+func (b *Breaker) OpenTimeout() time.Duration {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.openTimeout
+}
+
+// backOff lengthens the open timeout after a failed probe. Callers must hold b.mu.
+func (b *Breaker) backOff() {
+	if b.backoff == nil {
+		return
+	}
+	if next := b.backoff.next(b.openTimeout); next != b.openTimeout {
+		b.logger.Debug("open timeout backed off", "backend", b.address, "from", b.openTimeout, "to", next)
+		b.openTimeout = next
+	}
+}
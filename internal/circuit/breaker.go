@@ -1,9 +1,11 @@
 package circuit
 
 import (
-	"log"
 	"sync"
 	"time"
+
+	"github.com/hermes-proxy/hermes/internal/events"
+	"github.com/hermes-proxy/hermes/internal/logging"
 )
 
 // State represents the circuit breaker state
@@ -41,16 +43,36 @@ type Breaker struct {
 	failures    int
 	successes   int
 	lastFailure time.Time
-	mu          sync.RWMutex
+
+	// Adaptive tuning; failureThreshold tracks the policy when set
+	adaptive             *AdaptivePolicy
+	baseFailureThreshold int
+	windowStart          time.Time
+	windowRequests       int
+
+	// Open period before the next probe; grows from timeout under backoff
+	backoff     *BackoffPolicy
+	openTimeout time.Duration
+
+	// State changes are published here and logged, tagged with the backend
+	// address
+	events  *events.Bus
+	logger  logging.Logger
+	address string
+
+	mu sync.RWMutex
 }
 
 // NewBreaker creates a new circuit breaker
 func NewBreaker(failureThreshold, successThreshold int, timeout time.Duration) *Breaker {
 	return &Breaker{
-		state:            StateClosed,
-		failureThreshold: failureThreshold,
-		successThreshold: successThreshold,
-		timeout:          timeout,
+		state:                StateClosed,
+		failureThreshold:     failureThreshold,
+		baseFailureThreshold: failureThreshold,
+		successThreshold:     successThreshold,
+		timeout:              timeout,
+		openTimeout:          timeout,
+		logger:               logging.Default(),
 	}
 }
 
@@ -64,10 +86,10 @@ func (b *Breaker) Allow() bool {
 		return true
 	case StateOpen:
 		// Check if timeout has passed
-		if time.Since(b.lastFailure) >= b.timeout {
-			b.state = StateHalfOpen
+		if time.Since(b.lastFailure) >= b.openTimeout {
+			b.setState(StateHalfOpen)
 			b.successes = 0
-			log.Printf("[CIRCUIT] State changed to HALF-OPEN")
+			b.logger.Info("circuit half-open", "backend", b.address)
 			return true
 		}
 		return false
@@ -82,6 +104,7 @@ func (b *Breaker) Allow() bool {
 func (b *Breaker) RecordSuccess() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.observe()
 
 	switch b.state {
 	case StateClosed:
@@ -89,9 +112,10 @@ func (b *Breaker) RecordSuccess() {
 	case StateHalfOpen:
 		b.successes++
 		if b.successes >= b.successThreshold {
-			b.state = StateClosed
+			b.setState(StateClosed)
 			b.failures = 0
-			log.Printf("[CIRCUIT] State changed to CLOSED (recovered)")
+			b.openTimeout = b.timeout
+			b.logger.Info("circuit closed", "backend", b.address)
 		}
 	}
 }
@@ -100,20 +124,22 @@ func (b *Breaker) RecordSuccess() {
 func (b *Breaker) RecordFailure() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.observe()
 
 	switch b.state {
 	case StateClosed:
 		b.failures++
 		if b.failures >= b.failureThreshold {
-			b.state = StateOpen
+			b.setState(StateOpen)
 			b.lastFailure = time.Now()
-			log.Printf("[CIRCUIT] State changed to OPEN after %d failures", b.failures)
+			b.logger.Info("circuit opened", "backend", b.address, "failures", b.failures)
 		}
 	case StateHalfOpen:
-		b.state = StateOpen
+		b.setState(StateOpen)
 		b.lastFailure = time.Now()
 		b.successes = 0
-		log.Printf("[CIRCUIT] State changed to OPEN (half-open test failed)")
+		b.backOff()
+		b.logger.Info("circuit reopened after failed probe", "backend", b.address)
 	}
 }
 
@@ -128,7 +154,38 @@ func (b *Breaker) State() State {
 func (b *Breaker) Reset() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.state = StateClosed
+	b.setState(StateClosed)
 	b.failures = 0
 	b.successes = 0
+	b.openTimeout = b.timeout
+}
+
+// SetEvents publishes this breaker's state changes to bus under address;
+// a nil bus stops publishing
+func (b *Breaker) SetEvents(bus *events.Bus, address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = bus
+	b.address = address
+}
+
+// SetLogger sets where state changes are logged
+func (b *Breaker) SetLogger(logger logging.Logger) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.logger = logger
+}
+
+// setState moves the breaker to state and publishes the change. Callers must hold b.mu.
+func (b *Breaker) setState(state State) {
+	if state == b.state {
+		return
+	}
+	b.events.Publish(events.Event{
+		Type:    events.CircuitState,
+		Backend: b.address,
+		From:    b.state.String(),
+		To:      state.String(),
+	})
+	b.state = state
 }
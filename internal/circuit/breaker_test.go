@@ -1,8 +1,13 @@
 package circuit
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/hermes-proxy/hermes/internal/events"
+	"github.com/hermes-proxy/hermes/internal/logging"
 )
 
 func TestBreaker_InitialState(t *testing.T) {
@@ -137,3 +142,182 @@ func TestBreaker_Reset(t *testing.T) {
 		t.Error("Should allow requests after reset")
 	}
 }
+
+func TestBreaker_AdaptiveThresholdScalesWithTraffic(t *testing.T) {
+	policy := &AdaptivePolicy{
+		Window:              50 * time.Millisecond,
+		FailureRatio:        0.1,
+		MinFailureThreshold: 3,
+		MaxFailureThreshold: 50,
+	}
+
+	// failuresToTrip warms a breaker with the given traffic, then counts the
+	// consecutive failures it takes to open once the window has rolled over
+	failuresToTrip := func(requests int) int {
+		breaker := NewBreaker(5, 2, 30*time.Second)
+		breaker.SetAdaptive(policy)
+		for i := 0; i < requests; i++ {
+			breaker.RecordSuccess()
+		}
+		time.Sleep(60 * time.Millisecond)
+
+		failures := 0
+		for breaker.State() == StateClosed && failures < 100 {
+			breaker.RecordFailure()
+			failures++
+		}
+		return failures
+	}
+
+	busy, quiet := failuresToTrip(200), failuresToTrip(10)
+	if busy != 20 {
+		t.Errorf("Expected high-traffic breaker to trip after 20 failures, got %d", busy)
+	}
+	if quiet != 3 {
+		t.Errorf("Expected low-traffic breaker to trip at the minimum of 3 failures, got %d", quiet)
+	}
+}
+
+func TestBreakerPool_PerBackendOverride(t *testing.T) {
+	pool := NewBreakerPool(3, 2, 30)
+	pool.SetOverrides(map[string]Override{
+		"flaky:8080": {FailureThreshold: 6},
+	})
+
+	flaky := pool.Get("flaky:8080")
+	internal := pool.Get("internal:8080")
+
+	for i := 0; i < 5; i++ {
+		flaky.RecordFailure()
+		internal.RecordFailure()
+	}
+
+	if internal.State() != StateOpen {
+		t.Errorf("Expected default backend OPEN, got %s", internal.State())
+	}
+	if flaky.State() != StateClosed {
+		t.Errorf("Expected overridden backend CLOSED after 5 failures, got %s", flaky.State())
+	}
+
+	flaky.RecordFailure()
+	if flaky.State() != StateOpen {
+		t.Errorf("Expected overridden backend OPEN after 6 failures, got %s", flaky.State())
+	}
+
+	// A fixed per-backend threshold is not replaced by the adaptive policy
+	pool.SetAdaptive(&AdaptivePolicy{Window: time.Hour, FailureRatio: 0.5, MinFailureThreshold: 10, MaxFailureThreshold: 10})
+	if got := flaky.FailureThreshold(); got != 6 {
+		t.Errorf("Expected overridden threshold 6 under adaptive policy, got %d", got)
+	}
+	if got := internal.FailureThreshold(); got != 10 {
+		t.Errorf("Expected adaptive threshold 10 for default backend, got %d", got)
+	}
+
+	// Changing the overrides replaces only the breakers they affect
+	pool.SetOverrides(map[string]Override{"internal:8080": {FailureThreshold: 8}})
+	if pool.Get("flaky:8080") == flaky || pool.Get("internal:8080") == internal {
+		t.Error("Expected breakers with changed overrides to be replaced")
+	}
+	if got := pool.Get("internal:8080").FailureThreshold(); got != 8 {
+		t.Errorf("Expected the new override threshold 8, got %d", got)
+	}
+	other := pool.Get("other:8080")
+	pool.SetOverrides(map[string]Override{"internal:8080": {FailureThreshold: 8}})
+	if pool.Get("other:8080") != other {
+		t.Error("Expected a breaker without an override change to be kept")
+	}
+}
+
+func TestBreaker_BackoffGrowsOpenTimeout(t *testing.T) {
+	breaker := NewBreaker(1, 1, 10*time.Second)
+	breaker.SetBackoff(&BackoffPolicy{Multiplier: 2, MaxTimeout: 30 * time.Second})
+
+	// probe expires the open period and fails the half-open request
+	probe := func() {
+		breaker.mu.Lock()
+		breaker.lastFailure = time.Now().Add(-breaker.openTimeout)
+		breaker.mu.Unlock()
+		if !breaker.Allow() {
+			t.Fatal("Expected HALF-OPEN after the open timeout")
+		}
+		breaker.RecordFailure()
+	}
+
+	breaker.RecordFailure()
+	if got := breaker.OpenTimeout(); got != 10*time.Second {
+		t.Fatalf("Expected base open timeout 10s, got %v", got)
+	}
+
+	for _, want := range []time.Duration{20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		probe()
+		if got := breaker.OpenTimeout(); got != want {
+			t.Errorf("Expected open timeout %v after failed probe, got %v", want, got)
+		}
+	}
+
+	breaker.mu.Lock()
+	breaker.lastFailure = time.Now().Add(-25 * time.Second)
+	breaker.mu.Unlock()
+	if breaker.Allow() {
+		t.Error("Expected circuit to stay OPEN within the backed-off timeout")
+	}
+
+	breaker.mu.Lock()
+	breaker.lastFailure = time.Now().Add(-30 * time.Second)
+	breaker.mu.Unlock()
+	if !breaker.Allow() {
+		t.Fatal("Expected HALF-OPEN after the backed-off timeout")
+	}
+	breaker.RecordSuccess()
+	if breaker.State() != StateClosed || breaker.OpenTimeout() != 10*time.Second {
+		t.Errorf("Expected CLOSED with base timeout after recovery, got %s %v", breaker.State(), breaker.OpenTimeout())
+	}
+}
+
+func TestBreakerPool_PublishesStateChanges(t *testing.T) {
+	bus := events.NewBus()
+	queue := bus.Subscribe(10)
+
+	pool := NewBreakerPool(1, 1, 0)
+	pool.SetEvents(bus)
+	breaker := pool.Get("server1:8080")
+
+	breaker.RecordFailure()
+	breaker.Allow()
+	breaker.RecordSuccess()
+
+	want := [][2]string{{"CLOSED", "OPEN"}, {"OPEN", "HALF-OPEN"}, {"HALF-OPEN", "CLOSED"}}
+	for _, w := range want {
+		e := <-queue
+		if e.Type != events.CircuitState || e.Backend != "server1:8080" || e.From != w[0] || e.To != w[1] {
+			t.Errorf("Expected %s -> %s for server1:8080, got %+v", w[0], w[1], e)
+		}
+	}
+	if len(queue) != 0 {
+		t.Errorf("Expected no further events, got %d", len(queue))
+	}
+}
+
+func TestBreakerPool_LogsTransitionsAtConfiguredLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "info", logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewBreakerPool(1, 1, 30)
+	pool.SetLogger(logger)
+	pool.Get("backend:8080").RecordFailure()
+
+	line := buf.String()
+	if !strings.Contains(line, `"level":"INFO"`) || !strings.Contains(line, `"msg":"circuit opened"`) || !strings.Contains(line, `"backend":"backend:8080"`) {
+		t.Errorf("Expected structured INFO transition log, got %q", line)
+	}
+
+	buf.Reset()
+	quiet, _ := logging.New(&buf, "warn", logging.FormatText)
+	pool.SetLogger(quiet)
+	pool.Get("other:8080").RecordFailure()
+	if buf.Len() != 0 {
+		t.Errorf("Expected transition logs suppressed at warn level, got %q", buf.String())
+	}
+}
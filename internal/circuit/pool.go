@@ -3,6 +3,9 @@ package circuit
 import (
 	"sync"
 	"time"
+
+	"github.com/hermes-proxy/hermes/internal/events"
+	"github.com/hermes-proxy/hermes/internal/logging"
 )
 
 // BreakerPool manages circuit breakers for multiple backends
@@ -11,9 +14,23 @@ type BreakerPool struct {
 	failureThreshold int
 	successThreshold int
 	timeout          time.Duration
+	adaptive         *AdaptivePolicy
+	backoff          *BackoffPolicy
+	overrides        map[string]Override
+	events           *events.Bus
+	logger           logging.Logger
 	mu               sync.RWMutex
 }
 
+// Override replaces the pool's settings for a single backend. Zero fields
+// keep the pool default. A backend with its own failure threshold keeps it
+// fixed rather than following the adaptive policy.
+type Override struct {
+	FailureThreshold int
+	SuccessThreshold int
+	Timeout          time.Duration
+}
+
 // NewBreakerPool creates a new circuit breaker pool
 func NewBreakerPool(failureThreshold, successThreshold int, timeoutSeconds int64) *BreakerPool {
 	return &BreakerPool{
@@ -43,15 +60,101 @@ func (p *BreakerPool) Get(address string) *Breaker {
 		return breaker
 	}
 
-	breaker = NewBreaker(
-		p.failureThreshold,
-		p.successThreshold,
-		p.timeout,
-	)
+	failureThreshold, successThreshold, timeout := p.failureThreshold, p.successThreshold, p.timeout
+	override := p.overrides[address]
+	if override.FailureThreshold > 0 {
+		failureThreshold = override.FailureThreshold
+	}
+	if override.SuccessThreshold > 0 {
+		successThreshold = override.SuccessThreshold
+	}
+	if override.Timeout > 0 {
+		timeout = override.Timeout
+	}
+
+	breaker = NewBreaker(failureThreshold, successThreshold, timeout)
+	breaker.address = address
+	if p.logger != nil {
+		breaker.SetLogger(p.logger)
+	}
+	if p.adaptive != nil && override.FailureThreshold == 0 {
+		breaker.SetAdaptive(p.adaptive)
+	}
+	if p.backoff != nil {
+		breaker.SetBackoff(p.backoff)
+	}
+	if p.events != nil {
+		breaker.SetEvents(p.events, address)
+	}
 	p.breakers[address] = breaker
 	return breaker
 }
 
+// SetAdaptive applies an adaptive threshold policy to every breaker in the
+// pool, including those created later; nil restores fixed thresholds
+func (p *BreakerPool) SetAdaptive(policy *AdaptivePolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.adaptive = policy
+	for address, breaker := range p.breakers {
+		if p.overrides[address].FailureThreshold == 0 {
+			breaker.SetAdaptive(policy)
+		}
+	}
+}
+
+// SetBackoff applies an open-timeout backoff policy to every breaker in the
+// pool, including those created later; nil restores fixed timeouts
+func (p *BreakerPool) SetBackoff(policy *BackoffPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.backoff = policy
+	for _, breaker := range p.breakers {
+		breaker.SetBackoff(policy)
+	}
+}
+
+// SetEvents publishes state changes of every breaker in the pool, including
+// those created later, to bus
+func (p *BreakerPool) SetEvents(bus *events.Bus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = bus
+	for address, breaker := range p.breakers {
+		breaker.SetEvents(bus, address)
+	}
+}
+
+// SetLogger sets the logger of every breaker in the pool, including those
+// created later
+func (p *BreakerPool) SetLogger(logger logging.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logger = logger
+	for _, breaker := range p.breakers {
+		breaker.SetLogger(logger)
+	}
+}
+
+// SetOverrides sets per-backend settings keyed by address. The breaker of a
+// backend whose override changed is dropped, so the next Get starts a fresh
+// one with the new settings; the others keep their state.
+func (p *BreakerPool) SetOverrides(overrides map[string]Override) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for address := range p.breakers {
+		if p.overrides[address] != overrides[address] {
+			delete(p.breakers, address)
+		}
+	}
+	p.overrides = overrides
+}
+
 // AllBreakers returns a map of all breakers and their states
 func (p *BreakerPool) AllBreakers() map[string]State {
 	p.mu.RLock()
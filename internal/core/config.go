@@ -1,38 +1,680 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/circuit"
+	"github.com/hermes-proxy/hermes/internal/discovery"
+	"github.com/hermes-proxy/hermes/internal/health"
+	"github.com/hermes-proxy/hermes/internal/logging"
+	"github.com/hermes-proxy/hermes/internal/proxy"
 )
 
 // Config represents the complete proxy configuration
 type Config struct {
-	Server         ServerConfig         `yaml:"server"`
-	Backends       []BackendConfig      `yaml:"backends"`
-	LoadBalancing  LoadBalancingConfig  `yaml:"load_balancing"`
-	HealthCheck    HealthCheckConfig    `yaml:"health_check"`
-	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
-	Buffer         BufferConfig         `yaml:"buffer"`
+	// Files merged in before this one; see LoadConfig
+	Include []string `yaml:"include"`
+
+	Server         ServerConfig               `yaml:"server"`
+	Backends       []BackendConfig            `yaml:"backends"`
+	Discovery      DiscoveryConfig            `yaml:"discovery"`
+	LoadBalancing  LoadBalancingConfig        `yaml:"load_balancing"`
+	HealthCheck    HealthCheckConfig          `yaml:"health_check"`
+	CircuitBreaker CircuitBreakerConfig       `yaml:"circuit_breaker"`
+	Buffer         BufferConfig               `yaml:"buffer"`
+	Retry          RetryConfig                `yaml:"retry"`
+	Compression    CompressionConfig          `yaml:"compression"`
+	HeaderLimits   HeaderLimitsConfig         `yaml:"header_limits"`
+	Regions        RegionsConfig              `yaml:"regions"`
+	RateLimit      RateLimitConfig            `yaml:"rate_limit"`
+	Logging        LoggingConfig              `yaml:"logging"`
+	ErrorPages     map[string]ErrorPageConfig `yaml:"error_pages"`
+	ClientIP       ClientIPConfig             `yaml:"client_ip"`
+	Cache          CacheConfig                `yaml:"cache"`
+	Outliers       OutlierDetectionConfig     `yaml:"outlier_detection"`
+	Tracing        TracingConfig              `yaml:"tracing"`
+	BodyRouting    BodyRoutingConfig          `yaml:"body_routing"`
+	Upstream       UpstreamConfig             `yaml:"upstream"`
+	LoadShedding   LoadSheddingConfig         `yaml:"load_shedding"`
+	Concurrency    ConcurrencyConfig          `yaml:"concurrency"`
+	Headers        HeadersConfig              `yaml:"headers"`
+	Maintenance    MaintenanceConfig          `yaml:"maintenance"`
+	AccessControl  AccessControlConfig        `yaml:"access_control"`
+	Events         EventsConfig               `yaml:"events"`
+	GRPC           GRPCConfig                 `yaml:"grpc"`
+	Shutdown       ShutdownConfig             `yaml:"shutdown"`
+}
+
+// EventsConfig sends backend health and circuit breaker state changes to a
+// webhook as JSON POSTs. Delivery is asynchronous; when more than queue_size
+// events are waiting, new ones are dropped.
+type EventsConfig struct {
+	WebhookURL string        `yaml:"webhook_url"`
+	Timeout    time.Duration `yaml:"timeout"` // per delivery attempt
+	MaxRetries int           `yaml:"max_retries"`
+	QueueSize  int           `yaml:"queue_size"`
+}
+
+// MaintenanceConfig lists the requests still proxied while maintenance mode
+// is on. Maintenance mode itself is toggled through the admin API and always
+// starts off.
+type MaintenanceConfig struct {
+	AllowPaths []string `yaml:"allow_paths"` // path prefixes, e.g. "/status"
+	AllowIPs   []string `yaml:"allow_ips"`   // client CIDRs or IPs
+}
+
+// AccessControlConfig refuses requests by client IP with a 403 before any
+// backend is chosen. The client IP is resolved as configured in client_ip.
+// A request must pass the global list and the most specific matching route.
+type AccessControlConfig struct {
+	Mode   string              `yaml:"mode"`  // allowlist or denylist; empty for no global list
+	CIDRs  []string            `yaml:"cidrs"` // client CIDRs or IPs
+	Routes []AccessRouteConfig `yaml:"routes"`
+}
+
+// AccessRouteConfig restricts clients for paths starting with path_prefix
+type AccessRouteConfig struct {
+	PathPrefix string   `yaml:"path_prefix"`
+	Mode       string   `yaml:"mode"`
+	CIDRs      []string `yaml:"cidrs"`
+}
+
+// Build returns the access control for the proxy, or nil if none is
+// configured
+func (a AccessControlConfig) Build() (*proxy.AccessControl, error) {
+	if a.Mode == "" && len(a.CIDRs) > 0 {
+		return nil, fmt.Errorf("mode is required when cidrs are set")
+	}
+	if a.Mode == "" && len(a.Routes) == 0 {
+		return nil, nil
+	}
+
+	var global *proxy.AccessRule
+	if a.Mode != "" {
+		global = &proxy.AccessRule{Mode: a.Mode, CIDRs: a.CIDRs}
+	}
+	routes := make([]proxy.AccessRule, len(a.Routes))
+	for i, r := range a.Routes {
+		routes[i] = proxy.AccessRule{PathPrefix: r.PathPrefix, Mode: r.Mode, CIDRs: r.CIDRs}
+	}
+	return proxy.NewAccessControl(global, routes)
+}
+
+// GRPCConfig enables proxying gRPC. The proxy listener then also accepts
+// cleartext HTTP/2 (h2c), and gRPC calls reach backends over HTTP/2 with
+// streamed bodies and trailers.
+type GRPCConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// ShutdownConfig controls the shutdown sequence on SIGTERM or SIGINT
+type ShutdownConfig struct {
+	// Time spent serving normally while /readyz reports not ready, before
+	// the drain starts, so load balancers stop routing here first
+	PreStopDelay time.Duration `yaml:"pre_stop_delay"`
+}
+
+// LoadSheddingConfig turns off optional features while the proxy is under
+// heavy load. A zero threshold disables that check.
+type LoadSheddingConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	MaxActiveRequests int64         `yaml:"max_active_requests"`
+	MaxCPU            float64       `yaml:"max_cpu"` // fraction of all cores, e.g. 0.9
+	Interval          time.Duration `yaml:"interval"`
+	Features          []string      `yaml:"features"` // "compression", "access_log", "tracing"
+}
+
+// HeadersConfig edits headers on requests sent to backends and on responses
+// returned to clients
+type HeadersConfig struct {
+	Request  HeaderRulesConfig `yaml:"request"`
+	Response HeaderRulesConfig `yaml:"response"`
+}
+
+// HeaderRulesConfig removes, then sets, then adds headers. Values may use
+// {backend}, {client_ip}, {host}, {method}, {path} and {scheme}.
+type HeaderRulesConfig struct {
+	Remove []string          `yaml:"remove"`
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+}
+
+// Rules returns the header rules for the proxy
+func (h HeaderRulesConfig) Rules() proxy.HeaderRules {
+	return proxy.HeaderRules{Remove: h.Remove, Set: h.Set, Add: h.Add}
+}
+
+// ConcurrencyConfig caps concurrently proxied requests. Requests over the cap
+// are queued and admitted by weighted fair queuing across tenants, identified
+// by tenant_header or else by client IP.
+type ConcurrencyConfig struct {
+	Enabled      bool               `yaml:"enabled"`
+	MaxActive    int                `yaml:"max_active"`
+	MaxQueue     int                `yaml:"max_queue"`     // 0 = unbounded
+	QueueTimeout time.Duration      `yaml:"queue_timeout"` // 0 = wait for the client
+	TenantHeader string             `yaml:"tenant_header"` // e.g. "X-Tenant-ID"
+	Weights      map[string]float64 `yaml:"weights"`       // tenant -> weight, default 1
+}
+
+// UpstreamConfig holds defaults for connections to backends
+type UpstreamConfig struct {
+	// Protocol used by backends that do not set their own: "auto" (HTTP/2
+	// via ALPN over TLS, else HTTP/1.1), "http1", or "h2c" (cleartext HTTP/2
+	// with prior knowledge, no HTTP/1.1 fallback)
+	Protocol string `yaml:"protocol"`
+
+	// Connection pooling; 0 for max_idle_conns or max_conns_per_host means no limit
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+
+	// Host header sent to backends: the client's Host with preserve_host,
+	// or a fixed value with host_header. The default is the backend address.
+	// The client's Host is always forwarded as X-Forwarded-Host.
+	PreserveHost bool   `yaml:"preserve_host"`
+	HostHeader   string `yaml:"host_header"`
+
+	// Re-resolution interval for backends with resolve set; 0 resolves
+	// them only at startup
+	DNSRefresh time.Duration `yaml:"dns_refresh"`
+}
+
+// PoolOptions returns the upstream connection pool settings
+func (u UpstreamConfig) PoolOptions() proxy.PoolOptions {
+	return proxy.PoolOptions{
+		MaxIdleConns:        u.MaxIdleConns,
+		MaxIdleConnsPerHost: u.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     u.MaxConnsPerHost,
+		IdleConnTimeout:     u.IdleConnTimeout,
+	}
+}
+
+// BodyRoutingConfig routes requests to a subset of the configured backends
+// based on a field in the JSON request body. Requests whose value has no
+// route use the full backend set.
+type BodyRoutingConfig struct {
+	Enabled      bool                `yaml:"enabled"`
+	JSONPath     string              `yaml:"json_path"`      // dot-separated, e.g. "tenant.id"
+	MaxBodyBytes int64               `yaml:"max_body_bytes"` // larger bodies are not inspected
+	Routes       map[string][]string `yaml:"routes"`         // value -> backend addresses
+	Algorithm    string              `yaml:"algorithm"`      // for route pools, default load_balancing.algorithm
+}
+
+// TracingConfig exports OpenTelemetry spans for proxied requests over OTLP/HTTP
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"` // collector host:port, e.g. "localhost:4318"
+	Insecure    bool    `yaml:"insecure"` // plain HTTP to the collector
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"` // fraction of new traces recorded
+}
+
+// OutlierDetectionConfig groups policies that eject misbehaving backends
+// based on the responses they return. Ejected backends are reinstated after
+// an ejection time that grows with each repeated ejection, once they pass a
+// health check when active checks are enabled.
+type OutlierDetectionConfig struct {
+	ErrorRate ErrorRateConfig `yaml:"error_rate"`
+	Deviation DeviationConfig `yaml:"deviation"`
+
+	// Eject a backend after this many 5xx responses in a row; 0 = off
+	Consecutive5xx int `yaml:"consecutive_5xx"`
+
+	// The nth ejection of a backend lasts n times base_ejection_time, up
+	// to max_ejection_time
+	BaseEjectionTime time.Duration `yaml:"base_ejection_time"`
+	MaxEjectionTime  time.Duration `yaml:"max_ejection_time"`
+
+	// Most of the pool that may be ejected at once, as a percentage; the
+	// last available backend is never ejected
+	MaxEjectionPercent int `yaml:"max_ejection_percent"`
+}
+
+// ErrorRateConfig ejects a backend whose 5xx fraction over the rolling window
+// reaches threshold
+type ErrorRateConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Threshold   float64       `yaml:"threshold"`    // 0-1, e.g. 0.5 for 50% 5xx
+	MinRequests int           `yaml:"min_requests"` // responses needed in the window before ejecting
+	Window      time.Duration `yaml:"window"`
+
+	// Older name for outlier_detection.base_ejection_time, which it
+	// overrides when set
+	EjectionTime time.Duration `yaml:"ejection_time"`
+}
+
+// DeviationConfig ejects backends that stand out from the rest of the pool,
+// comparing the backends with at least min_requests responses each interval
+type DeviationConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`
+	MinHosts    int           `yaml:"min_hosts"` // backends needed to compare, at least 2
+	MinRequests int           `yaml:"min_requests"`
+
+	// Eject below the pool's mean success rate minus this many standard
+	// deviations; 0 = off
+	SuccessRateStdevFactor float64 `yaml:"success_rate_stdev_factor"`
+
+	// Eject above this multiple of the pool's median latency; 0 = off
+	LatencyFactor float64 `yaml:"latency_factor"`
+}
+
+// enabled reports whether any outlier detection policy is on
+func (o OutlierDetectionConfig) enabled() bool {
+	return o.ErrorRate.Enabled || o.Consecutive5xx > 0 || o.Deviation.Enabled
+}
+
+// baseEjectionTime returns the ejection time of a first ejection
+func (o OutlierDetectionConfig) baseEjectionTime() time.Duration {
+	if o.ErrorRate.EjectionTime > 0 {
+		return o.ErrorRate.EjectionTime
+	}
+	return o.BaseEjectionTime
+}
+
+// CacheConfig controls in-memory caching of GET responses. Freshness and the
+// stale-while-revalidate window come from the backend's Cache-Control header.
+type CacheConfig struct {
+	Enabled      bool  `yaml:"enabled"`
+	MaxEntries   int   `yaml:"max_entries"`
+	MaxBodyBytes int64 `yaml:"max_body_bytes"` // larger responses are not cached
+
+	// Extra request headers to key on, for responses that depend on headers
+	// the backend does not list in Vary
+	KeyHeaders []CacheKeyHeadersConfig `yaml:"key_headers"`
+}
+
+// CacheKeyHeadersConfig adds request headers to the cache key for paths
+// starting with path_prefix
+type CacheKeyHeadersConfig struct {
+	PathPrefix string   `yaml:"path_prefix"`
+	Headers    []string `yaml:"headers"`
+}
+
+// KeyRules returns the cache key rules for the proxy
+func (c CacheConfig) KeyRules() []proxy.CacheKeyRule {
+	rules := make([]proxy.CacheKeyRule, len(c.KeyHeaders))
+	for i, kh := range c.KeyHeaders {
+		rules[i] = proxy.CacheKeyRule{PathPrefix: kh.PathPrefix, Headers: kh.Headers}
+	}
+	return rules
+}
+
+// ClientIPConfig selects where the client IP is read from. Headers are
+// consulted in order, and only for peers listed in trusted_proxies; without
+// trusted proxies the connection's remote address is always used. With no
+// headers configured, X-Real-IP and X-Forwarded-For are used.
+type ClientIPConfig struct {
+	Headers        []string `yaml:"headers"`         // e.g. ["CF-Connecting-IP", "X-Forwarded-For"]
+	TrustedProxies []string `yaml:"trusted_proxies"` // CIDRs or IPs
 }
 
 // ServerConfig holds the main server settings
 type ServerConfig struct {
-	Listen      string `yaml:"listen"`
-	AdminListen string `yaml:"admin_listen"`
+	Listen        string          `yaml:"listen"`
+	AdminListen   string          `yaml:"admin_listen"`
+	AdminTimeouts TimeoutsConfig  `yaml:"admin_timeouts"`
+	AdminAuth     AdminAuthConfig `yaml:"admin_auth"`
+	AdminCORS     AdminCORSConfig `yaml:"admin_cors"`
+
+	// Optional second admin listener, e.g. on an internal interface, and a
+	// guard that refuses to start unless every admin address is loopback
+	AdminListenInternal   string `yaml:"admin_listen_internal"`
+	AdminBindLoopbackOnly bool   `yaml:"admin_bind_loopback_only"`
+
+	// Serve net/http/pprof profiles under /debug/pprof/ on the admin API
+	AdminPprof bool `yaml:"admin_pprof"`
+
+	// Proxy listener timeouts. write bounds the whole response, so it cuts
+	// off long downloads and streams (SSE, gRPC); set it to 0 when proxying
+	// those. MaxHeaderBytes caps request headers; 0 keeps Go's 1MB default.
+	Timeouts       TimeoutsConfig `yaml:"timeouts"`
+	MaxHeaderBytes int            `yaml:"max_header_bytes"`
+
+	// Terminates TLS from clients when cert_file is set
+	TLS ServerTLSConfig `yaml:"tls"`
+
+	// Ceiling on a proxied request including all retries; 0 = unlimited
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// Socket tuning for client and upstream connections; unset keeps defaults
+	TCPNoDelay  *bool `yaml:"tcp_nodelay"`
+	ReadBuffer  int   `yaml:"read_buffer"`  // SO_RCVBUF in bytes
+	WriteBuffer int   `yaml:"write_buffer"` // SO_SNDBUF in bytes
+
+	// Proxy listener tuning. tcp_keepalive is the keep-alive probe period
+	// for client connections (0 = Go's 15s default, negative disables).
+	// reuse_port sets SO_REUSEPORT so several Hermes processes can share
+	// listen; the kernel spreads connections across them on Linux and
+	// FreeBSD 12+, while other BSDs and macOS favour the newest listener.
+	TCPKeepAlive time.Duration `yaml:"tcp_keepalive"`
+	ReusePort    bool          `yaml:"reuse_port"`
+}
+
+// AdminAddresses returns the addresses the admin API listens on
+func (s ServerConfig) AdminAddresses() []string {
+	var addrs []string
+	for _, addr := range []string{s.AdminListen, s.AdminListenInternal} {
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// SocketOptions returns the socket tuning for proxy connections
+func (s ServerConfig) SocketOptions() proxy.SocketOptions {
+	return proxy.SocketOptions{
+		NoDelay:     s.TCPNoDelay,
+		ReadBuffer:  s.ReadBuffer,
+		WriteBuffer: s.WriteBuffer,
+		KeepAlive:   s.TCPKeepAlive,
+		ReusePort:   s.ReusePort,
+	}
+}
+
+// ServerTLSConfig holds the certificates for serving HTTPS. The first
+// certificate is the default; additional ones are chosen by SNI. Certificates
+// are reloaded from disk on SIGHUP.
+type ServerTLSConfig struct {
+	CertFile     string          `yaml:"cert_file"`
+	KeyFile      string          `yaml:"key_file"`
+	Certificates []TLSCertConfig `yaml:"certificates"`
+	MinVersion   string          `yaml:"min_version"`   // "1.0" to "1.3", default "1.2"
+	CipherSuites []string        `yaml:"cipher_suites"` // crypto/tls names; TLS 1.3 suites are fixed
+}
+
+// Enabled reports whether TLS termination is configured
+func (t ServerTLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+func (t ServerTLSConfig) validate() error {
+	if !t.Enabled() {
+		if t.KeyFile != "" || len(t.Certificates) > 0 {
+			return fmt.Errorf("cert_file is required")
+		}
+		return nil
+	}
+	if t.KeyFile == "" {
+		return fmt.Errorf("key_file is required with cert_file")
+	}
+	for i, cert := range t.Certificates {
+		if cert.CertFile == "" || cert.KeyFile == "" {
+			return fmt.Errorf("certificates[%d]: cert_file and key_file are required", i)
+		}
+	}
+	if _, err := parseTLSVersion(t.MinVersion); err != nil {
+		return err
+	}
+	if _, err := parseCipherSuites(t.CipherSuites); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TLSCertConfig is a certificate and private key pair
+type TLSCertConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// AdminAuthConfig protects the admin API with a bearer token and/or basic auth
+type AdminAuthConfig struct {
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// AdminCORSConfig lets browser dashboards on other origins call the admin API
+type AdminCORSConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	AllowedOrigins []string `yaml:"allowed_origins"` // "*" allows any origin
+	AllowedMethods []string `yaml:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+}
+
+// TimeoutsConfig holds read/write/idle timeouts for an HTTP server
+type TimeoutsConfig struct {
+	Read       time.Duration `yaml:"read"`
+	ReadHeader time.Duration `yaml:"read_header"` // 0 = same as read
+	Write      time.Duration `yaml:"write"`
+	Idle       time.Duration `yaml:"idle"`
+}
+
+// validate checks that no timeout is negative
+func (t TimeoutsConfig) validate() error {
+	if t.Read < 0 || t.ReadHeader < 0 || t.Write < 0 || t.Idle < 0 {
+		return fmt.Errorf("must be non-negative")
+	}
+	return nil
+}
+
+// readHeader returns the timeout for reading request headers
+func (t TimeoutsConfig) readHeader() time.Duration {
+	if t.ReadHeader > 0 {
+		return t.ReadHeader
+	}
+	return t.Read
 }
 
 // BackendConfig defines a single backend server
 type BackendConfig struct {
-	Address string `yaml:"address"`
-	Weight  int    `yaml:"weight"`
+	Address  string `yaml:"address"`
+	Weight   int    `yaml:"weight"`
+	Scheme   string `yaml:"scheme"`   // "http" (default) or "https"
+	Protocol string `yaml:"protocol"` // "auto" (default), "http1" or "h2c"
+
+	// Concurrent requests sent to the backend, however many connections
+	// carry them; 0 = unlimited
+	MaxInflight int `yaml:"max_inflight"`
+
+	// Concurrent connections in use to the backend; 0 = unlimited. Each
+	// in-flight HTTP/1.1 request holds its own connection, so this enforces
+	// the same limit as max_inflight, and the lower of the two applies.
+	MaxConnections int `yaml:"max_connections"`
+
+	// How long a request waits for a free slot when the backend is at its
+	// limit and no other backend has room; 0 = fail at once
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+
+	// Host header for this backend, overriding upstream.preserve_host and
+	// upstream.host_header
+	HostHeader string `yaml:"host_header"`
+
+	// Resolve the hostname every upstream.dns_refresh and balance across
+	// one backend per A/AAAA record, each with this backend's settings
+	Resolve bool `yaml:"resolve"`
+
+	// Circuit breaker settings for this backend; unset fields use the
+	// global circuit_breaker values
+	CircuitBreaker BackendBreakerConfig `yaml:"circuit_breaker"`
+
+	// Health check settings for this backend; unset fields use the global
+	// health_check values
+	HealthCheck BackendHealthCheckConfig `yaml:"health_check"`
+}
+
+// BackendBreakerConfig overrides circuit breaker settings for one backend.
+// Setting failure_threshold also opts the backend out of adaptive thresholds.
+type BackendBreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	SuccessThreshold int           `yaml:"success_threshold"`
+	Timeout          time.Duration `yaml:"timeout"`
+}
+
+// BackendHealthCheckConfig overrides how one backend is health checked
+type BackendHealthCheckConfig struct {
+	Path string `yaml:"path"`
+	Type string `yaml:"type"` // "http" or "tcp"
+}
+
+// inflightLimit returns the effective cap on concurrent requests, 0 = unlimited
+func (b BackendConfig) inflightLimit() int {
+	if b.MaxConnections > 0 && (b.MaxInflight == 0 || b.MaxConnections < b.MaxInflight) {
+		return b.MaxConnections
+	}
+	return b.MaxInflight
+}
+
+// validate checks a single backend definition, using defaultProtocol when
+// the backend does not set one
+func (b BackendConfig) validate(defaultProtocol string) error {
+	if b.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if err := balancer.ValidateAddress(b.Address); err != nil {
+		return err
+	}
+	if b.Weight < 0 {
+		return fmt.Errorf("weight must be non-negative")
+	}
+	if b.MaxInflight < 0 || b.MaxConnections < 0 {
+		return fmt.Errorf("max_inflight and max_connections must be non-negative")
+	}
+	if b.QueueTimeout < 0 {
+		return fmt.Errorf("queue_timeout must be non-negative")
+	}
+	if b.QueueTimeout > 0 && b.inflightLimit() == 0 {
+		return fmt.Errorf("queue_timeout requires max_inflight or max_connections")
+	}
+	if cb := b.CircuitBreaker; cb.FailureThreshold < 0 || cb.SuccessThreshold < 0 || cb.Timeout < 0 {
+		return fmt.Errorf("circuit_breaker settings must be non-negative")
+	}
+	if hc := b.HealthCheck; hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("health_check.path must start with /")
+	}
+	if err := validateCheckType(b.HealthCheck.Type); err != nil {
+		return fmt.Errorf("health_check: %w", err)
+	}
+	if b.Resolve {
+		host, _, _ := net.SplitHostPort(b.Address)
+		if net.ParseIP(host) != nil {
+			return fmt.Errorf("resolve requires a hostname, not an IP address")
+		}
+	}
+	switch b.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("invalid scheme: %s", b.Scheme)
+	}
+	protocol := b.Protocol
+	if protocol == "" {
+		protocol = defaultProtocol
+	}
+	if err := validateProtocol(protocol); err != nil {
+		return err
+	}
+	if protocol == balancer.ProtocolH2C && b.Scheme == "https" {
+		return fmt.Errorf("protocol h2c requires scheme http")
+	}
+	return nil
+}
+
+// validateProtocol checks an upstream protocol name
+func validateProtocol(protocol string) error {
+	switch protocol {
+	case "", balancer.ProtocolAuto, balancer.ProtocolHTTP1, balancer.ProtocolH2C:
+		return nil
+	default:
+		return fmt.Errorf("invalid protocol: %s", protocol)
+	}
+}
+
+// validateCheckType checks a health check type; empty means the default
+func validateCheckType(checkType string) error {
+	switch checkType {
+	case "", health.CheckHTTP, health.CheckTCP:
+		return nil
+	default:
+		return fmt.Errorf("invalid type: %s", checkType)
+	}
+}
+
+// newBackend creates a balancer backend from its configuration, falling back
+// to defaultProtocol when the backend does not set one
+func newBackend(bc BackendConfig, defaultProtocol string) *balancer.Backend {
+	b := balancer.NewBackend(bc.Address, bc.Weight)
+	if bc.Scheme != "" {
+		b.Scheme = bc.Scheme
+	}
+	if bc.Protocol != "" {
+		b.Protocol = bc.Protocol
+	} else if defaultProtocol != "" {
+		b.Protocol = defaultProtocol
+	}
+	b.SetMaxInflight(bc.inflightLimit())
+	b.SetQueueTimeout(bc.QueueTimeout)
+	b.HostHeader = bc.HostHeader
+	return b
+}
+
+// DiscoveryConfig selects where the top-level backends come from: the
+// backends list ("static", the default) or a service registry
+type DiscoveryConfig struct {
+	Provider string                `yaml:"provider"` // "static" or "consul"
+	Consul   ConsulDiscoveryConfig `yaml:"consul"`
+}
+
+// ConsulDiscoveryConfig makes the healthy instances of a Consul service the
+// backends, following changes with blocking queries
+type ConsulDiscoveryConfig struct {
+	Address    string        `yaml:"address"` // agent URL
+	Service    string        `yaml:"service"`
+	Tag        string        `yaml:"tag"`        // only instances with this tag
+	Datacenter string        `yaml:"datacenter"` // default: the agent's
+	Token      string        `yaml:"token"`      // ACL token
+	WaitTime   time.Duration `yaml:"wait_time"`  // longest a blocking query waits
+	Scheme     string        `yaml:"scheme"`     // scheme of the instances, "http" (default) or "https"
+}
+
+// ConsulConfig returns the settings of the Consul provider
+func (c ConsulDiscoveryConfig) ConsulConfig() discovery.ConsulConfig {
+	return discovery.ConsulConfig{
+		Address:    strings.TrimSuffix(c.Address, "/"),
+		Service:    c.Service,
+		Tag:        c.Tag,
+		Datacenter: c.Datacenter,
+		Token:      c.Token,
+		WaitTime:   c.WaitTime,
+	}
 }
 
 // LoadBalancingConfig specifies the load balancing strategy
 type LoadBalancingConfig struct {
-	Algorithm string `yaml:"algorithm"` // "round-robin" or "least-connections"
+	Algorithm string        `yaml:"algorithm"`  // see balancer.Algorithms()
+	SlowStart time.Duration `yaml:"slow_start"` // weight ramp-up window for recovered backends
+
+	// Load minimized by least-connections, weighted-least-connections and
+	// p2c: "requests" (in flight), "connections" (open TCP) or "header"
+	LoadMetric string `yaml:"load_metric"`
+	LoadHeader string `yaml:"load_header"` // response header carrying the backend's load
+
+	// Request header maglev hashes to pick a backend; empty or absent uses
+	// the client IP
+	HashHeader string `yaml:"hash_header"`
+}
+
+// AlgorithmFor returns a group's algorithm, falling back to the global one
+func (l LoadBalancingConfig) AlgorithmFor(group string) string {
+	if group != "" {
+		return group
+	}
+	return l.Algorithm
 }
 
 // HealthCheckConfig controls health checking behavior
@@ -40,9 +682,121 @@ type HealthCheckConfig struct {
 	Enabled            bool          `yaml:"enabled"`
 	Interval           time.Duration `yaml:"interval"`
 	Timeout            time.Duration `yaml:"timeout"`
+	Jitter             float64       `yaml:"jitter"` // fraction of interval, e.g. 0.1 for ±10%
 	Path               string        `yaml:"path"`
+	Type               string        `yaml:"type"`   // "http" (default) or "tcp", which only connects
+	Method             string        `yaml:"method"` // default GET
+	Body               string        `yaml:"body"`   // sent with every check, e.g. a JSON probe
 	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
 	HealthyThreshold   int           `yaml:"healthy_threshold"`
+	RecoveryDecrement  int           `yaml:"recovery_decrement"` // failures forgiven per success, 0 = reset
+	EjectOnMalformed   bool          `yaml:"eject_on_malformed"` // mark unhealthy at once on a malformed response
+	FailOn5xx          bool          `yaml:"fail_on_5xx"`        // shorthand for failure_status: ["500-599"]
+	WarmupConnections  int           `yaml:"warmup_connections"` // connections opened before a recovered backend rejoins
+	StartUnhealthy     bool          `yaml:"start_unhealthy"`    // no traffic to a backend until its first check passes
+
+	// Extra headers and Host override sent only with health check requests
+	Headers map[string]string `yaml:"headers"`
+	Host    string            `yaml:"host"`
+
+	// HTTPS health checks: SNI server name sent, and the name the backend's
+	// certificate must be valid for (a mismatch indicates misrouting)
+	TLSServerName    string `yaml:"tls_server_name"`
+	ExpectedCertName string `yaml:"expected_cert_name"`
+
+	// Response expectations; by default any 2xx/3xx status is healthy
+	ExpectedStatus    []string `yaml:"expected_status"`     // e.g. "200" or "200-299"
+	ExpectedBody      string   `yaml:"expected_body"`       // substring the body must contain
+	ExpectedBodyRegex string   `yaml:"expected_body_regex"` // regex the body must match
+
+	// Proxied response statuses counted as passive and circuit breaker
+	// failures, e.g. "502-504"; the response still reaches the client
+	FailureStatus []string `yaml:"failure_status"`
+
+	// Honor Retry-After on 503 responses by sparing the backend for the
+	// requested time, up to max
+	RetryAfter RetryAfterConfig `yaml:"retry_after"`
+
+	// Representative request a backend must also serve to pass a check
+	Synthetic SyntheticCheckConfig `yaml:"synthetic"`
+}
+
+// SyntheticCheckConfig describes a request sent to each backend after the
+// health path check, exercising a real endpoint rather than /health
+type SyntheticCheckConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Method         string   `yaml:"method"` // default GET
+	Path           string   `yaml:"path"`
+	Body           string   `yaml:"body"`
+	ExpectedStatus []string `yaml:"expected_status"` // default 2xx/3xx
+	ExpectedBody   string   `yaml:"expected_body"`   // substring the body must contain
+}
+
+// Check builds the health checker's synthetic check
+func (s SyntheticCheckConfig) Check() (health.SyntheticCheck, error) {
+	check := health.SyntheticCheck{
+		Method: strings.ToUpper(s.Method),
+		Path:   s.Path,
+		Body:   s.Body,
+	}
+	for _, status := range s.ExpectedStatus {
+		r, err := health.ParseStatusRange(status)
+		if err != nil {
+			return health.SyntheticCheck{}, err
+		}
+		check.ExpectedStatus = append(check.ExpectedStatus, r)
+	}
+	if s.ExpectedBody != "" {
+		check.BodyMatch = regexp.MustCompile(regexp.QuoteMeta(s.ExpectedBody))
+	}
+	return check, nil
+}
+
+// RetryAfterConfig controls backoff requested by backends via Retry-After
+type RetryAfterConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Max     time.Duration `yaml:"max"`
+}
+
+// StatusRanges parses the configured expected status codes
+func (h HealthCheckConfig) StatusRanges() ([]health.StatusRange, error) {
+	ranges := make([]health.StatusRange, 0, len(h.ExpectedStatus))
+	for _, s := range h.ExpectedStatus {
+		r, err := health.ParseStatusRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// FailureStatusRanges parses the statuses that count as backend failures
+func (h HealthCheckConfig) FailureStatusRanges() ([]health.StatusRange, error) {
+	var ranges []health.StatusRange
+	if h.FailOn5xx {
+		ranges = append(ranges, health.StatusRange{Min: 500, Max: 599})
+	}
+	for _, s := range h.FailureStatus {
+		r, err := health.ParseStatusRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// BodyMatcher compiles the configured body expectation, or returns nil if none
+func (h HealthCheckConfig) BodyMatcher() (*regexp.Regexp, error) {
+	switch {
+	case h.ExpectedBodyRegex != "":
+		return regexp.Compile(h.ExpectedBodyRegex)
+	case h.ExpectedBody != "":
+		return regexp.MustCompile(regexp.QuoteMeta(h.ExpectedBody)), nil
+	default:
+		return nil, nil
+	}
 }
 
 // CircuitBreakerConfig controls circuit breaker behavior
@@ -51,11 +805,144 @@ type CircuitBreakerConfig struct {
 	FailureThreshold int           `yaml:"failure_threshold"`
 	SuccessThreshold int           `yaml:"success_threshold"`
 	Timeout          time.Duration `yaml:"timeout"`
+
+	Adaptive AdaptiveBreakerConfig `yaml:"adaptive"`
+	Backoff  BreakerBackoffConfig  `yaml:"backoff"`
+}
+
+// BreakerBackoffConfig grows the open period after each failed half-open
+// probe, by multiplier up to max, and resets it once the circuit closes.
+// Base replaces timeout as the initial open period when set.
+type BreakerBackoffConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Base       time.Duration `yaml:"base"`
+	Multiplier float64       `yaml:"multiplier"`
+	Max        time.Duration `yaml:"max"`
+}
+
+// openTimeout returns the initial open period of a breaker
+func (c CircuitBreakerConfig) openTimeout() time.Duration {
+	if c.Backoff.Enabled && c.Backoff.Base > 0 {
+		return c.Backoff.Base
+	}
+	return c.Timeout
+}
+
+// AdaptiveBreakerConfig derives the failure threshold from recent traffic
+// volume, within the given bounds, instead of using failure_threshold as is
+type AdaptiveBreakerConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	Window              time.Duration `yaml:"window"`
+	FailureRatio        float64       `yaml:"failure_ratio"` // threshold as a fraction of requests per window
+	MinFailureThreshold int           `yaml:"min_failure_threshold"`
+	MaxFailureThreshold int           `yaml:"max_failure_threshold"`
 }
 
 // BufferConfig controls request buffering
 type BufferConfig struct {
 	MaxRequestBody int64 `yaml:"max_request_body"`
+
+	// Bodies larger than this many bytes are buffered in a temporary file
+	// (under $TMPDIR) instead of memory; 0 keeps every body in memory
+	DiskThreshold int64 `yaml:"disk_threshold"`
+
+	// Handling of chunked bodies without Content-Length: "buffer" sends them
+	// upstream with a Content-Length; "stream" keeps them chunked, unbuffered
+	// and without retries
+	ChunkedRequests string `yaml:"chunked_requests"`
+
+	// Buffer request bodies so failed attempts can be retried. When false,
+	// every body is forwarded as it arrives and no request is retried.
+	Enabled bool `yaml:"enabled"`
+}
+
+// RetryConfig controls retrying failed requests on other backends
+type RetryConfig struct {
+	MaxRetries  int           `yaml:"max_retries"`  // additional distinct backends tried per request
+	BackoffBase time.Duration `yaml:"backoff_base"` // delay before the first retry, doubling after; 0 retries at once
+	BackoffMax  time.Duration `yaml:"backoff_max"`
+	Jitter      string        `yaml:"jitter"` // "none", "equal" or "full"
+
+	// Only retry failures before a connection was established, which are
+	// safe to retry for any method
+	ConnectionFailuresOnly bool `yaml:"connection_failures_only"`
+
+	// Also retry POST, PATCH and other non-idempotent requests after
+	// failures that may have reached the backend. Off by default: they are
+	// retried only after failing to connect, unless they carry an
+	// Idempotency-Key header.
+	NonIdempotent bool `yaml:"non_idempotent"`
+}
+
+// CompressionConfig controls gzip compression of proxied responses and,
+// under requests, of request bodies sent to backends
+type CompressionConfig struct {
+	Enabled      bool                     `yaml:"enabled"`
+	MinSize      int64                    `yaml:"min_size"`
+	ContentTypes []string                 `yaml:"content_types"`
+	Requests     RequestCompressionConfig `yaml:"requests"`
+}
+
+// RequestCompressionConfig controls gzip compression of buffered request
+// bodies for backends that advertise accepting it with an Accept-Encoding
+// response header
+type RequestCompressionConfig struct {
+	Enabled bool  `yaml:"enabled"`
+	MinSize int64 `yaml:"min_size"` // smaller bodies are sent as-is
+}
+
+// HeaderLimitsConfig controls per-client request header size accounting
+type HeaderLimitsConfig struct {
+	MaxRequestBytes int64         `yaml:"max_request_bytes"` // per request, 0 = unlimited
+	MaxWindowBytes  int64         `yaml:"max_window_bytes"`  // per client per window, 0 = unlimited
+	Window          time.Duration `yaml:"window"`
+}
+
+// ErrorPageConfig defines a custom error response. Keys in the error_pages
+// map are status codes ("502"), "no_backend" or "maintenance". The body is a Go template
+// with {{.Status}}, {{.StatusText}} and {{.RequestID}} available.
+type ErrorPageConfig struct {
+	Status      int    `yaml:"status"` // overrides the response status, 0 keeps it
+	ContentType string `yaml:"content_type"`
+	Body        string `yaml:"body"` // inline template
+	File        string `yaml:"file"` // template file, used instead of body
+}
+
+// LoggingConfig controls the process log and request logging
+type LoggingConfig struct {
+	Level     string `yaml:"level"`      // debug, info, warn or error
+	Format    string `yaml:"format"`     // text (logfmt) or json
+	AccessLog bool   `yaml:"access_log"` // log each proxied request with its outcome, at info level
+}
+
+// RateLimitConfig controls token-bucket rate limiting by client IP
+type RateLimitConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"` // per client, 0 = no per-client limit
+	Burst             int     `yaml:"burst"`
+	GlobalRPS         float64 `yaml:"global_requests_per_second"` // across all clients, 0 = unlimited
+	GlobalBurst       int     `yaml:"global_burst"`
+	MaxClients        int     `yaml:"max_clients"` // bound on tracked client buckets
+}
+
+// RegionsConfig defines a multi-region failover policy. When pools are
+// configured they replace the top-level backends list.
+type RegionsConfig struct {
+	Local      string         `yaml:"local"`       // preferred region, always tried first
+	DNSRefresh time.Duration  `yaml:"dns_refresh"` // re-resolution interval for DNS pools
+	Pools      []RegionConfig `yaml:"pools"`
+}
+
+// RegionConfig defines one region's backend pool
+type RegionConfig struct {
+	Name     string          `yaml:"name"`
+	Priority int             `yaml:"priority"` // lower is preferred after the local region
+	Backends []BackendConfig `yaml:"backends"`
+	DNS      string          `yaml:"dns"`    // host:port resolved to backend addresses
+	Weight   int             `yaml:"weight"` // weight for DNS-discovered backends
+
+	// Algorithm balancing this pool, default load_balancing.algorithm
+	Algorithm string `yaml:"algorithm"`
 }
 
 // DefaultConfig returns sensible default configuration
@@ -64,47 +951,345 @@ func DefaultConfig() *Config {
 		Server: ServerConfig{
 			Listen:      ":8080",
 			AdminListen: ":8081",
+			Timeouts: TimeoutsConfig{
+				Read:  30 * time.Second,
+				Write: 30 * time.Second,
+				Idle:  60 * time.Second,
+			},
+			AdminTimeouts: TimeoutsConfig{
+				Read:  10 * time.Second,
+				Write: 10 * time.Second,
+				Idle:  60 * time.Second,
+			},
+			AdminCORS: AdminCORSConfig{
+				AllowedMethods: []string{"GET", "POST", "DELETE"},
+				AllowedHeaders: []string{"Authorization", "Content-Type"},
+			},
 		},
 		LoadBalancing: LoadBalancingConfig{
-			Algorithm: "round-robin",
+			Algorithm:  "round-robin",
+			LoadMetric: balancer.LoadRequests,
 		},
 		HealthCheck: HealthCheckConfig{
 			Enabled:            true,
 			Interval:           10 * time.Second,
 			Timeout:            2 * time.Second,
 			Path:               "/health",
+			Type:               health.CheckHTTP,
 			UnhealthyThreshold: 3,
 			HealthyThreshold:   2,
+			RetryAfter: RetryAfterConfig{
+				Max: 60 * time.Second,
+			},
 		},
 		CircuitBreaker: CircuitBreakerConfig{
 			Enabled:          true,
 			FailureThreshold: 5,
 			SuccessThreshold: 3,
 			Timeout:          30 * time.Second,
+			Adaptive: AdaptiveBreakerConfig{
+				Window:              10 * time.Second,
+				FailureRatio:        0.05,
+				MinFailureThreshold: 5,
+				MaxFailureThreshold: 50,
+			},
+			Backoff: BreakerBackoffConfig{
+				Multiplier: 2,
+				Max:        5 * time.Minute,
+			},
+		},
+		Events: EventsConfig{
+			Timeout:    5 * time.Second,
+			MaxRetries: 3,
+			QueueSize:  100,
 		},
 		Buffer: BufferConfig{
-			MaxRequestBody: 10 * 1024 * 1024, // 10MB
+			Enabled:         true,
+			MaxRequestBody:  10 * 1024 * 1024, // 10MB
+			ChunkedRequests: "buffer",
+		},
+		Retry: RetryConfig{
+			MaxRetries: 2,
+			BackoffMax: time.Second,
+			Jitter:     "full",
+		},
+		Compression: CompressionConfig{
+			Enabled: false,
+			MinSize: 1024,
+			ContentTypes: []string{
+				"text/html",
+				"text/plain",
+				"text/css",
+				"text/xml",
+				"application/json",
+				"application/javascript",
+				"application/xml",
+			},
+			Requests: RequestCompressionConfig{
+				MinSize: 1024,
+			},
+		},
+		HeaderLimits: HeaderLimitsConfig{
+			Window: time.Minute,
+		},
+		Regions: RegionsConfig{
+			DNSRefresh: 30 * time.Second,
+		},
+		Discovery: DiscoveryConfig{
+			Provider: discovery.ProviderStatic,
+			Consul: ConsulDiscoveryConfig{
+				Address:  "http://127.0.0.1:8500",
+				WaitTime: 5 * time.Minute,
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:           false,
+			RequestsPerSecond: 10,
+			Burst:             20,
+			MaxClients:        10000,
+		},
+		Cache: CacheConfig{
+			Enabled:      false,
+			MaxEntries:   1000,
+			MaxBodyBytes: 1024 * 1024, // 1MB
+		},
+		Upstream: UpstreamConfig{
+			MaxIdleConnsPerHost: proxy.DefaultPoolOptions().MaxIdleConnsPerHost,
+			IdleConnTimeout:     proxy.DefaultPoolOptions().IdleConnTimeout,
+			DNSRefresh:          30 * time.Second,
+		},
+		Concurrency: ConcurrencyConfig{
+			Enabled:      false,
+			MaxActive:    1000,
+			MaxQueue:     10000,
+			QueueTimeout: 10 * time.Second,
+		},
+		LoadShedding: LoadSheddingConfig{
+			Enabled:  false,
+			MaxCPU:   0.9,
+			Interval: time.Second,
+			Features: []string{"compression", "access_log", "tracing"},
+		},
+		BodyRouting: BodyRoutingConfig{
+			Enabled:      false,
+			MaxBodyBytes: 64 * 1024, // 64KB
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: logging.FormatText,
+		},
+		Tracing: TracingConfig{
+			Enabled:     false,
+			Endpoint:    "localhost:4318",
+			ServiceName: "hermes",
+			SampleRatio: 1.0,
+		},
+		Outliers: OutlierDetectionConfig{
+			ErrorRate: ErrorRateConfig{
+				Enabled:     false,
+				Threshold:   0.5,
+				MinRequests: 20,
+				Window:      30 * time.Second,
+			},
+			Deviation: DeviationConfig{
+				Enabled:                false,
+				Interval:               10 * time.Second,
+				MinHosts:               3,
+				MinRequests:            20,
+				SuccessRateStdevFactor: 1.9,
+			},
+			BaseEjectionTime:   30 * time.Second,
+			MaxEjectionTime:    5 * time.Minute,
+			MaxEjectionPercent: 50,
 		},
 	}
 }
 
-// LoadConfig reads configuration from a YAML file
+// LoadConfig reads configuration from a YAML file. Files listed under
+// include, resolved relative to the including file, are applied first in
+// the order listed, and the including file last. Later files replace scalars
+// and lists set by earlier ones, and merge into mappings key by key.
+// Validation runs once on the merged result.
 func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	if err := loadInto(config, path, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadInto applies the file at path, after the files it includes, onto
+// config. active holds the files currently being loaded, to detect cycles.
+func loadInto(config *Config, path string, active map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if active[abs] {
+		return fmt.Errorf("config include cycle at %s", path)
+	}
+	active[abs] = true
+	defer delete(active, abs)
+
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	data, err = expandEnv(data)
+	if err != nil {
+		return fmt.Errorf("failed to expand config file %s: %w", path, err)
+	}
+
+	var header struct {
+		Include []string `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for _, include := range header.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := loadInto(config, include, active); err != nil {
+			return err
+		}
 	}
 
-	config := DefaultConfig()
 	if err := yaml.Unmarshal(data, config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
 	}
+	return nil
+}
 
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+// redacted replaces secret values in the effective configuration
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with credentials and health
+// check header values masked, suitable for exposing over the admin API
+func (c *Config) Redacted() *Config {
+	out := *c
+	if out.Server.AdminAuth.Token != "" {
+		out.Server.AdminAuth.Token = redacted
 	}
+	if out.Server.AdminAuth.Password != "" {
+		out.Server.AdminAuth.Password = redacted
+	}
+	if out.Events.WebhookURL != "" {
+		// Webhook URLs often embed their credentials
+		out.Events.WebhookURL = redacted
+	}
+	if out.Discovery.Consul.Token != "" {
+		out.Discovery.Consul.Token = redacted
+	}
+	// Header values often carry API keys
+	out.HealthCheck.Headers = redactValues(c.HealthCheck.Headers)
+	out.Headers.Request.Set = redactValues(c.Headers.Request.Set)
+	out.Headers.Request.Add = redactValues(c.Headers.Request.Add)
+	out.Headers.Response.Set = redactValues(c.Headers.Response.Set)
+	out.Headers.Response.Add = redactValues(c.Headers.Response.Add)
+	return &out
+}
 
-	return config, nil
+// redactValues returns a copy of m with every value masked
+func redactValues(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return m
+	}
+	out := make(map[string]string, len(m))
+	for key := range m {
+		out[key] = redacted
+	}
+	return out
+}
+
+// expandEnv substitutes ${VAR} and ${VAR:-default} with environment
+// variables; "$$" yields a literal "$". A variable that is unset and has no
+// default is an error. Comments are copied unchanged, so a commented-out
+// example does not need its variables set.
+func expandEnv(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var quote byte // the open quote of a quoted scalar, if any
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case quote == '"' && c == '\\' && i+1 < len(data),
+			quote == '\'' && c == '\'' && i+1 < len(data) && data[i+1] == '\'':
+			out.WriteByte(c)
+			out.WriteByte(data[i+1])
+			i++
+			continue
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\'') && startsScalar(data, i):
+			quote = c
+		case quote == 0 && c == '#' && (i == 0 || isSpace(data[i-1])):
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				end = len(data) - i
+			}
+			out.Write(data[i : i+end])
+			i += end - 1
+			continue
+		}
+
+		if c != '$' || i+1 >= len(data) {
+			out.WriteByte(c)
+			continue
+		}
+
+		switch data[i+1] {
+		case '$':
+			out.WriteByte('$')
+			i++
+		case '{':
+			end := bytes.IndexByte(data[i+2:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable reference at offset %d", i)
+			}
+			expr := string(data[i+2 : i+2+end])
+			name, def, hasDefault := strings.Cut(expr, ":-")
+			if name == "" {
+				return nil, fmt.Errorf("empty variable name at offset %d", i)
+			}
+
+			value, ok := os.LookupEnv(name)
+			switch {
+			case ok && (value != "" || !hasDefault):
+				out.WriteString(value)
+			case hasDefault:
+				out.WriteString(def)
+			default:
+				return nil, fmt.Errorf("environment variable %s is not set", name)
+			}
+			i += 2 + end
+		default:
+			out.WriteByte('$')
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// startsScalar reports whether a quote at data[i] opens a quoted scalar
+// rather than sitting inside a plain one, as in "it's"
+func startsScalar(data []byte, i int) bool {
+	if i == 0 {
+		return true
+	}
+	switch data[i-1] {
+	case ' ', '\t', '\n', '\r', ':', ',', '[', '{', '-':
+		return true
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
 }
 
 // Validate checks if the configuration is valid
@@ -113,26 +1298,546 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("server.listen is required")
 	}
 
-	if len(c.Backends) == 0 {
+	if c.Server.AdminListenInternal != "" && c.Server.AdminListen == "" {
+		return fmt.Errorf("server.admin_listen_internal requires server.admin_listen")
+	}
+	if c.Server.AdminBindLoopbackOnly {
+		for _, addr := range c.Server.AdminAddresses() {
+			if !isLoopback(addr) {
+				return fmt.Errorf("server.admin_bind_loopback_only is set but admin address %s is not loopback", addr)
+			}
+		}
+	}
+
+	if auth := c.Server.AdminAuth; auth.Username != "" && auth.Password == "" {
+		return fmt.Errorf("server.admin_auth.password is required when username is set")
+	}
+
+	if cors := c.Server.AdminCORS; cors.Enabled && len(cors.AllowedOrigins) == 0 {
+		return fmt.Errorf("server.admin_cors.allowed_origins is required when admin_cors is enabled")
+	}
+
+	if err := c.Server.TLS.validate(); err != nil {
+		return fmt.Errorf("server.tls: %w", err)
+	}
+
+	if c.Server.RequestTimeout < 0 {
+		return fmt.Errorf("server.request_timeout must be non-negative")
+	}
+
+	if err := c.Server.Timeouts.validate(); err != nil {
+		return fmt.Errorf("server.timeouts %w", err)
+	}
+	if err := c.Server.AdminTimeouts.validate(); err != nil {
+		return fmt.Errorf("server.admin_timeouts %w", err)
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server.max_header_bytes must be non-negative")
+	}
+
+	if err := c.validateDiscovery(); err != nil {
+		return err
+	}
+	if len(c.Regions.Pools) > 0 {
+		if err := c.Regions.validate(c.Upstream.Protocol); err != nil {
+			return err
+		}
+		if len(c.Backends) > 0 {
+			return fmt.Errorf("backends and regions.pools are mutually exclusive")
+		}
+	} else if len(c.Backends) == 0 && c.Discovery.Provider != discovery.ProviderConsul {
 		return fmt.Errorf("at least one backend is required")
 	}
 
 	for i, backend := range c.Backends {
-		if backend.Address == "" {
-			return fmt.Errorf("backend[%d].address is required", i)
+		if err := backend.validate(c.Upstream.Protocol); err != nil {
+			return fmt.Errorf("backend[%d]: %w", i, err)
 		}
-		if backend.Weight < 0 {
-			return fmt.Errorf("backend[%d].weight must be non-negative", i)
+	}
+
+	if !balancer.IsValidAlgorithm(c.LoadBalancing.Algorithm) {
+		return fmt.Errorf("invalid load balancing algorithm: %s", c.LoadBalancing.Algorithm)
+	}
+
+	if c.Retry.BackoffBase < 0 || c.Retry.BackoffMax < 0 {
+		return fmt.Errorf("retry backoff durations must be non-negative")
+	}
+	if _, err := proxy.NewBackoff(c.Retry.BackoffBase, c.Retry.BackoffMax, c.Retry.Jitter); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+
+	if u := c.Upstream; u.MaxIdleConns < 0 || u.MaxIdleConnsPerHost < 0 || u.MaxConnsPerHost < 0 || u.IdleConnTimeout < 0 {
+		return fmt.Errorf("upstream connection pool settings must be non-negative")
+	}
+	if c.Upstream.DNSRefresh < 0 {
+		return fmt.Errorf("upstream.dns_refresh must be non-negative")
+	}
+
+	if c.Upstream.PreserveHost && c.Upstream.HostHeader != "" {
+		return fmt.Errorf("upstream.preserve_host and upstream.host_header are mutually exclusive")
+	}
+
+	if err := validateProtocol(c.Upstream.Protocol); err != nil {
+		return fmt.Errorf("upstream: %w", err)
+	}
+
+	if c.Buffer.DiskThreshold < 0 {
+		return fmt.Errorf("buffer.disk_threshold must be non-negative")
+	}
+
+	if c.Server.ReadBuffer < 0 || c.Server.WriteBuffer < 0 {
+		return fmt.Errorf("server.read_buffer and server.write_buffer must be non-negative")
+	}
+	if c.Server.ReusePort && !proxy.ReusePortSupported {
+		return fmt.Errorf("server.reuse_port is not supported on %s", runtime.GOOS)
+	}
+
+	switch c.Buffer.ChunkedRequests {
+	case "", "buffer", "stream":
+	default:
+		return fmt.Errorf("invalid buffer.chunked_requests: %s", c.Buffer.ChunkedRequests)
+	}
+
+	if c.Compression.MinSize < 0 {
+		return fmt.Errorf("compression.min_size must be non-negative")
+	}
+	if rc := c.Compression.Requests; rc.Enabled {
+		if rc.MinSize < 0 {
+			return fmt.Errorf("compression.requests.min_size must be non-negative")
+		}
+		// The whole body is needed before it can be compressed
+		if !c.Buffer.Enabled {
+			return fmt.Errorf("compression.requests requires buffer.enabled")
 		}
 	}
 
-	validAlgorithms := map[string]bool{
-		"round-robin":       true,
-		"least-connections": true,
+	if a := c.CircuitBreaker.Adaptive; a.Enabled {
+		if a.Window <= 0 || a.FailureRatio <= 0 || a.FailureRatio > 1 {
+			return fmt.Errorf("circuit_breaker.adaptive requires a positive window and a failure_ratio between 0 and 1")
+		}
+		if a.MinFailureThreshold < 1 || a.MaxFailureThreshold < a.MinFailureThreshold {
+			return fmt.Errorf("circuit_breaker.adaptive thresholds must satisfy 1 <= min_failure_threshold <= max_failure_threshold")
+		}
 	}
-	if !validAlgorithms[c.LoadBalancing.Algorithm] {
-		return fmt.Errorf("invalid load balancing algorithm: %s", c.LoadBalancing.Algorithm)
+
+	if b := c.CircuitBreaker.Backoff; b.Enabled {
+		if b.Base < 0 || b.Multiplier < 1 {
+			return fmt.Errorf("circuit_breaker.backoff requires a non-negative base and a multiplier of at least 1")
+		}
+		if b.Max < c.CircuitBreaker.openTimeout() {
+			return fmt.Errorf("circuit_breaker.backoff.max must be at least the base timeout")
+		}
+	}
+
+	if c.Cache.Enabled && (c.Cache.MaxEntries <= 0 || c.Cache.MaxBodyBytes <= 0) {
+		return fmt.Errorf("cache.max_entries and cache.max_body_bytes must be positive")
+	}
+	for i, kh := range c.Cache.KeyHeaders {
+		if !strings.HasPrefix(kh.PathPrefix, "/") || len(kh.Headers) == 0 {
+			return fmt.Errorf("cache.key_headers[%d]: path_prefix must start with / and headers must not be empty", i)
+		}
+	}
+
+	if err := c.Outliers.validate(); err != nil {
+		return err
+	}
+
+	if shed := c.LoadShedding; shed.Enabled {
+		if shed.MaxActiveRequests < 0 || shed.MaxCPU < 0 || shed.MaxCPU > 1 {
+			return fmt.Errorf("load_shedding thresholds must be non-negative, and max_cpu at most 1")
+		}
+		if shed.Interval <= 0 {
+			return fmt.Errorf("load_shedding.interval must be positive")
+		}
+		if _, err := proxy.NewLoadShedder(shed.MaxActiveRequests, shed.MaxCPU, shed.Features); err != nil {
+			return fmt.Errorf("load_shedding: %w", err)
+		}
 	}
 
+	if err := c.Headers.Request.Rules().Validate(); err != nil {
+		return fmt.Errorf("headers.request: %w", err)
+	}
+	if err := c.Headers.Response.Rules().Validate(); err != nil {
+		return fmt.Errorf("headers.response: %w", err)
+	}
+
+	if cc := c.Concurrency; cc.Enabled {
+		if cc.MaxQueue < 0 || cc.QueueTimeout < 0 {
+			return fmt.Errorf("concurrency.max_queue and concurrency.queue_timeout must be non-negative")
+		}
+		if _, err := proxy.NewFairQueue(cc.MaxActive, cc.MaxQueue, cc.QueueTimeout, cc.TenantHeader, cc.Weights); err != nil {
+			return fmt.Errorf("concurrency: %w", err)
+		}
+	}
+
+	if c.BodyRouting.Enabled {
+		if !c.Buffer.Enabled {
+			return fmt.Errorf("body_routing requires buffer.enabled")
+		}
+		if err := c.validateBodyRouting(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := logging.ParseLevel(c.Logging.Level); err != nil {
+		return fmt.Errorf("logging.level: %w", err)
+	}
+	if f := c.Logging.Format; f != "" && f != logging.FormatText && f != logging.FormatJSON {
+		return fmt.Errorf("logging.format must be %q or %q", logging.FormatText, logging.FormatJSON)
+	}
+
+	if c.Tracing.Enabled {
+		if c.Tracing.Endpoint == "" {
+			return fmt.Errorf("tracing.endpoint is required when tracing is enabled")
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+		}
+	}
+
+	if len(c.ClientIP.Headers) > 0 && len(c.ClientIP.TrustedProxies) == 0 {
+		return fmt.Errorf("client_ip.headers requires client_ip.trusted_proxies")
+	}
+	if _, err := proxy.NewClientIPResolver(c.ClientIP.Headers, c.ClientIP.TrustedProxies); err != nil {
+		return fmt.Errorf("client_ip: %w", err)
+	}
+
+	if e := c.Events; e.WebhookURL != "" {
+		if u, err := url.Parse(e.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("events.webhook_url must be an http or https URL")
+		}
+		if e.Timeout <= 0 || e.QueueSize <= 0 || e.MaxRetries < 0 {
+			return fmt.Errorf("events requires a positive timeout and queue_size and non-negative max_retries")
+		}
+	}
+
+	if _, err := proxy.NewMaintenanceAllowlist(c.Maintenance.AllowPaths, c.Maintenance.AllowIPs); err != nil {
+		return fmt.Errorf("maintenance: %w", err)
+	}
+
+	if _, err := c.AccessControl.Build(); err != nil {
+		return fmt.Errorf("access_control: %w", err)
+	}
+
+	for key, page := range c.ErrorPages {
+		if key != proxy.ErrorPageNoBackend && key != proxy.ErrorPageMaintenance {
+			if code, err := strconv.Atoi(key); err != nil || code < 400 || code > 599 {
+				return fmt.Errorf("error_pages: invalid key %q", key)
+			}
+		}
+		if page.Status != 0 && (page.Status < 400 || page.Status > 599) {
+			return fmt.Errorf("error_pages.%s.status must be a 4xx or 5xx code", key)
+		}
+		if (page.Body == "") == (page.File == "") {
+			return fmt.Errorf("error_pages.%s must set exactly one of body or file", key)
+		}
+	}
+
+	if rl := c.RateLimit; rl.Enabled {
+		if rl.RequestsPerSecond < 0 || rl.GlobalRPS < 0 {
+			return fmt.Errorf("rate_limit rates must be non-negative")
+		}
+		if rl.RequestsPerSecond > 0 && rl.Burst < 1 {
+			return fmt.Errorf("rate_limit.burst must be at least 1")
+		}
+		if rl.GlobalRPS > 0 && rl.GlobalBurst < 1 {
+			return fmt.Errorf("rate_limit.global_burst must be at least 1")
+		}
+	}
+
+	if hl := c.HeaderLimits; hl.MaxRequestBytes < 0 || hl.MaxWindowBytes < 0 {
+		return fmt.Errorf("header_limits byte limits must be non-negative")
+	}
+	if c.HeaderLimits.MaxWindowBytes > 0 && c.HeaderLimits.Window <= 0 {
+		return fmt.Errorf("header_limits.window must be positive when max_window_bytes is set")
+	}
+
+	if c.LoadBalancing.SlowStart < 0 {
+		return fmt.Errorf("load_balancing.slow_start must be non-negative")
+	}
+	if _, err := balancer.ParseLoadMetric(c.LoadBalancing.LoadMetric); err != nil {
+		return fmt.Errorf("load_balancing: %w", err)
+	}
+	if c.LoadBalancing.LoadMetric == balancer.LoadHeader && c.LoadBalancing.LoadHeader == "" {
+		return fmt.Errorf("load_balancing.load_header is required for the header load metric")
+	}
+	if c.LoadBalancing.HashHeader != "" && !c.usesAlgorithm("maglev") {
+		return fmt.Errorf("load_balancing.hash_header requires the maglev algorithm")
+	}
+
+	if c.Shutdown.PreStopDelay < 0 {
+		return fmt.Errorf("shutdown.pre_stop_delay must be non-negative")
+	}
+
+	if c.Retry.MaxRetries < 0 {
+		return fmt.Errorf("retry.max_retries must be non-negative")
+	}
+
+	if err := validateCheckType(c.HealthCheck.Type); err != nil {
+		return fmt.Errorf("health_check: %w", err)
+	}
+	if c.HealthCheck.Jitter < 0 || c.HealthCheck.Jitter >= 1 {
+		return fmt.Errorf("health_check.jitter must be in the range [0, 1)")
+	}
+	if c.HealthCheck.RecoveryDecrement < 0 {
+		return fmt.Errorf("health_check.recovery_decrement must be non-negative")
+	}
+	if c.HealthCheck.WarmupConnections < 0 {
+		return fmt.Errorf("health_check.warmup_connections must be non-negative")
+	}
+	if c.HealthCheck.StartUnhealthy && !c.HealthCheck.Enabled {
+		return fmt.Errorf("health_check.start_unhealthy requires health_check.enabled")
+	}
+	if c.HealthCheck.RetryAfter.Enabled && c.HealthCheck.RetryAfter.Max <= 0 {
+		return fmt.Errorf("health_check.retry_after.max must be positive")
+	}
+
+	if _, err := c.HealthCheck.StatusRanges(); err != nil {
+		return fmt.Errorf("health_check.expected_status: %w", err)
+	}
+	if _, err := c.HealthCheck.FailureStatusRanges(); err != nil {
+		return fmt.Errorf("health_check.failure_status: %w", err)
+	}
+	if c.HealthCheck.ExpectedBody != "" && c.HealthCheck.ExpectedBodyRegex != "" {
+		return fmt.Errorf("health_check.expected_body and expected_body_regex are mutually exclusive")
+	}
+	if _, err := c.HealthCheck.BodyMatcher(); err != nil {
+		return fmt.Errorf("health_check.expected_body_regex: %w", err)
+	}
+	if synthetic := c.HealthCheck.Synthetic; synthetic.Enabled {
+		if !c.HealthCheck.Enabled {
+			return fmt.Errorf("health_check.synthetic requires health_check.enabled")
+		}
+		if !strings.HasPrefix(synthetic.Path, "/") {
+			return fmt.Errorf("health_check.synthetic.path must start with /")
+		}
+		if _, err := synthetic.Check(); err != nil {
+			return fmt.Errorf("health_check.synthetic.expected_status: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validateDiscovery checks the backend provider and its settings
+func (c *Config) validateDiscovery() error {
+	switch c.Discovery.Provider {
+	case "", discovery.ProviderStatic:
+		return nil
+	case discovery.ProviderConsul:
+	default:
+		return fmt.Errorf("discovery.provider must be %q or %q", discovery.ProviderStatic, discovery.ProviderConsul)
+	}
+
+	if len(c.Backends) > 0 || len(c.Regions.Pools) > 0 {
+		return fmt.Errorf("discovery.provider consul replaces backends and regions.pools; remove them")
+	}
+	consul := c.Discovery.Consul
+	switch consul.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("discovery.consul.scheme must be http or https")
+	}
+	if _, err := discovery.NewConsul(consul.ConsulConfig(), nil); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	return nil
+}
+
+// validate checks the region pools and the local region reference
+func (r *RegionsConfig) validate(defaultProtocol string) error {
+	names := make(map[string]bool)
+	for i, pool := range r.Pools {
+		if pool.Name == "" {
+			return fmt.Errorf("regions.pools[%d].name is required", i)
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("duplicate region name: %s", pool.Name)
+		}
+		names[pool.Name] = true
+
+		if (len(pool.Backends) == 0) == (pool.DNS == "") {
+			return fmt.Errorf("region %s must set exactly one of backends or dns", pool.Name)
+		}
+		if pool.Algorithm != "" && !balancer.IsValidAlgorithm(pool.Algorithm) {
+			return fmt.Errorf("region %s: invalid load balancing algorithm: %s", pool.Name, pool.Algorithm)
+		}
+		for j, backend := range pool.Backends {
+			if err := backend.validate(defaultProtocol); err != nil {
+				return fmt.Errorf("region %s backend[%d]: %w", pool.Name, j, err)
+			}
+			if backend.Resolve {
+				return fmt.Errorf("region %s backend[%d]: resolve is not supported in regions; use the pool's dns", pool.Name, j)
+			}
+		}
+	}
+
+	if r.Local != "" && !names[r.Local] {
+		return fmt.Errorf("regions.local %q does not match any pool", r.Local)
+	}
+	if r.DNSRefresh < 0 {
+		return fmt.Errorf("regions.dns_refresh must be non-negative")
+	}
+	return nil
+}
+
+// BuildErrorPages loads and parses the configured error pages
+func (c *Config) BuildErrorPages() (map[string]*proxy.ErrorPage, error) {
+	pages := make(map[string]*proxy.ErrorPage, len(c.ErrorPages))
+	for key, pc := range c.ErrorPages {
+		body := pc.Body
+		if pc.File != "" {
+			data, err := os.ReadFile(pc.File)
+			if err != nil {
+				return nil, fmt.Errorf("error_pages.%s: %w", key, err)
+			}
+			body = string(data)
+		}
+
+		page, err := proxy.NewErrorPage(pc.Status, pc.ContentType, body)
+		if err != nil {
+			return nil, fmt.Errorf("error_pages.%s: %w", key, err)
+		}
+		pages[key] = page
+	}
+	return pages, nil
+}
+
+// BreakerOverrides returns the per-backend circuit breaker settings, keyed
+// by address, for every backend that sets any
+func (c *Config) BreakerOverrides() map[string]circuit.Override {
+	overrides := make(map[string]circuit.Override)
+	add := func(b BackendConfig) {
+		if cb := b.CircuitBreaker; cb != (BackendBreakerConfig{}) {
+			overrides[b.Address] = circuit.Override{
+				FailureThreshold: cb.FailureThreshold,
+				SuccessThreshold: cb.SuccessThreshold,
+				Timeout:          cb.Timeout,
+			}
+		}
+	}
+	for _, b := range c.Backends {
+		add(b)
+	}
+	for _, pool := range c.Regions.Pools {
+		for _, b := range pool.Backends {
+			add(b)
+		}
+	}
+	return overrides
+}
+
+// validate checks the outlier detection policies
+func (o OutlierDetectionConfig) validate() error {
+	if errorRate := o.ErrorRate; errorRate.Enabled {
+		if errorRate.Threshold <= 0 || errorRate.Threshold > 1 {
+			return fmt.Errorf("outlier_detection.error_rate.threshold must be between 0 and 1")
+		}
+		if errorRate.MinRequests <= 0 || errorRate.Window <= 0 {
+			return fmt.Errorf("outlier_detection.error_rate min_requests and window must be positive")
+		}
+	}
+	if o.ErrorRate.EjectionTime < 0 {
+		return fmt.Errorf("outlier_detection.error_rate.ejection_time must be non-negative")
+	}
+	if o.Consecutive5xx < 0 {
+		return fmt.Errorf("outlier_detection.consecutive_5xx must be non-negative")
+	}
+	if d := o.Deviation; d.Enabled {
+		if d.Interval <= 0 || d.MinRequests <= 0 {
+			return fmt.Errorf("outlier_detection.deviation interval and min_requests must be positive")
+		}
+		if d.MinHosts < 2 {
+			return fmt.Errorf("outlier_detection.deviation.min_hosts must be at least 2")
+		}
+		if d.SuccessRateStdevFactor < 0 || d.LatencyFactor < 0 || (d.SuccessRateStdevFactor == 0 && d.LatencyFactor == 0) {
+			return fmt.Errorf("outlier_detection.deviation requires a positive success_rate_stdev_factor or latency_factor")
+		}
+		if d.LatencyFactor > 0 && d.LatencyFactor <= 1 {
+			return fmt.Errorf("outlier_detection.deviation.latency_factor must be greater than 1")
+		}
+	}
+	if !o.enabled() {
+		return nil
+	}
+	if o.baseEjectionTime() <= 0 || o.MaxEjectionTime < 0 {
+		return fmt.Errorf("outlier_detection.base_ejection_time must be positive and max_ejection_time non-negative")
+	}
+	if o.MaxEjectionPercent < 1 || o.MaxEjectionPercent > 100 {
+		return fmt.Errorf("outlier_detection.max_ejection_percent must be between 1 and 100")
+	}
+	return nil
+}
+
+// HealthCheckOverrides returns the per-backend health check settings keyed
+// by address
+func (c *Config) HealthCheckOverrides() map[string]health.Override {
+	overrides := make(map[string]health.Override)
+	add := func(b BackendConfig) {
+		if hc := b.HealthCheck; hc != (BackendHealthCheckConfig{}) {
+			overrides[b.Address] = health.Override{Path: hc.Path, Type: hc.Type}
+		}
+	}
+	for _, b := range c.Backends {
+		add(b)
+	}
+	for _, pool := range c.Regions.Pools {
+		for _, b := range pool.Backends {
+			add(b)
+		}
+	}
+	return overrides
+}
+
+// usesAlgorithm reports whether any backend group is balanced by algorithm
+func (c *Config) usesAlgorithm(algorithm string) bool {
+	if len(c.Regions.Pools) == 0 && c.LoadBalancing.Algorithm == algorithm {
+		return true
+	}
+	for _, pool := range c.Regions.Pools {
+		if c.LoadBalancing.AlgorithmFor(pool.Algorithm) == algorithm {
+			return true
+		}
+	}
+	return c.BodyRouting.Enabled && c.LoadBalancing.AlgorithmFor(c.BodyRouting.Algorithm) == algorithm
+}
+
+// validateBodyRouting checks that routes only name statically configured backends
+func (c *Config) validateBodyRouting() error {
+	if c.BodyRouting.JSONPath == "" {
+		return fmt.Errorf("body_routing.json_path is required")
+	}
+	if c.BodyRouting.MaxBodyBytes <= 0 {
+		return fmt.Errorf("body_routing.max_body_bytes must be positive")
+	}
+	if c.BodyRouting.Algorithm != "" && !balancer.IsValidAlgorithm(c.BodyRouting.Algorithm) {
+		return fmt.Errorf("body_routing: invalid load balancing algorithm: %s", c.BodyRouting.Algorithm)
+	}
+
+	known := make(map[string]bool)
+	resolved := make(map[string]bool)
+	for _, b := range c.Backends {
+		known[b.Address] = true
+		resolved[b.Address] = b.Resolve
+	}
+	for _, pool := range c.Regions.Pools {
+		for _, b := range pool.Backends {
+			known[b.Address] = true
+		}
+	}
+
+	for value, addresses := range c.BodyRouting.Routes {
+		if len(addresses) == 0 {
+			return fmt.Errorf("body_routing.routes[%s] has no backends", value)
+		}
+		for _, addr := range addresses {
+			if !known[addr] {
+				return fmt.Errorf("body_routing.routes[%s]: unknown backend %s", value, addr)
+			}
+			if resolved[addr] {
+				return fmt.Errorf("body_routing.routes[%s]: backend %s is resolved and cannot be routed to", value, addr)
+			}
+		}
+	}
 	return nil
 }
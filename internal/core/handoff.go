@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// Zero-downtime restarts hand the listening sockets to a new process. On
+// SIGUSR2 the running process starts a copy of its executable with the
+// sockets as extra files and waits for it to report readiness over a pipe.
+// Connections arriving meanwhile queue on the shared sockets, so none are
+// refused. Only once the new process is serving does the old one drain and
+// exit; if the new one fails to start, the old one keeps serving.
+
+const (
+	// listenersEnv lists the addresses of inherited listeners, in the order
+	// of their file descriptors starting at 3
+	listenersEnv = "HERMES_LISTENERS"
+	// readyFDEnv names the file descriptor to write to once serving
+	readyFDEnv = "HERMES_READY_FD"
+
+	// handoffReadyTimeout bounds how long the old process waits for the new one
+	handoffReadyTimeout = 30 * time.Second
+)
+
+// namedListener is a listener opened for a configured address
+type namedListener struct {
+	addr string
+	ln   net.Listener
+}
+
+// takeInheritedListeners returns the listeners passed down by the process
+// being replaced, keyed by address. The variable is cleared so that a later
+// handoff from this process does not see stale descriptors.
+func takeInheritedListeners() (map[string]net.Listener, error) {
+	spec := os.Getenv(listenersEnv)
+	os.Unsetenv(listenersEnv)
+	if spec == "" {
+		return nil, nil
+	}
+
+	listeners := make(map[string]net.Listener)
+	for i, addr := range strings.Split(spec, ",") {
+		f := os.NewFile(uintptr(3+i), "listener:"+addr)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit listener for %s: %w", addr, err)
+		}
+		listeners[addr] = ln
+	}
+	return listeners, nil
+}
+
+// listen returns the listener inherited for addr, or opens one. Listeners are
+// recorded so they can be handed to a replacement process.
+func (s *Server) listen(addr string, open func() (net.Listener, error)) (net.Listener, error) {
+	ln, ok := s.inherited[addr]
+	if ok {
+		delete(s.inherited, addr)
+		s.logger.Info("using listener inherited from previous process", "addr", addr)
+	} else {
+		var err error
+		if ln, err = open(); err != nil {
+			return nil, err
+		}
+	}
+	s.listeners = append(s.listeners, namedListener{addr: addr, ln: ln})
+	return ln, nil
+}
+
+// notifyReady tells the process being replaced that this one is serving
+func notifyReady() {
+	spec := os.Getenv(readyFDEnv)
+	os.Unsetenv(readyFDEnv)
+	if spec == "" {
+		return
+	}
+	fd, err := strconv.Atoi(spec)
+	if err != nil {
+		logging.Default().Warn("ignoring invalid ready descriptor", "env", readyFDEnv, "value", spec)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	if _, err := f.Write([]byte{1}); err != nil {
+		logging.Default().Error("failed to signal readiness to previous process", "error", err)
+	}
+	f.Close()
+}
+
+// listenerFile returns a duplicate of the descriptor behind ln
+func listenerFile(ln net.Listener) (*os.File, error) {
+	if u, ok := ln.(interface{ Unwrap() net.Listener }); ok {
+		ln = u.Unwrap()
+	}
+	fl, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener on %s cannot be passed to another process", ln.Addr())
+	}
+	return fl.File()
+}
+
+// handoff starts a copy of this executable with the same arguments, passing
+// it the listeners, and returns once it reports that it is serving
+func (s *Server) handoff(ctx context.Context) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	files := make([]*os.File, 0, len(s.listeners)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	addrs := make([]string, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		f, err := listenerFile(l.ln)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		addrs = append(addrs, l.addr)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		listenersEnv+"="+strings.Join(addrs, ","),
+		readyFDEnv+"="+strconv.Itoa(3+len(addrs)),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+	s.logger.Info("started new process, waiting for it to serve", "pid", cmd.Process.Pid)
+
+	// Close our copy of the write end so the read sees EOF if the new
+	// process exits without signalling readiness
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(readyR, make([]byte, 1))
+		ready <- err
+	}()
+
+	timer := time.NewTimer(handoffReadyTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+		if err == nil {
+			go cmd.Wait()
+			return nil
+		}
+		err = fmt.Errorf("new process exited before it was ready")
+	case <-timer.C:
+		err = fmt.Errorf("new process not ready after %v", handoffReadyTimeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	return err
+}
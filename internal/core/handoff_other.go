@@ -0,0 +1,9 @@
+//go:build !unix
+
+package core
+
+import "context"
+
+// handoffOnSignal does nothing: listener handoff is triggered by SIGUSR2,
+// which this platform lacks
+func (s *Server) handoffOnSignal(ctx context.Context) {}
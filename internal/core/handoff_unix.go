@@ -0,0 +1,34 @@
+//go:build unix
+
+package core
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handoffOnSignal hands the listeners to a new process on SIGUSR2 and, once
+// it is serving, shuts this one down through the usual SIGTERM path
+func (s *Server) handoffOnSignal(ctx context.Context) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR2)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigChan:
+			s.logger.Info("received SIGUSR2, handing listeners to a new process")
+			if err := s.handoff(ctx); err != nil {
+				s.logger.Error("handoff failed, continuing to serve", "error", err)
+				continue
+			}
+			s.logger.Info("new process is serving, shutting down")
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// buildRegions creates a failover balancer with the local region first and
+// the remaining regions ordered by priority
+func buildRegions(config *Config) (*balancer.Failover, error) {
+	pools := make([]RegionConfig, len(config.Regions.Pools))
+	copy(pools, config.Regions.Pools)
+
+	local := config.Regions.Local
+	sort.SliceStable(pools, func(i, j int) bool {
+		if (pools[i].Name == local) != (pools[j].Name == local) {
+			return pools[i].Name == local
+		}
+		return pools[i].Priority < pools[j].Priority
+	})
+
+	regions := make([]*balancer.Region, len(pools))
+	for i, pool := range pools {
+		var backends []*balancer.Backend
+		if pool.DNS != "" {
+			resolved, err := resolveBackends(pool.DNS, pool.Weight, config.Upstream.Protocol)
+			if err != nil {
+				// Start empty and let the refresh loop populate the pool
+				logging.Default().Error("failed to resolve region backends", "region", pool.Name, "dns", pool.DNS, "error", err)
+			}
+			backends = resolved
+		} else {
+			for _, bc := range pool.Backends {
+				backends = append(backends, newBackend(bc, config.Upstream.Protocol))
+			}
+		}
+
+		lb, err := balancer.New(config.LoadBalancing.AlgorithmFor(pool.Algorithm), backends)
+		if err != nil {
+			return nil, err
+		}
+		regions[i] = &balancer.Region{Name: pool.Name, Balancer: lb}
+	}
+
+	return balancer.NewFailover(regions), nil
+}
+
+// resolveBackends looks up host:port and returns a backend per address,
+// using protocol when set
+func resolveBackends(hostport string, weight int, protocol string) ([]*balancer.Backend, error) {
+	addrs, err := lookupAddresses(hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make([]*balancer.Backend, len(addrs))
+	for i, addr := range addrs {
+		backends[i] = balancer.NewBackend(addr, weight)
+		if protocol != "" {
+			backends[i].Protocol = protocol
+		}
+	}
+	return backends, nil
+}
+
+// refreshRegions periodically re-resolves DNS-discovered region pools
+func refreshRegions(ctx context.Context, failover *balancer.Failover, config *Config) {
+	dnsPools := make(map[string]RegionConfig)
+	for _, pool := range config.Regions.Pools {
+		if pool.DNS != "" {
+			dnsPools[pool.Name] = pool
+		}
+	}
+	if len(dnsPools) == 0 || config.Regions.DNSRefresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.Regions.DNSRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, region := range failover.Regions() {
+				pool, ok := dnsPools[region.Name]
+				if !ok {
+					continue
+				}
+
+				resolved, err := resolveBackends(pool.DNS, pool.Weight, config.Upstream.Protocol)
+				if err != nil {
+					logging.Default().Warn("failed to refresh region backends", "region", pool.Name, "dns", pool.DNS, "error", err)
+					continue
+				}
+				region.Balancer.SetBackends(balancer.Reconcile(region.Balancer.Backends(), resolved))
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+// ReloadSummary reports what a configuration reload applied
+type ReloadSummary struct {
+	BackendsAdded        []string `json:"backends_added,omitempty"`
+	BackendsRemoved      []string `json:"backends_removed,omitempty"`
+	BackendsReweighted   []string `json:"backends_reweighted,omitempty"`
+	BackendsUpdated      []string `json:"backends_updated,omitempty"`
+	CertificatesReloaded bool     `json:"certificates_reloaded"`
+
+	// Changed top-level sections that only take effect after a restart
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// SetConfigPath sets the file Reload reads the configuration from
+func (s *Server) SetConfigPath(path string) {
+	s.configPath = path
+}
+
+// Reload reads the configuration file again and applies what can change
+// while running: the backends list and TLS certificates. Other changed
+// sections are reported as needing a restart. An invalid configuration is
+// rejected without applying anything. Concurrent reloads are serialized.
+func (s *Server) Reload() (*ReloadSummary, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	return s.logReload(s.reload())
+}
+
+// Apply applies config as Reload applies the configuration file, for
+// programs that build their configuration in code
+func (s *Server) Apply(config *Config) (*ReloadSummary, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if err := config.Validate(); err != nil {
+		return s.logReload(nil, fmt.Errorf("invalid configuration: %w", err))
+	}
+	return s.logReload(s.apply(config))
+}
+
+// logReload logs the outcome of a reload and passes it through
+func (s *Server) logReload(summary *ReloadSummary, err error) (*ReloadSummary, error) {
+	if err != nil {
+		s.logger.Error("configuration reload failed, keeping current configuration", "error", err)
+		return nil, err
+	}
+	s.logger.Info("configuration reloaded",
+		"added", summary.BackendsAdded, "removed", summary.BackendsRemoved,
+		"reweighted", summary.BackendsReweighted, "updated", summary.BackendsUpdated,
+		"restart_required", summary.RestartRequired)
+	return summary, nil
+}
+
+func (s *Server) reload() (*ReloadSummary, error) {
+	if s.configPath == "" {
+		return nil, fmt.Errorf("no configuration file to reload")
+	}
+	config, err := LoadConfig(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(config)
+}
+
+// apply switches to a validated configuration and records what it applied
+// as the running configuration, served by /config
+func (s *Server) apply(config *Config) (*ReloadSummary, error) {
+	running := *s.running
+	summary := &ReloadSummary{}
+
+	// Certificates go first: if they fail, nothing has been applied yet.
+	// Turning TLS on or off, or changing its version or cipher suites,
+	// needs a restart.
+	if s.certs != nil && config.Server.TLS.Enabled() {
+		if err := s.certs.reload(config.Server.TLS); err != nil {
+			return nil, fmt.Errorf("failed to reload certificates: %w", err)
+		}
+		summary.CertificatesReloaded = true
+		running.Server.TLS.CertFile = config.Server.TLS.CertFile
+		running.Server.TLS.KeyFile = config.Server.TLS.KeyFile
+		running.Server.TLS.Certificates = config.Server.TLS.Certificates
+	}
+
+	// Reconfiguring also reapplies the per-backend overrides
+	if static, ok := s.provider.(*staticProvider); ok {
+		static.reconfigure(config, func(backends []*balancer.Backend) {
+			current := s.balancer.Backends()
+			summary.diffBackends(current, backends)
+			next := balancer.Reconcile(current, backends)
+			// Reconcile leaves the limits of reused instances alone; here the
+			// configuration is the source of truth for them
+			for i, b := range next {
+				b.SetMaxInflight(backends[i].MaxInflight())
+				b.SetQueueTimeout(backends[i].QueueTimeout())
+			}
+			s.balancer.SetBackends(next)
+		})
+		running.Backends = config.Backends
+	}
+
+	summary.RestartRequired = restartRequired(&running, config)
+	s.running = &running
+	s.adminAPI.SetConfig(running.Redacted())
+	return summary, nil
+}
+
+// diffBackends records the backends added, removed, reweighted and updated
+// between the current and next sets. Updated backends changed a setting
+// other than their weight.
+func (r *ReloadSummary) diffBackends(current, next []*balancer.Backend) {
+	existing := make(map[string]*balancer.Backend, len(current))
+	for _, b := range current {
+		existing[b.Address] = b
+	}
+
+	for _, b := range next {
+		old, ok := existing[b.Address]
+		if !ok {
+			r.BackendsAdded = append(r.BackendsAdded, b.Address)
+			continue
+		}
+		if old.GetWeight() != b.GetWeight() {
+			r.BackendsReweighted = append(r.BackendsReweighted, b.Address)
+		}
+		if !old.SameEndpoint(b) || old.MaxInflight() != b.MaxInflight() || old.QueueTimeout() != b.QueueTimeout() {
+			r.BackendsUpdated = append(r.BackendsUpdated, b.Address)
+		}
+		delete(existing, b.Address)
+	}
+	for address := range existing {
+		r.BackendsRemoved = append(r.BackendsRemoved, address)
+	}
+
+	slices.Sort(r.BackendsAdded)
+	slices.Sort(r.BackendsRemoved)
+	slices.Sort(r.BackendsReweighted)
+	slices.Sort(r.BackendsUpdated)
+}
+
+// restartRequired lists the top-level sections that differ between the
+// running configuration, already updated with what a reload applied, and
+// the new one
+func restartRequired(running, next *Config) []string {
+	var sections []string
+	rv, nv := reflect.ValueOf(running).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		name, _, _ := strings.Cut(rv.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "include" {
+			continue
+		}
+		if !reflect.DeepEqual(rv.Field(i).Interface(), nv.Field(i).Interface()) {
+			sections = append(sections, name)
+		}
+	}
+	return sections
+}
+
+// reloadOnHangup reloads the configuration on each SIGHUP
+func (s *Server) reloadOnHangup(ctx context.Context) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigChan:
+			s.Reload()
+		}
+	}
+}
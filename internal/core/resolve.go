@@ -0,0 +1,183 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/circuit"
+	"github.com/hermes-proxy/hermes/internal/health"
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// lookupAddresses resolves the host of hostport and returns host:port for
+// each of its A and AAAA records, sorted
+func lookupAddresses(hostport string) ([]string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns target %q: %w", hostport, err)
+	}
+
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	slices.Sort(addrs)
+	return addrs, nil
+}
+
+// expandBackends creates the configured top-level backends. A backend with
+// resolve set becomes one backend per address its hostname resolves to, each
+// with its settings. If a lookup fails, the backends that entry expanded to
+// in previous are kept. The expansion of each resolved entry is returned
+// keyed by its configured address, for the next refresh.
+func expandBackends(config *Config, previous map[string][]*balancer.Backend) ([]*balancer.Backend, map[string][]*balancer.Backend) {
+	var backends []*balancer.Backend
+	resolved := make(map[string][]*balancer.Backend)
+	for _, bc := range config.Backends {
+		if !bc.Resolve {
+			backends = append(backends, newBackend(bc, config.Upstream.Protocol))
+			continue
+		}
+
+		addrs, err := lookupAddresses(bc.Address)
+		if err != nil {
+			// Keep the last known set; at startup the entry starts empty
+			logging.Default().Warn("failed to resolve backend", "backend", bc.Address, "error", err)
+			resolved[bc.Address] = previous[bc.Address]
+			backends = append(backends, previous[bc.Address]...)
+			continue
+		}
+
+		expanded := make([]*balancer.Backend, len(addrs))
+		for i, addr := range addrs {
+			resolvedConfig := bc
+			resolvedConfig.Address = addr
+			expanded[i] = newBackend(resolvedConfig, config.Upstream.Protocol)
+		}
+		if !sameAddresses(previous[bc.Address], expanded) {
+			logging.Default().Info("resolved backend addresses", "backend", bc.Address, "addresses", addrs)
+		}
+		resolved[bc.Address] = expanded
+		backends = append(backends, expanded...)
+	}
+	return backends, resolved
+}
+
+// resolvedOverrides returns the per-backend circuit breaker and health check
+// settings of config, with those of each resolved entry moved from its
+// configured address to the addresses it expanded to
+func resolvedOverrides(config *Config, resolved map[string][]*balancer.Backend) (map[string]circuit.Override, map[string]health.Override) {
+	breakers, checks := config.BreakerOverrides(), config.HealthCheckOverrides()
+	for address, backends := range resolved {
+		breaker, hasBreaker := breakers[address]
+		check, hasCheck := checks[address]
+		for _, b := range backends {
+			if hasBreaker {
+				breakers[b.Address] = breaker
+			}
+			if hasCheck {
+				checks[b.Address] = check
+			}
+		}
+		delete(breakers, address)
+		delete(checks, address)
+	}
+	return breakers, checks
+}
+
+// sameAddresses reports whether a and b hold backends with the same addresses
+// in order
+func sameAddresses(a, b []*balancer.Backend) bool {
+	return slices.EqualFunc(a, b, func(x, y *balancer.Backend) bool { return x.Address == y.Address })
+}
+
+// staticProvider supplies the configured backends list. Backends with
+// resolve set are re-resolved every upstream.dns_refresh; without them the
+// set only changes when the configuration is reloaded.
+type staticProvider struct {
+	mu     sync.Mutex
+	config *Config
+
+	// Backends expanded from resolved hostnames, by configured address
+	resolved map[string][]*balancer.Backend
+
+	// Given the per-backend overrides after each expansion, so those of a
+	// resolved entry follow its addresses
+	applyOverrides func(map[string]circuit.Override, map[string]health.Override)
+}
+
+// expand creates the configured backends, resolving hostnames, and passes
+// on the overrides for the result. Callers must hold p.mu.
+func (p *staticProvider) expand() []*balancer.Backend {
+	var backends []*balancer.Backend
+	backends, p.resolved = expandBackends(p.config, p.resolved)
+	if p.applyOverrides != nil {
+		p.applyOverrides(resolvedOverrides(p.config, p.resolved))
+	}
+	return backends
+}
+
+// setOverrides sets the function given the per-backend overrides after each
+// expansion and calls it for the current one
+func (p *staticProvider) setOverrides(apply func(map[string]circuit.Override, map[string]health.Override)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.applyOverrides = apply
+	apply(resolvedOverrides(p.config, p.resolved))
+}
+
+// Backends returns the configured backends, resolving hostnames as needed
+func (p *staticProvider) Backends(ctx context.Context) ([]*balancer.Backend, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.expand(), nil
+}
+
+// Watch periodically re-resolves backends configured with resolve
+func (p *staticProvider) Watch(ctx context.Context, update func([]*balancer.Backend)) {
+	p.mu.Lock()
+	interval := p.config.Upstream.DNSRefresh
+	p.mu.Unlock()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if len(p.resolved) > 0 {
+				update(p.expand())
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// reconfigure switches to the backends of config and passes them to update.
+// The lock is held across update so a concurrent refresh cannot apply a set
+// built from the previous configuration afterwards.
+func (p *staticProvider) reconfigure(config *Config, update func([]*balancer.Backend)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.config = config
+	update(p.expand())
+}
@@ -2,63 +2,313 @@ package core
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/hermes-proxy/hermes/internal/admin"
 	"github.com/hermes-proxy/hermes/internal/balancer"
 	"github.com/hermes-proxy/hermes/internal/circuit"
+	"github.com/hermes-proxy/hermes/internal/discovery"
+	"github.com/hermes-proxy/hermes/internal/events"
 	"github.com/hermes-proxy/hermes/internal/health"
+	"github.com/hermes-proxy/hermes/internal/logging"
 	"github.com/hermes-proxy/hermes/internal/proxy"
+	"github.com/hermes-proxy/hermes/internal/tracing"
 )
 
+// Shutdown budgets: the whole sequence, and the part spent draining
+// in-flight proxy requests
+const (
+	shutdownTimeout = 30 * time.Second
+	drainTimeout    = 20 * time.Second
+)
+
+// discoveryTimeout bounds the initial backend fetch from a provider at startup
+const discoveryTimeout = 10 * time.Second
+
 // Server is the main Hermes proxy server
 type Server struct {
 	config         *Config
 	balancer       balancer.Balancer
 	healthChecker  *health.Checker
+	outliers       *health.OutlierDetector
 	passiveMonitor *health.PassiveMonitor
 	breakerPool    *circuit.BreakerPool
 	proxyHandler   *proxy.Handler
 	adminAPI       *admin.API
+	tracer         *tracing.Tracer
+	shedder        *proxy.LoadShedder
+	certs          *certStore
+
+	// Source of the top-level backends; nil with regions
+	provider discovery.Provider
+
+	// File re-read by Reload, and the lock serializing reloads
+	configPath string
+	reloadMu   sync.Mutex
+
+	// The configuration in effect: config with what reloads applied.
+	// Guarded by reloadMu.
+	running *Config
 
-	proxyServer *http.Server
-	adminServer *http.Server
+	// Delivers state change events when a webhook is configured
+	webhook *events.Webhook
+	events  <-chan events.Event
+
+	proxyServer  *http.Server
+	adminServers []*http.Server
+	logger       logging.Logger
+
+	// Listeners passed down by a previous process, and those in use, for
+	// zero-downtime restarts
+	inherited map[string]net.Listener
+	listeners []namedListener
 }
 
 // NewServer creates a new Hermes server
 func NewServer(config *Config) (*Server, error) {
-	// Create backends
-	backends := make([]*balancer.Backend, len(config.Backends))
-	for i, bc := range config.Backends {
-		backends[i] = balancer.NewBackend(bc.Address, bc.Weight)
-	}
+	// Components log through the process-wide logger, tagged by component
+	logger := slog.Default()
 
-	// Create the appropriate balancer
+	// Create the appropriate balancer, or a regional failover policy
 	var lb balancer.Balancer
-	switch config.LoadBalancing.Algorithm {
-	case "least-connections":
-		lb = balancer.NewLeastConnections(backends)
-	default:
-		lb = balancer.NewRoundRobin(backends)
+	var provider discovery.Provider
+	if len(config.Regions.Pools) > 0 {
+		failover, err := buildRegions(config)
+		if err != nil {
+			return nil, err
+		}
+		lb = failover
+	} else {
+		var err error
+		provider, err = newProvider(config, logger)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+		backends, err := provider.Backends(ctx)
+		cancel()
+		if err != nil {
+			// Start empty and let the watch populate the pool
+			logger.Error("failed to fetch backends", "provider", config.Discovery.Provider, "error", err)
+		}
+
+		lb, err = balancer.New(config.LoadBalancing.Algorithm, backends)
+		if err != nil {
+			return nil, err
+		}
 	}
+	lb.SetSlowStart(config.LoadBalancing.SlowStart)
+	loadMetric, err := balancer.ParseLoadMetric(config.LoadBalancing.LoadMetric)
+	if err != nil {
+		return nil, err
+	}
+	lb.SetLoadMetric(loadMetric)
 
 	// Create circuit breaker pool
 	breakerPool := circuit.NewBreakerPool(
 		config.CircuitBreaker.FailureThreshold,
 		config.CircuitBreaker.SuccessThreshold,
-		int64(config.CircuitBreaker.Timeout.Seconds()),
+		int64(config.CircuitBreaker.openTimeout().Seconds()),
 	)
+	breakerPool.SetOverrides(config.BreakerOverrides())
+	breakerPool.SetLogger(logger.With("component", "circuit"))
+
+	// Health and circuit state changes are only published when consumed
+	var eventBus *events.Bus
+	var eventQueue <-chan events.Event
+	if config.Events.WebhookURL != "" {
+		eventBus = events.NewBus()
+		eventQueue = eventBus.Subscribe(config.Events.QueueSize)
+		breakerPool.SetEvents(eventBus)
+	}
+	if b := config.CircuitBreaker.Backoff; b.Enabled {
+		breakerPool.SetBackoff(&circuit.BackoffPolicy{
+			Multiplier: b.Multiplier,
+			MaxTimeout: b.Max,
+		})
+	}
+	if a := config.CircuitBreaker.Adaptive; a.Enabled {
+		breakerPool.SetAdaptive(&circuit.AdaptivePolicy{
+			Window:              a.Window,
+			FailureRatio:        a.FailureRatio,
+			MinFailureThreshold: a.MinFailureThreshold,
+			MaxFailureThreshold: a.MaxFailureThreshold,
+		})
+	}
 
 	// Create passive health monitor
 	passiveMonitor := health.NewPassiveMonitor(lb, config.HealthCheck.UnhealthyThreshold)
+	passiveMonitor.SetEvents(eventBus)
+	passiveMonitor.SetLogger(logger.With("component", "passive"))
 
 	// Create proxy handler
 	proxyHandler := proxy.NewHandler(lb, breakerPool, passiveMonitor, config.Buffer.MaxRequestBody)
+	proxyHandler.SetLogger(logger.With("component", "proxy"))
+	proxyHandler.SetMaxRetries(config.Retry.MaxRetries)
+	proxyHandler.SetRetryConnectFailuresOnly(config.Retry.ConnectionFailuresOnly)
+	proxyHandler.SetRetryNonIdempotent(config.Retry.NonIdempotent)
+	proxyHandler.SetRequestTimeout(config.Server.RequestTimeout)
+	if config.LoadBalancing.LoadMetric == balancer.LoadHeader {
+		proxyHandler.SetLoadHeader(config.LoadBalancing.LoadHeader)
+	}
+	proxyHandler.SetHashHeader(config.LoadBalancing.HashHeader)
+	proxyHandler.SetPoolOptions(config.Upstream.PoolOptions())
+	proxyHandler.SetHostHeader(config.Upstream.PreserveHost, config.Upstream.HostHeader)
+	proxyHandler.SetHeaderRules(config.Headers.Request.Rules(), config.Headers.Response.Rules())
+	allowlist, err := proxy.NewMaintenanceAllowlist(config.Maintenance.AllowPaths, config.Maintenance.AllowIPs)
+	if err != nil {
+		return nil, err
+	}
+	proxyHandler.SetMaintenanceAllowlist(allowlist)
+	accessControl, err := config.AccessControl.Build()
+	if err != nil {
+		return nil, err
+	}
+	proxyHandler.SetAccessControl(accessControl)
+	if config.Retry.BackoffBase > 0 {
+		backoff, err := proxy.NewBackoff(config.Retry.BackoffBase, config.Retry.BackoffMax, config.Retry.Jitter)
+		if err != nil {
+			return nil, err
+		}
+		proxyHandler.SetRetryBackoff(backoff)
+	}
+	proxyHandler.SetAccessLog(config.Logging.AccessLog)
+	proxyHandler.SetEjectOnMalformed(config.HealthCheck.EjectOnMalformed)
+	failureStatuses, err := config.HealthCheck.FailureStatusRanges()
+	if err != nil {
+		return nil, err
+	}
+	proxyHandler.SetFailureStatuses(failureStatuses)
+	if config.HealthCheck.RetryAfter.Enabled {
+		proxyHandler.SetRetryAfter(config.HealthCheck.RetryAfter.Max)
+	}
+	proxyHandler.SetGRPC(config.GRPC.Enabled)
+	proxyHandler.SetBuffering(config.Buffer.Enabled)
+	proxyHandler.SetStreamChunked(config.Buffer.ChunkedRequests == "stream")
+	proxyHandler.SetBodyDiskThreshold(config.Buffer.DiskThreshold)
+	if opts := config.Server.SocketOptions(); !opts.IsZero() {
+		proxyHandler.SetSocketOptions(opts)
+	}
+
+	if len(config.ClientIP.TrustedProxies) > 0 {
+		resolver, err := proxy.NewClientIPResolver(config.ClientIP.Headers, config.ClientIP.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		proxyHandler.SetClientIPResolver(resolver)
+	}
+
+	errorPages, err := config.BuildErrorPages()
+	if err != nil {
+		return nil, err
+	}
+	proxyHandler.SetErrorPages(errorPages)
+	if rl := config.RateLimit; rl.Enabled {
+		proxyHandler.SetRateLimiter(proxy.NewRateLimiter(
+			rl.RequestsPerSecond, rl.Burst,
+			rl.GlobalRPS, rl.GlobalBurst,
+			rl.MaxClients,
+		))
+	}
+	if hl := config.HeaderLimits; hl.MaxRequestBytes > 0 || hl.MaxWindowBytes > 0 {
+		proxyHandler.SetHeaderLimiter(proxy.NewHeaderLimiter(hl.MaxRequestBytes, hl.MaxWindowBytes, hl.Window))
+	}
+	if config.Compression.Enabled {
+		proxyHandler.SetCompressor(proxy.NewCompressor(
+			config.Compression.MinSize,
+			config.Compression.ContentTypes,
+		))
+	}
+	if rc := config.Compression.Requests; rc.Enabled {
+		proxyHandler.SetRequestCompressor(proxy.NewRequestCompressor(rc.MinSize, config.Buffer.DiskThreshold))
+	}
+
+	var outliers *health.OutlierDetector
+	if o := config.Outliers; o.enabled() {
+		var threshold float64
+		if o.ErrorRate.Enabled {
+			threshold = o.ErrorRate.Threshold
+		}
+		outliers = health.NewOutlierDetector(
+			lb,
+			threshold,
+			o.ErrorRate.MinRequests,
+			o.ErrorRate.Window,
+			o.baseEjectionTime(),
+		)
+		outliers.SetConsecutive5xx(o.Consecutive5xx)
+		if d := o.Deviation; d.Enabled {
+			outliers.SetDeviation(health.Deviation{
+				Interval:      d.Interval,
+				MinHosts:      d.MinHosts,
+				MinRequests:   d.MinRequests,
+				StdevFactor:   d.SuccessRateStdevFactor,
+				LatencyFactor: d.LatencyFactor,
+			})
+		}
+		outliers.SetEjectionLimits(o.MaxEjectionTime, o.MaxEjectionPercent)
+		outliers.SetEvents(eventBus)
+		outliers.SetLogger(logger.With("component", "outlier"))
+		proxyHandler.SetOutlierDetector(outliers)
+	}
+
+	var shedder *proxy.LoadShedder
+	if shed := config.LoadShedding; shed.Enabled {
+		var err error
+		shedder, err = proxy.NewLoadShedder(shed.MaxActiveRequests, shed.MaxCPU, shed.Features)
+		if err != nil {
+			return nil, err
+		}
+		proxyHandler.SetLoadShedder(shedder)
+	}
+
+	if cc := config.Concurrency; cc.Enabled {
+		fairQueue, err := proxy.NewFairQueue(cc.MaxActive, cc.MaxQueue, cc.QueueTimeout, cc.TenantHeader, cc.Weights)
+		if err != nil {
+			return nil, err
+		}
+		proxyHandler.SetFairQueue(fairQueue)
+	}
+
+	if config.BodyRouting.Enabled {
+		router, err := buildBodyRouter(config, lb)
+		if err != nil {
+			return nil, err
+		}
+		proxyHandler.SetBodyRouter(router)
+	}
+
+	var tracer *tracing.Tracer
+	if config.Tracing.Enabled {
+		var err error
+		tracer, err = tracing.New(context.Background(), tracing.Config{
+			Endpoint:    config.Tracing.Endpoint,
+			Insecure:    config.Tracing.Insecure,
+			ServiceName: config.Tracing.ServiceName,
+			SampleRatio: config.Tracing.SampleRatio,
+		})
+		if err != nil {
+			return nil, err
+		}
+		proxyHandler.SetTracer(tracer)
+	}
+
+	if config.Cache.Enabled {
+		cache := proxy.NewResponseCache(config.Cache.MaxEntries, config.Cache.MaxBodyBytes)
+		cache.SetKeyRules(config.Cache.KeyRules())
+		proxyHandler.SetResponseCache(cache)
+	}
 
 	// Create health checker
 	var healthChecker *health.Checker
@@ -71,93 +321,461 @@ func NewServer(config *Config) (*Server, error) {
 			config.HealthCheck.UnhealthyThreshold,
 			config.HealthCheck.HealthyThreshold,
 		)
+
+		statuses, err := config.HealthCheck.StatusRanges()
+		if err != nil {
+			return nil, err
+		}
+		bodyMatch, err := config.HealthCheck.BodyMatcher()
+		if err != nil {
+			return nil, err
+		}
+		healthChecker.SetExpectations(statuses, bodyMatch)
+		healthChecker.SetRequest(strings.ToUpper(config.HealthCheck.Method), config.HealthCheck.Body)
+		healthChecker.SetType(config.HealthCheck.Type)
+		healthChecker.SetOverrides(config.HealthCheckOverrides())
+		healthChecker.SetJitter(config.HealthCheck.Jitter)
+		healthChecker.SetRecoveryDecrement(config.HealthCheck.RecoveryDecrement)
+		healthChecker.SetRequestHeaders(config.HealthCheck.Headers, config.HealthCheck.Host)
+		healthChecker.SetEvents(eventBus)
+		healthChecker.SetLogger(logger.With("component", "health"))
+		if config.HealthCheck.Synthetic.Enabled {
+			check, err := config.HealthCheck.Synthetic.Check()
+			if err != nil {
+				return nil, err
+			}
+			healthChecker.SetSynthetic(check)
+		}
+		if config.HealthCheck.StartUnhealthy {
+			healthChecker.StartUnhealthy()
+		}
+		if hc := config.HealthCheck; hc.TLSServerName != "" || hc.ExpectedCertName != "" {
+			var tlsConfig *tls.Config
+			if hc.TLSServerName != "" {
+				tlsConfig = &tls.Config{ServerName: hc.TLSServerName}
+			}
+			healthChecker.SetTLS(tlsConfig, hc.ExpectedCertName)
+		}
+
+		if outliers != nil {
+			outliers.SetProbe(healthChecker.Probe)
+		}
+
+		if n := config.HealthCheck.WarmupConnections; n > 0 {
+			healthChecker.SetRecoveryHook(func(b *balancer.Backend) {
+				proxyHandler.Warmup(b, n, config.HealthCheck.Path, config.HealthCheck.Timeout)
+			})
+		}
+	}
+
+	// Overrides of resolved backends are keyed by the addresses they expand
+	// to, which change on each refresh
+	if static, ok := provider.(*staticProvider); ok {
+		static.setOverrides(func(breakers map[string]circuit.Override, checks map[string]health.Override) {
+			breakerPool.SetOverrides(breakers)
+			if healthChecker != nil {
+				healthChecker.SetOverrides(checks)
+			}
+		})
 	}
 
 	// Create admin API
 	adminAPI := admin.NewAPI(lb, breakerPool, proxyHandler)
+	adminAPI.SetAuth(
+		config.Server.AdminAuth.Token,
+		config.Server.AdminAuth.Username,
+		config.Server.AdminAuth.Password,
+	)
+	if config.Server.AdminPprof {
+		adminAPI.SetPprof(true)
+		logger.Info("admin API serving pprof profiles under /debug/pprof/")
+	}
+	if cors := config.Server.AdminCORS; cors.Enabled {
+		adminAPI.SetCORS(cors.AllowedOrigins, cors.AllowedMethods, cors.AllowedHeaders)
+	}
+	adminAPI.SetConfig(config.Redacted())
 
-	return &Server{
+	var certs *certStore
+	if config.Server.TLS.Enabled() {
+		certs, err = newCertStore(config.Server.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var webhook *events.Webhook
+	if e := config.Events; e.WebhookURL != "" {
+		webhook = events.NewWebhook(e.WebhookURL, e.Timeout, e.MaxRetries)
+	}
+
+	s := &Server{
 		config:         config,
+		running:        config,
 		balancer:       lb,
+		provider:       provider,
 		healthChecker:  healthChecker,
+		outliers:       outliers,
 		passiveMonitor: passiveMonitor,
 		breakerPool:    breakerPool,
 		proxyHandler:   proxyHandler,
 		adminAPI:       adminAPI,
-	}, nil
+		tracer:         tracer,
+		shedder:        shedder,
+		certs:          certs,
+		webhook:        webhook,
+		events:         eventQueue,
+		logger:         logger.With("component", "hermes"),
+	}
+	adminAPI.SetReloader(func() (any, error) { return s.Reload() })
+	return s, nil
+}
+
+// Start runs the work behind the proxy until ctx is done: health checks,
+// outlier detection, load shedding, backend discovery and event delivery.
+// Run calls it; a program serving ProxyHandler itself calls it directly.
+func (s *Server) Start(ctx context.Context) {
+	if s.healthChecker != nil {
+		s.healthChecker.Start(ctx)
+		s.logger.Info("health checker started", "interval", s.config.HealthCheck.Interval)
+	}
+
+	if s.outliers != nil {
+		s.outliers.Start(ctx)
+	}
+
+	if s.shedder != nil {
+		s.shedder.Start(ctx, s.config.LoadShedding.Interval, func() int64 {
+			return atomic.LoadInt64(&s.proxyHandler.ActiveRequests)
+		})
+	}
+
+	if failover, ok := s.balancer.(*balancer.Failover); ok {
+		go refreshRegions(ctx, failover, s.config)
+	} else {
+		go discovery.Sync(ctx, s.provider, s.balancer)
+	}
+
+	if s.webhook != nil {
+		go s.webhook.Run(ctx, s.events)
+		s.logger.Info("sending state change events to webhook")
+	}
 }
 
 // Run starts the server and blocks until shutdown
 func (s *Server) Run() error {
-	// Start health checker
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	s.Start(ctx)
 
-	if s.healthChecker != nil {
-		s.healthChecker.Start(ctx)
-		log.Printf("[HERMES] Health checker started (interval: %v)", s.config.HealthCheck.Interval)
+	// Create proxy server
+	s.proxyServer = s.newProxyServer()
+	if s.config.GRPC.Enabled {
+		// gRPC clients connect with HTTP/2 prior knowledge on plaintext ports,
+		// or negotiate it via ALPN when TLS is terminated here
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		s.proxyServer.Protocols = protocols
 	}
 
-	// Create proxy server
-	s.proxyServer = &http.Server{
-		Addr:         s.config.Server.Listen,
-		Handler:      s.proxyHandler,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	var err error
+	if s.inherited, err = takeInheritedListeners(); err != nil {
+		return err
+	}
+
+	if s.certs != nil {
+		tlsConfig, err := s.certs.tlsConfig()
+		if err != nil {
+			return err
+		}
+		s.proxyServer.TLSConfig = tlsConfig
 	}
+	go s.reloadOnHangup(ctx)
 
 	// Create admin server
-	if s.config.Server.AdminListen != "" {
-		s.adminServer = &http.Server{
-			Addr:    s.config.Server.AdminListen,
-			Handler: s.adminAPI.Handler(),
+	for _, addr := range s.config.Server.AdminAddresses() {
+		adminServer := s.newAdminServer(addr)
+		s.adminServers = append(s.adminServers, adminServer)
+
+		if !s.adminAPI.AuthEnabled() && !isLoopback(addr) {
+			s.logger.Warn("admin API is reachable beyond loopback without authentication; "+
+				"set server.admin_auth or bind it to loopback", "addr", addr)
 		}
 
+		ln, err := s.listen(addr, func() (net.Listener, error) {
+			return net.Listen("tcp", addr)
+		})
+		if err != nil {
+			s.logger.Error("admin server error", "addr", addr, "error", err)
+			continue
+		}
 		go func() {
-			log.Printf("[HERMES] Admin API listening on %s", s.config.Server.AdminListen)
-			if err := s.adminServer.ListenAndServe(); err != http.ErrServerClosed {
-				log.Printf("[HERMES] Admin server error: %v", err)
+			s.logger.Info("admin API listening", "addr", addr)
+			if err := adminServer.Serve(ln); err != http.ErrServerClosed {
+				s.logger.Error("admin server error", "addr", addr, "error", err)
 			}
 		}()
 	}
 
 	// Handle shutdown signals
-	go s.handleShutdown(cancel)
+	stopped := make(chan struct{})
+	go func() {
+		s.handleShutdown(cancel)
+		close(stopped)
+	}()
 
 	// Start proxy server
-	log.Printf("[HERMES] Proxy listening on %s", s.config.Server.Listen)
-	log.Printf("[HERMES] Load balancing algorithm: %s", s.config.LoadBalancing.Algorithm)
-	log.Printf("[HERMES] Backends: %d configured", len(s.config.Backends))
+	s.logger.Info("proxy listening", "addr", s.config.Server.Listen,
+		"algorithm", s.config.LoadBalancing.Algorithm, "backends", len(s.balancer.Backends()))
+
+	opts := s.config.Server.SocketOptions()
+	_, inherited := s.inherited[s.config.Server.Listen]
+	ln, err := s.listen(s.config.Server.Listen, func() (net.Listener, error) {
+		return opts.Listen(ctx, s.config.Server.Listen)
+	})
+	if err != nil {
+		return err
+	}
+	if inherited {
+		ln = opts.Wrap(ln)
+	}
+
+	// Close inherited listeners for addresses no longer configured, then let
+	// the previous process know it can stop
+	for addr, l := range s.inherited {
+		s.logger.Info("closing inherited listener that is no longer configured", "addr", addr)
+		l.Close()
+	}
+	notifyReady()
+	go s.handoffOnSignal(ctx)
 
-	if err := s.proxyServer.ListenAndServe(); err != http.ErrServerClosed {
+	if s.certs != nil {
+		err = s.proxyServer.ServeTLS(ln, "", "")
+	} else {
+		err = s.proxyServer.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
 		return err
 	}
 
+	// Serve returns as soon as shutdown begins; wait for it to complete
+	<-stopped
 	return nil
 }
 
+// ProxyHandler returns the handler proxying requests to the backends
+func (s *Server) ProxyHandler() *proxy.Handler {
+	return s.proxyHandler
+}
+
+// AdminHandler returns the admin API, with its authentication applied
+func (s *Server) AdminHandler() http.Handler {
+	return s.adminAPI.Handler()
+}
+
+// Balancer returns the balancer holding the backends
+func (s *Server) Balancer() balancer.Balancer {
+	return s.balancer
+}
+
+// Stop refuses new requests, waits for those in flight until ctx is done
+// and flushes pending traces. Run does this itself on a shutdown signal;
+// Stop is for programs serving ProxyHandler themselves, after cancelling
+// the context passed to Start.
+func (s *Server) Stop(ctx context.Context) error {
+	err := s.proxyHandler.Shutdown(ctx)
+	if s.tracer != nil {
+		if traceErr := s.tracer.Shutdown(ctx); traceErr != nil {
+			s.logger.Error("failed to flush traces", "error", traceErr)
+		}
+	}
+	return err
+}
+
+// newProvider creates the source of the top-level backends
+func newProvider(config *Config, logger *slog.Logger) (discovery.Provider, error) {
+	if config.Discovery.Provider != discovery.ProviderConsul {
+		return &staticProvider{config: config}, nil
+	}
+
+	c := config.Discovery.Consul
+	consul, err := discovery.NewConsul(c.ConsulConfig(), func(address string, weight int) *balancer.Backend {
+		return newBackend(BackendConfig{Address: address, Weight: weight, Scheme: c.Scheme}, config.Upstream.Protocol)
+	})
+	if err != nil {
+		return nil, err
+	}
+	consul.SetLogger(logger.With("component", "discovery"))
+	return consul, nil
+}
+
+// buildBodyRouter creates a pool per body routing value. Pools share the
+// backend instances of lb so health state applies to routed traffic too.
+func buildBodyRouter(config *Config, lb balancer.Balancer) (*proxy.BodyRouter, error) {
+	byAddress := make(map[string]*balancer.Backend)
+	for _, b := range lb.Backends() {
+		byAddress[b.Address] = b
+	}
+
+	loadMetric, err := balancer.ParseLoadMetric(config.LoadBalancing.LoadMetric)
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make(map[string]balancer.Balancer, len(config.BodyRouting.Routes))
+	for value, addresses := range config.BodyRouting.Routes {
+		backends := make([]*balancer.Backend, 0, len(addresses))
+		for _, addr := range addresses {
+			backend, ok := byAddress[addr]
+			if !ok {
+				return nil, fmt.Errorf("body_routing.routes[%s]: unknown backend %s", value, addr)
+			}
+			backends = append(backends, backend)
+		}
+
+		pool, err := balancer.New(config.LoadBalancing.AlgorithmFor(config.BodyRouting.Algorithm), backends)
+		if err != nil {
+			return nil, err
+		}
+		pool.SetSlowStart(config.LoadBalancing.SlowStart)
+		pool.SetLoadMetric(loadMetric)
+		pools[value] = pool
+	}
+
+	return proxy.NewBodyRouter(config.BodyRouting.JSONPath, config.BodyRouting.MaxBodyBytes, pools)
+}
+
+// isLoopback reports whether a listen address binds only to loopback
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+
+	// A hostname is loopback only if every address it resolves to is
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+// newProxyServer builds the proxy HTTP server with its configured timeouts
+// and header limit
+func (s *Server) newProxyServer() *http.Server {
+	timeouts := s.config.Server.Timeouts
+	return &http.Server{
+		Addr:              s.config.Server.Listen,
+		Handler:           s.proxyHandler,
+		ReadHeaderTimeout: timeouts.readHeader(),
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		MaxHeaderBytes:    s.config.Server.MaxHeaderBytes,
+	}
+}
+
+// newAdminServer builds an admin HTTP server on addr with the configured
+// timeouts
+func (s *Server) newAdminServer(addr string) *http.Server {
+	timeouts := s.config.Server.AdminTimeouts
+	return &http.Server{
+		Addr:              addr,
+		Handler:           s.adminAPI.Handler(),
+		ReadHeaderTimeout: timeouts.readHeader(),
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+}
+
 func (s *Server) handleShutdown(cancel context.CancelFunc) {
-	sigChan := make(chan os.Signal, 1)
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	s.shutdown(sigChan, cancel)
+}
 
+// shutdown waits for a signal, then drains and stops the servers. With a
+// pre-stop delay, the proxy first keeps serving while reporting not ready. A
+// second signal during the sequence aborts it and closes all connections.
+func (s *Server) shutdown(sigChan <-chan os.Signal, cancel context.CancelFunc) {
 	<-sigChan
-	log.Println("[HERMES] Shutdown signal received")
+	s.logger.Info("shutdown signal received")
+
+	forced := false
+	if delay := s.config.Shutdown.PreStopDelay; delay > 0 {
+		s.proxyHandler.SetStopping(true)
+		s.logger.Info("pre-stop: reporting not ready and serving before draining", "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-sigChan:
+			timer.Stop()
+			s.logger.Warn("second signal received, forcing shutdown")
+			forced = true
+		}
+	}
 
 	// Cancel context to stop health checker
 	cancel()
 
 	// Graceful shutdown with 30 second timeout
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutdownCancel()
 
-	if s.adminServer != nil {
-		s.adminServer.Shutdown(shutdownCtx)
+	if forced {
+		shutdownCancel()
+	} else {
+		go func() {
+			select {
+			case <-sigChan:
+				s.logger.Warn("second signal received, forcing shutdown")
+				shutdownCancel()
+			case <-shutdownCtx.Done():
+			}
+		}()
+	}
+
+	s.logger.Info("stopping admin API")
+	for _, adminServer := range s.adminServers {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			adminServer.Close()
+		}
+	}
+
+	// Refuse new requests and let in-flight ones finish before closing
+	// connections, leaving the rest of the budget for the server shutdown
+	s.logger.Info("draining in-flight requests")
+	drainCtx, drainCancel := context.WithTimeout(shutdownCtx, drainTimeout)
+	if err := s.proxyHandler.Shutdown(drainCtx); err != nil {
+		s.logger.Warn("drain incomplete", "error", err)
 	}
+	drainCancel()
 
+	s.logger.Info("closing proxy connections")
 	if err := s.proxyServer.Shutdown(shutdownCtx); err != nil {
-		log.Printf("[HERMES] Shutdown error: %v", err)
+		s.logger.Error("shutdown error", "error", err)
+		// Terminate whatever is still in flight
+		s.proxyServer.Close()
+	}
+
+	if s.tracer != nil {
+		if err := s.tracer.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error("failed to flush traces", "error", err)
+		}
 	}
 
-	log.Println("[HERMES] Server stopped")
+	s.logger.Info("server stopped")
 }
@@ -0,0 +1,1115 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+func newTestConfig() *Config {
+	config := DefaultConfig()
+	config.Backends = []BackendConfig{{Address: "localhost:9001", Weight: 1}}
+	config.HealthCheck.Enabled = false
+	return config
+}
+
+func TestServer_AdminTimesOutSlowClient(t *testing.T) {
+	config := newTestConfig()
+	config.Server.AdminTimeouts.Read = 100 * time.Millisecond
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	adminServer := server.newAdminServer(ln.Addr().String())
+	go adminServer.Serve(ln)
+	defer adminServer.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Send an incomplete request and stall
+	conn.Write([]byte("GET /health HTTP/1.1\r\nHost: admin\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("Slow admin client was not timed out (waited %v)", elapsed)
+	}
+}
+
+func TestServer_ProxyTimeoutsFromConfig(t *testing.T) {
+	config := newTestConfig()
+	config.Server.Timeouts.ReadHeader = 100 * time.Millisecond
+	config.Server.MaxHeaderBytes = 4096
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	proxyServer := server.newProxyServer()
+	if proxyServer.ReadTimeout != 30*time.Second || proxyServer.WriteTimeout != 30*time.Second ||
+		proxyServer.IdleTimeout != 60*time.Second || proxyServer.MaxHeaderBytes != 4096 {
+		t.Errorf("Unexpected proxy server settings: read %v write %v idle %v max header %d",
+			proxyServer.ReadTimeout, proxyServer.WriteTimeout, proxyServer.IdleTimeout, proxyServer.MaxHeaderBytes)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go proxyServer.Serve(ln)
+	defer proxyServer.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Stall partway through the headers; read_header should cut this off
+	// well before the 30s read timeout
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: proxy\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("Slow client headers were not timed out (waited %v)", elapsed)
+	}
+}
+
+func TestBuildRegions_LocalFirstAndDNS(t *testing.T) {
+	config := newTestConfig()
+	config.Backends = nil
+	config.Regions = RegionsConfig{
+		Local: "us-east",
+		Pools: []RegionConfig{
+			{Name: "eu-west", Priority: 1, DNS: "localhost:9100"},
+			{Name: "us-east", Priority: 5, Backends: []BackendConfig{{Address: "10.0.0.1:80"}}},
+			{Name: "ap-south", Priority: 2, Backends: []BackendConfig{{Address: "10.0.1.1:80"}}, Algorithm: "least-connections"},
+		},
+	}
+
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	failover, err := buildRegions(config)
+	if err != nil {
+		t.Fatalf("buildRegions failed: %v", err)
+	}
+
+	var order []string
+	for _, r := range failover.Regions() {
+		order = append(order, r.Name)
+	}
+	if order[0] != "us-east" || order[1] != "eu-west" || order[2] != "ap-south" {
+		t.Errorf("Unexpected region order: %v", order)
+	}
+
+	// Pools without their own algorithm use the global one
+	if _, ok := failover.Regions()[0].Balancer.(*balancer.RoundRobin); !ok {
+		t.Errorf("Expected global round-robin for us-east, got %T", failover.Regions()[0].Balancer)
+	}
+	if _, ok := failover.Regions()[2].Balancer.(*balancer.LeastConnections); !ok {
+		t.Errorf("Expected least-connections for ap-south, got %T", failover.Regions()[2].Balancer)
+	}
+
+	remote := failover.Regions()[1].Balancer.Backends()
+	if len(remote) == 0 {
+		t.Fatal("Expected DNS pool to resolve localhost")
+	}
+	for _, b := range remote {
+		if _, port, _ := net.SplitHostPort(b.Address); port != "9100" {
+			t.Errorf("Resolved backend %s lost its port", b.Address)
+		}
+	}
+}
+
+func TestExpandBackends_ResolvesHostnames(t *testing.T) {
+	config := newTestConfig()
+	config.Backends = []BackendConfig{
+		{Address: "10.0.0.1:80", Weight: 1},
+		{Address: "localhost:9100", Weight: 3, HostHeader: "svc.internal", Resolve: true},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	// A record that has since disappeared is drained on refresh
+	gone := balancer.NewBackend("192.0.2.1:9100", 3)
+	backends, resolved := expandBackends(config, map[string][]*balancer.Backend{"localhost:9100": {gone}})
+	if len(backends) < 2 || backends[0].Address != "10.0.0.1:80" {
+		t.Fatalf("Expected the static backend followed by resolved ones, got %d backends", len(backends))
+	}
+	for _, b := range backends[1:] {
+		host, port, _ := net.SplitHostPort(b.Address)
+		if net.ParseIP(host) == nil || port != "9100" {
+			t.Errorf("Expected resolved IP with the configured port, got %s", b.Address)
+		}
+		if b.GetWeight() != 3 || b.HostHeader != "svc.internal" {
+			t.Errorf("Expected resolved backend %s to keep its settings", b.Address)
+		}
+	}
+	if len(resolved["localhost:9100"]) != len(backends)-1 {
+		t.Errorf("Expected the expansion to be recorded for the next refresh")
+	}
+
+	lb := balancer.NewRoundRobin([]*balancer.Backend{backends[0], gone})
+	lb.SetBackends(balancer.Reconcile(lb.Backends(), backends))
+	if !gone.IsDraining() {
+		t.Error("Expected the backend of a vanished record to be drained")
+	}
+
+	// A failed lookup keeps the last known set
+	config.Backends[1].Address = "hermes-test.invalid:9100"
+	previous := map[string][]*balancer.Backend{"hermes-test.invalid:9100": {gone}}
+	backends, _ = expandBackends(config, previous)
+	if len(backends) != 2 || backends[1] != gone {
+		t.Errorf("Expected the previous backends to be kept when resolution fails, got %d backends", len(backends))
+	}
+}
+
+func TestConfig_ResolveValidation(t *testing.T) {
+	config := newTestConfig()
+	config.Backends = []BackendConfig{{Address: "10.0.0.1:80", Resolve: true}}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected resolve on an IP address to be refused")
+	}
+}
+
+func TestServer_ResolvedBackendsTakeOverrides(t *testing.T) {
+	config := newTestConfig()
+	config.Backends = []BackendConfig{{
+		Address:        "localhost:9100",
+		Resolve:        true,
+		CircuitBreaker: BackendBreakerConfig{FailureThreshold: 2},
+		HealthCheck:    BackendHealthCheckConfig{Path: "/healthz"},
+	}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected overrides with resolve to be accepted, got %v", err)
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	backends := server.balancer.Backends()
+	if len(backends) == 0 {
+		t.Fatal("Expected localhost to resolve")
+	}
+	for _, b := range backends {
+		if got := server.breakerPool.Get(b.Address).FailureThreshold(); got != 2 {
+			t.Errorf("Expected resolved backend %s to take the breaker override, got threshold %d", b.Address, got)
+		}
+	}
+
+	// A refresh that moves the entry to new addresses moves its overrides too
+	moved := map[string][]*balancer.Backend{"localhost:9100": {balancer.NewBackend("192.0.2.7:9100", 1)}}
+	breakers, checks := resolvedOverrides(config, moved)
+	if len(breakers) != 1 || breakers["192.0.2.7:9100"].FailureThreshold != 2 {
+		t.Errorf("Expected the breaker override keyed by the resolved address, got %v", breakers)
+	}
+	if len(checks) != 1 || checks["192.0.2.7:9100"].Path != "/healthz" {
+		t.Errorf("Expected the health check override keyed by the resolved address, got %v", checks)
+	}
+}
+
+func TestConfig_BackendHealthCheckOverrides(t *testing.T) {
+	config := newTestConfig()
+	config.Backends = []BackendConfig{
+		{Address: "10.0.0.1:80", HealthCheck: BackendHealthCheckConfig{Path: "/healthz"}},
+		{Address: "10.0.0.2:80", HealthCheck: BackendHealthCheckConfig{Path: "/status", Type: "tcp"}},
+		{Address: "10.0.0.3:80"},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected valid overrides, got %v", err)
+	}
+	overrides := config.HealthCheckOverrides()
+	if len(overrides) != 2 || overrides["10.0.0.1:80"].Path != "/healthz" || overrides["10.0.0.2:80"].Type != "tcp" {
+		t.Errorf("Unexpected overrides %+v", overrides)
+	}
+
+	bad := []BackendHealthCheckConfig{{Path: "healthz"}, {Type: "grpc"}}
+	for _, hc := range bad {
+		config.Backends[2].HealthCheck = hc
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be refused", hc)
+		}
+	}
+}
+
+func TestConfig_OutlierDetectionValidation(t *testing.T) {
+	config := newTestConfig()
+	config.Outliers.Consecutive5xx = 5
+	config.Outliers.Deviation.Enabled = true
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected the defaults to be valid, got %v", err)
+	}
+
+	bad := []func(o *OutlierDetectionConfig){
+		func(o *OutlierDetectionConfig) { o.MaxEjectionPercent = 0 },
+		func(o *OutlierDetectionConfig) { o.MaxEjectionPercent = 101 },
+		func(o *OutlierDetectionConfig) { o.BaseEjectionTime = 0 },
+		func(o *OutlierDetectionConfig) { o.Deviation.MinHosts = 1 },
+		func(o *OutlierDetectionConfig) { o.Deviation.LatencyFactor = 0.5 },
+		func(o *OutlierDetectionConfig) { o.Deviation.SuccessRateStdevFactor = 0 },
+	}
+	for i, mutate := range bad {
+		config := newTestConfig()
+		config.Outliers.Consecutive5xx = 5
+		config.Outliers.Deviation.Enabled = true
+		mutate(&config.Outliers)
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected case %d to be refused", i)
+		}
+	}
+
+	// The older error_rate.ejection_time stands in for base_ejection_time
+	config.Outliers.BaseEjectionTime = 0
+	config.Outliers.ErrorRate.EjectionTime = time.Minute
+	if err := config.Validate(); err != nil || config.Outliers.baseEjectionTime() != time.Minute {
+		t.Errorf("Expected error_rate.ejection_time to set the base ejection time, got %v", err)
+	}
+}
+
+func TestConfig_DiscoveryValidation(t *testing.T) {
+	config := newTestConfig()
+	config.Discovery.Provider = "consul"
+	config.Discovery.Consul.Service = "api"
+	if err := config.Validate(); err == nil {
+		t.Error("Expected consul discovery alongside static backends to be refused")
+	}
+
+	config.Backends = nil
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected consul discovery without backends to pass, got %v", err)
+	}
+
+	config.Discovery.Consul.Service = ""
+	if err := config.Validate(); err == nil {
+		t.Error("Expected consul discovery without a service to be refused")
+	}
+
+	config.Discovery.Provider = "etcd"
+	if err := config.Validate(); err == nil {
+		t.Error("Expected an unknown provider to be refused")
+	}
+}
+
+func TestConfig_RequestCompressionRequiresBuffering(t *testing.T) {
+	config := newTestConfig()
+	config.Compression.Requests.Enabled = true
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected request compression with buffering to pass, got %v", err)
+	}
+
+	config.Buffer.Enabled = false
+	if err := config.Validate(); err == nil {
+		t.Error("Expected request compression without buffering to be refused")
+	}
+}
+
+func TestConfig_RegionsValidation(t *testing.T) {
+	config := newTestConfig()
+	config.Regions.Pools = []RegionConfig{{Name: "a", Backends: []BackendConfig{{Address: "x:1"}}}}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error when both backends and regions are set")
+	}
+
+	config.Backends = nil
+	config.Regions.Local = "missing"
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for unknown local region")
+	}
+
+	config.Regions.Local = ""
+	config.Regions.Pools[0].Algorithm = "fastest"
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for unknown region algorithm")
+	}
+
+	// hash_header is accepted once any group uses maglev
+	config.Regions.Pools[0].Algorithm = "maglev"
+	config.LoadBalancing.HashHeader = "X-User-ID"
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected maglev region to allow hash_header, got %v", err)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:8081": true,
+		"localhost:8081": true,
+		"[::1]:8081":     true,
+		":8081":          false,
+		"0.0.0.0:8081":   false,
+		"10.0.0.5:8081":  false,
+	}
+
+	for addr, want := range tests {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q): expected %v, got %v", addr, want, got)
+		}
+	}
+}
+
+func TestConfig_AdminBindLoopbackOnly(t *testing.T) {
+	config := newTestConfig()
+	config.Server.AdminListen = "127.0.0.1:8081"
+	config.Server.AdminBindLoopbackOnly = true
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected loopback admin address to pass, got %v", err)
+	}
+
+	config.Server.AdminListenInternal = "10.0.0.5:8081"
+	if err := config.Validate(); err == nil {
+		t.Error("Expected a non-loopback internal admin listener to be refused")
+	}
+
+	config.Server.AdminListenInternal = ""
+	config.Server.AdminListen = ":8081"
+	if err := config.Validate(); err == nil {
+		t.Error("Expected a wildcard admin address to be refused")
+	}
+}
+
+func TestConfig_AccessControlValidation(t *testing.T) {
+	config := newTestConfig()
+	config.AccessControl = AccessControlConfig{
+		Routes: []AccessRouteConfig{{PathPrefix: "/admin", Mode: "allowlist", CIDRs: []string{"10.0.0.0/8", "fd00::/8"}}},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected route allowlist to pass, got %v", err)
+	}
+
+	config.AccessControl.CIDRs = []string{"203.0.113.0/24"}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected global cidrs without a mode to be refused")
+	}
+
+	config.AccessControl.Mode = "denylist"
+	config.AccessControl.Routes[0].CIDRs = nil
+	if err := config.Validate(); err == nil {
+		t.Error("Expected an empty route allowlist to be refused")
+	}
+}
+
+func TestConfig_UpstreamProtocolDefault(t *testing.T) {
+	config := newTestConfig()
+	config.Upstream.Protocol = "h2c"
+	config.Backends = []BackendConfig{
+		{Address: "localhost:9001"},
+		{Address: "localhost:9002", Protocol: "http1"},
+	}
+
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	backends := server.balancer.Backends()
+	if backends[0].Protocol != "h2c" || backends[1].Protocol != "http1" {
+		t.Errorf("Expected h2c default with per-backend override, got %s/%s",
+			backends[0].Protocol, backends[1].Protocol)
+	}
+
+	// The default must not produce h2c over TLS
+	config.Backends = []BackendConfig{{Address: "localhost:9443", Scheme: "https"}}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for https backend with h2c default")
+	}
+}
+
+func TestServer_ConfigEndpointRedactsSecrets(t *testing.T) {
+	config := newTestConfig()
+	config.Server.AdminAuth.Token = "s3cret"
+	config.HealthCheck.Headers = map[string]string{"Authorization": "Bearer hc-token"}
+	config.Discovery.Consul.Token = "consul-acl"
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/config", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	server.adminAPI.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	body := rec.Body.String()
+	if strings.Contains(body, "s3cret") || strings.Contains(body, "hc-token") || strings.Contains(body, "consul-acl") {
+		t.Errorf("Secrets leaked in /config: %s", body)
+	}
+
+	var got struct {
+		Retry       map[string]interface{} `json:"retry"`
+		HealthCheck map[string]interface{} `json:"health_check"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if got.Retry["max_retries"] != float64(2) || got.HealthCheck["interval"] != "10s" {
+		t.Errorf("Expected defaults with config file keys, got retry=%v health_check.interval=%v",
+			got.Retry, got.HealthCheck["interval"])
+	}
+	if config.Server.AdminAuth.Token != "s3cret" {
+		t.Error("Redaction must not modify the running configuration")
+	}
+}
+
+func TestConfig_RedactsHeaderRuleValues(t *testing.T) {
+	config := newTestConfig()
+	config.Headers.Request.Set = map[string]string{"X-Api-Key": "static-key"}
+	config.Headers.Request.Add = map[string]string{"Authorization": "Bearer upstream-token"}
+	config.Headers.Response.Set = map[string]string{"X-Signature": "resp-secret"}
+	config.Headers.Response.Add = map[string]string{"X-Trace-Token": "added-secret"}
+
+	out := config.Redacted()
+	for _, rules := range []map[string]string{
+		out.Headers.Request.Set, out.Headers.Request.Add, out.Headers.Response.Set, out.Headers.Response.Add,
+	} {
+		if len(rules) != 1 {
+			t.Fatalf("Expected header names kept, got %v", rules)
+		}
+		for name, value := range rules {
+			if value != redacted {
+				t.Errorf("Header %s value leaked: %q", name, value)
+			}
+		}
+	}
+	if config.Headers.Request.Set["X-Api-Key"] != "static-key" {
+		t.Error("Redaction must not modify the running configuration")
+	}
+}
+
+func TestConfig_RejectsMalformedBackendAddress(t *testing.T) {
+	config := newTestConfig()
+	config.Backends = []BackendConfig{{Address: "localhost:9001"}, {Address: "http://localhost:9002"}}
+
+	err := config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "backend[1]") {
+		t.Errorf("Expected error naming backend[1], got %v", err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("HERMES_TEST_HOST", "10.0.0.7")
+	t.Setenv("HERMES_TEST_EMPTY", "")
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{`address: "${HERMES_TEST_HOST}:8080"`, `address: "10.0.0.7:8080"`, false},
+		{`port: ${HERMES_TEST_PORT:-9001}`, `port: 9001`, false},
+		{`host: ${HERMES_TEST_HOST:-localhost}`, `host: 10.0.0.7`, false},
+		{`host: ${HERMES_TEST_EMPTY:-fallback}`, `host: fallback`, false},
+		{`price: $$5 and $${HERMES_TEST_HOST}`, `price: $5 and ${HERMES_TEST_HOST}`, false},
+		{`plain: $HOME`, `plain: $HOME`, false},
+		{`address: ${HERMES_TEST_MISSING}`, "", true},
+		{`address: ${HERMES_TEST_HOST`, "", true},
+		{"# token: ${HERMES_TEST_MISSING}\nport: 1", "# token: ${HERMES_TEST_MISSING}\nport: 1", false},
+		{`port: ${HERMES_TEST_PORT:-9001} # or ${HERMES_TEST_MISSING}`, `port: 9001 # or ${HERMES_TEST_MISSING}`, false},
+		{`url: "http://h/#${HERMES_TEST_HOST}"`, `url: "http://h/#10.0.0.7"`, false},
+		{`path: 'a # ${HERMES_TEST_HOST}'`, `path: 'a # 10.0.0.7'`, false},
+		{`name: it's # ${HERMES_TEST_MISSING}`, `name: it's # ${HERMES_TEST_MISSING}`, false},
+		{`name: 'it''s # ${HERMES_TEST_HOST}'`, `name: 'it''s # 10.0.0.7'`, false},
+		{`tag: a#${HERMES_TEST_HOST}`, `tag: a#10.0.0.7`, false},
+	}
+
+	for _, tt := range tests {
+		got, err := expandEnv([]byte(tt.input))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandEnv(%q): unexpected error state: %v", tt.input, err)
+			continue
+		}
+		if !tt.wantErr && string(got) != tt.want {
+			t.Errorf("expandEnv(%q): expected %q, got %q", tt.input, tt.want, got)
+		}
+	}
+
+	if _, err := expandEnv([]byte("${HERMES_TEST_MISSING}")); err == nil || !strings.Contains(err.Error(), "HERMES_TEST_MISSING") {
+		t.Errorf("Expected error naming the missing variable, got %v", err)
+	}
+}
+
+// writeTestCert writes a self-signed certificate for host into dir
+func writeTestCert(t *testing.T, dir, host string, serial int64) TLSCertConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := TLSCertConfig{CertFile: filepath.Join(dir, host+".crt"), KeyFile: filepath.Join(dir, host+".key")}
+	os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return pair
+}
+
+func TestServer_TerminatesTLS(t *testing.T) {
+	var proto string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Header.Get("X-Forwarded-Proto")
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	def := writeTestCert(t, dir, "default.example", 1)
+	api := writeTestCert(t, dir, "api.example", 2)
+
+	config := newTestConfig()
+	config.Backends = []BackendConfig{{Address: strings.TrimPrefix(backend.URL, "http://"), Weight: 1}}
+	config.Server.TLS = ServerTLSConfig{
+		CertFile:     def.CertFile,
+		KeyFile:      def.KeyFile,
+		Certificates: []TLSCertConfig{api},
+		MinVersion:   "1.2",
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	tlsConfig, err := server.certs.tlsConfig()
+	if err != nil {
+		t.Fatalf("tlsConfig failed: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	proxyServer := &http.Server{Handler: server.proxyHandler, TLSConfig: tlsConfig}
+	go proxyServer.ServeTLS(ln, "", "")
+	defer proxyServer.Close()
+
+	// get requests / with the given SNI name and returns the served certificate's serial
+	get := func(serverName string, maxVersion uint16) (int64, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			MaxVersion:         maxVersion,
+		}}}
+		resp, err := client.Get("https://" + ln.Addr().String() + "/")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	if serial, err := get("api.example", 0); err != nil || serial != 2 {
+		t.Fatalf("Expected SNI certificate 2, got %d (err %v)", serial, err)
+	}
+	if proto != "https" {
+		t.Errorf("Expected X-Forwarded-Proto https, got %q", proto)
+	}
+	if serial, err := get("other.example", 0); err != nil || serial != 1 {
+		t.Errorf("Expected default certificate 1 for unknown SNI, got %d (err %v)", serial, err)
+	}
+	if _, err := get("api.example", tls.VersionTLS11); err == nil {
+		t.Error("Expected handshake below min_version to fail")
+	}
+
+	// Replace the SNI certificate on disk and reload as SIGHUP would
+	writeTestCert(t, dir, "api.example", 3)
+	if err := server.certs.reload(config.Server.TLS); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if serial, err := get("api.example", 0); err != nil || serial != 3 {
+		t.Errorf("Expected reloaded certificate 3, got %d (err %v)", serial, err)
+	}
+
+	// A reload may point at different files; other TLS settings need a restart
+	next := *config
+	next.Server.TLS.Certificates = []TLSCertConfig{writeTestCert(t, t.TempDir(), "api.example", 4)}
+	summary, err := server.Apply(&next)
+	if err != nil || !summary.CertificatesReloaded || len(summary.RestartRequired) != 0 {
+		t.Fatalf("Expected the new certificate paths applied, got %+v (err %v)", summary, err)
+	}
+	if serial, err := get("api.example", 0); err != nil || serial != 4 {
+		t.Errorf("Expected certificate 4 from the new path, got %d (err %v)", serial, err)
+	}
+	next.Server.TLS.MinVersion = "1.3"
+	if summary, err := server.Apply(&next); err != nil || !slices.Equal(summary.RestartRequired, []string{"server"}) {
+		t.Errorf("Expected a min_version change to need a restart, got %+v (err %v)", summary, err)
+	}
+}
+
+func TestServer_ReloadAppliesBackendsAndReportsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hermes.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+backends:
+  - address: "10.0.0.1:80"
+  - address: "10.0.0.2:80"
+health_check:
+  enabled: false
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.SetConfigPath(path)
+	kept := server.balancer.Backends()[1]
+
+	write(`
+backends:
+  - address: "10.0.0.2:80"
+    weight: 3
+  - address: "10.0.0.3:80"
+health_check:
+  enabled: false
+rate_limit:
+  enabled: true
+`)
+	summary, err := server.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if !slices.Equal(summary.BackendsAdded, []string{"10.0.0.3:80"}) ||
+		!slices.Equal(summary.BackendsRemoved, []string{"10.0.0.1:80"}) ||
+		!slices.Equal(summary.BackendsReweighted, []string{"10.0.0.2:80"}) {
+		t.Errorf("Unexpected backend changes: %+v", summary)
+	}
+	if !slices.Equal(summary.RestartRequired, []string{"rate_limit"}) {
+		t.Errorf("Expected rate_limit to need a restart, got %v", summary.RestartRequired)
+	}
+	backends := server.balancer.Backends()
+	if len(backends) != 2 || backends[0] != kept || kept.GetWeight() != 3 {
+		t.Errorf("Expected the kept backend to be reused with its new weight")
+	}
+
+	// /config serves the running configuration, and sections that were not
+	// applied keep needing a restart
+	rec := httptest.NewRecorder()
+	server.adminAPI.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/config", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "10.0.0.3:80") || strings.Contains(body, "10.0.0.1:80") {
+		t.Errorf("Expected /config to list the reloaded backends, got %s", body)
+	}
+	summary, err = server.Reload()
+	if err != nil || len(summary.BackendsAdded) != 0 || !slices.Equal(summary.RestartRequired, []string{"rate_limit"}) {
+		t.Errorf("Expected only rate_limit still needing a restart, got %+v (err %v)", summary, err)
+	}
+
+	// An invalid file is rejected and nothing changes
+	write(`
+backends: []
+`)
+	if _, err := server.Reload(); err == nil {
+		t.Fatal("Expected an invalid configuration to be rejected")
+	}
+	if len(server.balancer.Backends()) != 2 {
+		t.Errorf("Expected the backend set to be unchanged after a rejected reload")
+	}
+}
+
+func TestServer_ReloadAppliesBackendSettings(t *testing.T) {
+	config := newTestConfig()
+	config.Backends = []BackendConfig{{Address: "10.0.0.1:80"}, {Address: "10.0.0.2:80"}}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	limited, moved := server.balancer.Backends()[0], server.balancer.Backends()[1]
+	moved.SetHealthy(false)
+	server.breakerPool.Get("10.0.0.1:80")
+
+	next := *config
+	next.Backends = []BackendConfig{
+		{Address: "10.0.0.1:80", MaxInflight: 5, QueueTimeout: time.Second, CircuitBreaker: BackendBreakerConfig{FailureThreshold: 7}},
+		{Address: "10.0.0.2:80", Scheme: "https", HostHeader: "api.example"},
+	}
+	summary, err := server.Apply(&next)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !slices.Equal(summary.BackendsUpdated, []string{"10.0.0.1:80", "10.0.0.2:80"}) || len(summary.RestartRequired) != 0 {
+		t.Errorf("Expected both backends reported as updated, got %+v", summary)
+	}
+
+	backends := server.balancer.Backends()
+	if backends[0] != limited || limited.MaxInflight() != 5 || limited.QueueTimeout() != time.Second {
+		t.Errorf("Expected the limits applied to the reused backend")
+	}
+	if got := server.breakerPool.Get("10.0.0.1:80").FailureThreshold(); got != 7 {
+		t.Errorf("Expected the circuit_breaker override applied, got threshold %d", got)
+	}
+	if b := backends[1]; b == moved || b.Scheme != "https" || b.HostHeader != "api.example" || b.IsHealthy() {
+		t.Errorf("Expected an unhealthy https replacement for 10.0.0.2:80, got scheme %q healthy %v", b.Scheme, b.IsHealthy())
+	}
+}
+
+func TestServer_ConcurrentReloadsAreSerialized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hermes.yaml")
+	if err := os.WriteFile(path, []byte("backends:\n  - address: \"10.0.0.1:80\"\n  - address: \"10.0.0.2:80\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(newTestConfig())
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	server.SetConfigPath(path)
+
+	var wg sync.WaitGroup
+	var added atomic.Int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summary, err := server.Reload()
+			if err != nil {
+				t.Errorf("Reload failed: %v", err)
+				return
+			}
+			added.Add(int64(len(summary.BackendsAdded)))
+		}()
+	}
+	wg.Wait()
+
+	// Only the first reload sees the new backends as added
+	if got := added.Load(); got != 2 {
+		t.Errorf("Expected 2 additions across all reloads, got %d", got)
+	}
+	if got := len(server.balancer.Backends()); got != 2 {
+		t.Errorf("Expected 2 backends, got %d", got)
+	}
+}
+
+func TestServer_SecondSignalForcesShutdown(t *testing.T) {
+	hold := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hold
+	}))
+	defer backend.Close()
+	defer close(hold)
+
+	config := newTestConfig()
+	config.Backends = []BackendConfig{{Address: strings.TrimPrefix(backend.URL, "http://"), Weight: 1}}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server.proxyServer = &http.Server{Handler: server.proxyHandler}
+	go server.proxyServer.Serve(ln)
+
+	// Leave a request in flight so the drain has something to wait for
+	inFlight := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		inFlight <- err
+	}()
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt64(&server.proxyHandler.ActiveRequests) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Request never reached the proxy")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sigChan := make(chan os.Signal, 2)
+	stopped := make(chan struct{})
+	go func() {
+		server.shutdown(sigChan, func() {})
+		close(stopped)
+	}()
+
+	sigChan <- syscall.SIGTERM
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown finished while a request was still draining")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	sigChan <- syscall.SIGTERM
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Second signal did not force shutdown")
+	}
+	if err := <-inFlight; err == nil {
+		t.Error("Expected the in-flight request to be terminated")
+	}
+}
+
+func TestServer_PreStopDelayServesWhileNotReady(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	config := newTestConfig()
+	config.Backends = []BackendConfig{{Address: strings.TrimPrefix(backend.URL, "http://"), Weight: 1}}
+	config.HealthCheck.Enabled = false
+	config.Shutdown.PreStopDelay = 300 * time.Millisecond
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server.proxyServer = &http.Server{Handler: server.proxyHandler}
+	go server.proxyServer.Serve(ln)
+	admin := httptest.NewServer(server.adminAPI.Handler())
+	defer admin.Close()
+
+	sigChan := make(chan os.Signal, 2)
+	stopped := make(chan struct{})
+	go func() {
+		server.shutdown(sigChan, func() {})
+		close(stopped)
+	}()
+	sigChan <- syscall.SIGTERM
+
+	for deadline := time.Now().Add(time.Second); !server.proxyHandler.Stopping(); {
+		if time.Now().After(deadline) {
+			t.Fatal("Pre-stop phase never began")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	resp, err := http.Get(admin.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to fail during pre-stop, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Expected requests served during pre-stop, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 during pre-stop, got %d", resp.StatusCode)
+	}
+
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown finished before the pre-stop delay elapsed")
+	default:
+	}
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not finish after the pre-stop delay")
+	}
+}
+
+func TestBackendConfig_MaxConnectionsLimitsInflight(t *testing.T) {
+	tests := []struct {
+		maxInflight, maxConnections, want int
+	}{
+		{0, 0, 0},
+		{0, 4, 4},
+		{8, 0, 8},
+		{8, 4, 4},
+		{2, 4, 2},
+	}
+	for _, tt := range tests {
+		bc := BackendConfig{Address: "10.0.0.1:80", MaxInflight: tt.maxInflight, MaxConnections: tt.maxConnections}
+		if got := bc.inflightLimit(); got != tt.want {
+			t.Errorf("max_inflight %d, max_connections %d: expected limit %d, got %d",
+				tt.maxInflight, tt.maxConnections, tt.want, got)
+		}
+	}
+}
+
+func TestLoadConfig_MergesIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	write("shared/backends.yaml", `
+backends:
+  - address: "shared1:8080"
+  - address: "shared2:8080"
+error_pages:
+  "502":
+    body: "shared 502"
+  "503":
+    body: "shared 503"
+retry:
+  max_retries: 5
+`)
+	write("shared/timeouts.yaml", `
+retry:
+  max_retries: 4
+load_balancing:
+  algorithm: "least-connections"
+`)
+	main := write("prod.yaml", `
+include:
+  - shared/backends.yaml
+  - shared/timeouts.yaml
+error_pages:
+  "503":
+    body: "prod 503"
+load_balancing:
+  algorithm: "p2c"
+`)
+
+	config, err := LoadConfig(main)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if len(config.Backends) != 2 || config.Backends[0].Address != "shared1:8080" {
+		t.Errorf("Expected included backends, got %v", config.Backends)
+	}
+	if config.Retry.MaxRetries != 4 {
+		t.Errorf("Expected later include to win, got max_retries %d", config.Retry.MaxRetries)
+	}
+	if config.LoadBalancing.Algorithm != "p2c" {
+		t.Errorf("Expected main file to win, got algorithm %s", config.LoadBalancing.Algorithm)
+	}
+	if config.ErrorPages["502"].Body != "shared 502" || config.ErrorPages["503"].Body != "prod 503" {
+		t.Errorf("Expected error_pages merged by key, got %v", config.ErrorPages)
+	}
+
+	// Validation runs on the merged result, not on each file
+	write("empty.yaml", "include: [shared/timeouts.yaml]\n")
+	if _, err := LoadConfig(filepath.Join(dir, "empty.yaml")); err == nil {
+		t.Error("Expected validation error without backends")
+	}
+
+	write("loop.yaml", "include: [loop.yaml]\n")
+	if _, err := LoadConfig(filepath.Join(dir, "loop.yaml")); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected include cycle error, got %v", err)
+	}
+}
+
+func TestServer_ListenUsesInheritedListener(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+
+	// Pass the socket through a file descriptor as a handoff would
+	f, err := listenerFile(orig)
+	if err != nil {
+		t.Fatalf("listenerFile failed: %v", err)
+	}
+	inherited, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+
+	s := &Server{inherited: map[string]net.Listener{"proxy": inherited}, logger: logging.Default()}
+	ln, err := s.listen("proxy", func() (net.Listener, error) {
+		t.Error("Expected the inherited listener to be used instead of opening one")
+		return nil, io.EOF
+	})
+	if err != nil || ln != inherited {
+		t.Fatalf("Expected inherited listener, got %v, %v", ln, err)
+	}
+	if len(s.inherited) != 0 || len(s.listeners) != 1 || s.listeners[0].addr != "proxy" {
+		t.Errorf("Expected listener moved to the handoff list, got %v / %v", s.inherited, s.listeners)
+	}
+
+	// The inherited descriptor accepts connections for the original address
+	conn, err := net.Dial("tcp", orig.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept on inherited listener failed: %v", err)
+	}
+	accepted.Close()
+
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// tlsVersions maps min_version settings to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the version for s, defaulting to TLS 1.2
+func parseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[strings.TrimPrefix(s, "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q (use 1.0, 1.1, 1.2 or 1.3)", s)
+	}
+	return version, nil
+}
+
+// parseCipherSuites resolves cipher suite names as spelled by crypto/tls,
+// e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Insecure suites are rejected.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certStore serves the configured certificates, picking one by SNI, and can
+// reload them from disk without restarting the listener
+type certStore struct {
+	config ServerTLSConfig // the version and cipher suites the listener uses
+	certs  atomic.Pointer[[]tls.Certificate]
+}
+
+// newCertStore loads the certificates named in cfg
+func newCertStore(cfg ServerTLSConfig) (*certStore, error) {
+	store := &certStore{config: cfg}
+	if err := store.reload(cfg); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload reads the certificates named in cfg, which may differ from those
+// loaded before; on error the current set is kept. The TLS version and
+// cipher suites stay as the listener was built.
+func (s *certStore) reload(cfg ServerTLSConfig) error {
+	pairs := append([]TLSCertConfig{{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile}}, cfg.Certificates...)
+
+	certs := make([]tls.Certificate, 0, len(pairs))
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %w", pair.CertFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	s.certs.Store(&certs)
+	return nil
+}
+
+// getCertificate returns the first certificate valid for the client's SNI
+// name, falling back to the default certificate
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.certs.Load()
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+// tlsConfig builds the server TLS configuration backed by the store
+func (s *certStore) tlsConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(s.config.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(s.config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: s.getCertificate,
+	}, nil
+}
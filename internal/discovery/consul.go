@@ -0,0 +1,207 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// consulRetryDelay is how long the watch waits after a failed query
+const consulRetryDelay = 5 * time.Second
+
+// ConsulConfig selects the Consul service whose instances are backends
+type ConsulConfig struct {
+	Address    string // agent URL, e.g. "http://127.0.0.1:8500"
+	Service    string
+	Tag        string // only instances with this tag, if set
+	Datacenter string // default: the agent's datacenter
+	Token      string // ACL token
+
+	// Longest a blocking query waits for a change before it is reissued
+	WaitTime time.Duration
+}
+
+// Consul provides the instances of a service that pass their Consul health
+// checks. Changes are picked up with blocking queries, so they reach the
+// balancer as soon as Consul sees them.
+type Consul struct {
+	config  ConsulConfig
+	newFunc BackendFactory
+	client  *http.Client
+	logger  logging.Logger
+
+	mu    sync.Mutex
+	index uint64   // X-Consul-Index of the last answer
+	addrs []string // addresses in the last answer
+}
+
+// consulEntry is the part of a /v1/health/service entry used here
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// NewConsul creates a Consul provider; newBackend builds each instance's backend
+func NewConsul(config ConsulConfig, newBackend BackendFactory) (*Consul, error) {
+	u, err := url.Parse(config.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("consul address %q must be an http or https URL", config.Address)
+	}
+	if config.Service == "" {
+		return nil, fmt.Errorf("consul service is required")
+	}
+	if config.WaitTime <= 0 {
+		return nil, fmt.Errorf("consul wait_time must be positive")
+	}
+
+	return &Consul{
+		config:  config,
+		newFunc: newBackend,
+		// Consul adds up to wait/16 of jitter to a blocking query
+		client: &http.Client{Timeout: config.WaitTime + config.WaitTime/16 + 10*time.Second},
+		logger: logging.Default(),
+	}, nil
+}
+
+// SetLogger sets where query failures and instance changes are logged
+func (c *Consul) SetLogger(logger logging.Logger) {
+	c.logger = logger
+}
+
+// Backends fetches the service's healthy instances
+func (c *Consul) Backends(ctx context.Context) ([]*balancer.Backend, error) {
+	entries, index, err := c.query(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	backends, addrs := c.backends(entries)
+
+	c.mu.Lock()
+	c.index, c.addrs = index, addrs
+	c.mu.Unlock()
+	return backends, nil
+}
+
+// Watch issues blocking queries and calls update whenever the set of
+// healthy instances changes
+func (c *Consul) Watch(ctx context.Context, update func([]*balancer.Backend)) {
+	for ctx.Err() == nil {
+		c.mu.Lock()
+		index := c.index
+		c.mu.Unlock()
+
+		entries, next, err := c.query(ctx, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Warn("consul query failed", "service", c.config.Service, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(consulRetryDelay):
+			}
+			continue
+		}
+
+		// The index can go backwards, e.g. after a Consul restore; start over
+		if next < index {
+			next = 0
+		}
+		backends, addrs := c.backends(entries)
+
+		c.mu.Lock()
+		changed := !slices.Equal(addrs, c.addrs)
+		c.index, c.addrs = next, addrs
+		c.mu.Unlock()
+
+		if changed {
+			c.logger.Info("consul instances changed", "service", c.config.Service, "addresses", addrs)
+			update(backends)
+		}
+	}
+}
+
+// query fetches the passing instances, blocking until the index moves past
+// index when it is non-zero
+func (c *Consul) query(ctx context.Context, index uint64) ([]consulEntry, uint64, error) {
+	params := url.Values{"passing": {"1"}}
+	if c.config.Tag != "" {
+		params.Set("tag", c.config.Tag)
+	}
+	if c.config.Datacenter != "" {
+		params.Set("dc", c.config.Datacenter)
+	}
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", c.config.WaitTime.String())
+	}
+	endpoint := c.config.Address + "/v1/health/service/" + url.PathEscape(c.config.Service) + "?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.config.Token != "" {
+		req.Header.Set("X-Consul-Token", c.config.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned %s", resp.Status)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("invalid consul response: %w", err)
+	}
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul response has no valid X-Consul-Index")
+	}
+	return entries, next, nil
+}
+
+// backends creates a backend per instance, using the service address when
+// registered and the node address otherwise, and returns their sorted addresses
+func (c *Consul) backends(entries []consulEntry) ([]*balancer.Backend, []string) {
+	backends := make([]*balancer.Backend, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+		backends = append(backends, c.newFunc(addr, e.Service.Weights.Passing))
+	}
+	slices.SortFunc(backends, func(a, b *balancer.Backend) int { return strings.Compare(a.Address, b.Address) })
+
+	addrs := make([]string, len(backends))
+	for i, b := range backends {
+		addrs[i] = b.Address
+	}
+	return backends, addrs
+}
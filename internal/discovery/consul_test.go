@@ -0,0 +1,175 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+// fakeConsul serves /v1/health/service with blocking query semantics
+type fakeConsul struct {
+	mu        sync.Mutex
+	index     uint64
+	instances []map[string]any
+	changed   chan struct{} // closed and replaced on every change
+	requests  []*http.Request
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{index: 1, changed: make(chan struct{})}
+}
+
+// set replaces the registered healthy instances
+func (f *fakeConsul) set(instances ...map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances = instances
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	if want, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); want >= index {
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(f.instances)
+}
+
+func instance(node, address string, port, weight int) map[string]any {
+	return map[string]any{
+		"Node":    map[string]any{"Address": node},
+		"Service": map[string]any{"Address": address, "Port": port, "Weights": map[string]any{"Passing": weight}},
+	}
+}
+
+func newTestConsul(t *testing.T, f *fakeConsul) *Consul {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	consul, err := NewConsul(ConsulConfig{
+		Address:  server.URL,
+		Service:  "api",
+		Tag:      "v2",
+		Token:    "secret",
+		WaitTime: time.Second,
+	}, balancer.NewBackend)
+	if err != nil {
+		t.Fatalf("NewConsul failed: %v", err)
+	}
+	return consul
+}
+
+func TestConsul_BackendsFromHealthyInstances(t *testing.T) {
+	f := newFakeConsul()
+	f.set(instance("10.0.0.2", "", 8080, 1), instance("10.0.0.9", "10.1.0.1", 9090, 5))
+	consul := newTestConsul(t, f)
+
+	backends, err := consul.Backends(context.Background())
+	if err != nil {
+		t.Fatalf("Backends failed: %v", err)
+	}
+	if len(backends) != 2 || backends[0].Address != "10.0.0.2:8080" || backends[1].Address != "10.1.0.1:9090" {
+		t.Fatalf("Expected node address fallback and service address, got %v", addresses(backends))
+	}
+	if backends[1].GetWeight() != 5 {
+		t.Errorf("Expected passing weight 5, got %d", backends[1].GetWeight())
+	}
+
+	req := f.requests[0]
+	q := req.URL.Query()
+	if req.URL.Path != "/v1/health/service/api" || q.Get("passing") != "1" || q.Get("tag") != "v2" || req.Header.Get("X-Consul-Token") != "secret" {
+		t.Errorf("Unexpected query %s with token %q", req.URL, req.Header.Get("X-Consul-Token"))
+	}
+}
+
+func TestConsul_WatchSyncsBalancer(t *testing.T) {
+	f := newFakeConsul()
+	f.set(instance("10.0.0.1", "", 80, 1), instance("10.0.0.2", "", 80, 1))
+	consul := newTestConsul(t, f)
+
+	initial, err := consul.Backends(context.Background())
+	if err != nil {
+		t.Fatalf("Backends failed: %v", err)
+	}
+	lb := balancer.NewRoundRobin(initial)
+	leaving := initial[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		Sync(ctx, consul, lb)
+		close(done)
+	}()
+
+	// One instance leaves and another joins
+	f.set(instance("10.0.0.2", "", 80, 1), instance("10.0.0.3", "", 80, 1))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := addresses(lb.Backends()); len(got) == 2 && got[0] == "10.0.0.2:80" && got[1] == "10.0.0.3:80" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := addresses(lb.Backends()); len(got) != 2 || got[0] != "10.0.0.2:80" || got[1] != "10.0.0.3:80" {
+		t.Fatalf("Expected the balancer to follow Consul, got %v", got)
+	}
+	if lb.Backends()[0] != initial[1] {
+		t.Error("Expected a remaining instance to keep its backend")
+	}
+	if !leaving.IsDraining() {
+		t.Error("Expected the departed instance to be drained")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch did not stop when its context was cancelled")
+	}
+}
+
+func TestNewConsul_RejectsInvalidConfig(t *testing.T) {
+	bad := []ConsulConfig{
+		{Address: "127.0.0.1:8500", Service: "api", WaitTime: time.Minute},
+		{Address: "http://127.0.0.1:8500", WaitTime: time.Minute},
+		{Address: "http://127.0.0.1:8500", Service: "api"},
+	}
+	for _, config := range bad {
+		if _, err := NewConsul(config, balancer.NewBackend); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+}
+
+func addresses(backends []*balancer.Backend) []string {
+	addrs := make([]string, len(backends))
+	for i, b := range backends {
+		addrs[i] = b.Address
+	}
+	return addrs
+}
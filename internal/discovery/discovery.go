@@ -0,0 +1,38 @@
+// Package discovery supplies the backend set from sources that change at
+// runtime, such as a service registry.
+package discovery
+
+import (
+	"context"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+// Provider names
+const (
+	ProviderStatic = "static" // backends listed in the configuration
+	ProviderConsul = "consul" // healthy instances of a Consul service
+)
+
+// Provider is a source of backends
+type Provider interface {
+	// Backends fetches the current backend set
+	Backends(ctx context.Context) ([]*balancer.Backend, error)
+
+	// Watch calls update with the full backend set each time it changes,
+	// until ctx is done. Providers whose set never changes return at once.
+	Watch(ctx context.Context, update func([]*balancer.Backend))
+}
+
+// BackendFactory creates a backend for a discovered address, applying the
+// proxy's defaults such as the upstream protocol
+type BackendFactory func(address string, weight int) *balancer.Backend
+
+// Sync keeps lb's backends in step with p until ctx is done. Backends that
+// remain keep their health and connection state; removed backends are
+// drained.
+func Sync(ctx context.Context, p Provider, lb balancer.Balancer) {
+	p.Watch(ctx, func(backends []*balancer.Backend) {
+		lb.SetBackends(balancer.Reconcile(lb.Backends(), backends))
+	})
+}
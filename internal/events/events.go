@@ -0,0 +1,90 @@
+// Package events carries backend health and circuit breaker state changes
+// from the components that detect them to consumers such as webhooks.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// Type identifies the kind of state change
+type Type string
+
+const (
+	// BackendHealth is a backend moving between healthy and unhealthy
+	BackendHealth Type = "backend_health"
+	// CircuitState is a circuit breaker moving between CLOSED, OPEN and HALF-OPEN
+	CircuitState Type = "circuit_state"
+)
+
+// Backend health states reported in events
+const (
+	Healthy   = "healthy"
+	Unhealthy = "unhealthy"
+)
+
+// Event describes a single state transition of a backend
+type Event struct {
+	Type      Type      `json:"type"`
+	Backend   string    `json:"backend"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Source    string    `json:"source,omitempty"` // component that detected the change
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// whose buffer is full misses the event.
+type Bus struct {
+	subscribers []chan Event
+	mu          sync.RWMutex
+}
+
+// NewBus creates an event bus with no subscribers
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// buffering up to size undelivered events
+func (b *Bus) Subscribe(size int) <-chan Event {
+	ch := make(chan Event, size)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, ch)
+	return ch
+}
+
+// Publish sends e to all subscribers, stamping it with the current time if
+// unset. Publishing on a nil bus does nothing, so components can publish
+// unconditionally.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			logging.Default().Warn("subscriber queue full, dropping event", "type", e.Type, "backend", e.Backend)
+		}
+	}
+}
+
+// HealthChange is a convenience for publishing a backend health transition
+func (b *Bus) HealthChange(address string, healthy bool, source string) {
+	from, to := Healthy, Unhealthy
+	if healthy {
+		from, to = Unhealthy, Healthy
+	}
+	b.Publish(Event{Type: BackendHealth, Backend: address, From: from, To: to, Source: source})
+}
@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook_DeliversWithRetry(t *testing.T) {
+	var attempts int64
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("Invalid event body: %v", err)
+		}
+		received <- e
+	}))
+	defer server.Close()
+
+	bus := NewBus()
+	queue := bus.Subscribe(10)
+
+	webhook := NewWebhook(server.URL, time.Second, 2)
+	webhook.retryDelay = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhook.Run(ctx, queue)
+
+	bus.HealthChange("server1:8080", false, "active")
+
+	select {
+	case e := <-received:
+		if e.Type != BackendHealth || e.Backend != "server1:8080" || e.From != Healthy || e.To != Unhealthy {
+			t.Errorf("Unexpected event: %+v", e)
+		}
+		if e.Source != "active" || e.Timestamp.IsZero() {
+			t.Errorf("Expected source and timestamp, got %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Webhook did not receive the event")
+	}
+	if got := atomic.LoadInt64(&attempts); got != 2 {
+		t.Errorf("Expected 2 delivery attempts, got %d", got)
+	}
+}
+
+func TestBus_PublishDoesNotBlock(t *testing.T) {
+	bus := NewBus()
+	queue := bus.Subscribe(1)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			bus.HealthChange("server1:8080", i%2 == 0, "passive")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+	if len(queue) != 1 {
+		t.Errorf("Expected 1 buffered event, got %d", len(queue))
+	}
+
+	// Publishing without a bus is a no-op
+	var nilBus *Bus
+	nilBus.HealthChange("server1:8080", true, "active")
+}
@@ -0,0 +1,99 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// Webhook POSTs each event as JSON to a URL, retrying failed deliveries with
+// exponential backoff. Events are delivered one at a time, in order.
+type Webhook struct {
+	url        string
+	client     *http.Client
+	maxRetries int
+	retryDelay time.Duration // delay before the first retry, doubled after each
+}
+
+// NewWebhook creates a webhook sender; timeout bounds each delivery attempt
+func NewWebhook(url string, timeout time.Duration, maxRetries int) *Webhook {
+	return &Webhook{
+		url:        url,
+		client:     &http.Client{Timeout: timeout},
+		maxRetries: maxRetries,
+		retryDelay: time.Second,
+	}
+}
+
+// Run delivers events until ctx is done or events is closed
+func (w *Webhook) Run(ctx context.Context, events <-chan Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := w.deliver(ctx, e); err != nil && ctx.Err() == nil {
+				logging.Default().Warn("dropping event", "type", e.Type, "backend", e.Backend, "error", err)
+			}
+		}
+	}
+}
+
+// deliver sends one event, retrying network errors, 429s and 5xx responses
+func (w *Webhook) deliver(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	delay := w.retryDelay
+	for attempt := 0; ; attempt++ {
+		retryable, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= w.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is
+// worth retrying
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+}
@@ -2,23 +2,136 @@ package health
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/events"
+	"github.com/hermes-proxy/hermes/internal/logging"
 )
 
+// maxBodyMatchBytes bounds how much of a health response body is read for matching
+const maxBodyMatchBytes = 64 * 1024
+
+// StatusRange is an inclusive range of HTTP status codes
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// Contains reports whether code falls within the range
+func (r StatusRange) Contains(code int) bool {
+	return code >= r.Min && code <= r.Max
+}
+
+// ParseStatusRange parses a status code ("200") or range ("200-299")
+func ParseStatusRange(s string) (StatusRange, error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(s), "-")
+
+	low, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return StatusRange{}, fmt.Errorf("invalid status code %q", s)
+	}
+	high := low
+	if isRange {
+		high, err = strconv.Atoi(strings.TrimSpace(last))
+		if err != nil {
+			return StatusRange{}, fmt.Errorf("invalid status code %q", s)
+		}
+	}
+
+	if low < 100 || high > 599 || low > high {
+		return StatusRange{}, fmt.Errorf("invalid status range %q", s)
+	}
+	return StatusRange{Min: low, Max: high}, nil
+}
+
+// Check types
+const (
+	CheckHTTP = "http" // request the health path and judge the response
+	CheckTCP  = "tcp"  // only open a TCP connection to the backend
+)
+
+// Override replaces the checker's probe for a single backend. Zero fields
+// keep the checker default.
+type Override struct {
+	Path string
+	Type string // one of the Check* constants
+}
+
+// defaultStatusRanges treats any 2xx/3xx response as healthy
+var defaultStatusRanges = []StatusRange{{Min: 200, Max: 399}}
+
+// SyntheticCheck is a representative request sent after the health path
+// check passes, to catch backends whose health endpoint is fine while the
+// real request path is broken
+type SyntheticCheck struct {
+	Method string
+	Path   string
+	Body   string
+
+	// Response expectations, evaluated like those of the path check
+	ExpectedStatus []StatusRange
+	BodyMatch      *regexp.Regexp
+}
+
+// RecoveryHook runs before a recovering backend is marked healthy
+type RecoveryHook func(backend *balancer.Backend)
+
 // Checker performs active health checks on backends
 type Checker struct {
 	balancer           balancer.Balancer
 	interval           time.Duration
 	timeout            time.Duration
 	path               string
+	checkType          string
+	method             string
+	body               string
 	unhealthyThreshold int
 	healthyThreshold   int
 
+	// Response expectations
+	expectedStatus []StatusRange
+	bodyMatch      *regexp.Regexp
+
+	// Optional request a backend must also serve to be healthy
+	synthetic *SyntheticCheck
+
+	// Extra request headers and Host override sent only with health checks
+	headers http.Header
+	host    string
+
+	// Name the certificate presented by HTTPS backends must be valid for
+	expectedCertName string
+
+	// Fraction of interval used to randomize scheduling, e.g. 0.1 for ±10%
+	jitter float64
+
+	// Called before an unhealthy backend is returned to rotation
+	onRecover RecoveryHook
+
+	// Failures forgiven per success; 0 resets the failure count outright
+	recoveryDecrement int
+
+	// Health transitions are published and logged here
+	events *events.Bus
+	logger logging.Logger
+
+	// Backends held out of rotation until their first check passes
+	unverified map[string]bool
+
+	// Per-backend probe settings, keyed by address
+	overrides map[string]Override
+
 	// Track consecutive successes/failures per backend
 	failureCounts map[string]int
 	successCounts map[string]int
@@ -40,16 +153,159 @@ func NewChecker(
 		interval:           interval,
 		timeout:            timeout,
 		path:               path,
+		checkType:          CheckHTTP,
+		method:             http.MethodGet,
 		unhealthyThreshold: unhealthyThreshold,
 		healthyThreshold:   healthyThreshold,
+		expectedStatus:     defaultStatusRanges,
 		failureCounts:      make(map[string]int),
 		successCounts:      make(map[string]int),
+		logger:             logging.Default(),
 		client: &http.Client{
 			Timeout: timeout,
 		},
 	}
 }
 
+// SetExpectations configures which responses count as healthy. An empty
+// status list keeps the default 2xx/3xx behavior; a nil bodyMatch skips
+// body inspection.
+func (c *Checker) SetExpectations(statuses []StatusRange, bodyMatch *regexp.Regexp) {
+	if len(statuses) == 0 {
+		statuses = defaultStatusRanges
+	}
+	c.expectedStatus = statuses
+	c.bodyMatch = bodyMatch
+}
+
+// SetRequest sets the method and body of the health path check, for
+// endpoints that only accept POST or expect a probe payload. An empty method
+// means GET. The body is sent afresh with every check.
+func (c *Checker) SetRequest(method, body string) {
+	if method == "" {
+		method = http.MethodGet
+	}
+	c.method = method
+	c.body = body
+}
+
+// SetType sets how backends are checked: CheckHTTP requests the health path,
+// CheckTCP only opens a connection. An empty type means CheckHTTP.
+func (c *Checker) SetType(checkType string) {
+	if checkType == "" {
+		checkType = CheckHTTP
+	}
+	c.checkType = checkType
+}
+
+// SetOverrides sets per-backend health paths and check types keyed by
+// address, replacing any set before. Backends without one use the checker's
+// path and type.
+func (c *Checker) SetOverrides(overrides map[string]Override) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.overrides = overrides
+}
+
+// probe returns the health path and check type for backend
+func (c *Checker) probe(backend *balancer.Backend) (path, checkType string) {
+	c.mu.Lock()
+	override := c.overrides[backend.Address]
+	c.mu.Unlock()
+
+	path, checkType = c.path, c.checkType
+	if override.Path != "" {
+		path = override.Path
+	}
+	if override.Type != "" {
+		checkType = override.Type
+	}
+	return path, checkType
+}
+
+// SetSynthetic adds a synthetic request to every check: a backend passes
+// only if both the health path and the synthetic request get the expected
+// responses. An empty status list defaults to 2xx/3xx.
+func (c *Checker) SetSynthetic(check SyntheticCheck) {
+	if check.Method == "" {
+		check.Method = http.MethodGet
+	}
+	if len(check.ExpectedStatus) == 0 {
+		check.ExpectedStatus = defaultStatusRanges
+	}
+	c.synthetic = &check
+}
+
+// SetRequestHeaders configures extra headers (e.g. Authorization) and an
+// optional Host override sent with each health check request
+func (c *Checker) SetRequestHeaders(headers map[string]string, host string) {
+	c.headers = make(http.Header, len(headers))
+	for key, value := range headers {
+		c.headers.Set(key, value)
+	}
+	c.host = host
+}
+
+// SetTLS configures HTTPS health checks: cfg sets the SNI server name and
+// trusted roots, and a non-empty expectedCertName requires the presented leaf
+// certificate to be valid for that name. A mismatch means traffic is being
+// routed to the wrong backend, so it marks the backend unhealthy at once.
+func (c *Checker) SetTLS(cfg *tls.Config, expectedCertName string) {
+	if cfg != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.Clone()
+		c.client.Transport = transport
+	}
+	c.expectedCertName = expectedCertName
+}
+
+// SetJitter randomizes each check interval by ±fraction and staggers
+// individual backend checks within that window
+func (c *Checker) SetJitter(fraction float64) {
+	c.jitter = fraction
+}
+
+// SetRecoveryHook registers a function (e.g. connection warmup) that runs
+// before a recovered backend is marked healthy. Because slow start measures
+// from the moment the backend is marked healthy, the ramp begins after the
+// hook completes.
+func (c *Checker) SetRecoveryHook(hook RecoveryHook) {
+	c.onRecover = hook
+}
+
+// SetRecoveryDecrement makes each success only forgive n failures instead of
+// clearing the failure count, so a flapping backend recovers gradually.
+// A value of 0 restores the default reset-on-success behavior.
+func (c *Checker) SetRecoveryDecrement(n int) {
+	c.recoveryDecrement = n
+}
+
+// SetEvents publishes the health transitions found by checks to bus
+func (c *Checker) SetEvents(bus *events.Bus) {
+	c.events = bus
+}
+
+// SetLogger sets where health transitions are logged
+func (c *Checker) SetLogger(logger logging.Logger) {
+	c.logger = logger
+}
+
+// StartUnhealthy marks every current backend unhealthy until its first
+// check passes, so no traffic reaches a backend before it is verified. A
+// single passing check is enough, whatever the healthy threshold; a backend
+// that fails first must then recover normally. Call before Start.
+func (c *Checker) StartUnhealthy() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unverified = make(map[string]bool)
+	for _, b := range c.balancer.Backends() {
+		b.SetHealthy(false)
+		c.unverified[b.Address] = true
+	}
+}
+
 // Start begins the health check loop
 func (c *Checker) Start(ctx context.Context) {
 	ctx, c.cancel = context.WithCancel(ctx)
@@ -64,30 +320,57 @@ func (c *Checker) Stop() {
 }
 
 func (c *Checker) run(ctx context.Context) {
-	ticker := time.NewTicker(c.interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(c.nextInterval())
+	defer timer.Stop()
 
 	// Run initial check immediately
-	c.checkAll()
+	c.checkAll(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			c.checkAll()
+		case <-timer.C:
+			c.checkAll(ctx)
+			timer.Reset(c.nextInterval())
 		}
 	}
 }
 
-func (c *Checker) checkAll() {
+// nextInterval returns the check interval randomized by ±jitter
+func (c *Checker) nextInterval() time.Duration {
+	if c.jitter <= 0 {
+		return c.interval
+	}
+	spread := float64(c.interval) * c.jitter
+	return c.interval + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// staggerDelay returns a random offset within the jitter window, used to
+// spread individual backend checks across a cycle
+func (c *Checker) staggerDelay() time.Duration {
+	if c.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Float64() * float64(c.interval) * c.jitter)
+}
+
+func (c *Checker) checkAll(ctx context.Context) {
 	backends := c.balancer.Backends()
+	c.prune(backends)
 	var wg sync.WaitGroup
 
 	for _, backend := range backends {
 		wg.Add(1)
 		go func(b *balancer.Backend) {
 			defer wg.Done()
+			if delay := c.staggerDelay(); delay > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
 			c.checkBackend(b)
 		}(backend)
 	}
@@ -95,26 +378,163 @@ func (c *Checker) checkAll() {
 	wg.Wait()
 }
 
+// prune drops counters for backends no longer in the balancer, so removed
+// addresses do not accumulate and a re-added one starts from a clean slate
+func (c *Checker) prune(backends []*balancer.Backend) {
+	present := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		present[b.Address] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, counts := range []map[string]int{c.failureCounts, c.successCounts} {
+		for addr := range counts {
+			if !present[addr] {
+				delete(counts, addr)
+			}
+		}
+	}
+	for addr := range c.unverified {
+		if !present[addr] {
+			delete(c.unverified, addr)
+		}
+	}
+}
+
 func (c *Checker) checkBackend(backend *balancer.Backend) {
-	url := "http://" + backend.Address + c.path
+	healthy, misrouted := c.check(backend)
+	switch {
+	case misrouted:
+		c.markUnhealthy(backend, fmt.Sprintf("certificate not valid for %s", c.expectedCertName))
+	case healthy:
+		c.recordSuccess(backend)
+	default:
+		c.recordFailure(backend)
+	}
+}
+
+// Probe runs a single health check against backend and reports whether it
+// passed, without counting it towards the backend's thresholds
+func (c *Checker) Probe(backend *balancer.Backend) bool {
+	healthy, _ := c.check(backend)
+	return healthy
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// check probes backend, also reporting whether it presented a certificate
+// not valid for the expected name
+func (c *Checker) check(backend *balancer.Backend) (healthy, misrouted bool) {
+	path, checkType := c.probe(backend)
+	if checkType == CheckTCP {
+		return c.connects(backend), false
+	}
+
+	resp, err := c.send(backend, c.method, path, c.body)
 	if err != nil {
-		c.recordFailure(backend)
-		return
+		return false, false
 	}
+	defer resp.Body.Close()
 
-	resp, err := c.client.Do(req)
+	if c.expectedCertName != "" && resp.TLS != nil && !certMatches(resp.TLS, c.expectedCertName) {
+		return false, true
+	}
+	return isHealthyResponse(resp, c.expectedStatus, c.bodyMatch) && c.syntheticPasses(backend), false
+}
+
+// connects reports whether a TCP connection to backend can be opened within
+// the timeout. Response expectations and the synthetic request do not apply.
+func (c *Checker) connects(backend *balancer.Backend) bool {
+	conn, err := net.DialTimeout("tcp", backend.Address, c.timeout)
 	if err != nil {
-		c.recordFailure(backend)
-		return
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// send issues a check request to backend; the caller closes the response body
+func (c *Checker) send(backend *balancer.Backend, method, path, body string) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, backend.URL(path), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.headers {
+		req.Header[key] = values
+	}
+	if c.host != "" {
+		req.Host = c.host
+	}
+	return c.client.Do(req)
+}
+
+// syntheticPasses sends the synthetic request, if configured, and reports
+// whether the response meets its expectations
+func (c *Checker) syntheticPasses(backend *balancer.Backend) bool {
+	if c.synthetic == nil {
+		return true
+	}
+	resp, err := c.send(backend, c.synthetic.Method, c.synthetic.Path, c.synthetic.Body)
+	if err != nil {
+		return false
 	}
 	defer resp.Body.Close()
+	return isHealthyResponse(resp, c.synthetic.ExpectedStatus, c.synthetic.BodyMatch)
+}
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
-		c.recordSuccess(backend)
-	} else {
-		c.recordFailure(backend)
+// isHealthyResponse evaluates the status code and, if bodyMatch is set, the body
+func isHealthyResponse(resp *http.Response, statuses []StatusRange, bodyMatch *regexp.Regexp) bool {
+	statusOK := false
+	for _, r := range statuses {
+		if r.Contains(resp.StatusCode) {
+			statusOK = true
+			break
+		}
+	}
+	if !statusOK {
+		return false
+	}
+
+	if bodyMatch == nil {
+		return true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyMatchBytes))
+	if err != nil {
+		return false
+	}
+	return bodyMatch.Match(body)
+}
+
+// certMatches reports whether the leaf certificate names the expected host in
+// its SANs or, for legacy certificates without SANs, its common name
+func certMatches(state *tls.ConnectionState, name string) bool {
+	if len(state.PeerCertificates) == 0 {
+		return false
+	}
+	leaf := state.PeerCertificates[0]
+	if leaf.VerifyHostname(name) == nil {
+		return true
+	}
+	return len(leaf.DNSNames) == 0 && len(leaf.IPAddresses) == 0 && strings.EqualFold(leaf.Subject.CommonName, name)
+}
+
+// markUnhealthy takes a backend out of rotation without waiting for the
+// failure threshold
+func (c *Checker) markUnhealthy(backend *balancer.Backend, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.successCounts[backend.Address] = 0
+	c.failureCounts[backend.Address] = c.unhealthyThreshold
+	delete(c.unverified, backend.Address)
+	if backend.IsHealthy() {
+		c.logger.Warn("backend marked unhealthy", "backend", backend.Address, "reason", reason)
+		backend.SetHealthy(false)
+		c.events.HealthChange(backend.Address, false, "active")
 	}
 }
 
@@ -124,28 +544,50 @@ func (c *Checker) recordFailure(backend *balancer.Backend) {
 
 	c.successCounts[backend.Address] = 0
 	c.failureCounts[backend.Address]++
+	delete(c.unverified, backend.Address)
 
 	if c.failureCounts[backend.Address] >= c.unhealthyThreshold {
 		if backend.IsHealthy() {
-			log.Printf("[HEALTH] Backend %s marked UNHEALTHY after %d failures",
-				backend.Address, c.failureCounts[backend.Address])
+			c.logger.Warn("backend marked unhealthy", "backend", backend.Address,
+				"failures", c.failureCounts[backend.Address])
 			backend.SetHealthy(false)
+			c.events.HealthChange(backend.Address, false, "active")
 		}
 	}
 }
 
 func (c *Checker) recordSuccess(backend *balancer.Backend) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	c.failureCounts[backend.Address] = 0
+	c.decayFailures(backend.Address)
 	c.successCounts[backend.Address]++
+	successes := c.successCounts[backend.Address]
+	threshold := c.healthyThreshold
+	if c.unverified[backend.Address] {
+		threshold = 1
+		delete(c.unverified, backend.Address)
+	}
+	c.mu.Unlock()
 
-	if c.successCounts[backend.Address] >= c.healthyThreshold {
-		if !backend.IsHealthy() {
-			log.Printf("[HEALTH] Backend %s marked HEALTHY after %d successes",
-				backend.Address, c.successCounts[backend.Address])
-			backend.SetHealthy(true)
+	// The recovery hook may block, so it runs without holding c.mu
+	if successes >= threshold && !backend.IsHealthy() {
+		if c.onRecover != nil {
+			c.onRecover(backend)
 		}
+		c.logger.Info("backend marked healthy", "backend", backend.Address, "successes", successes)
+		backend.SetHealthy(true)
+		c.events.HealthChange(backend.Address, true, "active")
+	}
+}
+
+// decayFailures lowers the failure count after a success. Callers must hold c.mu.
+func (c *Checker) decayFailures(address string) {
+	if c.recoveryDecrement <= 0 {
+		c.failureCounts[address] = 0
+		return
+	}
+
+	c.failureCounts[address] -= c.recoveryDecrement
+	if c.failureCounts[address] < 0 {
+		c.failureCounts[address] = 0
 	}
 }
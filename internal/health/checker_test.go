@@ -0,0 +1,696 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+func newTestChecker(addr string) (*Checker, *balancer.Backend) {
+	backend := balancer.NewBackend(addr, 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{backend})
+	return NewChecker(lb, time.Second, time.Second, "/health", 1, 1), backend
+}
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    StatusRange
+		wantErr bool
+	}{
+		{"200", StatusRange{200, 200}, false},
+		{"200-299", StatusRange{200, 299}, false},
+		{" 204 - 206 ", StatusRange{204, 206}, false},
+		{"abc", StatusRange{}, true},
+		{"299-200", StatusRange{}, true},
+		{"600", StatusRange{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStatusRange(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStatusRange(%q): unexpected error state: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseStatusRange(%q): expected %v, got %v", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestChecker_BodyMismatchMarksUnhealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"degraded"}`))
+	}))
+	defer server.Close()
+
+	checker, backend := newTestChecker(strings.TrimPrefix(server.URL, "http://"))
+	checker.SetExpectations(nil, regexp.MustCompile(`"status":"ok"`))
+
+	checker.checkBackend(backend)
+
+	if backend.IsHealthy() {
+		t.Error("Backend with 200 but non-matching body should be unhealthy")
+	}
+}
+
+func TestChecker_BodyMatchKeepsHealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	checker, backend := newTestChecker(strings.TrimPrefix(server.URL, "http://"))
+	checker.SetExpectations(nil, regexp.MustCompile(`"status":"ok"`))
+
+	checker.checkBackend(backend)
+
+	if !backend.IsHealthy() {
+		t.Error("Backend with matching body should stay healthy")
+	}
+}
+
+func TestChecker_UnexpectedStatusMarksUnhealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	checker, backend := newTestChecker(strings.TrimPrefix(server.URL, "http://"))
+	checker.SetExpectations([]StatusRange{{200, 200}}, nil)
+
+	checker.checkBackend(backend)
+
+	if backend.IsHealthy() {
+		t.Error("Backend returning 204 should be unhealthy when only 200 is expected")
+	}
+}
+
+func TestChecker_RecoveryDecrementIsGradual(t *testing.T) {
+	backend := balancer.NewBackend("test:8080", 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{backend})
+	checker := NewChecker(lb, time.Second, time.Second, "/health", 3, 1)
+	checker.SetRecoveryDecrement(1)
+
+	// Two failures, one short of the threshold
+	checker.recordFailure(backend)
+	checker.recordFailure(backend)
+
+	checker.recordSuccess(backend)
+
+	if got := checker.failureCounts[backend.Address]; got != 1 {
+		t.Fatalf("Expected failure count 1 after single success, got %d", got)
+	}
+
+	// Two more failures reach the threshold; a full reset would have needed three
+	checker.recordFailure(backend)
+	checker.recordFailure(backend)
+
+	if backend.IsHealthy() {
+		t.Error("Flapping backend should be marked unhealthy")
+	}
+}
+
+func TestChecker_DefaultSuccessResetsFailures(t *testing.T) {
+	backend := balancer.NewBackend("test:8080", 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{backend})
+	checker := NewChecker(lb, time.Second, time.Second, "/health", 3, 1)
+
+	checker.recordFailure(backend)
+	checker.recordFailure(backend)
+	checker.recordSuccess(backend)
+
+	if got := checker.failureCounts[backend.Address]; got != 0 {
+		t.Errorf("Expected failure count reset to 0, got %d", got)
+	}
+}
+
+func TestChecker_SendsCustomHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Host != "internal.example.com" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	checker, backend := newTestChecker(strings.TrimPrefix(server.URL, "http://"))
+	checker.SetRequestHeaders(map[string]string{"Authorization": "Bearer secret"}, "internal.example.com")
+
+	checker.checkBackend(backend)
+
+	if !backend.IsHealthy() {
+		t.Error("Backend should accept health check with configured headers")
+	}
+}
+
+func TestChecker_JitterBoundsInterval(t *testing.T) {
+	checker, _ := newTestChecker("test:8080")
+	checker.interval = 10 * time.Second
+	checker.SetJitter(0.2)
+
+	for i := 0; i < 100; i++ {
+		d := checker.nextInterval()
+		if d < 8*time.Second || d > 12*time.Second {
+			t.Fatalf("Interval %v outside ±20%% of 10s", d)
+		}
+		if s := checker.staggerDelay(); s < 0 || s > 2*time.Second {
+			t.Fatalf("Stagger delay %v outside jitter window", s)
+		}
+	}
+}
+
+func TestChecker_RecoveryHookRunsBeforeHealthy(t *testing.T) {
+	checker, backend := newTestChecker("test:8080")
+	backend.SetHealthy(false)
+
+	hookCalls := 0
+	checker.SetRecoveryHook(func(b *balancer.Backend) {
+		hookCalls++
+		if b.IsHealthy() {
+			t.Error("Backend should not receive traffic before the recovery hook finishes")
+		}
+	})
+
+	checker.recordSuccess(backend)
+
+	if hookCalls != 1 {
+		t.Errorf("Expected recovery hook to run once, got %d", hookCalls)
+	}
+	if !backend.IsHealthy() {
+		t.Error("Backend should be healthy after recovery")
+	}
+
+	// Already healthy backends do not trigger the hook again
+	checker.recordSuccess(backend)
+	if hookCalls != 1 {
+		t.Errorf("Expected no further hook calls, got %d", hookCalls)
+	}
+}
+
+func TestOutlierDetector_EjectsAndReinstates(t *testing.T) {
+	backend := balancer.NewBackend("server1:8080", 1)
+	spare := balancer.NewBackend("server2:8080", 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{backend, spare})
+	detector := NewOutlierDetector(lb, 0.5, 4, time.Minute, 50*time.Millisecond)
+
+	// Below min_requests nothing happens, however bad the rate
+	for i := 0; i < 3; i++ {
+		detector.Record(backend.Address, http.StatusInternalServerError, time.Millisecond)
+	}
+	if !backend.IsAvailable() {
+		t.Fatal("Backend ejected before reaching min_requests")
+	}
+
+	detector.Record(backend.Address, http.StatusOK, time.Millisecond)
+	if backend.IsAvailable() {
+		t.Fatal("Expected backend ejected at 75% error rate")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !backend.IsAvailable() {
+		t.Error("Expected backend reinstated after ejection time")
+	}
+}
+
+// newOutlierPool creates n backends and a detector over them that only
+// ejects on consecutive 5xx responses
+func newOutlierPool(n int) ([]*balancer.Backend, *OutlierDetector) {
+	backends := make([]*balancer.Backend, n)
+	for i := range backends {
+		backends[i] = balancer.NewBackend(fmt.Sprintf("server%d:8080", i+1), 1)
+	}
+	detector := NewOutlierDetector(balancer.NewRoundRobin(backends), 0, 0, 0, time.Minute)
+	detector.SetConsecutive5xx(2)
+	return backends, detector
+}
+
+func TestOutlierDetector_MaxEjectionPercent(t *testing.T) {
+	backends, detector := newOutlierPool(4)
+	detector.SetEjectionLimits(time.Minute, 50)
+
+	// Every backend fails, but at most half the pool may be ejected
+	for _, b := range backends {
+		for i := 0; i < 2; i++ {
+			detector.Record(b.Address, http.StatusBadGateway, 0)
+		}
+	}
+	healthy := 0
+	for _, b := range backends {
+		if b.IsAvailable() {
+			healthy++
+		}
+	}
+	if healthy != 2 {
+		t.Errorf("Expected 2 of 4 backends left in rotation at 50%%, got %d", healthy)
+	}
+}
+
+func TestOutlierDetector_NeverEjectsWholePool(t *testing.T) {
+	backends, detector := newOutlierPool(2)
+	detector.SetEjectionLimits(time.Minute, 100)
+
+	for _, b := range backends {
+		for i := 0; i < 2; i++ {
+			detector.Record(b.Address, http.StatusServiceUnavailable, 0)
+		}
+	}
+	if backends[0].IsAvailable() || !backends[1].IsAvailable() {
+		t.Fatalf("Expected only the first backend ejected, got %v %v", backends[0].IsAvailable(), backends[1].IsAvailable())
+	}
+
+	// Nor is the last available backend when the others are out for other
+	// reasons, such as draining
+	backends, detector = newOutlierPool(2)
+	backends[0].SetDraining(true)
+	for i := 0; i < 2; i++ {
+		detector.Record(backends[1].Address, http.StatusInternalServerError, 0)
+	}
+	if !backends[1].IsAvailable() {
+		t.Error("Expected the last available backend kept in rotation")
+	}
+}
+
+func TestOutlierDetector_RepeatedEjectionsLastLonger(t *testing.T) {
+	backends, detector := newOutlierPool(2)
+	detector.SetEjectionLimits(time.Minute, 50)
+	detector.baseEjectionTime = 50 * time.Millisecond
+
+	var probes atomic.Int64
+	var passing atomic.Bool
+	detector.SetProbe(func(b *balancer.Backend) bool {
+		probes.Add(1)
+		return passing.Load()
+	})
+
+	target := backends[0]
+	// ejectFor ejects the target and returns how long it stayed out
+	ejectFor := func() time.Duration {
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			detector.Record(target.Address, http.StatusInternalServerError, 0)
+		}
+		for !target.IsAvailable() {
+			if time.Since(start) > 2*time.Second {
+				t.Fatal("Backend was never reinstated")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return time.Since(start)
+	}
+
+	// The first probe fails, keeping the backend out for a second period
+	// of twice the base time
+	go func() {
+		time.Sleep(75 * time.Millisecond)
+		passing.Store(true)
+	}()
+	if out := ejectFor(); out < 150*time.Millisecond || probes.Load() != 2 {
+		t.Fatalf("Expected 50ms + 100ms out with two probes, got %v and %d probes", out, probes.Load())
+	}
+
+	// Ejected again at once: the third ejection lasts three base periods
+	if out := ejectFor(); out < 150*time.Millisecond {
+		t.Errorf("Expected a repeated ejection to last 150ms, got %v", out)
+	}
+}
+
+func TestOutlierDetector_EjectionOutlastsActiveChecks(t *testing.T) {
+	var failing atomic.Bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+	spare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer spare.Close()
+
+	backend := balancer.NewBackend(strings.TrimPrefix(target.URL, "http://"), 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{
+		backend, balancer.NewBackend(strings.TrimPrefix(spare.URL, "http://"), 1),
+	})
+	checker := NewChecker(lb, 10*time.Millisecond, time.Second, "/health", 1, 1)
+	detector := NewOutlierDetector(lb, 0, 0, 0, 200*time.Millisecond)
+	detector.SetConsecutive5xx(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checker.Start(ctx)
+
+	detector.Record(backend.Address, http.StatusBadGateway, 0)
+	detector.Record(backend.Address, http.StatusBadGateway, 0)
+
+	// Passing checks during the ejection do not return the backend early
+	time.Sleep(100 * time.Millisecond)
+	if backend.IsAvailable() || !backend.IsHealthy() {
+		t.Fatalf("Expected the backend healthy but still ejected, got available=%v healthy=%v",
+			backend.IsAvailable(), backend.IsHealthy())
+	}
+
+	// Nor does reinstatement return a backend the checker has marked down
+	failing.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for backend.IsEjected() || backend.IsHealthy() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected reinstated and unhealthy, got ejected=%v healthy=%v",
+				backend.IsEjected(), backend.IsHealthy())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if backend.IsAvailable() {
+		t.Error("Expected the unhealthy backend kept out of rotation after reinstatement")
+	}
+}
+
+func TestOutlierDetector_RepeatedEjectionsOutlastActiveChecks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	spare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer spare.Close()
+
+	backend := balancer.NewBackend(strings.TrimPrefix(server.URL, "http://"), 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{
+		backend, balancer.NewBackend(strings.TrimPrefix(spare.URL, "http://"), 1),
+	})
+	checker := NewChecker(lb, 10*time.Millisecond, time.Second, "/health", 1, 1)
+	detector := NewOutlierDetector(lb, 0, 0, 0, 50*time.Millisecond)
+	detector.SetConsecutive5xx(2)
+	detector.SetProbe(checker.Probe)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checker.Start(ctx)
+
+	// ejectFor ejects the backend and returns how long it stayed out
+	ejectFor := func() time.Duration {
+		start := time.Now()
+		detector.Record(backend.Address, http.StatusInternalServerError, 0)
+		detector.Record(backend.Address, http.StatusInternalServerError, 0)
+		for !backend.IsAvailable() {
+			if time.Since(start) > 2*time.Second {
+				t.Fatal("Backend was never reinstated")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return time.Since(start)
+	}
+
+	if out := ejectFor(); out < 50*time.Millisecond {
+		t.Fatalf("Expected the first ejection to last 50ms, got %v", out)
+	}
+	// Ejected again at once, for twice the base time, with checks passing
+	if out := ejectFor(); out < 100*time.Millisecond {
+		t.Errorf("Expected the second ejection to last 100ms, got %v", out)
+	}
+}
+
+func TestOutlierDetector_DeviationFromPool(t *testing.T) {
+	backends := []*balancer.Backend{
+		balancer.NewBackend("server1:8080", 1),
+		balancer.NewBackend("server2:8080", 1),
+		balancer.NewBackend("server3:8080", 1),
+		balancer.NewBackend("server4:8080", 1),
+		balancer.NewBackend("server5:8080", 1),
+	}
+	detector := NewOutlierDetector(balancer.NewRoundRobin(backends), 0, 0, 0, time.Minute)
+	detector.SetDeviation(Deviation{
+		Interval:      time.Second,
+		MinHosts:      3,
+		MinRequests:   10,
+		StdevFactor:   1.5,
+		LatencyFactor: 3,
+	})
+
+	for i := 0; i < 20; i++ {
+		for j, b := range backends {
+			status, latency := http.StatusOK, 10*time.Millisecond
+			switch {
+			case j == 1 && i%2 == 0:
+				status = http.StatusInternalServerError // 50% errors
+			case j == 2:
+				latency = 100 * time.Millisecond
+			case j == 3 && i%10 == 0:
+				status = http.StatusInternalServerError // 10% errors, within the pool's spread
+			}
+			detector.Record(b.Address, status, latency)
+		}
+	}
+	// Below min_requests, not compared
+	detector.Record("server6:8080", http.StatusInternalServerError, 0)
+
+	detector.evaluate()
+	want := []bool{true, false, false, true, true}
+	for i, b := range backends {
+		if b.IsAvailable() != want[i] {
+			t.Errorf("Expected %s available=%v, got %v", b.Address, want[i], b.IsAvailable())
+		}
+	}
+}
+
+func TestChecker_UnexpectedCertificateMarksUnhealthy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// The httptest certificate is issued for example.com
+	trusted := server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	checker, backend := newTestChecker(strings.TrimPrefix(server.URL, "https://"))
+	backend.Scheme = "https"
+	checker.SetTLS(trusted, "example.com")
+
+	checker.checkBackend(backend)
+	if !backend.IsHealthy() {
+		t.Fatal("Backend presenting the expected certificate should stay healthy")
+	}
+
+	checker.SetTLS(trusted, "api.internal")
+	checker.checkBackend(backend)
+	if backend.IsHealthy() {
+		t.Error("Backend presenting an unexpected certificate should be unhealthy")
+	}
+}
+
+func TestChecker_StartUnhealthyUntilFirstCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	backend := balancer.NewBackend(strings.TrimPrefix(server.URL, "http://"), 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{backend})
+	checker := NewChecker(lb, time.Second, time.Second, "/health", 3, 3)
+
+	checker.StartUnhealthy()
+	if next := lb.Next(); next != nil {
+		t.Fatalf("Expected no backend before the first check, got %s", next.Address)
+	}
+
+	// One passing check is enough, despite healthy_threshold of 3
+	checker.checkBackend(backend)
+	if next := lb.Next(); next != backend {
+		t.Fatal("Expected backend in rotation after its first passing check")
+	}
+}
+
+func TestChecker_StartUnhealthyFailedFirstCheckRecoversNormally(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	backend := balancer.NewBackend(strings.TrimPrefix(server.URL, "http://"), 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{backend})
+	checker := NewChecker(lb, time.Second, time.Second, "/health", 1, 2)
+	checker.StartUnhealthy()
+
+	checker.checkBackend(backend)
+	healthy.Store(true)
+	checker.checkBackend(backend)
+	if backend.IsHealthy() {
+		t.Error("Expected healthy_threshold to apply after a failed first check")
+	}
+	checker.checkBackend(backend)
+	if !backend.IsHealthy() {
+		t.Error("Expected backend healthy after healthy_threshold successes")
+	}
+}
+
+func TestChecker_PrunesRemovedBackends(t *testing.T) {
+	kept := balancer.NewBackend("127.0.0.1:1", 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{kept})
+	checker := NewChecker(lb, time.Second, time.Second, "/health", 3, 1)
+
+	// Closed ports refuse at once, so every check records a failure
+	for port := 2; port <= 6; port++ {
+		lb.SetBackends([]*balancer.Backend{kept, balancer.NewBackend(fmt.Sprintf("127.0.0.1:%d", port), 1)})
+		checker.checkAll(context.Background())
+	}
+	lb.SetBackends([]*balancer.Backend{kept})
+	checker.checkAll(context.Background())
+
+	checker.mu.Lock()
+	for _, counts := range []map[string]int{checker.failureCounts, checker.successCounts} {
+		for addr := range counts {
+			if addr != kept.Address {
+				t.Errorf("Expected counters for removed backend %s to be pruned", addr)
+			}
+		}
+	}
+	checker.mu.Unlock()
+
+	// A re-added address starts over instead of inheriting old failures
+	readded := balancer.NewBackend("127.0.0.1:2", 1)
+	lb.SetBackends([]*balancer.Backend{kept, readded})
+	checker.checkAll(context.Background())
+	if !readded.IsHealthy() {
+		t.Error("Expected a re-added backend to need a full unhealthy_threshold of failures")
+	}
+}
+
+func TestChecker_SyntheticFailureMarksUnhealthy(t *testing.T) {
+	var broken atomic.Bool
+	var gotMethod, gotBody atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotMethod.Store(r.Method + " " + r.URL.Path)
+		gotBody.Store(string(body))
+		if broken.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+
+	checker, backend := newTestChecker(strings.TrimPrefix(server.URL, "http://"))
+	checker.SetSynthetic(SyntheticCheck{
+		Method:         http.MethodPost,
+		Path:           "/api/orders",
+		Body:           `{"dry_run":true}`,
+		ExpectedStatus: []StatusRange{{201, 201}},
+		BodyMatch:      regexp.MustCompile(`"id"`),
+	})
+
+	checker.checkBackend(backend)
+	if !backend.IsHealthy() {
+		t.Fatal("Backend serving both requests should stay healthy")
+	}
+	if gotMethod.Load() != "POST /api/orders" || gotBody.Load() != `{"dry_run":true}` {
+		t.Errorf("Expected configured synthetic request, got %v with body %v", gotMethod.Load(), gotBody.Load())
+	}
+
+	// The health path still passes, but the real endpoint is broken
+	broken.Store(true)
+	checker.checkBackend(backend)
+	if backend.IsHealthy() {
+		t.Error("Backend failing the synthetic request should be unhealthy")
+	}
+}
+
+func TestChecker_ConfiguredMethodAndBody(t *testing.T) {
+	var probes, mismatched atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		probes.Add(1)
+		if r.Method != http.MethodPost || string(body) != `{"probe":true}` {
+			mismatched.Add(1)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	checker, backend := newTestChecker(strings.TrimPrefix(server.URL, "http://"))
+	checker.SetRequest(http.MethodPost, `{"probe":true}`)
+	checker.SetExpectations(nil, regexp.MustCompile(`"ok"`))
+
+	// Checks run repeatedly and concurrently; each must carry the full body
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checker.checkBackend(backend)
+		}()
+	}
+	wg.Wait()
+
+	if probes.Load() != 10 || mismatched.Load() != 0 {
+		t.Errorf("Expected 10 POST probes with the configured body, got %d with %d mismatched", probes.Load(), mismatched.Load())
+	}
+	if !backend.IsHealthy() {
+		t.Error("Backend answering the POST probe should be healthy")
+	}
+}
+
+func TestChecker_PerBackendPathAndType(t *testing.T) {
+	healthz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer healthz.Close()
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer status.Close()
+	// Accepts connections but never speaks HTTP
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer raw.Close()
+
+	a := balancer.NewBackend(strings.TrimPrefix(healthz.URL, "http://"), 1)
+	b := balancer.NewBackend(strings.TrimPrefix(status.URL, "http://"), 1)
+	c := balancer.NewBackend(raw.Addr().String(), 1)
+	lb := balancer.NewRoundRobin([]*balancer.Backend{a, b, c})
+	checker := NewChecker(lb, time.Second, 200*time.Millisecond, "/healthz", 1, 1)
+	checker.SetOverrides(map[string]Override{
+		b.Address: {Path: "/status"},
+		c.Address: {Type: CheckTCP},
+	})
+
+	checker.checkAll(context.Background())
+	for _, backend := range []*balancer.Backend{a, b, c} {
+		if !backend.IsHealthy() {
+			t.Errorf("Expected %s healthy on its own health check", backend.Address)
+		}
+	}
+
+	// Without the overrides, the global path and type apply again
+	checker.SetOverrides(nil)
+	checker.checkAll(context.Background())
+	if !a.IsHealthy() || b.IsHealthy() || c.IsHealthy() {
+		t.Errorf("Expected only %s healthy on the global check, got %v %v %v",
+			a.Address, a.IsHealthy(), b.IsHealthy(), c.IsHealthy())
+	}
+}
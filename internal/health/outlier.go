@@ -0,0 +1,456 @@
+package health
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/events"
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// outlierBuckets is how many slices the rolling error-rate window is split into
+const outlierBuckets = 10
+
+// Ejection limits used until SetEjectionLimits is called
+const (
+	defaultMaxEjectionTime    = 5 * time.Minute
+	defaultMaxEjectionPercent = 50
+)
+
+// OutlierDetector ejects backends whose responses stand out: a rolling 5xx
+// rate over a threshold, a run of consecutive 5xx responses, or a success
+// rate or latency far from the rest of the pool. An ejected backend is
+// reinstated once its ejection time has passed and, if a probe is set, the
+// probe succeeds. Each repeated ejection lasts longer. Ejection does not
+// change a backend's health.
+type OutlierDetector struct {
+	balancer balancer.Balancer
+
+	// Rolling error rate; a zero threshold disables it
+	threshold   float64
+	minRequests int64
+	bucketWidth time.Duration
+
+	// Responses in a row that are 5xx before ejecting; 0 disables it
+	consecutive5xx int
+
+	// Pool-relative checks, run by Start; nil disables them
+	deviation *Deviation
+
+	baseEjectionTime   time.Duration
+	maxEjectionTime    time.Duration
+	maxEjectionPercent int
+
+	// Run before an ejected backend is reinstated; false keeps it ejected
+	probe func(*balancer.Backend) bool
+
+	hosts map[string]*outlierHost
+	mu    sync.Mutex
+
+	events *events.Bus
+	logger logging.Logger
+}
+
+// Deviation configures the checks that compare each backend with the rest
+// of the pool. Only backends with at least MinRequests responses within an
+// interval are compared, and only when MinHosts of them have that many.
+type Deviation struct {
+	Interval    time.Duration
+	MinHosts    int
+	MinRequests int
+
+	// Eject a backend whose success rate is below the pool mean by more
+	// than StdevFactor standard deviations; 0 disables it
+	StdevFactor float64
+
+	// Eject a backend whose mean latency exceeds LatencyFactor times the
+	// pool median; 0 disables it
+	LatencyFactor float64
+}
+
+// outlierHost is the detector's state for one backend
+type outlierHost struct {
+	window      rateWindow
+	consecutive int
+
+	// Counted since the last deviation interval
+	total        int64
+	errors       int64
+	latency      time.Duration // sum over latencyCount non-5xx responses
+	latencyCount int64
+
+	ejected      bool
+	ejections    int // multiplies the base ejection time
+	reinstatedAt time.Time
+}
+
+// rateWindow counts responses per time slice for one backend
+type rateWindow struct {
+	buckets [outlierBuckets]rateBucket
+}
+
+type rateBucket struct {
+	slot   int64
+	total  int64
+	errors int64
+}
+
+// NewOutlierDetector creates a detector that ejects a backend for
+// ejectionTime when at least minRequests responses within window have a
+// 5xx fraction of threshold or more. A zero threshold turns that check off,
+// for use with only the other checks. ejectionTime is also the base time of
+// ejections made by the other checks.
+func NewOutlierDetector(
+	b balancer.Balancer,
+	threshold float64,
+	minRequests int,
+	window, ejectionTime time.Duration,
+) *OutlierDetector {
+	bucketWidth := window / outlierBuckets
+	if bucketWidth <= 0 {
+		bucketWidth = 1
+	}
+	return &OutlierDetector{
+		balancer:           b,
+		threshold:          threshold,
+		minRequests:        int64(minRequests),
+		bucketWidth:        bucketWidth,
+		baseEjectionTime:   ejectionTime,
+		maxEjectionTime:    defaultMaxEjectionTime,
+		maxEjectionPercent: defaultMaxEjectionPercent,
+		hosts:              make(map[string]*outlierHost),
+		logger:             logging.Default(),
+	}
+}
+
+// SetEvents publishes ejections and reinstatements to bus
+func (d *OutlierDetector) SetEvents(bus *events.Bus) {
+	d.events = bus
+}
+
+// SetLogger sets where ejections and reinstatements are logged
+func (d *OutlierDetector) SetLogger(logger logging.Logger) {
+	d.logger = logger
+}
+
+// SetConsecutive5xx ejects a backend after n 5xx responses in a row; 0
+// disables it
+func (d *OutlierDetector) SetConsecutive5xx(n int) {
+	d.consecutive5xx = n
+}
+
+// SetDeviation enables the pool-relative success rate and latency checks,
+// evaluated every interval once Start is called
+func (d *OutlierDetector) SetDeviation(deviation Deviation) {
+	d.deviation = &deviation
+}
+
+// SetEjectionLimits bounds ejections. The nth ejection of a backend lasts n
+// times the base ejection time, up to maxTime; the count falls by one for
+// each base ejection time the backend then stays in rotation. A backend is
+// only ejected while at most maxPercent of the pool would be ejected, and
+// never when no other backend is available.
+func (d *OutlierDetector) SetEjectionLimits(maxTime time.Duration, maxPercent int) {
+	d.maxEjectionTime = maxTime
+	d.maxEjectionPercent = maxPercent
+}
+
+// SetProbe sets a check run once an ejection time has passed: a backend
+// that fails it stays ejected for another, longer, period. Without a probe
+// backends are reinstated unchecked.
+func (d *OutlierDetector) SetProbe(probe func(*balancer.Backend) bool) {
+	d.probe = probe
+}
+
+// Start runs the pool-relative checks every deviation interval until ctx is
+// done. It does nothing when they are not enabled.
+func (d *OutlierDetector) Start(ctx context.Context) {
+	if d.deviation == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(d.deviation.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.evaluate()
+			}
+		}
+	}()
+}
+
+// Record counts a response from a backend, with the time until its headers
+// arrived, and ejects the backend if it now trips the error rate or the
+// consecutive 5xx limit
+func (d *OutlierDetector) Record(address string, status int, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h, ok := d.hosts[address]
+	if !ok {
+		h = &outlierHost{}
+		d.hosts[address] = h
+	}
+	if h.ejected {
+		return
+	}
+
+	failed := status >= 500
+	h.total++
+	if failed {
+		h.errors++
+		h.consecutive++
+	} else {
+		h.consecutive = 0
+		h.latency += latency
+		h.latencyCount++
+	}
+
+	if d.consecutive5xx > 0 && h.consecutive >= d.consecutive5xx {
+		d.eject(address, h, "consecutive 5xx", "responses", h.consecutive)
+		return
+	}
+	if d.threshold <= 0 {
+		return
+	}
+
+	errors, total := h.window.add(time.Now(), failed, d.bucketWidth)
+	if total >= d.minRequests && float64(errors)/float64(total) >= d.threshold {
+		d.eject(address, h, "error rate", "errors", errors, "responses", total)
+	}
+}
+
+// add counts a response in the window and returns the errors and total
+// within it
+func (w *rateWindow) add(now time.Time, failed bool, bucketWidth time.Duration) (errors, total int64) {
+	slot := now.UnixNano() / int64(bucketWidth)
+	bucket := &w.buckets[slot%outlierBuckets]
+	if bucket.slot != slot {
+		*bucket = rateBucket{slot: slot}
+	}
+	bucket.total++
+	if failed {
+		bucket.errors++
+	}
+
+	for _, b := range w.buckets {
+		if slot-b.slot < outlierBuckets {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	return errors, total
+}
+
+// evaluate ejects backends whose success rate or latency over the last
+// interval deviates from the pool, then starts a new interval
+func (d *OutlierDetector) evaluate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	backends := d.balancer.Backends()
+	d.prune(backends)
+
+	var compared []string
+	for _, b := range backends {
+		if h := d.hosts[b.Address]; h != nil && !h.ejected && h.total >= int64(d.deviation.MinRequests) {
+			compared = append(compared, b.Address)
+		}
+	}
+	defer func() {
+		for _, h := range d.hosts {
+			h.total, h.errors, h.latency, h.latencyCount = 0, 0, 0, 0
+		}
+	}()
+	if len(compared) < d.deviation.MinHosts {
+		return
+	}
+
+	if factor := d.deviation.StdevFactor; factor > 0 {
+		rates := make([]float64, len(compared))
+		for i, address := range compared {
+			h := d.hosts[address]
+			rates[i] = 1 - float64(h.errors)/float64(h.total)
+		}
+		mean, stdev := meanStdev(rates)
+		for i, address := range compared {
+			if rates[i] < mean-factor*stdev {
+				d.eject(address, d.hosts[address], "success rate",
+					"success_rate", rates[i], "pool_mean", mean)
+			}
+		}
+	}
+
+	if factor := d.deviation.LatencyFactor; factor > 0 {
+		latencies := make(map[string]time.Duration)
+		for _, address := range compared {
+			if h := d.hosts[address]; !h.ejected && h.latencyCount > 0 {
+				latencies[address] = h.latency / time.Duration(h.latencyCount)
+			}
+		}
+		if len(latencies) < d.deviation.MinHosts {
+			return
+		}
+		median := medianDuration(latencies)
+		for address, latency := range latencies {
+			if float64(latency) > factor*float64(median) {
+				d.eject(address, d.hosts[address], "latency",
+					"latency", latency, "pool_median", median)
+			}
+		}
+	}
+}
+
+// prune drops state for backends no longer in the balancer. Callers must
+// hold d.mu.
+func (d *OutlierDetector) prune(backends []*balancer.Backend) {
+	present := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		present[b.Address] = true
+	}
+	for address := range d.hosts {
+		if !present[address] {
+			delete(d.hosts, address)
+		}
+	}
+}
+
+// eject takes a backend out of rotation unless that would exceed the
+// ejection limits; either way its counts start over. Callers must hold d.mu.
+func (d *OutlierDetector) eject(address string, h *outlierHost, reason string, details ...any) {
+	h.window, h.consecutive = rateWindow{}, 0
+	h.total, h.errors, h.latency, h.latencyCount = 0, 0, 0, 0
+
+	if !d.canEject(address) {
+		d.logger.Warn("backend not ejected, ejection limit reached",
+			append([]any{"backend", address, "reason", reason}, details...)...)
+		return
+	}
+
+	// Time spent back in rotation earns forgiveness for earlier ejections
+	if !h.reinstatedAt.IsZero() && d.baseEjectionTime > 0 {
+		forgiven := int(time.Since(h.reinstatedAt) / d.baseEjectionTime)
+		h.ejections = max(h.ejections-forgiven, 0)
+	}
+	h.ejections++
+	h.ejected = true
+	duration := d.ejectionTime(h.ejections)
+
+	d.logger.Warn("backend ejected",
+		append([]any{"backend", address, "reason", reason, "duration", duration}, details...)...)
+	d.setEjected(address, true)
+	time.AfterFunc(duration, func() { d.reinstate(address) })
+}
+
+// canEject reports whether address may be ejected: another backend must
+// remain available and the ejected share of the pool must stay within
+// maxEjectionPercent. Callers must hold d.mu.
+func (d *OutlierDetector) canEject(address string) bool {
+	backends := d.balancer.Backends()
+	ejected, available := 0, 0
+	for _, b := range backends {
+		if h := d.hosts[b.Address]; h != nil && h.ejected {
+			ejected++
+		} else if b.Address != address && b.IsAvailable() {
+			available++
+		}
+	}
+	return available > 0 && (ejected+1)*100 <= d.maxEjectionPercent*len(backends)
+}
+
+// ejectionTime returns how long the nth ejection of a backend lasts
+func (d *OutlierDetector) ejectionTime(n int) time.Duration {
+	return min(d.baseEjectionTime*time.Duration(n), max(d.maxEjectionTime, d.baseEjectionTime))
+}
+
+// reinstate returns an ejected backend to rotation once it passes the
+// probe; one that fails stays ejected for its next, longer, period
+func (d *OutlierDetector) reinstate(address string) {
+	var backend *balancer.Backend
+	for _, b := range d.balancer.Backends() {
+		if b.Address == address {
+			backend = b
+			break
+		}
+	}
+
+	d.mu.Lock()
+	h := d.hosts[address]
+	if backend == nil || h == nil || !h.ejected {
+		// Removed from the pool while ejected
+		delete(d.hosts, address)
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+
+	// The probe may block, so it runs without holding d.mu
+	if d.probe != nil && !d.probe(backend) {
+		d.mu.Lock()
+		h.ejections++
+		duration := d.ejectionTime(h.ejections)
+		d.mu.Unlock()
+
+		d.logger.Warn("ejected backend failed its probe", "backend", address, "duration", duration)
+		time.AfterFunc(duration, func() { d.reinstate(address) })
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.logger.Info("backend reinstated after ejection", "backend", address)
+	h.ejected = false
+	h.reinstatedAt = time.Now()
+	d.setEjected(address, false)
+}
+
+// setEjected takes a backend out of rotation or returns it. Ejection is
+// separate from health, so active checks passing meanwhile do not end it,
+// and a backend the checker marked down stays down once reinstated.
+func (d *OutlierDetector) setEjected(address string, ejected bool) {
+	for _, b := range d.balancer.Backends() {
+		if b.Address == address {
+			b.SetEjected(ejected)
+			d.events.HealthChange(address, !ejected, "outlier")
+			return
+		}
+	}
+}
+
+// meanStdev returns the mean and population standard deviation of values
+func meanStdev(values []float64) (mean, stdev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// medianDuration returns the median of the values in m
+func medianDuration(m map[string]time.Duration) time.Duration {
+	values := make([]time.Duration, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	slices.Sort(values)
+
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
@@ -1,10 +1,11 @@
 package health
 
 import (
-	"log"
 	"sync"
 
 	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/events"
+	"github.com/hermes-proxy/hermes/internal/logging"
 )
 
 // PassiveMonitor tracks failures during actual request proxying
@@ -14,6 +15,9 @@ type PassiveMonitor struct {
 
 	failureCounts map[string]int
 	mu            sync.Mutex
+
+	events *events.Bus
+	logger logging.Logger
 }
 
 // NewPassiveMonitor creates a new passive health monitor
@@ -22,9 +26,20 @@ func NewPassiveMonitor(b balancer.Balancer, unhealthyThreshold int) *PassiveMoni
 		balancer:           b,
 		unhealthyThreshold: unhealthyThreshold,
 		failureCounts:      make(map[string]int),
+		logger:             logging.Default(),
 	}
 }
 
+// SetEvents publishes the health transitions this monitor causes to bus
+func (p *PassiveMonitor) SetEvents(bus *events.Bus) {
+	p.events = bus
+}
+
+// SetLogger sets where health transitions are logged
+func (p *PassiveMonitor) SetLogger(logger logging.Logger) {
+	p.logger = logger
+}
+
 // RecordSuccess records a successful request to a backend
 func (p *PassiveMonitor) RecordSuccess(address string) {
 	p.mu.Lock()
@@ -42,15 +57,46 @@ func (p *PassiveMonitor) RecordFailure(address string) {
 	p.failureCounts[address]++
 
 	if p.failureCounts[address] >= p.unhealthyThreshold {
-		log.Printf("[PASSIVE] Backend %s marked UNHEALTHY after %d consecutive failures",
-			address, p.failureCounts[address])
-		p.balancer.MarkUnhealthy(address)
+		p.logger.Warn("backend marked unhealthy", "backend", address,
+			"consecutive_failures", p.failureCounts[address])
+		setHealth(p.balancer, p.events, address, false, "passive")
 	}
 }
 
+// Eject marks a backend unhealthy immediately, bypassing the threshold
+func (p *PassiveMonitor) Eject(address, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logger.Warn("backend marked unhealthy", "backend", address, "reason", reason)
+	p.failureCounts[address] = p.unhealthyThreshold
+	setHealth(p.balancer, p.events, address, false, "passive")
+}
+
 // Reset clears all failure counts
 func (p *PassiveMonitor) Reset(address string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.failureCounts[address] = 0
 }
+
+// setHealth marks the backend at address healthy or unhealthy, publishing an
+// event from source when that changes its state
+func setHealth(lb balancer.Balancer, bus *events.Bus, address string, healthy bool, source string) {
+	changed := false
+	for _, b := range lb.Backends() {
+		if b.Address == address {
+			changed = b.IsHealthy() != healthy
+			break
+		}
+	}
+
+	if healthy {
+		lb.MarkHealthy(address)
+	} else {
+		lb.MarkUnhealthy(address)
+	}
+	if changed {
+		bus.HealthChange(address, healthy, source)
+	}
+}
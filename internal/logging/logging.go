@@ -0,0 +1,72 @@
+// Package logging provides the leveled, structured logger used across Hermes.
+// It is a thin layer over log/slog: components accept the Logger interface,
+// and *slog.Logger satisfies it.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Logger is implemented by leveled loggers. Args are alternating keys and
+// values, as in log/slog.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses "debug", "info", "warn" or "error"; empty means info
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+}
+
+// New creates a logger writing records at or above level to w, as
+// logfmt-style text or JSON lines
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (use text or json)", format)
+}
+
+// Default returns a logger that forwards to slog.Default at each call, so
+// components created before the process logger is installed still use it
+func Default() Logger {
+	return defaultLogger{}
+}
+
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, args ...any) { slog.Default().Debug(msg, args...) }
+func (defaultLogger) Info(msg string, args ...any)  { slog.Default().Info(msg, args...) }
+func (defaultLogger) Warn(msg string, args ...any)  { slog.Default().Warn(msg, args...) }
+func (defaultLogger) Error(msg string, args ...any) { slog.Default().Error(msg, args...) }
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Access list modes
+const (
+	AccessAllowlist = "allowlist" // only listed clients are let through
+	AccessDenylist  = "denylist"  // listed clients are refused
+)
+
+// AccessRule restricts clients by IP. A rule with a PathPrefix applies only
+// to requests under it.
+type AccessRule struct {
+	PathPrefix string
+	Mode       string   // AccessAllowlist or AccessDenylist
+	CIDRs      []string // client CIDRs or bare IPs
+}
+
+// accessList is a parsed AccessRule
+type accessList struct {
+	prefix   string
+	allow    bool // allowlist mode
+	networks []*net.IPNet
+}
+
+// AccessControl refuses requests by client IP before they reach a backend.
+// A request must pass the global rule, if any, and the route rule with the
+// longest matching path prefix, if any.
+type AccessControl struct {
+	global *accessList
+	routes []*accessList
+}
+
+// NewAccessControl creates access control from an optional global rule (nil
+// for none) and per-route rules
+func NewAccessControl(global *AccessRule, routes []AccessRule) (*AccessControl, error) {
+	ac := &AccessControl{}
+	if global != nil {
+		list, err := newAccessList(*global)
+		if err != nil {
+			return nil, err
+		}
+		ac.global = list
+	}
+	for i, rule := range routes {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("routes[%d]: path_prefix %q must start with /", i, rule.PathPrefix)
+		}
+		list, err := newAccessList(rule)
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d]: %w", i, err)
+		}
+		ac.routes = append(ac.routes, list)
+	}
+	return ac, nil
+}
+
+// newAccessList parses a rule
+func newAccessList(rule AccessRule) (*accessList, error) {
+	list := &accessList{prefix: rule.PathPrefix}
+	switch rule.Mode {
+	case AccessAllowlist:
+		list.allow = true
+		if len(rule.CIDRs) == 0 {
+			return nil, fmt.Errorf("an allowlist needs at least one CIDR")
+		}
+	case AccessDenylist:
+	default:
+		return nil, fmt.Errorf("unknown mode %q (use %s or %s)", rule.Mode, AccessAllowlist, AccessDenylist)
+	}
+
+	networks, err := parseNetworks(rule.CIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR: %w", err)
+	}
+	list.networks = networks
+	return list, nil
+}
+
+// permits reports whether the list lets clientIP through. A client IP that
+// does not parse matches no network.
+func (l *accessList) permits(clientIP string) bool {
+	return containsIP(l.networks, clientIP) == l.allow
+}
+
+// allows reports whether a request from clientIP may proceed
+func (a *AccessControl) allows(r *http.Request, clientIP string) bool {
+	if a.global != nil && !a.global.permits(clientIP) {
+		return false
+	}
+
+	var route *accessList
+	for _, l := range a.routes {
+		if strings.HasPrefix(r.URL.Path, l.prefix) && (route == nil || len(l.prefix) > len(route.prefix)) {
+			route = l
+		}
+	}
+	return route == nil || route.permits(clientIP)
+}
+
+// SetAccessControl sets the client IP restrictions; nil lets every client
+// through
+func (h *Handler) SetAccessControl(a *AccessControl) {
+	h.accessControl = a
+}
+
+// deniedAccess reports whether access control refuses r
+func (h *Handler) deniedAccess(r *http.Request) bool {
+	return h.accessControl != nil && !h.accessControl.allows(r, h.clientIP(r))
+}
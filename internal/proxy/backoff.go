@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Jitter strategies for retry backoff
+const (
+	// JitterNone waits the full exponential delay
+	JitterNone = "none"
+	// JitterEqual waits half the delay plus a random share of the other half
+	JitterEqual = "equal"
+	// JitterFull waits a random time between zero and the delay
+	JitterFull = "full"
+)
+
+// Backoff computes exponential delays between retry attempts, randomized so
+// concurrent requests that failed together do not retry together
+type Backoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter string
+}
+
+// NewBackoff creates a backoff doubling from base up to max
+func NewBackoff(base, max time.Duration, jitter string) (*Backoff, error) {
+	switch jitter {
+	case JitterNone, JitterEqual, JitterFull:
+	default:
+		return nil, fmt.Errorf("unknown jitter strategy: %s", jitter)
+	}
+	if max < base {
+		max = base
+	}
+	return &Backoff{base: base, max: max, jitter: jitter}, nil
+}
+
+// Delay returns how long to wait before the given retry (1 for the first)
+func (b *Backoff) Delay(retry int) time.Duration {
+	delay := b.base
+	for i := 1; i < retry && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	switch b.jitter {
+	case JitterEqual:
+		half := delay / 2
+		return half + rand.N(delay-half+1)
+	case JitterFull:
+		return rand.N(delay + 1)
+	default:
+		return delay
+	}
+}
+
+// wait sleeps for the retry's delay, returning false if ctx ends first
+func (b *Backoff) wait(ctx context.Context, retry int) bool {
+	delay := b.Delay(retry)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+// BodyRouter picks a backend pool from a field in the buffered JSON request
+// body, e.g. a tenant ID. Requests without a matching value use the default
+// balancer.
+type BodyRouter struct {
+	path     []string
+	maxBytes int64
+	pools    map[string]balancer.Balancer
+}
+
+// NewBodyRouter routes on the value at jsonPath (dot-separated object keys,
+// e.g. "tenant.id"). Bodies larger than maxBytes are not inspected.
+func NewBodyRouter(jsonPath string, maxBytes int64, pools map[string]balancer.Balancer) (*BodyRouter, error) {
+	path := strings.Split(jsonPath, ".")
+	for _, key := range path {
+		if key == "" {
+			return nil, fmt.Errorf("invalid json path %q", jsonPath)
+		}
+	}
+	return &BodyRouter{
+		path:     path,
+		maxBytes: maxBytes,
+		pools:    pools,
+	}, nil
+}
+
+// Route returns the pool for the body's routing value, or nil to use the
+// default balancer
+func (b *BodyRouter) Route(body *BufferedBody) balancer.Balancer {
+	if body.Len() == 0 || body.Len() > b.maxBytes {
+		return nil
+	}
+	value, ok := b.lookup(body.Reader())
+	if !ok {
+		return nil
+	}
+	return b.pools[value]
+}
+
+// lookup extracts the value at the configured path as a string. Strings,
+// numbers and booleans are supported.
+func (b *BodyRouter) lookup(data io.Reader) (string, bool) {
+	decoder := json.NewDecoder(data)
+	decoder.UseNumber()
+
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return "", false
+	}
+
+	for _, key := range b.path {
+		object, ok := doc.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if doc, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
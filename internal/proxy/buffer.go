@@ -2,14 +2,25 @@ package proxy
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+var (
+	errBodyTooLarge = errors.New("request body too large")
+	errClientClosed = errors.New("client closed request while its body was buffered")
+	errSpillFailed  = errors.New("failed to spill request body to disk")
 )
 
 // Buffer wraps request body with buffering capabilities
 type Buffer struct {
-	maxSize int64
+	maxSize       int64
+	diskThreshold int64 // bodies larger than this spill to a temp file; 0 = never
 }
 
 // NewBuffer creates a new request buffer
@@ -17,8 +28,106 @@ func NewBuffer(maxSize int64) *Buffer {
 	return &Buffer{maxSize: maxSize}
 }
 
-// BufferRequest reads and buffers the request body
-func (b *Buffer) BufferRequest(r *http.Request) (*bytes.Buffer, error) {
+// BufferedBody is a fully read request body, held in memory or, once it
+// grows past the disk threshold, in a temporary file. It can be read any
+// number of times; Close releases the file.
+type BufferedBody struct {
+	mem  bytes.Buffer
+	file *os.File
+	size int64
+
+	// Gzip-compressed copy made by a RequestCompressor; gzipDone is set
+	// once it has been attempted, even if the body did not shrink
+	gzipped  *BufferedBody
+	gzipDone bool
+}
+
+// Len returns the size of the body in bytes
+func (b *BufferedBody) Len() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.size
+}
+
+// OnDisk reports whether the body was spilled to a temporary file
+func (b *BufferedBody) OnDisk() bool {
+	return b != nil && b.file != nil
+}
+
+// Reader returns a reader positioned at the start of the body. Readers are
+// independent, so each retry attempt gets its own.
+func (b *BufferedBody) Reader() io.ReadSeeker {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+	return bytes.NewReader(b.mem.Bytes())
+}
+
+// Close removes the temporary file, if any, including that of a compressed
+// copy. It is safe on a nil body.
+func (b *BufferedBody) Close() error {
+	if b == nil {
+		return nil
+	}
+	gzErr := b.gzipped.Close()
+	if b.file == nil {
+		return gzErr
+	}
+	name := b.file.Name()
+	b.file.Close()
+	b.file = nil
+	return errors.Join(gzErr, os.Remove(name))
+}
+
+// write appends p, moving the body to a temporary file once it would exceed
+// threshold
+func (b *BufferedBody) write(p []byte, threshold int64) (int, error) {
+	if b.file == nil && threshold > 0 && b.size+int64(len(p)) > threshold {
+		file, err := os.CreateTemp("", "hermes-body-*")
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", errSpillFailed, err)
+		}
+		b.file = file
+		if _, err := file.Write(b.mem.Bytes()); err != nil {
+			return 0, fmt.Errorf("%w: %w", errSpillFailed, err)
+		}
+		b.mem = bytes.Buffer{}
+	}
+
+	if b.file == nil {
+		n, _ := b.mem.Write(p)
+		b.size += int64(n)
+		return n, nil
+	}
+	n, err := b.file.Write(p)
+	b.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("%w: %w", errSpillFailed, err)
+	}
+	return n, nil
+}
+
+// bodyWriter adapts a BufferedBody to io.Writer with a fixed threshold
+type bodyWriter struct {
+	body      *BufferedBody
+	threshold int64
+}
+
+func (w bodyWriter) Write(p []byte) (int, error) {
+	return w.body.write(p, w.threshold)
+}
+
+// SetDiskThreshold makes bodies larger than threshold bytes spill to a
+// temporary file instead of memory. Zero keeps every body in memory.
+func (b *Buffer) SetDiskThreshold(threshold int64) {
+	b.diskThreshold = threshold
+}
+
+// BufferRequest reads and buffers the request body. It gives up as soon as
+// the request context ends, so an abandoned upload is not read to the end.
+// The caller must Close the returned body once the request is done.
+func (b *Buffer) BufferRequest(r *http.Request) (*BufferedBody, error) {
 	if r.Body == nil {
 		return nil, nil
 	}
@@ -26,23 +135,59 @@ func (b *Buffer) BufferRequest(r *http.Request) (*bytes.Buffer, error) {
 	// Limit the reader to prevent OOM
 	limitedReader := io.LimitReader(r.Body, b.maxSize+1)
 
-	buf := &bytes.Buffer{}
-	n, err := io.Copy(buf, limitedReader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to buffer request body: %w", err)
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	body := &BufferedBody{}
+	// A blocked body read cannot be interrupted, so copy in the background;
+	// it ends once the server closes the abandoned connection
+	go func() {
+		n, err := io.Copy(bodyWriter{body, b.diskThreshold}, limitedReader)
+		done <- result{n, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-r.Context().Done():
+		// The copy may still be writing to a spill file; remove it after
+		go func() {
+			<-done
+			closeBody(body)
+		}()
+		return nil, fmt.Errorf("%w: %w", errClientClosed, r.Context().Err())
 	}
 
-	if n > b.maxSize {
-		return nil, fmt.Errorf("request body too large: %d bytes (max: %d)", n, b.maxSize)
+	if res.err != nil {
+		closeBody(body)
+		if errors.Is(res.err, errSpillFailed) {
+			return nil, res.err
+		}
+		return nil, fmt.Errorf("%w: %w", errClientClosed, res.err)
 	}
 
-	return buf, nil
+	if res.n > b.maxSize {
+		closeBody(body)
+		return nil, fmt.Errorf("%w: %d bytes (max: %d)", errBodyTooLarge, res.n, b.maxSize)
+	}
+
+	return body, nil
+}
+
+// closeBody releases a buffered body, logging a temp file that could not
+// be removed
+func closeBody(body *BufferedBody) {
+	if err := body.Close(); err != nil {
+		logging.Default().Warn("failed to remove request body spill file", "error", err)
+	}
 }
 
-// WrapBody wraps a buffer as a ReadCloser for re-reading
-func WrapBody(buf *bytes.Buffer) io.ReadCloser {
-	if buf == nil {
+// WrapBody wraps a buffered body as a ReadCloser for re-reading
+func WrapBody(body *BufferedBody) io.ReadCloser {
+	if body == nil {
 		return nil
 	}
-	return io.NopCloser(bytes.NewReader(buf.Bytes()))
+	return io.NopCloser(body.Reader())
 }
@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"container/list"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache keeps cacheable GET responses in memory, evicting the least
+// recently used entry when full. Responses that Vary on request headers are
+// stored per combination of those header values. Entries past their max-age
+// are still served for the stale-while-revalidate window while a single
+// background request refreshes them.
+type ResponseCache struct {
+	maxEntries   int
+	maxBodyBytes int64
+	entries      map[string]*list.Element // variant key -> *cacheEntry
+	lru          *list.List               // most recently used at the front
+	vary         map[string]*cacheVary    // request key -> how its responses vary
+	keyRules     []CacheKeyRule
+	mu           sync.Mutex
+}
+
+// CacheKeyRule adds request headers to the cache key of requests under
+// PathPrefix, for backends whose responses depend on headers they do not
+// list in Vary
+type CacheKeyRule struct {
+	PathPrefix string
+	Headers    []string
+}
+
+// cacheVary records the request headers the responses to a request key vary
+// on, and how many variants of it are stored; it is dropped with the last one
+type cacheVary struct {
+	names    []string
+	variants int
+}
+
+// cacheEntry is a stored response and its freshness bounds
+type cacheEntry struct {
+	key          string // variant key
+	base         string // request key the variant belongs to
+	status       int
+	header       http.Header
+	body         []byte
+	storedAt     time.Time
+	maxAge       time.Duration
+	staleWindow  time.Duration
+	revalidating bool
+}
+
+// NewResponseCache creates a cache holding up to maxEntries responses, each
+// with a body no larger than maxBodyBytes
+func NewResponseCache(maxEntries int, maxBodyBytes int64) *ResponseCache {
+	return &ResponseCache{
+		maxEntries:   maxEntries,
+		maxBodyBytes: maxBodyBytes,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		vary:         make(map[string]*cacheVary),
+	}
+}
+
+// SetKeyRules sets the per-path headers added to cache keys. It must be
+// called before the cache is used.
+func (c *ResponseCache) SetKeyRules(rules []CacheKeyRule) {
+	c.keyRules = make([]CacheKeyRule, len(rules))
+	for i, rule := range rules {
+		headers := make([]string, len(rule.Headers))
+		for j, name := range rule.Headers {
+			headers[j] = http.CanonicalHeaderKey(name)
+		}
+		c.keyRules[i] = CacheKeyRule{PathPrefix: rule.PathPrefix, Headers: headers}
+	}
+}
+
+// key returns the cache key for r, or "" if the request is not cacheable
+func (c *ResponseCache) key(r *http.Request) string {
+	if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" {
+		return ""
+	}
+	key := r.Method + " " + r.Host + r.URL.RequestURI()
+	// Compressed and identity bodies are stored separately
+	if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		key += "|gzip"
+	}
+
+	var names []string
+	for _, rule := range c.keyRules {
+		if strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			for _, name := range rule.Headers {
+				if !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	slices.Sort(names)
+	return variantKey(key, r, names)
+}
+
+// variantKey extends key with r's values for the headers the response varies on
+func variantKey(key string, r *http.Request, names []string) string {
+	for _, name := range names {
+		key += "|" + name + "=" + strings.Join(r.Header.Values(name), ",")
+	}
+	return key
+}
+
+// lookup returns the entry for r if it is fresh or within its stale window.
+// revalidate is true for exactly one caller per stale period, which is then
+// responsible for refreshing the entry.
+func (c *ResponseCache) lookup(r *http.Request, key string) (entry *cacheEntry, revalidate bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.vary[key]
+	if !ok {
+		return nil, false
+	}
+	el, ok := c.entries[variantKey(key, r, v.names)]
+	if !ok {
+		return nil, false
+	}
+	entry = el.Value.(*cacheEntry)
+
+	age := time.Since(entry.storedAt)
+	switch {
+	case age < entry.maxAge:
+		c.lru.MoveToFront(el)
+		return entry, false
+	case age < entry.maxAge+entry.staleWindow:
+		c.lru.MoveToFront(el)
+		if !entry.revalidating {
+			entry.revalidating = true
+			return entry, true
+		}
+		return entry, false
+	default:
+		c.remove(el)
+		return nil, false
+	}
+}
+
+// revalidationFailed lets a later request retry refreshing entry
+func (c *ResponseCache) revalidationFailed(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.revalidating = false
+}
+
+// store saves a captured response to r if its headers permit caching,
+// reporting whether it was stored
+func (c *ResponseCache) store(r *http.Request, key string, rec *cacheRecorder) bool {
+	if rec.status != http.StatusOK || rec.overflow || rec.incomplete {
+		return false
+	}
+	maxAge, staleWindow, ok := cacheLifetime(rec.header)
+	if !ok {
+		return false
+	}
+	names, ok := varyNames(rec.header)
+	if !ok {
+		return false
+	}
+
+	entry := &cacheEntry{
+		key:         variantKey(key, r, names),
+		base:        key,
+		status:      rec.status,
+		header:      rec.header,
+		body:        rec.body,
+		storedAt:    time.Now(),
+		maxAge:      maxAge,
+		staleWindow: staleWindow,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, exists := c.entries[entry.key]; exists {
+		c.vary[key].names = names
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return true
+	}
+	if c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	v, ok := c.vary[key]
+	if !ok {
+		v = &cacheVary{}
+		c.vary[key] = v
+	}
+	v.names = names
+	v.variants++
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	return true
+}
+
+// remove drops an entry from the cache, and the Vary names of its request
+// key along with the last variant. Callers must hold c.mu.
+func (c *ResponseCache) remove(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, entry.key)
+	if v := c.vary[entry.base]; v != nil {
+		if v.variants--; v.variants <= 0 {
+			delete(c.vary, entry.base)
+		}
+	}
+}
+
+// varyNames returns the canonical request header names a response varies on,
+// leaving out Accept-Encoding which the request key already covers; ok is
+// false for "Vary: *", which cannot be cached
+func varyNames(h http.Header) (names []string, ok bool) {
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			switch name {
+			case "":
+			case "*":
+				return nil, false
+			case "Accept-Encoding":
+			default:
+				if !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, true
+}
+
+// cacheLifetime derives the freshness lifetime and stale-while-revalidate
+// window from response headers; ok is false if the response must not be
+// cached. Responses setting cookies are never shared between clients.
+func cacheLifetime(h http.Header) (maxAge, staleWindow time.Duration, ok bool) {
+	if h.Get("Set-Cookie") != "" {
+		return 0, 0, false
+	}
+	directives := parseCacheControl(h.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, found := directives[d]; found {
+			return 0, 0, false
+		}
+	}
+
+	seconds, found := directives["s-maxage"]
+	if !found {
+		seconds, found = directives["max-age"]
+	}
+	if !found {
+		return 0, 0, false
+	}
+	maxAge = parseSeconds(seconds)
+	staleWindow = parseSeconds(directives["stale-while-revalidate"])
+
+	// Time already spent in upstream caches counts against freshness
+	if age := h.Get("Age"); age != "" {
+		maxAge -= parseSeconds(age)
+	}
+	if maxAge+staleWindow <= 0 {
+		return 0, 0, false
+	}
+	return maxAge, staleWindow, true
+}
+
+// parseCacheControl splits a Cache-Control header into lowercase directives
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+	}
+	return directives
+}
+
+// parseSeconds converts a delta-seconds value, treating invalid input as zero
+func parseSeconds(s string) time.Duration {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// writeTo replays the entry to w, marking whether it was served stale
+func (e *cacheEntry) writeTo(w http.ResponseWriter, stale bool) {
+	copyHeaders(w.Header(), e.header)
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(e.storedAt).Seconds())))
+	if stale {
+		w.Header().Set("X-Cache", "STALE")
+	} else {
+		w.Header().Set("X-Cache", "HIT")
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// cacheRecorder passes a response through to the client while keeping a copy
+// of the status, headers, and up to limit bytes of body. incomplete is set
+// when the upstream body broke off before its end.
+type cacheRecorder struct {
+	http.ResponseWriter
+	limit      int64
+	status     int
+	header     http.Header
+	body       []byte
+	overflow   bool
+	incomplete bool
+}
+
+func (c *cacheRecorder) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow {
+		if int64(len(c.body)+len(b)) > c.limit {
+			c.overflow = true
+			c.body = nil
+		} else {
+			c.body = append(c.body, b...)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// bodyIncomplete marks a response being captured for the cache as cut
+// short, so the partial body is never stored
+func bodyIncomplete(w http.ResponseWriter) {
+	if rec, ok := w.(*cacheRecorder); ok {
+		rec.incomplete = true
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *cacheRecorder) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// discardWriter is a ResponseWriter for background requests with no client
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver determines the client IP from an ordered list of headers,
+// honoring them only when the request arrives from a trusted proxy
+type ClientIPResolver struct {
+	headers []string
+	trusted []*net.IPNet
+}
+
+// defaultClientIPHeaders matches the handler's built-in lookup order
+var defaultClientIPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
+
+// NewClientIPResolver creates a resolver consulting headers in order (X-Real-IP
+// then X-Forwarded-For when empty). Headers are only honored for peers within
+// trustedProxies (CIDRs or bare IPs); an empty list trusts no peer, so the
+// client IP is always the connection's remote address.
+func NewClientIPResolver(headers []string, trustedProxies []string) (*ClientIPResolver, error) {
+	if len(headers) == 0 {
+		headers = defaultClientIPHeaders
+	}
+
+	resolver := &ClientIPResolver{}
+	for _, h := range headers {
+		resolver.headers = append(resolver.headers, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+	}
+
+	trusted, err := parseNetworks(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	resolver.trusted = trusted
+
+	return resolver, nil
+}
+
+// ClientIP returns the resolved client IP for a request
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	peer := remoteHost(r)
+	if !c.isTrusted(peer) {
+		return peer
+	}
+
+	for _, header := range c.headers {
+		if header == "X-Forwarded-For" {
+			// A proxy may add its own line rather than append to the last,
+			// so the chain spans every line in order
+			value := forwardedFor(r.Header)
+			if value == "" {
+				continue
+			}
+			if ip := c.fromForwardedFor(value); ip != "" {
+				return ip
+			}
+			continue
+		}
+
+		value := r.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(strings.TrimSpace(value)); ip != nil {
+			return ip.String()
+		}
+	}
+
+	return peer
+}
+
+// forwardedFor returns the X-Forwarded-For chain of h, joining its lines
+func forwardedFor(h http.Header) string {
+	return strings.Join(h.Values("X-Forwarded-For"), ",")
+}
+
+// fromForwardedFor walks the chain right to left, skipping trusted proxies,
+// and returns the first untrusted hop. Anything left of that hop was supplied
+// by the client and is ignored. A malformed entry stops the walk, leaving the
+// last trusted hop as the answer.
+func (c *ClientIPResolver) fromForwardedFor(value string) string {
+	hops := strings.Split(value, ",")
+	var leftmost string
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		leftmost = ip.String()
+		if !c.isTrusted(leftmost) {
+			return leftmost
+		}
+	}
+	return leftmost
+}
+
+// isTrusted reports whether addr may supply client IP headers
+func (c *ClientIPResolver) isTrusted(addr string) bool {
+	return containsIP(c.trusted, addr)
+}
+
+// parseNetworks parses CIDRs or bare IPs, widening a bare IP to a single
+// address network
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether addr parses as an IP within any of networks
+func containsIP(networks []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the host portion of the request's RemoteAddr
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compressor gzip-compresses eligible responses for clients that accept it
+type Compressor struct {
+	minSize      int64
+	contentTypes map[string]bool
+}
+
+// NewCompressor creates a compressor for the given media types. Responses
+// with a known length below minSize are sent uncompressed.
+func NewCompressor(minSize int64, contentTypes []string) *Compressor {
+	types := make(map[string]bool, len(contentTypes))
+	for _, t := range contentTypes {
+		types[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	return &Compressor{
+		minSize:      minSize,
+		contentTypes: types,
+	}
+}
+
+// ShouldCompress reports whether resp should be compressed for req
+func (c *Compressor) ShouldCompress(req *http.Request, resp *http.Response) bool {
+	if req.Method == http.MethodHead || !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+		return false
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < c.minSize {
+		return false
+	}
+
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return c.contentTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+}
+
+// PrepareHeaders rewrites response headers for a gzip-encoded body. The
+// length is unknown up front, so Content-Length is dropped and the response
+// is sent chunked.
+func (c *Compressor) PrepareHeaders(h http.Header) {
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// Copy writes body to w through a gzip encoder
+func (c *Compressor) Copy(w io.Writer, body io.Reader) error {
+	gz := gzip.NewWriter(w)
+	if _, err := io.Copy(gz, body); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// RequestCompressor gzip-compresses buffered request bodies for backends
+// that advertise accepting them (RFC 7694: an Accept-Encoding header in a
+// response lists the codings the server accepts in requests)
+type RequestCompressor struct {
+	minSize       int64
+	diskThreshold int64
+}
+
+// NewRequestCompressor creates a request compressor. Bodies smaller than
+// minSize are sent as-is; compressed bodies larger than diskThreshold spill
+// to a temporary file like the buffered body itself (0 = never).
+func NewRequestCompressor(minSize, diskThreshold int64) *RequestCompressor {
+	return &RequestCompressor{
+		minSize:       minSize,
+		diskThreshold: diskThreshold,
+	}
+}
+
+// Body returns the gzip-compressed form of body for req, or nil when req
+// should be sent as-is: the body is small, already encoded, or does not
+// shrink. The result is computed once and kept with body, so retries reuse
+// it; it is released when body is closed.
+func (c *RequestCompressor) Body(req *http.Request, body *BufferedBody) (*BufferedBody, error) {
+	if body.Len() == 0 || body.Len() < c.minSize || req.Header.Get("Content-Encoding") != "" {
+		return nil, nil
+	}
+	if body.gzipDone {
+		return body.gzipped, nil
+	}
+
+	gzipped := &BufferedBody{}
+	gz := gzip.NewWriter(bodyWriter{gzipped, c.diskThreshold})
+	_, err := io.Copy(gz, body.Reader())
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		closeBody(gzipped)
+		return nil, err
+	}
+
+	body.gzipDone = true
+	if gzipped.Len() >= body.Len() {
+		closeBody(gzipped)
+		return nil, nil
+	}
+	body.gzipped = gzipped
+	return gzipped, nil
+}
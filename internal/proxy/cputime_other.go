@@ -0,0 +1,11 @@
+//go:build !unix
+
+package proxy
+
+import "time"
+
+// processCPUTime is unavailable on this platform, so only the active request
+// threshold applies
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"text/template"
+)
+
+// ErrorPageNoBackend is the error page key used when no healthy backend is
+// available; it falls back to the 503 page when not configured
+const ErrorPageNoBackend = "no_backend"
+
+// requestIDPattern limits which client-supplied request IDs are echoed back
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// ErrorPage is a templated response sent in place of a plain-text error
+type ErrorPage struct {
+	status      int
+	contentType string
+	tmpl        *template.Template
+}
+
+// errorPageData is the data available to error page templates
+type errorPageData struct {
+	Status     int
+	StatusText string
+	RequestID  string
+}
+
+// NewErrorPage parses an error page template. A status of 0 keeps the
+// status code of the error being replaced.
+func NewErrorPage(status int, contentType, body string) (*ErrorPage, error) {
+	tmpl, err := template.New("error").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error page template: %w", err)
+	}
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	return &ErrorPage{
+		status:      status,
+		contentType: contentType,
+		tmpl:        tmpl,
+	}, nil
+}
+
+// SetErrorPages sets custom error pages keyed by status code (e.g. "502")
+// or ErrorPageNoBackend
+func (h *Handler) SetErrorPages(pages map[string]*ErrorPage) {
+	h.errorPages = pages
+}
+
+// writeError sends the configured error page for key or status, falling
+// back to a plain-text http.Error
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, status int, key, message string) {
+	page := h.errorPages[key]
+	if page == nil {
+		page = h.errorPages[strconv.Itoa(status)]
+	}
+	if page == nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	if page.status != 0 {
+		status = page.status
+	}
+	requestID := requestIDFor(r)
+
+	var buf bytes.Buffer
+	if err := page.tmpl.Execute(&buf, errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		RequestID:  requestID,
+	}); err != nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", page.contentType)
+	w.Header().Set("X-Request-ID", requestID)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// requestIDFor returns the client's X-Request-ID if well-formed, otherwise a
+// freshly generated one
+func requestIDFor(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); requestIDPattern.MatchString(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
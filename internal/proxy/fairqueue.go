@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	errQueueFull    = errors.New("concurrency limit reached and queue is full")
+	errQueueTimeout = errors.New("timed out waiting for a concurrency slot")
+)
+
+// maxIdleTenants bounds the per-tenant finish tags kept for tenants with no
+// queued requests before stale ones are pruned
+const maxIdleTenants = 1024
+
+// FairQueue caps the number of requests proxied at once. Requests arriving at
+// the cap wait in a weighted fair queue keyed by tenant, so a tenant sending
+// many requests cannot starve others: each tenant gets a share of the freed
+// slots proportional to its weight rather than to how much it sends.
+type FairQueue struct {
+	maxActive     int
+	maxQueue      int           // 0 = unbounded
+	maxWait       time.Duration // 0 = wait until the request is cancelled
+	tenantHeader  string        // empty keys tenants by client IP
+	weights       map[string]float64
+	defaultWeight float64
+
+	mu          sync.Mutex
+	active      int
+	waiting     waitQueue
+	virtualTime float64
+	lastFinish  map[string]float64 // finish tag of each tenant's latest request
+	seq         uint64
+}
+
+// NewFairQueue creates a queue admitting up to maxActive concurrent requests.
+// Tenants missing from weights get weight 1.
+func NewFairQueue(maxActive, maxQueue int, maxWait time.Duration, tenantHeader string, weights map[string]float64) (*FairQueue, error) {
+	if maxActive <= 0 {
+		return nil, fmt.Errorf("max_active must be positive")
+	}
+	for tenant, weight := range weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("weight for tenant %q must be positive", tenant)
+		}
+	}
+	return &FairQueue{
+		maxActive:     maxActive,
+		maxQueue:      maxQueue,
+		maxWait:       maxWait,
+		tenantHeader:  tenantHeader,
+		weights:       weights,
+		defaultWeight: 1,
+		lastFinish:    make(map[string]float64),
+	}, nil
+}
+
+// Acquire waits for a slot for tenant and returns the function that frees it.
+// It fails if the queue is full, the wait limit passes, or ctx ends first.
+func (q *FairQueue) Acquire(ctx context.Context, tenant string) (release func(), err error) {
+	q.mu.Lock()
+	if q.active < q.maxActive && q.waiting.Len() == 0 {
+		q.active++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if q.maxQueue > 0 && q.waiting.Len() >= q.maxQueue {
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+
+	weight, ok := q.weights[tenant]
+	if !ok {
+		weight = q.defaultWeight
+	}
+	// A request finishes, in virtual time, one weighted unit after the later
+	// of now and the tenant's previous request
+	finish := max(q.virtualTime, q.lastFinish[tenant]) + 1/weight
+	q.lastFinish[tenant] = finish
+	q.seq++
+	w := &waiter{tenant: tenant, finish: finish, seq: q.seq, ready: make(chan struct{})}
+	heap.Push(&q.waiting, w)
+	q.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if q.maxWait > 0 {
+		timer := time.NewTimer(q.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-w.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = errQueueTimeout
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.index < 0 {
+		// Admitted while giving up; hand the slot on
+		q.active--
+		q.dispatch()
+		return nil, err
+	}
+	heap.Remove(&q.waiting, w.index)
+	return nil, err
+}
+
+// Queued returns the number of requests waiting for a slot
+func (q *FairQueue) Queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiting.Len()
+}
+
+// tenant returns the queueing key for r: the tenant header if configured and
+// present, otherwise the client IP
+func (q *FairQueue) tenant(r *http.Request, clientIP string) string {
+	if q.tenantHeader != "" {
+		if tenant := r.Header.Get(q.tenantHeader); tenant != "" {
+			return tenant
+		}
+	}
+	return clientIP
+}
+
+func (q *FairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	q.dispatch()
+}
+
+// dispatch admits waiters in finish-tag order while slots are free. Callers
+// must hold q.mu.
+func (q *FairQueue) dispatch() {
+	for q.active < q.maxActive && q.waiting.Len() > 0 {
+		w := heap.Pop(&q.waiting).(*waiter)
+		q.virtualTime = w.finish
+		q.active++
+		close(w.ready)
+	}
+
+	if len(q.lastFinish) > maxIdleTenants {
+		// Tags at or behind virtual time no longer affect scheduling
+		for tenant, finish := range q.lastFinish {
+			if finish <= q.virtualTime {
+				delete(q.lastFinish, tenant)
+			}
+		}
+	}
+}
+
+// waiter is a request queued for a slot
+type waiter struct {
+	tenant string
+	finish float64
+	seq    uint64 // arrival order, breaking ties between equal finish tags
+	index  int    // position in the heap, -1 once admitted or removed
+	ready  chan struct{}
+}
+
+// waitQueue is a min-heap of waiters ordered by finish tag
+type waitQueue []*waiter
+
+func (wq waitQueue) Len() int { return len(wq) }
+
+func (wq waitQueue) Less(i, j int) bool {
+	if wq[i].finish != wq[j].finish {
+		return wq[i].finish < wq[j].finish
+	}
+	return wq[i].seq < wq[j].seq
+}
+
+func (wq waitQueue) Swap(i, j int) {
+	wq[i], wq[j] = wq[j], wq[i]
+	wq[i].index = i
+	wq[j].index = j
+}
+
+func (wq *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*wq)
+	*wq = append(*wq, w)
+}
+
+func (wq *waitQueue) Pop() any {
+	old := *wq
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*wq = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+// grpcUnavailable is the gRPC status code for a service that cannot be reached
+const grpcUnavailable = "14"
+
+// isGRPC reports whether the request is a gRPC call
+func isGRPC(r *http.Request) bool {
+	if r == nil || r.ProtoMajor < 2 {
+		return false
+	}
+	contentType := r.Header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+")
+}
+
+// grpcCall reports whether the request is proxied in gRPC mode
+func (h *Handler) grpcCall(r *http.Request) bool {
+	return h.grpc && isGRPC(r)
+}
+
+// streamsBody reports whether the request body is forwarded as it arrives
+// instead of being buffered, as gRPC streaming calls require, or chunked
+// bodies when chunked encoding is preserved, or any body once buffering is
+// off. Such requests cannot be retried.
+func (h *Handler) streamsBody(r *http.Request) bool {
+	return h.unbuffered || h.grpcCall(r) || (h.streamChunked && r.ContentLength == -1)
+}
+
+// forGRPC returns an HTTP/2 client for a gRPC call: h2c for plaintext
+// backends, or ALPN-negotiated HTTP/2 over TLS
+func (u *upstreamClients) forGRPC(backend *balancer.Backend) *http.Client {
+	if backend.Scheme == "https" {
+		return u.auto
+	}
+	return u.h2c
+}
+
+// writeGRPCError reports a proxy failure as a trailers-only gRPC response,
+// which gRPC clients understand where an HTTP error page would not
+func writeGRPCError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", grpcUnavailable)
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyTrailers sends the upstream response trailers (e.g. grpc-status) on to
+// the client once the body has been copied
+func copyTrailers(w http.ResponseWriter, resp *http.Response) {
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+key, value)
+		}
+	}
+}
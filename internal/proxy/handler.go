@@ -1,34 +1,111 @@
 package proxy
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net"
+	"math"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/hermes-proxy/hermes/internal/balancer"
 	"github.com/hermes-proxy/hermes/internal/circuit"
 	"github.com/hermes-proxy/hermes/internal/health"
+	"github.com/hermes-proxy/hermes/internal/logging"
 )
 
+// saturatedRetryAfter is the Retry-After, in seconds, sent when every
+// backend is at its concurrency limit or the request queue is full
+const saturatedRetryAfter = 1
+
 // Handler handles HTTP proxying to backends
 type Handler struct {
 	balancer       balancer.Balancer
 	breakerPool    *circuit.BreakerPool
 	passiveMonitor *health.PassiveMonitor
 	buffer         *Buffer
-	client         *http.Client
+	clients        *upstreamClients
+	maxRetries     int
+	compressor     *Compressor
+	reqCompressor  *RequestCompressor
+	headerLimiter  *HeaderLimiter
+	rateLimiter    *RateLimiter
+	accessLog      bool
+	errorPages     map[string]*ErrorPage
+	clientIPs      *ClientIPResolver
+	cache          *ResponseCache
+	outliers       *health.OutlierDetector
+	tracer         Tracer
+	bodyRouter     *BodyRouter
+	shedder        *LoadShedder
+	fairQueue      *FairQueue
+	grpc           bool
+	streamChunked  bool
+	unbuffered     bool
+	requestTimeout time.Duration
+	backoff        *Backoff
+	loadHeader     string
+	hashHeader     string
+	logger         logging.Logger
+
+	// Host header sent upstream: hostHeader if set, else the client's Host
+	// when preserveHost is on, else the backend address
+	preserveHost bool
+	hostHeader   string
+
+	// Header edits for upstream requests and client responses
+	requestHeaders  HeaderRules
+	responseHeaders HeaderRules
+
+	// Only retry attempts that failed before a connection was established
+	retryConnectOnly bool
+
+	// Retry non-idempotent methods after failures that may have reached
+	// the backend
+	retryNonIdempotent bool
+
+	// Eject a backend immediately when it sends a malformed response
+	ejectOnMalformed bool
+
+	// Response statuses counted as backend failures, though still relayed
+	failureStatuses []health.StatusRange
+
+	// Longest Retry-After backoff honored from a 503; 0 ignores the header
+	retryAfterMax time.Duration
+
+	// Set once Shutdown begins; new requests are then refused with 503
+	shuttingDown atomic.Bool
+
+	// Set during the pre-stop delay: requests are served normally, but the
+	// readiness probe fails so the orchestrator stops routing here
+	stopping atomic.Bool
+
+	// Set while the proxy is drained for a rolling deploy: requests are still
+	// served, but every response closes its connection
+	draining atomic.Bool
+
+	// Toggled at runtime via the admin API; not persisted across restarts
+	maintenance      atomic.Bool
+	maintenanceAllow *MaintenanceAllowlist
+
+	accessControl *AccessControl
 
 	// Statistics
-	TotalRequests  int64
-	ActiveRequests int64
-	FailedRequests int64
+	TotalRequests       int64
+	ActiveRequests      int64
+	FailedRequests      int64
+	RateLimitedRequests int64
+	MaintenanceRejected int64
+	AccessDenied        int64
+	outcomes            [numOutcomes]int64
+	statusClasses       [6]int64 // backend responses by status code / 100
 }
 
 // NewHandler creates a new proxy handler
@@ -38,123 +115,858 @@ func NewHandler(
 	passiveMonitor *health.PassiveMonitor,
 	maxRequestBody int64,
 ) *Handler {
-	return &Handler{
+	h := &Handler{
 		balancer:       b,
 		breakerPool:    breakerPool,
 		passiveMonitor: passiveMonitor,
 		buffer:         NewBuffer(maxRequestBody),
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConnsPerHost: 100,
-				IdleConnTimeout:     90 * time.Second,
-				DisableCompression:  true,
-			},
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse // Don't follow redirects
-			},
-		},
+		logger:         logging.Default(),
 	}
+	h.clients = newUpstreamClients(h.findBackend)
+	return h
+}
+
+// findBackend returns the backend with the given address, or nil
+func (h *Handler) findBackend(address string) *balancer.Backend {
+	for _, b := range h.balancer.Backends() {
+		if b.Address == address {
+			return b
+		}
+	}
+	return nil
+}
+
+// SetMaxRetries sets how many additional distinct backends are tried after
+// a failed attempt
+func (h *Handler) SetMaxRetries(n int) {
+	h.maxRetries = n
+}
+
+// SetRetryBackoff sets the delay between retry attempts; nil retries at once
+func (h *Handler) SetRetryBackoff(b *Backoff) {
+	h.backoff = b
+}
+
+// SetRetryConnectFailuresOnly restricts retries to attempts that failed
+// before a connection to the backend was established (refused, DNS, TLS
+// handshake). Such requests cannot have reached the backend, so even
+// non-idempotent methods are retried safely.
+func (h *Handler) SetRetryConnectFailuresOnly(enabled bool) {
+	h.retryConnectOnly = enabled
+}
+
+// SetRetryNonIdempotent allows retrying POST, PATCH and other
+// non-idempotent requests after failures that may have reached the backend,
+// such as a timeout or a read error. By default they are only retried when
+// the attempt failed before connecting.
+func (h *Handler) SetRetryNonIdempotent(enabled bool) {
+	h.retryNonIdempotent = enabled
+}
+
+// retriesConnectFailuresOnly reports whether r may only be retried after
+// attempts that failed before a connection was established
+func (h *Handler) retriesConnectFailuresOnly(r *http.Request) bool {
+	return h.retryConnectOnly || (!h.retryNonIdempotent && !isIdempotent(r))
+}
+
+// SetBackendTLSConfig sets the TLS configuration used for HTTPS backends
+func (h *Handler) SetBackendTLSConfig(cfg *tls.Config) {
+	h.clients.setTLSConfig(cfg)
+}
+
+// SetPoolOptions tunes upstream connection pooling
+func (h *Handler) SetPoolOptions(opts PoolOptions) {
+	h.clients.setPoolOptions(opts)
+}
+
+// SetSocketOptions tunes TCP sockets dialed to backends
+func (h *Handler) SetSocketOptions(opts SocketOptions) {
+	h.clients.setSocketOptions(opts)
+}
+
+// SetLoadHeader names a response header in which backends report their own
+// load, recorded for the header load metric; empty disables it
+func (h *Handler) SetLoadHeader(name string) {
+	h.loadHeader = name
+}
+
+// SetCompressor enables response compression; nil disables it
+func (h *Handler) SetCompressor(c *Compressor) {
+	h.compressor = c
+}
+
+// SetRequestCompressor enables gzip compression of buffered request bodies
+// sent to backends that advertise accepting it; nil disables it
+func (h *Handler) SetRequestCompressor(c *RequestCompressor) {
+	h.reqCompressor = c
+}
+
+// SetHeaderLimiter enables per-client header size limits; nil disables them
+func (h *Handler) SetHeaderLimiter(l *HeaderLimiter) {
+	h.headerLimiter = l
+}
+
+// SetRateLimiter enables per-client rate limiting; nil disables it
+func (h *Handler) SetRateLimiter(l *RateLimiter) {
+	h.rateLimiter = l
+}
+
+// SetLogger sets where request errors, the access log and drain progress
+// are logged
+func (h *Handler) SetLogger(logger logging.Logger) {
+	h.logger = logger
+}
+
+// SetClientIPResolver configures how the client IP is derived; nil uses the
+// connection's remote address and ignores forwarding headers
+func (h *Handler) SetClientIPResolver(c *ClientIPResolver) {
+	h.clientIPs = c
+}
+
+// clientIP returns the client IP using the configured resolver
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.clientIPs != nil {
+		return h.clientIPs.ClientIP(r)
+	}
+	return remoteHost(r)
+}
+
+// SetHashHeader makes consistent hash balancers key requests by the named
+// header instead of the client IP. Requests without the header fall back to
+// the client IP.
+func (h *Handler) SetHashHeader(name string) {
+	h.hashHeader = name
+}
+
+// balancingKey returns the key a keyed balancer maps to a backend
+func (h *Handler) balancingKey(r *http.Request) string {
+	if h.hashHeader != "" {
+		if key := r.Header.Get(h.hashHeader); key != "" {
+			return key
+		}
+	}
+	return h.clientIP(r)
+}
+
+// SetResponseCache enables response caching; nil disables it
+func (h *Handler) SetResponseCache(c *ResponseCache) {
+	h.cache = c
+}
+
+// SetOutlierDetector enables ejection of backends whose responses stand out;
+// nil disables it
+func (h *Handler) SetOutlierDetector(d *health.OutlierDetector) {
+	h.outliers = d
+}
+
+// SetTracer enables per-request tracing spans; nil disables them
+func (h *Handler) SetTracer(t Tracer) {
+	h.tracer = t
+}
+
+// SetBodyRouter enables routing on a JSON request body field; nil disables it
+func (h *Handler) SetBodyRouter(b *BodyRouter) {
+	h.bodyRouter = b
+}
+
+// SetLoadShedder lets optional features be turned off under load; nil keeps
+// them always on
+func (h *Handler) SetLoadShedder(l *LoadShedder) {
+	h.shedder = l
+}
+
+// SetFairQueue caps concurrent proxied requests, queueing the excess fairly
+// across tenants; nil removes the cap
+func (h *Handler) SetFairQueue(q *FairQueue) {
+	h.fairQueue = q
+}
+
+// shed reports whether an optional feature is currently turned off
+func (h *Handler) shed(feature string) bool {
+	return h.shedder != nil && h.shedder.Shed(feature)
+}
+
+// SetGRPC enables gRPC proxying: gRPC calls are forwarded over HTTP/2 with
+// their request bodies streamed rather than buffered
+func (h *Handler) SetGRPC(enabled bool) {
+	h.grpc = enabled
+}
+
+// SetStreamChunked forwards request bodies sent with chunked encoding and no
+// Content-Length as they arrive, keeping them chunked upstream, instead of
+// buffering them and sending a Content-Length. Such requests are not retried.
+func (h *Handler) SetStreamChunked(enabled bool) {
+	h.streamChunked = enabled
+}
+
+// SetBuffering turns request body buffering on or off. When off, every body
+// is forwarded to the backend as it arrives, keeping chunked encoding, and
+// requests are never retried since the body can only be read once.
+func (h *Handler) SetBuffering(enabled bool) {
+	h.unbuffered = !enabled
+}
+
+// SetBodyDiskThreshold makes buffered request bodies larger than threshold
+// bytes spill to a temporary file rather than memory. Zero disables it.
+func (h *Handler) SetBodyDiskThreshold(threshold int64) {
+	h.buffer.SetDiskThreshold(threshold)
+}
+
+// SetRequestTimeout bounds the total time spent on a request, shared by all
+// retry attempts; once it elapses the upstream call is cancelled and the
+// client gets 504. Zero disables the limit.
+func (h *Handler) SetRequestTimeout(d time.Duration) {
+	h.requestTimeout = d
+}
+
+// SetHostHeader chooses the Host header sent to backends: override, if
+// non-empty, is sent as is; otherwise preserve forwards the client's Host.
+// By default the backend address is used. Either way the client's Host is
+// also sent as X-Forwarded-Host, and a backend's own HostHeader wins.
+func (h *Handler) SetHostHeader(preserve bool, override string) {
+	h.preserveHost = preserve
+	h.hostHeader = override
+}
+
+// SetHeaderRules sets the header edits applied to requests sent upstream,
+// after the proxy headers, and to responses before they reach the client
+func (h *Handler) SetHeaderRules(request, response HeaderRules) {
+	h.requestHeaders = request
+	h.responseHeaders = response
+}
+
+// SetFailureStatuses makes responses with these statuses count as failures
+// for the circuit breaker and passive health monitor, so a backend that is
+// up but broken is taken out of rotation. The response is still sent to the
+// client as is.
+func (h *Handler) SetFailureStatuses(ranges []health.StatusRange) {
+	h.failureStatuses = ranges
+}
+
+// isFailureStatus reports whether a backend response counts as a failure
+func (h *Handler) isFailureStatus(code int) bool {
+	for _, r := range h.failureStatuses {
+		if r.Contains(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetEjectOnMalformed makes a malformed backend response mark the backend
+// unhealthy at once instead of counting toward the passive threshold
+func (h *Handler) SetEjectOnMalformed(enabled bool) {
+	h.ejectOnMalformed = enabled
+}
+
+// SetAccessLog enables a log line per proxied request with its outcome
+func (h *Handler) SetAccessLog(enabled bool) {
+	h.accessLog = enabled
 }
 
 // ServeHTTP implements the http.Handler interface
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		w.Header().Set("Connection", "close")
+		h.writeError(w, r, http.StatusServiceUnavailable, "", "Service Unavailable")
+		return
+	}
+
+	if h.draining.Load() {
+		w.Header().Set("Connection", "close")
+	}
+
+	// Denied clients are refused before they can use up rate limit tokens
+	if h.deniedAccess(r) {
+		atomic.AddInt64(&h.AccessDenied, 1)
+		h.writeError(w, r, http.StatusForbidden, "", "Forbidden")
+		return
+	}
+
+	if h.blockedByMaintenance(r) {
+		atomic.AddInt64(&h.MaintenanceRejected, 1)
+		h.writeError(w, r, http.StatusServiceUnavailable, ErrorPageMaintenance, "Service Unavailable: down for maintenance")
+		return
+	}
+
+	// Rejected requests never reach a backend and are counted separately
+	if h.rateLimiter != nil {
+		if ok, wait := h.rateLimiter.Allow(h.clientIP(r)); !ok {
+			atomic.AddInt64(&h.RateLimitedRequests, 1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			h.writeError(w, r, http.StatusTooManyRequests, "", "Too Many Requests")
+			return
+		}
+	}
+
 	atomic.AddInt64(&h.TotalRequests, 1)
 	atomic.AddInt64(&h.ActiveRequests, 1)
 	defer atomic.AddInt64(&h.ActiveRequests, -1)
 
+	if h.headerLimiter != nil && !h.headerLimiter.Allow(h.clientIP(r), r) {
+		h.writeError(w, r, http.StatusRequestHeaderFieldsTooLarge, "", "Request Header Fields Too Large")
+		return
+	}
+
+	if h.requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
 	// Buffer the request body for potential retries
-	var bodyBuf *bytes.Buffer
+	var bodyBuf *BufferedBody
 	var err error
-	if r.Body != nil && r.ContentLength != 0 {
+	if r.Body != nil && r.ContentLength != 0 && !h.streamsBody(r) {
 		bodyBuf, err = h.buffer.BufferRequest(r)
+		if errors.Is(err, errBodyTooLarge) {
+			h.writeError(w, r, http.StatusRequestEntityTooLarge, "", err.Error())
+			return
+		}
+		if errors.Is(err, errSpillFailed) {
+			atomic.AddInt64(&h.FailedRequests, 1)
+			h.logger.Error("request failed", "method", r.Method, "path", r.URL.RequestURI(), "error", err)
+			h.writeError(w, r, http.StatusInternalServerError, "", "Internal Server Error")
+			return
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			h.abortBuffering(w, r, err)
 			return
 		}
+		defer closeBody(bodyBuf)
+	} else if r.Body != nil && h.streamsBody(r) && !h.grpcCall(r) {
+		// Unbuffered chunked bodies still honor the request size limit
+		r.Body = http.MaxBytesReader(w, r.Body, h.buffer.maxSize)
+	}
+
+	// Optional per-request work, skipped while shedding load
+	accessLog := h.accessLog && !h.shed(FeatureAccessLog)
+	traced := h.tracer != nil && !h.shed(FeatureTracing)
+
+	var recorder *statusRecorder
+	if accessLog || traced {
+		recorder = &statusRecorder{ResponseWriter: w}
+		w = recorder
+	}
+	start := time.Now()
+
+	var endSpan func(status int, outcome Outcome)
+	if traced {
+		var ctx context.Context
+		ctx, endSpan = h.tracer.StartRequest(r)
+		r = r.WithContext(ctx)
 	}
 
 	// Try to proxy the request
-	if err := h.proxyRequest(w, r, bodyBuf); err != nil {
+	outcome, err := h.proxyAdmitted(w, r, bodyBuf)
+	atomic.AddInt64(&h.outcomes[outcome], 1)
+	if err != nil {
 		atomic.AddInt64(&h.FailedRequests, 1)
-		log.Printf("[PROXY] Error: %v", err)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		h.logger.Warn("request failed", "method", r.Method, "path", r.URL.RequestURI(),
+			"outcome", outcome.String(), "error", err)
+		key := ""
+		if outcome == OutcomeNoBackend {
+			key = ErrorPageNoBackend
+		}
+		switch {
+		case h.grpcCall(r):
+			writeGRPCError(w, "upstream unavailable")
+		case errors.Is(r.Context().Err(), context.DeadlineExceeded):
+			h.writeError(w, r, http.StatusGatewayTimeout, key, "Gateway Timeout")
+		default:
+			if outcome == OutcomeSaturated || outcome == OutcomeOverloaded {
+				// Capacity frees up as in-flight requests finish
+				w.Header().Set("Retry-After", strconv.Itoa(saturatedRetryAfter))
+			}
+			status, message := outcome.failureResponse()
+			h.writeError(w, r, status, key, message)
+		}
+	}
+
+	if endSpan != nil {
+		endSpan(recorder.status, outcome)
+	}
+
+	if accessLog {
+		h.logger.Info("access", "client", h.clientIP(r), "method", r.Method, "path", r.URL.RequestURI(),
+			"status", recorder.status, "outcome", outcome.String(), "duration", time.Since(start))
 	}
 }
 
-func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, bodyBuf *bytes.Buffer) error {
-	// Select a backend
-	backend := h.balancer.Next()
-	if backend == nil {
-		return fmt.Errorf("no healthy backends available")
+// abortBuffering ends a request whose body could not be read because the
+// client went away, stalled, or ran out of time. No backend was involved, so
+// nothing counts against backend health.
+func (h *Handler) abortBuffering(w http.ResponseWriter, r *http.Request, err error) {
+	outcome := OutcomeClientClosed
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		outcome = OutcomeTimeout
 	}
+	atomic.AddInt64(&h.outcomes[outcome], 1)
+	atomic.AddInt64(&h.FailedRequests, 1)
+	h.logger.Warn("request aborted while buffering", "method", r.Method, "path", r.URL.RequestURI(),
+		"outcome", outcome.String(), "error", err)
 
-	// Check circuit breaker
-	breaker := h.breakerPool.Get(backend.Address)
-	if !breaker.Allow() {
-		return fmt.Errorf("circuit breaker open for %s", backend.Address)
+	switch {
+	case outcome == OutcomeTimeout:
+		h.writeError(w, r, http.StatusGatewayTimeout, "", "Gateway Timeout")
+	case r.Context().Err() == nil:
+		// The body read failed but the client may still be listening
+		h.writeError(w, r, http.StatusBadRequest, "", "Bad Request")
 	}
+}
 
+// proxyAdmitted waits for a concurrency slot, if the fair queue is enabled,
+// before proxying r
+func (h *Handler) proxyAdmitted(w http.ResponseWriter, r *http.Request, bodyBuf *BufferedBody) (Outcome, error) {
+	if h.fairQueue != nil {
+		release, err := h.fairQueue.Acquire(r.Context(), h.fairQueue.tenant(r, h.clientIP(r)))
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return OutcomeClientClosed, err
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				return OutcomeTimeout, err
+			}
+			return OutcomeOverloaded, err
+		}
+		defer release()
+	}
+	return h.proxyCached(w, r, bodyBuf)
+}
+
+// proxyCached serves r from the response cache when possible, otherwise
+// proxies it and stores the response if it is cacheable
+func (h *Handler) proxyCached(w http.ResponseWriter, r *http.Request, bodyBuf *BufferedBody) (Outcome, error) {
+	key := ""
+	if h.cache != nil {
+		key = h.cache.key(r)
+	}
+	if key == "" {
+		return h.proxyRequest(w, r, bodyBuf)
+	}
+
+	if entry, revalidate := h.cache.lookup(r, key); entry != nil {
+		stale := time.Since(entry.storedAt) >= entry.maxAge
+		if revalidate {
+			// Cloned here: r must not be used once this handler returns
+			go h.revalidate(r.Clone(context.WithoutCancel(r.Context())), key, entry)
+		}
+		entry.writeTo(w, stale)
+		return OutcomeCacheHit, nil
+	}
+
+	rec := &cacheRecorder{ResponseWriter: w, limit: h.cache.maxBodyBytes}
+	outcome, err := h.proxyRequest(rec, r, bodyBuf)
+	if err == nil {
+		h.cache.store(r, key, rec)
+	}
+	return outcome, err
+}
+
+// revalidate refreshes a stale cache entry without holding up the client.
+// req is a clone of the client's request, detached from its cancellation.
+func (h *Handler) revalidate(req *http.Request, key string, entry *cacheEntry) {
+	req.Body = http.NoBody
+
+	rec := &cacheRecorder{ResponseWriter: &discardWriter{header: make(http.Header)}, limit: h.cache.maxBodyBytes}
+	if _, err := h.proxyRequest(rec, req, nil); err != nil {
+		h.logger.Warn("background revalidation failed", "path", req.URL.RequestURI(), "error", err)
+		h.cache.revalidationFailed(entry)
+		return
+	}
+	// Keep serving the stale copy if the refreshed response is not cacheable
+	if !h.cache.store(req, key, rec) {
+		h.cache.revalidationFailed(entry)
+	}
+}
+
+func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, bodyBuf *BufferedBody) (Outcome, error) {
+	// Each backend is attempted at most once per request
+	tried := make(map[string]bool)
+	var lastErr error
+
+	lb := h.balancer
+	if h.bodyRouter != nil {
+		if pool := h.bodyRouter.Route(bodyBuf); pool != nil {
+			lb = pool
+		}
+	}
+
+	var key string
+	if _, ok := lb.(balancer.KeyedBalancer); ok {
+		key = h.balancingKey(r)
+	}
+
+	// A streamed body is consumed by the first attempt
+	maxRetries := h.maxRetries
+	if h.streamsBody(r) {
+		maxRetries = 0
+	}
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 && h.backoff != nil && !h.backoff.wait(r.Context(), attempt) {
+			break
+		}
+
+		skip, endSelection := h.startSelection(r.Context())
+		backend, saturated := reserveBackend(r.Context(), lb, key, tried, skip)
+		if backend == nil {
+			endSelection("")
+			if saturated && lastErr == nil {
+				lastErr = errSaturated
+			}
+			break
+		}
+
+		var err error
+		breaker := h.breakerPool.Get(backend.Address)
+		if breaker.Allow() {
+			endSelection(backend.Address)
+			err = h.tryBackend(w, r, bodyBuf, backend, breaker)
+		} else {
+			skip(backend.Address, SkipCircuitOpen)
+			endSelection("")
+			err = fmt.Errorf("%w for %s", errCircuitOpen, backend.Address)
+		}
+		backend.ReleaseInflight()
+		if err == nil {
+			if attempt > 0 {
+				return OutcomeSuccessAfterRetry, nil
+			}
+			return OutcomeSuccess, nil
+		}
+		lastErr = err
+
+		// A departed client makes further attempts pointless
+		if r.Context().Err() != nil {
+			break
+		}
+		if h.retriesConnectFailuresOnly(r) && !requestNotSent(err) {
+			break
+		}
+		if attempt < maxRetries {
+			h.logger.Info("attempt failed, retrying", "attempt", attempt+1, "error", err)
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errNoBackend
+	}
+	return classifyFailure(r, lastErr), lastErr
+}
+
+// reserveBackend selects an untried backend and reserves an in-flight slot
+// on it. Backends at their in-flight limit are skipped without spending a
+// retry attempt; saturated reports whether any were. If every candidate is
+// saturated, the request queues on the first one with a queue timeout until
+// a slot frees up. The caller releases the slot once the attempt completes.
+func reserveBackend(ctx context.Context, lb balancer.Balancer, key string, tried map[string]bool, skip func(backend, reason string)) (backend *balancer.Backend, saturated bool) {
+	var queued *balancer.Backend
+	for {
+		backend = selectBackend(lb, key, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend.Address] = true
+		if backend.AcquireInflight() {
+			return backend, saturated
+		}
+		saturated = true
+		if queued == nil && backend.QueueTimeout() > 0 {
+			queued = backend
+			continue
+		}
+		skip(backend.Address, SkipSaturated)
+	}
+
+	if queued != nil {
+		if queued.WaitInflight(ctx) {
+			return queued, saturated
+		}
+		skip(queued.Address, SkipSaturated)
+	}
+	return nil, saturated
+}
+
+// startSelection traces the backend selection for one attempt when a tracer
+// is set
+func (h *Handler) startSelection(ctx context.Context) (skip func(backend, reason string), end func(backend string)) {
+	if h.tracer == nil {
+		return func(string, string) {}, func(string) {}
+	}
+	return h.tracer.StartSelection(ctx)
+}
+
+// selectBackend returns the next backend that has not yet been tried for
+// this request, or nil once every healthy backend has been attempted
+func selectBackend(lb balancer.Balancer, key string, tried map[string]bool) *balancer.Backend {
+	// A keyed balancer pins the key to one backend; once that has been
+	// tried, pick among the rest as usual
+	if keyed, ok := lb.(balancer.KeyedBalancer); ok {
+		if backend := keyed.NextForKey(key); backend != nil && !tried[backend.Address] {
+			return backend
+		}
+	}
+
+	backends := lb.Backends()
+
+	// Give the balancer a chance to pick according to its algorithm
+	for i := 0; i < len(backends); i++ {
+		backend := lb.Next()
+		if backend == nil {
+			return nil
+		}
+		if !tried[backend.Address] {
+			return backend
+		}
+	}
+
+	// Fall back to any healthy backend the balancer kept skipping past
+	for _, backend := range backends {
+		if backend.IsAvailable() && !tried[backend.Address] {
+			return backend
+		}
+	}
+	return nil
+}
+
+// tryBackend proxies the request to a single backend whose circuit breaker
+// has allowed it
+func (h *Handler) tryBackend(w http.ResponseWriter, r *http.Request, bodyBuf *BufferedBody, backend *balancer.Backend, breaker *circuit.Breaker) error {
 	// Track connection
 	backend.IncrementConnections()
 	defer backend.DecrementConnections()
 
 	// Build the proxied request
-	targetURL := fmt.Sprintf("http://%s%s", backend.Address, r.URL.RequestURI())
+	targetURL := backend.URL(r.URL.RequestURI())
+
+	streamed := h.streamsBody(r)
+	gzipped := h.compressRequest(r, bodyBuf, backend)
+	if gzipped != nil {
+		bodyBuf = gzipped
+	}
 
 	var body io.Reader
-	if bodyBuf != nil {
-		body = bytes.NewReader(bodyBuf.Bytes())
+	switch {
+	case bodyBuf != nil:
+		body = bodyBuf.Reader()
+	case streamed:
+		body = r.Body
 	}
 
-	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL, body)
+	// Bound the whole exchange, body included, unless it may be a
+	// long-lived stream. The deadline is lifted once a response turns out
+	// to be one.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var timedOut atomic.Bool
+	deadline := time.AfterFunc(h.clients.timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	defer deadline.Stop()
+	if h.grpcCall(r) || isUpgrade(r) {
+		deadline.Stop()
+	}
+
+	// Note whether a connection was obtained; failures before that point
+	// cannot have delivered any bytes to the backend
+	connected := false
+	connectOnly := h.retriesConnectFailuresOnly(r)
+	if connectOnly {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(httptrace.GotConnInfo) { connected = true },
+		})
+	}
+
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL, body)
 	if err != nil {
 		return fmt.Errorf("failed to create proxy request: %w", err)
 	}
+	switch {
+	case streamed:
+		proxyReq.ContentLength = r.ContentLength
+	case bodyBuf.OnDisk():
+		// NewRequest only infers the length and replay of in-memory readers
+		proxyReq.ContentLength = bodyBuf.Len()
+		proxyReq.GetBody = func() (io.ReadCloser, error) {
+			return WrapBody(bodyBuf), nil
+		}
+	}
 
 	// Copy headers
 	copyHeaders(proxyReq.Header, r.Header)
 
+	if gzipped != nil {
+		proxyReq.Header.Set("Content-Encoding", "gzip")
+	}
+
 	// Add proxy headers
 	h.setProxyHeaders(proxyReq, r)
+	h.setHost(proxyReq, r, backend)
+	if !h.requestHeaders.IsZero() {
+		h.requestHeaders.apply(proxyReq.Header, r, backend.Address, h.clientIP(r))
+	}
+
+	var endAttempt func(status int, err error)
+	if h.tracer != nil {
+		endAttempt = h.tracer.StartAttempt(r.Context(), backend.Address, proxyReq.Header)
+	}
 
-	// Send the request
-	resp, err := h.client.Do(proxyReq)
+	// Send the request, timing until response headers arrive
+	start := time.Now()
+	client := h.clients.forBackend(backend, r)
+	if h.grpcCall(r) {
+		client = h.clients.forGRPC(backend)
+	}
+	resp, err := client.Do(proxyReq)
+	if endAttempt != nil {
+		if err != nil {
+			endAttempt(0, err)
+		} else {
+			endAttempt(resp.StatusCode, nil)
+		}
+	}
 	if err != nil {
 		breaker.RecordFailure()
+		if isMalformedResponse(err) {
+			h.logger.Warn("malformed response", "backend", backend.Address, "error", err)
+			if h.ejectOnMalformed {
+				h.passiveMonitor.Eject(backend.Address, "malformed response")
+			} else {
+				h.passiveMonitor.RecordFailure(backend.Address)
+			}
+			return fmt.Errorf("malformed response from %s: %w", backend.Address, err)
+		}
 		h.passiveMonitor.RecordFailure(backend.Address)
-		return fmt.Errorf("failed to proxy request to %s: %w", backend.Address, err)
+		if r.Context().Err() == nil {
+			backend.RecordFailedLatency(time.Since(start))
+		}
+		if timedOut.Load() {
+			err = fmt.Errorf("%w after %v: %w", context.DeadlineExceeded, h.clients.timeout, err)
+		}
+		err = fmt.Errorf("failed to proxy request to %s: %w", backend.Address, err)
+		if connectOnly && !connected {
+			return &notSentError{err: err}
+		}
+		return err
 	}
 	defer resp.Body.Close()
 
-	// Record success
-	breaker.RecordSuccess()
-	h.passiveMonitor.RecordSuccess(backend.Address)
+	// Record the response; configured statuses count as soft failures
+	latency := time.Since(start)
+	backend.RecordStatus(resp.StatusCode)
+	if class := resp.StatusCode / 100; class >= 1 && class < len(h.statusClasses) {
+		atomic.AddInt64(&h.statusClasses[class], 1)
+	}
+	if h.isFailureStatus(resp.StatusCode) {
+		backend.RecordFailedLatency(latency)
+		breaker.RecordFailure()
+		h.passiveMonitor.RecordFailure(backend.Address)
+	} else {
+		backend.RecordLatency(latency)
+		breaker.RecordSuccess()
+		h.passiveMonitor.RecordSuccess(backend.Address)
+	}
+	h.honorRetryAfter(backend, resp)
+	if h.reqCompressor != nil {
+		learnRequestEncodings(backend, resp, gzipped != nil)
+	}
+	if h.outliers != nil {
+		h.outliers.Record(backend.Address, resp.StatusCode, latency)
+	}
+	if h.loadHeader != "" {
+		if load, err := strconv.ParseFloat(resp.Header.Get(h.loadHeader), 64); err == nil {
+			backend.SetReportedLoad(load)
+		}
+	}
 
 	// Copy response headers
 	copyHeaders(w.Header(), resp.Header)
+	if !h.responseHeaders.IsZero() {
+		h.responseHeaders.apply(w.Header(), r, backend.Address, h.clientIP(r))
+	}
+
+	streaming := h.grpcCall(r) || isStreaming(resp)
+	if streaming || resp.StatusCode == http.StatusSwitchingProtocols {
+		deadline.Stop()
+	}
+	compress := !streaming && h.compressor != nil && !h.shed(FeatureCompression) && h.compressor.ShouldCompress(r, resp)
+	if compress {
+		h.compressor.PrepareHeaders(w.Header())
+	}
 
 	// Set the status code
 	w.WriteHeader(resp.StatusCode)
 
 	// Copy response body
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		log.Printf("[PROXY] Error copying response body: %v", err)
+	switch {
+	case streaming:
+		h.streamBody(w, resp.Body)
+	case compress:
+		if err := h.compressor.Copy(w, resp.Body); err != nil {
+			h.logger.Warn("error compressing response body", "error", err)
+			bodyIncomplete(w)
+		}
+	default:
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			bodyIncomplete(w)
+			if isMalformedResponse(err) {
+				h.logger.Warn("malformed response body", "backend", backend.Address, "error", err)
+			} else {
+				h.logger.Warn("error copying response body", "error", err)
+			}
+		}
 	}
+	copyTrailers(w, resp)
 
 	return nil
 }
 
+// isStreaming reports whether a response should be flushed as it arrives
+func isStreaming(resp *http.Response) bool {
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream")
+}
+
+// streamBody copies a streaming response, flushing after each chunk and
+// lifting the server write deadline so long-lived streams are not cut off
+func (h *Handler) streamBody(w http.ResponseWriter, body io.Reader) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				h.logger.Warn("error streaming response body", "error", werr)
+				bodyIncomplete(w)
+				return
+			}
+			rc.Flush()
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			h.logger.Warn("error streaming response body", "error", err)
+			bodyIncomplete(w)
+			return
+		}
+	}
+}
+
 func (h *Handler) setProxyHeaders(proxyReq *http.Request, originalReq *http.Request) {
 	// X-Forwarded-For
-	clientIP := getClientIP(originalReq)
-	if prior := originalReq.Header.Get("X-Forwarded-For"); prior != "" {
+	clientIP := h.clientIP(originalReq)
+	if prior := forwardedFor(originalReq.Header); prior != "" {
 		clientIP = prior + ", " + clientIP
 	}
 	proxyReq.Header.Set("X-Forwarded-For", clientIP)
 
 	// X-Real-IP
-	proxyReq.Header.Set("X-Real-IP", getClientIP(originalReq))
+	proxyReq.Header.Set("X-Real-IP", h.clientIP(originalReq))
 
 	// X-Forwarded-Proto
 	scheme := "http"
@@ -167,26 +979,42 @@ func (h *Handler) setProxyHeaders(proxyReq *http.Request, originalReq *http.Requ
 	proxyReq.Header.Set("X-Forwarded-Host", originalReq.Host)
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Real-IP header first
-	if ip := r.Header.Get("X-Real-IP"); ip != "" {
-		return ip
+// setHost sets the Host header sent to backend; leaving it empty sends the
+// backend address
+func (h *Handler) setHost(proxyReq *http.Request, originalReq *http.Request, backend *balancer.Backend) {
+	switch {
+	case backend.HostHeader != "":
+		proxyReq.Host = backend.HostHeader
+	case h.hostHeader != "":
+		proxyReq.Host = h.hostHeader
+	case h.preserveHost:
+		proxyReq.Host = originalReq.Host
 	}
+}
 
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
+// compressRequest returns the gzip-compressed request body to send to
+// backend, or nil to send the body as it arrived
+func (h *Handler) compressRequest(r *http.Request, bodyBuf *BufferedBody, backend *balancer.Backend) *BufferedBody {
+	if h.reqCompressor == nil || bodyBuf == nil || !backend.AcceptsGzip() || h.shed(FeatureCompression) {
+		return nil
 	}
-
-	// Fall back to RemoteAddr
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	gzipped, err := h.reqCompressor.Body(r, bodyBuf)
 	if err != nil {
-		return r.RemoteAddr
+		h.logger.Warn("error compressing request body, sending it uncompressed", "error", err)
+		return nil
+	}
+	return gzipped
+}
+
+// learnRequestEncodings records whether backend accepts gzip request bodies
+// from the Accept-Encoding header of its response. A 415 to a compressed
+// body without that header also means it does not.
+func learnRequestEncodings(backend *balancer.Backend, resp *http.Response, sentGzip bool) {
+	if header := resp.Header.Values("Accept-Encoding"); len(header) > 0 {
+		backend.SetAcceptsGzip(acceptsGzip(strings.Join(header, ",")))
+	} else if sentGzip && resp.StatusCode == http.StatusUnsupportedMediaType {
+		backend.SetAcceptsGzip(false)
 	}
-	return host
 }
 
 func copyHeaders(dst, src http.Header) {
@@ -197,17 +1025,98 @@ func copyHeaders(dst, src http.Header) {
 	}
 }
 
+// Warmup opens up to n connections to a backend by issuing concurrent
+// requests to path, leaving them in the idle pool for real traffic
+func (h *Handler) Warmup(backend *balancer.Backend, n int, path string, timeout time.Duration) {
+	client := h.clients.forBackend(backend, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, backend.URL(path), nil)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			// Drain so the connection returns to the idle pool
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+}
+
 // GetStats returns current proxy statistics
 func (h *Handler) GetStats() map[string]int64 {
-	return map[string]int64{
-		"total_requests":  atomic.LoadInt64(&h.TotalRequests),
-		"active_requests": atomic.LoadInt64(&h.ActiveRequests),
-		"failed_requests": atomic.LoadInt64(&h.FailedRequests),
+	stats := map[string]int64{
+		"total_requests":       atomic.LoadInt64(&h.TotalRequests),
+		"active_requests":      atomic.LoadInt64(&h.ActiveRequests),
+		"failed_requests":      atomic.LoadInt64(&h.FailedRequests),
+		"rate_limited":         atomic.LoadInt64(&h.RateLimitedRequests),
+		"maintenance_rejected": atomic.LoadInt64(&h.MaintenanceRejected),
+		"access_denied":        atomic.LoadInt64(&h.AccessDenied),
+	}
+	if h.fairQueue != nil {
+		stats["queued_requests"] = int64(h.fairQueue.Queued())
+	}
+	for o := Outcome(0); o < numOutcomes; o++ {
+		stats["outcome_"+o.String()] = atomic.LoadInt64(&h.outcomes[o])
 	}
+	for class := 1; class < len(h.statusClasses); class++ {
+		stats[fmt.Sprintf("responses_%dxx", class)] = atomic.LoadInt64(&h.statusClasses[class])
+	}
+	return stats
 }
 
-// Shutdown gracefully shuts down the proxy
+// SetDraining starts or stops draining the whole proxy. While draining,
+// requests are still served but each response closes its connection, so
+// clients reconnect to another instance once the orchestrator stops routing
+// here. Unlike Shutdown this is reversible.
+func (h *Handler) SetDraining(draining bool) {
+	h.draining.Store(draining)
+}
+
+// IsDraining reports whether the proxy is draining
+func (h *Handler) IsDraining() bool {
+	return h.draining.Load()
+}
+
+// SetStopping marks the proxy as about to shut down. Nothing changes for
+// requests; only readiness is affected.
+func (h *Handler) SetStopping(stopping bool) {
+	h.stopping.Store(stopping)
+}
+
+// Stopping reports whether the proxy is in its pre-stop delay
+func (h *Handler) Stopping() bool {
+	return h.stopping.Load()
+}
+
+// ShuttingDown reports whether Shutdown has begun
+func (h *Handler) ShuttingDown() bool {
+	return h.shuttingDown.Load()
+}
+
+// Shutdown gracefully shuts down the proxy: new requests are refused with
+// 503 while in-flight requests, including long-lived streams, are given until
+// ctx is done to complete
 func (h *Handler) Shutdown(ctx context.Context) error {
+	h.shuttingDown.Store(true)
+
+	inFlight := atomic.LoadInt64(&h.ActiveRequests)
+	if inFlight == 0 {
+		return nil
+	}
+	h.logger.Info("draining in-flight requests", "requests", inFlight)
+
 	// Wait for active requests to complete
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
@@ -215,9 +1124,12 @@ func (h *Handler) Shutdown(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
+			remaining := atomic.LoadInt64(&h.ActiveRequests)
+			h.logger.Warn("drain timed out", "drained", max(inFlight-remaining, 0), "requests", inFlight)
 			return ctx.Err()
 		case <-ticker.C:
 			if atomic.LoadInt64(&h.ActiveRequests) == 0 {
+				h.logger.Info("drained in-flight requests", "requests", inFlight)
 				return nil
 			}
 		}
@@ -0,0 +1,2671 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/circuit"
+	"github.com/hermes-proxy/hermes/internal/health"
+)
+
+// newFailingBackend returns a server that drops every connection and counts hits
+func newFailingBackend(t *testing.T, hits *atomic.Int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+}
+
+func newTestHandler(addrs ...string) *Handler {
+	backends := make([]*balancer.Backend, len(addrs))
+	for i, addr := range addrs {
+		backends[i] = balancer.NewBackend(addr, 1)
+	}
+	lb := balancer.NewRoundRobin(backends)
+	breakerPool := circuit.NewBreakerPool(100, 1, 30)
+	passiveMonitor := health.NewPassiveMonitor(lb, 100)
+	return NewHandler(lb, breakerPool, passiveMonitor, 1024)
+}
+
+func TestHandler_RetriesDistinctBackends(t *testing.T) {
+	var hits1, hits2, hits3 atomic.Int64
+
+	failing1 := newFailingBackend(t, &hits1)
+	defer failing1.Close()
+	failing2 := newFailingBackend(t, &hits2)
+	defer failing2.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits3.Add(1)
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	handler := newTestHandler(
+		strings.TrimPrefix(failing1.URL, "http://"),
+		strings.TrimPrefix(failing2.URL, "http://"),
+		strings.TrimPrefix(healthy.URL, "http://"),
+	)
+	handler.SetMaxRetries(5)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if body, _ := io.ReadAll(rec.Body); string(body) != "ok" {
+		t.Errorf("Expected body from healthy backend, got %q", body)
+	}
+	if hits1.Load() != 1 || hits2.Load() != 1 || hits3.Load() != 1 {
+		t.Errorf("Expected each backend tried exactly once, got %d/%d/%d", hits1.Load(), hits2.Load(), hits3.Load())
+	}
+}
+
+func TestHandler_StopsWhenBackendsExhausted(t *testing.T) {
+	var hits1, hits2 atomic.Int64
+
+	failing1 := newFailingBackend(t, &hits1)
+	defer failing1.Close()
+	failing2 := newFailingBackend(t, &hits2)
+	defer failing2.Close()
+
+	handler := newTestHandler(
+		strings.TrimPrefix(failing1.URL, "http://"),
+		strings.TrimPrefix(failing2.URL, "http://"),
+	)
+	handler.SetMaxRetries(5)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502, got %d", rec.Code)
+	}
+	if hits1.Load() != 1 || hits2.Load() != 1 {
+		t.Errorf("Expected each backend tried exactly once, got %d/%d", hits1.Load(), hits2.Load())
+	}
+}
+
+func TestHandler_WarmupEstablishesConnections(t *testing.T) {
+	var newConns int64
+	release := make(chan struct{})
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			// Hold warmup requests so each needs its own connection
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&newConns, 1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+
+	go func() {
+		for atomic.LoadInt64(&newConns) < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+	}()
+	handler.Warmup(handler.balancer.Backends()[0], 3, "/health", 2*time.Second)
+
+	if got := atomic.LoadInt64(&newConns); got != 3 {
+		t.Fatalf("Expected 3 warmed connections, got %d", got)
+	}
+
+	// Real traffic reuses the warmed pool instead of dialing
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if got := atomic.LoadInt64(&newConns); got != 3 {
+		t.Errorf("Expected proxied request to reuse a warm connection, got %d connections", got)
+	}
+}
+
+func TestHandler_StreamsEventStream(t *testing.T) {
+	done := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		// Keep the stream open until the client has seen the first event
+		<-done
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer backend.Close()
+	defer close(done)
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	proxyServer := httptest.NewServer(handler)
+	defer proxyServer.Close()
+
+	resp, err := proxyServer.Client().Get(proxyServer.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		got <- string(buf[:n])
+	}()
+
+	select {
+	case event := <-got:
+		if event != "data: first\n\n" {
+			t.Errorf("Unexpected first event %q", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("First event was buffered instead of streamed")
+	}
+}
+
+func TestHandler_BoundsTricklingResponsesButNotStreams(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		// Send headers at once, then trickle the body past the deadline
+		for i := 0; i < 4; i++ {
+			fmt.Fprintf(w, "%d", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.clients.timeout = 150 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/download", nil))
+	if body := rec.Body.String(); body == "0123" {
+		t.Errorf("Expected the trickling body cut off at the deadline, got %q", body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+	if body := rec.Body.String(); body != "0123" {
+		t.Errorf("Expected the event stream exempt from the deadline, got %q", body)
+	}
+}
+
+func TestHandler_FailingBackendLosesLeastTime(t *testing.T) {
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+	erroring := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer erroring.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer working.Close()
+
+	backends := []*balancer.Backend{
+		balancer.NewBackend(strings.TrimPrefix(refused.URL, "http://"), 1),
+		balancer.NewBackend(strings.TrimPrefix(erroring.URL, "http://"), 1),
+		balancer.NewBackend(strings.TrimPrefix(working.URL, "http://"), 1),
+	}
+	lb := balancer.NewLeastTime(backends, false)
+	handler := NewHandler(lb, circuit.NewBreakerPool(1000, 1, 30), health.NewPassiveMonitor(lb, 1000), 1024)
+	handler.SetFailureStatuses([]health.StatusRange{{Min: 502, Max: 504}})
+	handler.SetMaxRetries(2)
+
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	for _, b := range backends[:2] {
+		if b.Latency() < time.Second {
+			t.Errorf("Expected failures from %s to count as slow, got %v", b.Address, b.Latency())
+		}
+	}
+	if b := lb.Next(); b != backends[2] {
+		t.Errorf("Expected the working backend selected, got %s", b.Address)
+	}
+}
+
+func TestHandler_CompressesEligibleResponses(t *testing.T) {
+	payload := strings.Repeat("hello hermes ", 200)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("tiny"))
+			return
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.Write([]byte(payload))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetCompressor(NewCompressor(1024, []string{"text/plain"}))
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"/", "gzip, deflate", true},
+		{"/", "gzip;q=0", false},
+		{"/", "", false},
+		{"/image", "gzip", false},
+		{"/small", "gzip", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+		if gotGzip != tt.wantGzip {
+			t.Errorf("%s with %q: expected gzip=%v, got %v", tt.path, tt.acceptEncoding, tt.wantGzip, gotGzip)
+			continue
+		}
+		if !gotGzip {
+			continue
+		}
+
+		if rec.Header().Get("Content-Length") != "" {
+			t.Error("Compressed response must not carry the upstream Content-Length")
+		}
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Invalid gzip body: %v", err)
+		}
+		body, _ := io.ReadAll(gz)
+		if string(body) != payload {
+			t.Error("Decompressed body does not match upstream payload")
+		}
+	}
+}
+
+func TestHandler_HeaderLimitsPerClient(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetHeaderLimiter(NewHeaderLimiter(1024, 4096, time.Minute))
+
+	send := func(remoteAddr string, headerSize int) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if headerSize > 0 {
+			req.Header.Set("X-Padding", strings.Repeat("a", headerSize))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Oversized headers from one client are rejected
+	if code := send("10.0.0.1:1234", 2048); code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("Expected 431 for oversized headers, got %d", code)
+	}
+
+	// Repeated large-but-legal headers exhaust that client's window budget
+	limited := false
+	for i := 0; i < 10; i++ {
+		if send("10.0.0.1:1234", 900) == http.StatusRequestHeaderFieldsTooLarge {
+			limited = true
+			break
+		}
+	}
+	if !limited {
+		t.Error("Expected client to be limited after exhausting its header budget")
+	}
+
+	// Other clients proceed normally
+	if code := send("10.0.0.2:1234", 900); code != http.StatusOK {
+		t.Errorf("Expected 200 for another client, got %d", code)
+	}
+}
+
+func TestHandler_RateLimitsPerClient(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetRateLimiter(NewRateLimiter(1, 2, 0, 0, 100))
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Burst of 2 is allowed, the third is limited
+	send("10.0.0.1:1000")
+	send("10.0.0.1:1000")
+	rec := send("10.0.0.1:1000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After of 1s, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	// Another client has its own bucket
+	if rec := send("10.0.0.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for another client, got %d", rec.Code)
+	}
+
+	stats := handler.GetStats()
+	if stats["total_requests"] != 3 || stats["rate_limited"] != 1 || hits.Load() != 3 {
+		t.Errorf("Limited request should not count against backend stats: %v, hits=%d", stats, hits.Load())
+	}
+}
+
+func TestRateLimiter_GlobalLimit(t *testing.T) {
+	limiter := NewRateLimiter(0, 0, 1, 2, 100)
+
+	limiter.Allow("10.0.0.1")
+	limiter.Allow("10.0.0.2")
+	if ok, _ := limiter.Allow("10.0.0.3"); ok {
+		t.Error("Expected global limit to apply across clients")
+	}
+}
+
+func TestRateLimiter_BoundsClientMap(t *testing.T) {
+	limiter := NewRateLimiter(1, 1, 0, 0, 10)
+
+	for i := 0; i < 1000; i++ {
+		limiter.Allow(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+
+	if len(limiter.clients) > 10 {
+		t.Errorf("Expected at most 10 tracked clients, got %d", len(limiter.clients))
+	}
+}
+
+func TestHandler_ClassifiesOutcomes(t *testing.T) {
+	var failingHits atomic.Int64
+	failing := newFailingBackend(t, &failingHits)
+	defer failing.Close()
+
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ok.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	addr := func(s *httptest.Server) string { return strings.TrimPrefix(s.URL, "http://") }
+
+	tests := []struct {
+		name    string
+		handler func() *Handler
+		request func() (*http.Request, context.CancelFunc)
+		want    Outcome
+		status  int
+	}{
+		{
+			name:    "success",
+			handler: func() *Handler { return newTestHandler(addr(ok)) },
+			want:    OutcomeSuccess,
+			status:  http.StatusOK,
+		},
+		{
+			name: "success after retry",
+			handler: func() *Handler {
+				h := newTestHandler(addr(failing), addr(ok))
+				h.SetMaxRetries(1)
+				return h
+			},
+			want:   OutcomeSuccessAfterRetry,
+			status: http.StatusOK,
+		},
+		{
+			name: "circuit skipped",
+			handler: func() *Handler {
+				h := newTestHandler(addr(ok))
+				breaker := h.breakerPool.Get(addr(ok))
+				for i := 0; i < 100; i++ {
+					breaker.RecordFailure()
+				}
+				return h
+			},
+			want:   OutcomeCircuitSkipped,
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:    "timeout",
+			handler: func() *Handler { return newTestHandler(addr(slow)) },
+			request: func() (*http.Request, context.CancelFunc) {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				return httptest.NewRequest("GET", "/", nil).WithContext(ctx), cancel
+			},
+			want:   OutcomeTimeout,
+			status: http.StatusGatewayTimeout,
+		},
+		{
+			name: "backend timeout",
+			handler: func() *Handler {
+				h := newTestHandler(addr(slow))
+				for _, c := range []*http.Client{h.clients.auto, h.clients.http1, h.clients.h2c} {
+					c.Transport.(*http.Transport).ResponseHeaderTimeout = 50 * time.Millisecond
+				}
+				return h
+			},
+			want:   OutcomeTimeout,
+			status: http.StatusGatewayTimeout,
+		},
+		{
+			name: "no backend",
+			handler: func() *Handler {
+				h := newTestHandler(addr(ok))
+				h.balancer.MarkUnhealthy(addr(ok))
+				return h
+			},
+			want:   OutcomeNoBackend,
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:    "client closed",
+			handler: func() *Handler { return newTestHandler(addr(slow)) },
+			request: func() (*http.Request, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return httptest.NewRequest("GET", "/", nil).WithContext(ctx), cancel
+			},
+			want:   OutcomeClientClosed,
+			status: http.StatusBadGateway,
+		},
+		{
+			name:    "upstream error",
+			handler: func() *Handler { return newTestHandler(addr(failing)) },
+			want:    OutcomeUpstreamError,
+			status:  http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.handler()
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.request != nil {
+				var cancel context.CancelFunc
+				req, cancel = tt.request()
+				defer cancel()
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+
+			stats := handler.GetStats()
+			for o := Outcome(0); o < numOutcomes; o++ {
+				want := int64(0)
+				if o == tt.want {
+					want = 1
+				}
+				if got := stats["outcome_"+o.String()]; got != want {
+					t.Errorf("outcome_%s: expected %d, got %d", o, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_NegotiatesHTTP2WithTLSBackend(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "https://"))
+	handler.balancer.Backends()[0].Scheme = "https"
+
+	pool := x509.NewCertPool()
+	pool.AddCert(backend.Certificate())
+	handler.SetBackendTLSConfig(&tls.Config{RootCAs: pool})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2.0 via ALPN, got %q (status %d)", rec.Body.String(), rec.Code)
+	}
+
+	// Upgrade requests must stay on HTTP/1.1
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Body.String() != "HTTP/1.1" {
+		t.Errorf("Expected upgrade request over HTTP/1.1, got %q", rec.Body.String())
+	}
+}
+
+func TestHandler_UsesH2CWhenConfigured(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "HTTP/1.1" {
+		t.Errorf("Expected HTTP/1.1 by default on cleartext, got %q", rec.Body.String())
+	}
+
+	handler.balancer.Backends()[0].Protocol = balancer.ProtocolH2C
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2.0 via h2c, got %q", rec.Body.String())
+	}
+}
+
+func TestHandler_CustomErrorPages(t *testing.T) {
+	var hits atomic.Int64
+	failing := newFailingBackend(t, &hits)
+	defer failing.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(failing.URL, "http://"))
+
+	maintenance, err := NewErrorPage(http.StatusServiceUnavailable, "text/html",
+		"<h1>Maintenance</h1><p>{{.Status}} {{.RequestID}}</p>")
+	if err != nil {
+		t.Fatalf("NewErrorPage failed: %v", err)
+	}
+	gateway, _ := NewErrorPage(0, "application/json", `{"status":{{.Status}},"id":"{{.RequestID}}"}`)
+	handler.SetErrorPages(map[string]*ErrorPage{
+		ErrorPageNoBackend: maintenance,
+		"502":              gateway,
+	})
+
+	// Upstream failure uses the 502 page
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway || rec.Body.String() != `{"status":502,"id":"abc-123"}` {
+		t.Errorf("Unexpected 502 page: %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+
+	// No healthy backend uses the maintenance page with its own status
+	handler.balancer.MarkUnhealthy(strings.TrimPrefix(failing.URL, "http://"))
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "<script>")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable || !strings.HasPrefix(rec.Body.String(), "<h1>Maintenance</h1><p>503 ") {
+		t.Errorf("Unexpected maintenance page: %d %q", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "<script>") {
+		t.Error("Malformed client request ID must not be echoed")
+	}
+}
+
+func TestHandler_ClassifiesMalformedResponse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4096)
+			conn.Read(buf)
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nThis Header Has No Colon\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	handler := newTestHandler(ln.Addr().String())
+	handler.SetEjectOnMalformed(true)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502, got %d", rec.Code)
+	}
+	if got := handler.GetStats()["outcome_malformed_response"]; got != 1 {
+		t.Errorf("Expected malformed_response outcome, got %d", got)
+	}
+	if handler.balancer.Backends()[0].IsHealthy() {
+		t.Error("Backend sending malformed responses should be ejected")
+	}
+}
+
+func TestHandler_ClientIPFromTrustedHeader(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Real-IP")
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	resolver, err := NewClientIPResolver([]string{"CF-Connecting-IP", "X-Forwarded-For"}, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver failed: %v", err)
+	}
+	handler.SetClientIPResolver(resolver)
+
+	send := func(remoteAddr string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("CF-Connecting-IP", "203.0.113.7")
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return <-received
+	}
+
+	if ip := send("10.1.2.3:4000"); ip != "203.0.113.7" {
+		t.Errorf("Expected CF-Connecting-IP from trusted proxy, got %s", ip)
+	}
+	if ip := send("192.0.2.50:4000"); ip != "192.0.2.50" {
+		t.Errorf("Expected peer address for untrusted proxy, got %s", ip)
+	}
+}
+
+func TestClientIPResolver_ForwardedForSkipsTrustedHops(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"X-Forwarded-For"}, []string{"10.0.0.1", "10.0.0.2"})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:4000"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 203.0.113.7, 10.0.0.2")
+
+	if ip := resolver.ClientIP(req); ip != "203.0.113.7" {
+		t.Errorf("Expected first untrusted hop, got %s", ip)
+	}
+
+	if _, err := NewClientIPResolver(nil, []string{"not-a-cidr"}); err == nil {
+		t.Error("Expected error for invalid trusted proxy")
+	}
+}
+
+func TestHandler_ClientIPIgnoresSpoofedHeaders(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Real-IP")
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.50:4000"
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if ip := <-received; ip != "192.0.2.50" {
+		t.Errorf("Expected peer address without trusted proxies, got %s", ip)
+	}
+}
+
+func TestClientIPResolver_ForwardedForSpoofing(t *testing.T) {
+	resolver, err := NewClientIPResolver(nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		xff        string
+		want       string
+	}{
+		{"untrusted peer ignores headers", "192.0.2.50:4000", "1.1.1.1", "1.1.1.1", "192.0.2.50"},
+		{"prepended entry ignored", "10.0.0.1:4000", "", "1.1.1.1, 203.0.113.7", "203.0.113.7"},
+		{"trusted hops skipped", "10.0.0.1:4000", "", "1.1.1.1, 203.0.113.7, 10.0.0.2, 10.0.0.3", "203.0.113.7"},
+		{"all hops trusted", "10.0.0.1:4000", "", "10.0.0.5, 10.0.0.2", "10.0.0.5"},
+		{"malformed hop stops walk", "10.0.0.1:4000", "", "203.0.113.7, garbage, 10.0.0.2", "10.0.0.2"},
+		{"invalid X-Real-IP falls through", "10.0.0.1:4000", "not-an-ip", "203.0.113.7", "203.0.113.7"},
+		{"X-Real-IP from trusted peer", "10.0.0.1:4000", "198.51.100.9", "203.0.113.7", "198.51.100.9"},
+		{"no headers", "10.0.0.1:4000", "", "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if ip := resolver.ClientIP(req); ip != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, ip)
+			}
+		})
+	}
+}
+
+func TestClientIPResolver_ForwardedForAcrossHeaderLines(t *testing.T) {
+	resolver, err := NewClientIPResolver(nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver failed: %v", err)
+	}
+
+	// The client spoofs the first line; the trusted proxy adds its own
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:4000"
+	req.Header.Add("X-Forwarded-For", "1.1.1.1")
+	req.Header.Add("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+	if ip := resolver.ClientIP(req); ip != "203.0.113.7" {
+		t.Errorf("Expected 203.0.113.7 from the last line, got %s", ip)
+	}
+}
+
+func TestHandler_MaintenanceMode(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	allowlist, err := NewMaintenanceAllowlist([]string{"/status"}, []string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatalf("NewMaintenanceAllowlist failed: %v", err)
+	}
+	handler.SetMaintenanceAllowlist(allowlist)
+	page, _ := NewErrorPage(0, "text/plain", "maintenance {{.Status}}")
+	handler.SetErrorPages(map[string]*ErrorPage{ErrorPageMaintenance: page})
+
+	send := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("/", "198.51.100.1:4000"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 before maintenance, got %d", rec.Code)
+	}
+
+	handler.SetMaintenance(true)
+	rec := send("/", "198.51.100.1:4000")
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "maintenance 503" {
+		t.Errorf("Expected maintenance page, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := send("/status/ping", "198.51.100.1:4000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected allow-listed path to pass, got %d", rec.Code)
+	}
+	if rec := send("/", "192.0.2.9:4000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected allow-listed IP to pass, got %d", rec.Code)
+	}
+
+	handler.SetMaintenance(false)
+	if rec := send("/", "198.51.100.1:4000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 after maintenance, got %d", rec.Code)
+	}
+
+	if got := hits.Load(); got != 4 {
+		t.Errorf("Expected 4 requests to reach the backend, got %d", got)
+	}
+	if got := handler.GetStats()["maintenance_rejected"]; got != 1 {
+		t.Errorf("Expected 1 maintenance rejection, got %d", got)
+	}
+}
+
+func TestHandler_AccessControlAllowlistAndDenylist(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	access, err := NewAccessControl(
+		&AccessRule{Mode: AccessDenylist, CIDRs: []string{"203.0.113.0/24", "2001:db8:bad::/48"}},
+		[]AccessRule{
+			{PathPrefix: "/internal", Mode: AccessAllowlist, CIDRs: []string{"192.0.2.0/24", "2001:db8:1::/64"}},
+			{PathPrefix: "/internal/public", Mode: AccessDenylist},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewAccessControl failed: %v", err)
+	}
+	handler.SetAccessControl(access)
+
+	tests := []struct {
+		path, remoteAddr string
+		want             int
+	}{
+		{"/", "198.51.100.1:4000", http.StatusOK},
+		{"/", "203.0.113.7:4000", http.StatusForbidden},
+		{"/", "[2001:db8:bad::1]:4000", http.StatusForbidden},
+		{"/", "[2001:db8:1::1]:4000", http.StatusOK},
+		{"/internal/x", "192.0.2.5:4000", http.StatusOK},
+		{"/internal/x", "198.51.100.1:4000", http.StatusForbidden},
+		{"/internal/x", "[2001:db8:1::9]:4000", http.StatusOK},
+		{"/internal/x", "[2001:db8:2::9]:4000", http.StatusForbidden},
+		// The longest prefix wins, but the global denylist still applies
+		{"/internal/public/x", "198.51.100.1:4000", http.StatusOK},
+		{"/internal/public/x", "203.0.113.7:4000", http.StatusForbidden},
+	}
+	var denied int64
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s from %s: expected %d, got %d", tt.path, tt.remoteAddr, tt.want, rec.Code)
+		}
+		if tt.want == http.StatusForbidden {
+			denied++
+		}
+	}
+
+	if got := hits.Load(); got != int64(len(tests))-denied {
+		t.Errorf("Expected %d requests to reach the backend, got %d", int64(len(tests))-denied, got)
+	}
+	if got := handler.GetStats()["access_denied"]; got != denied {
+		t.Errorf("Expected %d access denials, got %d", denied, got)
+	}
+}
+
+func TestHandler_AccessControlUsesTrustedClientIP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	access, err := NewAccessControl(&AccessRule{Mode: AccessAllowlist, CIDRs: []string{"192.0.2.0/24"}}, nil)
+	if err != nil {
+		t.Fatalf("NewAccessControl failed: %v", err)
+	}
+	handler.SetAccessControl(access)
+
+	send := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Without trusted proxies the header is ignored, so it cannot be spoofed
+	if code := send("198.51.100.1:4000", "192.0.2.5"); code != http.StatusForbidden {
+		t.Errorf("Expected spoofed X-Forwarded-For to be ignored, got %d", code)
+	}
+
+	resolver, err := NewClientIPResolver([]string{"X-Forwarded-For"}, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver failed: %v", err)
+	}
+	handler.SetClientIPResolver(resolver)
+
+	if code := send("10.0.0.1:4000", "192.0.2.5"); code != http.StatusOK {
+		t.Errorf("Expected allowed client behind trusted proxy to pass, got %d", code)
+	}
+	if code := send("10.0.0.1:4000", "198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("Expected other client behind trusted proxy to be denied, got %d", code)
+	}
+	// Hops left of the first untrusted one are client-supplied and ignored
+	if code := send("10.0.0.1:4000", "192.0.2.5, 198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("Expected client-supplied hop to be ignored, got %d", code)
+	}
+	if code := send("198.51.100.1:4000", "192.0.2.5"); code != http.StatusForbidden {
+		t.Errorf("Expected header from untrusted peer to be ignored, got %d", code)
+	}
+}
+
+func TestNewAccessControl_RejectsInvalidRules(t *testing.T) {
+	bad := []AccessRule{
+		{Mode: "block", CIDRs: []string{"192.0.2.0/24"}},
+		{Mode: AccessAllowlist},
+		{Mode: AccessDenylist, CIDRs: []string{"not-a-cidr"}},
+	}
+	for _, rule := range bad {
+		if _, err := NewAccessControl(&rule, nil); err == nil {
+			t.Errorf("Expected error for %+v", rule)
+		}
+	}
+	if _, err := NewAccessControl(nil, []AccessRule{{PathPrefix: "internal", Mode: AccessDenylist}}); err == nil {
+		t.Error("Expected error for path_prefix without leading /")
+	}
+}
+
+func TestHandler_DrainingClosesConnections(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	get := func() *http.Response {
+		resp, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get(); resp.Close {
+		t.Error("Expected keep-alive before draining")
+	}
+
+	handler.SetDraining(true)
+	resp := get()
+	if resp.StatusCode != http.StatusOK || !resp.Close {
+		t.Errorf("Expected request served with Connection: close while draining, got %d close=%v", resp.StatusCode, resp.Close)
+	}
+
+	handler.SetDraining(false)
+	if resp := get(); resp.Close {
+		t.Error("Expected keep-alive after undrain")
+	}
+}
+
+func TestHandler_StatusClassesAndFailOn5xx(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.WriteHeader(code)
+	}))
+	defer backend.Close()
+
+	addr := strings.TrimPrefix(backend.URL, "http://")
+	handler := newTestHandler(addr)
+	handler.breakerPool = circuit.NewBreakerPool(2, 1, 30)
+
+	send := func(code int) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/%d", code), nil))
+		return rec.Code
+	}
+
+	for _, code := range []int{200, 204, 302, 404, 503, 503} {
+		send(code)
+	}
+
+	stats := handler.GetStats()
+	for class, want := range map[string]int64{"2xx": 2, "3xx": 1, "4xx": 1, "5xx": 2} {
+		if got := stats["responses_"+class]; got != want {
+			t.Errorf("Expected %d %s responses, got %d", want, class, got)
+		}
+	}
+	if state := handler.breakerPool.Get(addr).State(); state != circuit.StateClosed {
+		t.Errorf("Expected 5xx to leave the circuit CLOSED by default, got %s", state)
+	}
+
+	handler.SetFailureStatuses([]health.StatusRange{{Min: 500, Max: 599}})
+	if code := send(500); code != http.StatusInternalServerError {
+		t.Errorf("Expected the 500 relayed to the client, got %d", code)
+	}
+	send(500)
+	if state := handler.breakerPool.Get(addr).State(); state != circuit.StateOpen {
+		t.Errorf("Expected 5xx soft failures to open the circuit, got %s", state)
+	}
+}
+
+func TestHandler_FailureStatusFailsOver(t *testing.T) {
+	var brokenHits, healthyHits atomic.Int64
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		brokenHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits.Add(1)
+	}))
+	defer healthy.Close()
+
+	brokenAddr := strings.TrimPrefix(broken.URL, "http://")
+	handler := newTestHandler(brokenAddr, strings.TrimPrefix(healthy.URL, "http://"))
+	handler.breakerPool = circuit.NewBreakerPool(3, 1, 30)
+	handler.SetMaxRetries(1)
+	handler.SetFailureStatuses([]health.StatusRange{{Min: 502, Max: 504}})
+
+	for i := 0; i < 20; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	if got := brokenHits.Load(); got != 3 {
+		t.Errorf("Expected the 503 backend to be cut off after 3 failures, got %d hits", got)
+	}
+	if got := healthyHits.Load(); got != 17 {
+		t.Errorf("Expected remaining requests on the healthy backend, got %d", got)
+	}
+	if state := handler.breakerPool.Get(brokenAddr).State(); state != circuit.StateOpen {
+		t.Errorf("Expected circuit OPEN for the 503 backend, got %s", state)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 120 * time.Second, true},
+		{" 5 ", 5 * time.Second, true},
+		{"Sun, 01 Mar 2026 12:00:30 GMT", 30 * time.Second, true},
+		{"Sunday, 01-Mar-26 12:01:00 GMT", time.Minute, true},
+		{"Sun, 01 Mar 2026 11:59:00 GMT", 0, false}, // already past
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHandler_RetryAfterBacksOffBackend(t *testing.T) {
+	var overloadedHits, healthyHits atomic.Int64
+	overloaded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		overloadedHits.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer overloaded.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits.Add(1)
+	}))
+	defer healthy.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(overloaded.URL, "http://"), strings.TrimPrefix(healthy.URL, "http://"))
+	handler.SetRetryAfter(time.Minute)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	if got := overloadedHits.Load(); got != 1 {
+		t.Errorf("Expected the backend to be spared after its Retry-After, got %d hits", got)
+	}
+	if got := healthyHits.Load(); got != 9 {
+		t.Errorf("Expected the other requests on the healthy backend, got %d", got)
+	}
+
+	backend := handler.balancer.Backends()[0]
+	if !backend.IsHealthy() || !backend.IsBackingOff() {
+		t.Error("Expected the backend to stay healthy while backing off")
+	}
+	if until := backend.BackoffUntil(); until.After(start.Add(time.Minute + time.Second)) {
+		t.Errorf("Expected the backoff capped at 1m, lasts until %v", until.Sub(start))
+	}
+}
+
+func TestHandler_LeastTimeFavorsFasterBackend(t *testing.T) {
+	var fastHits, slowHits atomic.Int64
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits.Add(1)
+	}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits.Add(1)
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	lb, _ := balancer.New("least-time", []*balancer.Backend{
+		balancer.NewBackend(strings.TrimPrefix(fast.URL, "http://"), 1),
+		balancer.NewBackend(strings.TrimPrefix(slow.URL, "http://"), 1),
+	})
+	handler := NewHandler(lb, circuit.NewBreakerPool(100, 1, 30), health.NewPassiveMonitor(lb, 100), 1024)
+
+	for i := 0; i < 20; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	fastCount, slowCount := fastHits.Load(), slowHits.Load()
+	if fastCount <= slowCount*4 {
+		t.Errorf("Expected traffic biased to the fast backend, got fast=%d slow=%d", fastCount, slowCount)
+	}
+}
+
+func TestHandler_ServesStaleWhileRevalidating(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		if n > 1 {
+			// Hold the background refresh until the stale response is served
+			<-release
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		fmt.Fprintf(w, "version %d", n)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetResponseCache(NewResponseCache(10, 1024))
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/page", nil))
+		return rec
+	}
+
+	if body := get().Body.String(); body != "version 1" {
+		t.Fatalf("Expected initial response, got %q", body)
+	}
+
+	// The entry is stale but within its SWR window, so it is returned at once
+	// even though the refresh is still blocked on the backend
+	rec := get()
+	if rec.Body.String() != "version 1" || rec.Header().Get("X-Cache") != "STALE" {
+		t.Fatalf("Expected stale cached response, got %q (X-Cache %q)", rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if body := get().Body.String(); body == "version 2" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Background revalidation did not update the cache entry")
+}
+
+func TestHandler_ResponseCacheHonorsCacheControl(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		switch r.URL.Path {
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/session":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", fmt.Sprintf("session=%d", n))
+		case "/lang":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		fmt.Fprintf(w, "%s %d", r.Header.Get("Accept-Language"), n)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	cache := NewResponseCache(2, 1024)
+	handler.SetResponseCache(cache)
+
+	get := func(path, lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("no-store", func(t *testing.T) {
+		first, second := get("/nostore", "").Body.String(), get("/nostore", "").Body.String()
+		if first == second {
+			t.Errorf("no-store response was served from cache: %q", second)
+		}
+	})
+
+	t.Run("per-client responses", func(t *testing.T) {
+		for _, path := range []string{"/private", "/session"} {
+			first, second := get(path, "").Body.String(), get(path, "").Body.String()
+			if first == second {
+				t.Errorf("%s response was shared from cache: %q", path, second)
+			}
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		first := get("/fresh", "").Body.String()
+		rec := get("/fresh", "")
+		if rec.Body.String() != first || rec.Header().Get("Age") == "" {
+			t.Fatalf("Expected cached response with Age header, got %q (Age %q)", rec.Body.String(), rec.Header().Get("Age"))
+		}
+
+		// Age the entry past its max-age rather than sleeping
+		cache.mu.Lock()
+		for _, el := range cache.entries {
+			el.Value.(*cacheEntry).storedAt = time.Now().Add(-2 * time.Minute)
+		}
+		cache.mu.Unlock()
+
+		if body := get("/fresh", "").Body.String(); body == first {
+			t.Errorf("Expired entry was still served: %q", body)
+		}
+	})
+
+	t.Run("vary", func(t *testing.T) {
+		en, fr := get("/lang", "en").Body.String(), get("/lang", "fr").Body.String()
+		if en == fr {
+			t.Fatalf("Variants shared a cache entry: %q", fr)
+		}
+		if body := get("/lang", "en").Body.String(); body != en {
+			t.Errorf("Expected cached en variant %q, got %q", en, body)
+		}
+	})
+
+	t.Run("least recently used eviction", func(t *testing.T) {
+		a := get("/a", "").Body.String()
+		b := get("/b", "").Body.String()
+		get("/a", "") // /a is now more recently used than /b
+		get("/c", "") // evicts /b
+
+		if body := get("/a", "").Body.String(); body != a {
+			t.Errorf("Recently used entry was evicted: got %q, want %q", body, a)
+		}
+		if body := get("/b", "").Body.String(); body == b {
+			t.Error("Expected least recently used entry to be evicted")
+		}
+	})
+
+	t.Run("vary names dropped with the last variant", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			get(fmt.Sprintf("/page/%d", i), "")
+		}
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if len(cache.vary) != len(cache.entries) {
+			t.Errorf("Expected Vary names kept only for the %d stored keys, got %d", len(cache.entries), len(cache.vary))
+		}
+	})
+}
+
+func TestHandler_ResponseCacheSkipsTruncatedBodies(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Length", "100")
+		// The server closes the connection after the short body
+		w.Write([]byte("partial"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetResponseCache(NewResponseCache(10, 1024))
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/report", nil))
+		if rec.Header().Get("X-Cache") == "HIT" {
+			t.Fatalf("Truncated body was served from cache: %q", rec.Body.String())
+		}
+	}
+	if hits.Load() != 2 {
+		t.Errorf("Expected both requests to reach the backend, got %d", hits.Load())
+	}
+}
+
+func TestHandler_HostHeader(t *testing.T) {
+	type seen struct{ host, forwardedHost string }
+	got := make(chan seen, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- seen{r.Host, r.Header.Get("X-Forwarded-Host")}
+	}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+
+	tests := []struct {
+		name        string
+		preserve    bool
+		override    string
+		backendHost string
+		want        string
+	}{
+		{name: "default uses backend address", want: addr},
+		{name: "preserve_host", preserve: true, want: "shop.example"},
+		{name: "host_header", override: "internal.example", want: "internal.example"},
+		{name: "backend override wins", preserve: true, backendHost: "pinned.example", want: "pinned.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestHandler(addr)
+			handler.SetHostHeader(tt.preserve, tt.override)
+			handler.balancer.Backends()[0].HostHeader = tt.backendHost
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = "shop.example"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			s := <-got
+			if s.host != tt.want {
+				t.Errorf("Expected Host %q, got %q", tt.want, s.host)
+			}
+			if s.forwardedHost != "shop.example" {
+				t.Errorf("Expected X-Forwarded-Host to carry the client Host, got %q", s.forwardedHost)
+			}
+		})
+	}
+}
+
+func TestHandler_HeaderRules(t *testing.T) {
+	got := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		w.Header().Set("X-Debug", "trace")
+		w.Header().Set("X-Powered-By", "app")
+	}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+
+	handler := newTestHandler(addr)
+	handler.SetHeaderRules(
+		HeaderRules{
+			Remove: []string{"X-Internal", "X-Api-Key"},
+			Set:    map[string]string{"X-Api-Key": "static-key", "X-Backend": "{backend}"},
+			Add:    map[string]string{"X-Api-Key": "second"},
+		},
+		HeaderRules{
+			Remove: []string{"X-Debug", "X-Powered-By"},
+			Set:    map[string]string{"X-Powered-By": "hermes {method} {path}"},
+		},
+	)
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("X-Internal", "secret")
+	req.Header.Set("X-Api-Key", "from-client")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	upstream := <-got
+	if upstream.Get("X-Internal") != "" {
+		t.Error("Removed request header reached the backend")
+	}
+	// Remove runs before set and set before add, so the client's value is gone
+	if keys := upstream.Values("X-Api-Key"); !slices.Equal(keys, []string{"static-key", "second"}) {
+		t.Errorf("Expected X-Api-Key [static-key second], got %v", keys)
+	}
+	if upstream.Get("X-Backend") != addr {
+		t.Errorf("Expected X-Backend %q, got %q", addr, upstream.Get("X-Backend"))
+	}
+
+	if rec.Header().Get("X-Debug") != "" {
+		t.Error("Removed response header reached the client")
+	}
+	if got := rec.Header().Values("X-Powered-By"); !slices.Equal(got, []string{"hermes GET /items"}) {
+		t.Errorf("Expected X-Powered-By replaced, got %v", got)
+	}
+
+	if err := (HeaderRules{Set: map[string]string{"X-Bad": "{nope}"}}).Validate(); err == nil {
+		t.Error("Expected unknown placeholder to be rejected")
+	}
+}
+
+func TestHandler_CacheKeyHeaders(t *testing.T) {
+	// The backend varies on X-Region without advertising it in Vary
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "region %s", r.Header.Get("X-Region"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	cache := NewResponseCache(10, 1024)
+	cache.SetKeyRules([]CacheKeyRule{{PathPrefix: "/geo/", Headers: []string{"x-region"}}})
+	handler.SetResponseCache(cache)
+
+	get := func(path, region string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Region", region)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	get("/geo/page", "eu")
+	if body := get("/geo/page", "us").Body.String(); body != "region us" {
+		t.Errorf("Expected a distinct entry per X-Region, got %q", body)
+	}
+	if rec := get("/geo/page", "eu"); rec.Body.String() != "region eu" || rec.Header().Get("Age") == "" {
+		t.Errorf("Expected cached eu response, got %q (Age %q)", rec.Body.String(), rec.Header().Get("Age"))
+	}
+
+	// Paths outside the rule keep sharing one entry
+	get("/other", "eu")
+	if body := get("/other", "us").Body.String(); body != "region eu" {
+		t.Errorf("Expected shared entry outside the configured prefix, got %q", body)
+	}
+}
+
+func TestHandler_EjectsBackendOnErrorRate(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Nine in ten responses are server errors
+		if hits.Add(1)%10 != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+	// The pool is never fully ejected, so a healthy backend shares the load
+	spare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer spare.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"), strings.TrimPrefix(spare.URL, "http://"))
+	handler.SetOutlierDetector(health.NewOutlierDetector(handler.balancer, 0.5, 10, time.Minute, time.Minute))
+
+	// Round-robin sends every other request to the failing backend
+	for i := 0; i < 18; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	target := handler.balancer.Backends()[0]
+	if !target.IsAvailable() {
+		t.Fatal("Backend ejected before min_requests was reached")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if target.IsAvailable() {
+		t.Error("Expected backend ejected once the 5xx rate crossed the threshold")
+	}
+
+	counts := target.StatusCounts()
+	if counts[http.StatusInternalServerError] != 9 || counts[http.StatusOK] != 1 {
+		t.Errorf("Unexpected status counters: %v", counts)
+	}
+}
+
+// recordingConn captures socket options applied to it
+type recordingConn struct {
+	net.Conn
+	noDelay     *bool
+	readBuffer  int
+	writeBuffer int
+}
+
+func (c *recordingConn) SetNoDelay(noDelay bool) error {
+	c.noDelay = &noDelay
+	return nil
+}
+
+func (c *recordingConn) SetReadBuffer(bytes int) error {
+	c.readBuffer = bytes
+	return nil
+}
+
+func (c *recordingConn) SetWriteBuffer(bytes int) error {
+	c.writeBuffer = bytes
+	return nil
+}
+
+func TestSocketOptions_Apply(t *testing.T) {
+	noDelay := false
+	opts := SocketOptions{NoDelay: &noDelay, ReadBuffer: 4096, WriteBuffer: 8192}
+
+	conn := &recordingConn{}
+	if err := opts.apply(conn); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if conn.noDelay == nil || *conn.noDelay || conn.readBuffer != 4096 || conn.writeBuffer != 8192 {
+		t.Errorf("Options not applied: nodelay=%v read=%d write=%d", conn.noDelay, conn.readBuffer, conn.writeBuffer)
+	}
+
+	// Unset options leave the connection untouched
+	conn = &recordingConn{}
+	SocketOptions{}.apply(conn)
+	if conn.noDelay != nil || conn.readBuffer != 0 || conn.writeBuffer != 0 {
+		t.Error("Zero options should not modify the connection")
+	}
+}
+
+func TestHandler_ProxiesWithSocketOptions(t *testing.T) {
+	noDelay := true
+	opts := SocketOptions{NoDelay: &noDelay, ReadBuffer: 64 * 1024, WriteBuffer: 64 * 1024}
+
+	ln, err := opts.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	backend.Listener = ln
+	backend.Start()
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetSocketOptions(opts)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("Expected proxied response over tuned sockets, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSocketOptions_ReusePortSharesAddress(t *testing.T) {
+	if !ReusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+	opts := SocketOptions{ReusePort: true, KeepAlive: 10 * time.Second}
+
+	first, err := opts.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer first.Close()
+
+	second, err := opts.Listen(context.Background(), first.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected a second listener on %s with reuse_port, got %v", first.Addr(), err)
+	}
+	second.Close()
+
+	if _, err := (SocketOptions{}).Listen(context.Background(), first.Addr().String()); err == nil {
+		t.Error("Expected a listener without reuse_port to fail on a shared address")
+	}
+}
+
+func TestHandler_PoolOptionsCapConnsPerHost(t *testing.T) {
+	var newConns int64
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&newConns, 1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	opts := DefaultPoolOptions()
+	opts.MaxConnsPerHost = 2
+	handler.SetPoolOptions(opts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("Expected 200, got %d", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&newConns); got > 2 {
+		t.Errorf("Expected at most 2 upstream connections, got %d", got)
+	}
+}
+
+func TestHandler_RecordsLoadMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-Load", "3.5")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetLoadHeader("X-Backend-Load")
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+	}
+
+	b := handler.balancer.Backends()[0]
+	if got := b.ReportedLoad(); got != 3.5 {
+		t.Errorf("Expected reported load 3.5, got %v", got)
+	}
+	// Sequential requests reuse one pooled connection
+	if got := b.OpenConnections(); got != 1 {
+		t.Errorf("Expected 1 open upstream connection, got %d", got)
+	}
+
+	handler.clients.auto.CloseIdleConnections()
+	if got := b.OpenConnections(); got != 0 {
+		t.Errorf("Expected closed connections to be uncounted, got %d", got)
+	}
+}
+
+func TestHandler_RoutesByBodyField(t *testing.T) {
+	newPool := func(name string) (*httptest.Server, *balancer.Backend) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		return server, balancer.NewBackend(strings.TrimPrefix(server.URL, "http://"), 1)
+	}
+	acme, acmeBackend := newPool("acme")
+	defer acme.Close()
+	globex, globexBackend := newPool("globex")
+	defer globex.Close()
+
+	lb := balancer.NewRoundRobin([]*balancer.Backend{acmeBackend, globexBackend})
+	handler := NewHandler(lb, circuit.NewBreakerPool(100, 1, 30), health.NewPassiveMonitor(lb, 100), 1024)
+	router, err := NewBodyRouter("tenant.id", 1024, map[string]balancer.Balancer{
+		"acme":   balancer.NewRoundRobin([]*balancer.Backend{acmeBackend}),
+		"globex": balancer.NewRoundRobin([]*balancer.Backend{globexBackend}),
+	})
+	if err != nil {
+		t.Fatalf("NewBodyRouter failed: %v", err)
+	}
+	handler.SetBodyRouter(router)
+
+	send := func(body string) string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return rec.Body.String()
+	}
+
+	// Repeat so round-robin over the default pool could not produce the match
+	for i := 0; i < 3; i++ {
+		if got := send(`{"tenant": {"id": "globex"}, "n": 1}`); got != "globex" {
+			t.Errorf("Expected globex pool, got %q", got)
+		}
+		if got := send(`{"tenant": {"id": "acme"}}`); got != "acme" {
+			t.Errorf("Expected acme pool, got %q", got)
+		}
+	}
+}
+
+func TestHandler_ShedsCompressionUnderLoad(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("hermes ", 500)))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetCompressor(NewCompressor(100, []string{"text/plain"}))
+	shedder, err := NewLoadShedder(100, 0, []string{FeatureCompression})
+	if err != nil {
+		t.Fatalf("NewLoadShedder failed: %v", err)
+	}
+	handler.SetLoadShedder(shedder)
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(); rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("Expected compression under normal load")
+	}
+
+	shedder.Observe(500, 0)
+	rec := get()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected uncompressed 200 while shedding, got %d (encoding %q)",
+			rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+
+	// Slightly under the threshold is not enough to resume
+	shedder.Observe(90, 0)
+	if !shedder.Shed(FeatureCompression) {
+		t.Error("Expected shedding to continue until load falls well below the threshold")
+	}
+
+	shedder.Observe(10, 0)
+	if rec := get(); rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Error("Expected compression restored once load subsided")
+	}
+}
+
+func TestHandler_CompressesRequestBodiesForBackendsThatAcceptGzip(t *testing.T) {
+	var mu sync.Mutex
+	var encodings []string
+	advertise := "gzip"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Invalid gzip request body: %v", err)
+				return
+			}
+			body = gz
+		}
+		data, _ := io.ReadAll(body)
+
+		mu.Lock()
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		w.Header().Set("Accept-Encoding", advertise)
+		mu.Unlock()
+		w.Write(data)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetRequestCompressor(NewRequestCompressor(100, 0))
+
+	large := strings.Repeat("hermes ", 100)
+	post := func(body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		if rec.Code != http.StatusOK || rec.Body.String() != body {
+			t.Fatalf("Expected the body echoed intact, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+
+	post(large) // not yet known to accept gzip
+	post(large)
+	post("small")
+
+	mu.Lock()
+	advertise = "identity"
+	mu.Unlock()
+	post(large) // still compressed; this response withdraws support
+	post(large)
+
+	want := []string{"", "gzip", "", "gzip", ""}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(encodings, want) {
+		t.Errorf("Expected request encodings %q, got %q", want, encodings)
+	}
+}
+
+func TestRequestCompressor_SkipsEncodedAndIncompressibleBodies(t *testing.T) {
+	c := NewRequestCompressor(10, 0)
+	buffer := NewBuffer(1024)
+	bufferBody := func(req *http.Request) *BufferedBody {
+		body, err := buffer.BufferRequest(req)
+		if err != nil {
+			t.Fatalf("BufferRequest failed: %v", err)
+		}
+		t.Cleanup(func() { body.Close() })
+		return body
+	}
+
+	encoded := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", 100)))
+	encoded.Header.Set("Content-Encoding", "br")
+	if gz, err := c.Body(encoded, bufferBody(encoded)); err != nil || gz != nil {
+		t.Errorf("Expected an already encoded body to be sent as-is, got %v, %v", gz, err)
+	}
+
+	// Random-looking bytes grow under gzip
+	random := make([]byte, 200)
+	for i := range random {
+		random[i] = byte(i*7919 + i*i*31)
+	}
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(random))
+	if gz, err := c.Body(req, bufferBody(req)); err != nil || gz != nil {
+		t.Errorf("Expected a body that does not shrink to be sent as-is, got %v, %v", gz, err)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", 100)))
+	body := bufferBody(req)
+	first, err := c.Body(req, body)
+	if err != nil || first == nil || first.Len() >= body.Len() {
+		t.Fatalf("Expected a smaller compressed body, got %v, %v", first, err)
+	}
+	if again, _ := c.Body(req, body); again != first {
+		t.Error("Expected the compressed body to be reused across attempts")
+	}
+}
+
+// newH2CServer starts a test server accepting cleartext HTTP/2
+func newH2CServer(handler http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	return server
+}
+
+func TestHandler_ProxiesGRPCServerStreaming(t *testing.T) {
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("Expected HTTP/2 to backend, got %s", r.Proto)
+		}
+		request, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "%s-%d;", request, i)
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "done")
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetGRPC(true)
+	front := newH2CServer(handler)
+	defer front.Close()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	req, _ := http.NewRequest("POST", front.URL+"/pkg.Service/Watch", strings.NewReader("msg"))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "msg-0;msg-1;msg-2;" {
+		t.Errorf("Unexpected streamed body: %q", body)
+	}
+	if resp.Trailer.Get("Grpc-Status") != "0" || resp.Trailer.Get("Grpc-Message") != "done" {
+		t.Errorf("Trailers not propagated: %v", resp.Trailer)
+	}
+}
+
+func TestHandler_SaturatedBackendsOverflow(t *testing.T) {
+	release := make(chan struct{})
+	var hits1, hits2 atomic.Int64
+	newHolding := func(hits *atomic.Int64) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			<-release
+		}))
+	}
+	backend1, backend2 := newHolding(&hits1), newHolding(&hits2)
+	defer backend1.Close()
+	defer backend2.Close()
+	defer close(release)
+
+	handler := newTestHandler(
+		strings.TrimPrefix(backend1.URL, "http://"),
+		strings.TrimPrefix(backend2.URL, "http://"),
+	)
+	handler.SetMaxRetries(0)
+	for _, b := range handler.balancer.Backends() {
+		b.SetMaxInflight(1)
+	}
+
+	// Two held requests fill both backends: the second overflows to the
+	// backend with capacity even though no retries are allowed
+	for i := 0; i < 2; i++ {
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for hits1.Load() == 0 || hits2.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected one request per backend, got %d and %d", hits1.Load(), hits2.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 with all backends saturated, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After on saturation")
+	}
+	if got := handler.GetStats()["outcome_saturated"]; got != 1 {
+		t.Errorf("Expected 1 saturated outcome, got %d", got)
+	}
+	for _, b := range handler.balancer.Backends() {
+		if !b.IsHealthy() {
+			t.Errorf("Saturation counted against %s", b.Address)
+		}
+	}
+}
+
+func TestHandler_QueuesForSaturatedBackend(t *testing.T) {
+	release := make(chan struct{})
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			<-release
+		}
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	b := handler.balancer.Backends()[0]
+	b.SetMaxInflight(1)
+	b.SetQueueTimeout(5 * time.Second)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	for hits.Load() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The second request waits for the first to finish instead of failing
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		done <- rec.Code
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Errorf("Expected the queued request to succeed, got %d", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Queued request was not admitted after the slot was released")
+	}
+}
+
+func TestHandler_MaxInflightCapsMultiplexedRequests(t *testing.T) {
+	var current, peak int64
+	release := make(chan struct{})
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&current, 1)
+		defer atomic.AddInt64(&current, -1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	b := handler.balancer.Backends()[0]
+	b.Protocol = balancer.ProtocolH2C
+	b.SetMaxInflight(2)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 6)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			codes <- rec.Code
+		}()
+	}
+
+	// The surplus requests are refused while two are held open
+	refused := 0
+	for refused < 4 {
+		select {
+		case code := <-codes:
+			if code != http.StatusServiceUnavailable {
+				t.Fatalf("Expected 503 for saturated backend, got %d", code)
+			}
+			refused++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected 4 requests refused, got %d", refused)
+		}
+	}
+	close(release)
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Expected admitted requests to succeed, got %d", code)
+		}
+	}
+	if got := atomic.LoadInt64(&peak); got > 2 {
+		t.Errorf("Expected at most 2 concurrent requests over the shared connection, got %d", got)
+	}
+	if got := b.OpenConnections(); got != 1 {
+		t.Errorf("Expected requests multiplexed over 1 connection, got %d", got)
+	}
+	if got := handler.GetStats()["outcome_saturated"]; got != 4 {
+		t.Errorf("Expected 4 saturated outcomes, got %d", got)
+	}
+}
+
+// firstBackendBalancer always offers the first backend, so every request
+// fails there before retrying on the next one
+type firstBackendBalancer struct {
+	*balancer.RoundRobin
+}
+
+func (f firstBackendBalancer) Next() *balancer.Backend {
+	return f.Backends()[0]
+}
+
+func TestHandler_RetryJitterSpreadsConcurrentRetries(t *testing.T) {
+	var failed atomic.Int64
+	failing := newFailingBackend(t, &failed)
+	defer failing.Close()
+
+	var mu sync.Mutex
+	var arrivals []time.Duration
+	start := time.Now()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Since(start))
+		mu.Unlock()
+	}))
+	defer working.Close()
+
+	lb := firstBackendBalancer{balancer.NewRoundRobin([]*balancer.Backend{
+		balancer.NewBackend(strings.TrimPrefix(failing.URL, "http://"), 1),
+		balancer.NewBackend(strings.TrimPrefix(working.URL, "http://"), 1),
+	})}
+	handler := NewHandler(lb, circuit.NewBreakerPool(1000, 1, 30), health.NewPassiveMonitor(lb, 1000), 1024)
+	handler.SetMaxRetries(1)
+	backoff, _ := NewBackoff(200*time.Millisecond, time.Second, JitterFull)
+	handler.SetRetryBackoff(backoff)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	wg.Wait()
+
+	if len(arrivals) != 20 {
+		t.Fatalf("Expected 20 retries to reach the working backend, got %d", len(arrivals))
+	}
+	earliest, latest := arrivals[0], arrivals[0]
+	for _, a := range arrivals {
+		earliest = min(earliest, a)
+		latest = max(latest, a)
+	}
+	// Without jitter every retry would land together, 200ms after the failure
+	if spread := latest - earliest; spread < 80*time.Millisecond {
+		t.Errorf("Retries clustered within %v; expected them spread across the backoff window", spread)
+	}
+}
+
+func TestHandler_RetriesOnlyConnectionFailures(t *testing.T) {
+	// A closed listener refuses connections
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+
+	var failedHits, okHits atomic.Int64
+	failing := newFailingBackend(t, &failedHits)
+	defer failing.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		okHits.Add(1)
+		w.Write([]byte("ok"))
+	}))
+	defer working.Close()
+
+	newHandler := func(first string) *Handler {
+		lb := firstBackendBalancer{balancer.NewRoundRobin([]*balancer.Backend{
+			balancer.NewBackend(strings.TrimPrefix(first, "http://"), 1),
+			balancer.NewBackend(strings.TrimPrefix(working.URL, "http://"), 1),
+		})}
+		handler := NewHandler(lb, circuit.NewBreakerPool(1000, 1, 30), health.NewPassiveMonitor(lb, 1000), 1024)
+		handler.SetMaxRetries(1)
+		handler.SetRetryConnectFailuresOnly(true)
+		return handler
+	}
+
+	// Refused before connecting: the POST never reached a backend
+	rec := httptest.NewRecorder()
+	newHandler(refused.URL).ServeHTTP(rec, httptest.NewRequest("POST", "/orders", strings.NewReader("{}")))
+	if rec.Code != http.StatusOK || okHits.Load() != 1 {
+		t.Fatalf("Expected POST retried after connection refused, got %d with %d hits", rec.Code, okHits.Load())
+	}
+
+	// Dropped after the request was sent: retrying could duplicate the order
+	rec = httptest.NewRecorder()
+	newHandler(failing.URL).ServeHTTP(rec, httptest.NewRequest("POST", "/orders", strings.NewReader("{}")))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 without retry, got %d", rec.Code)
+	}
+	if failedHits.Load() != 1 || okHits.Load() != 1 {
+		t.Errorf("Expected no retry after the request was sent, got %d/%d hits", failedHits.Load(), okHits.Load())
+	}
+}
+
+func TestHandler_RetriesNonIdempotentMethodsOnlyWhenAllowed(t *testing.T) {
+	var failedHits, okHits atomic.Int64
+	failing := newFailingBackend(t, &failedHits)
+	defer failing.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		okHits.Add(1)
+	}))
+	defer working.Close()
+
+	newHandler := func() *Handler {
+		lb := firstBackendBalancer{balancer.NewRoundRobin([]*balancer.Backend{
+			balancer.NewBackend(strings.TrimPrefix(failing.URL, "http://"), 1),
+			balancer.NewBackend(strings.TrimPrefix(working.URL, "http://"), 1),
+		})}
+		handler := NewHandler(lb, circuit.NewBreakerPool(1000, 1, 30), health.NewPassiveMonitor(lb, 1000), 1024)
+		handler.SetMaxRetries(1)
+		return handler
+	}
+
+	tests := []struct {
+		method        string
+		key           string
+		nonIdempotent bool
+		wantRetry     bool
+	}{
+		{"GET", "", false, true},
+		{"PUT", "", false, true},
+		{"POST", "", false, false},
+		{"PATCH", "", false, false},
+		{"POST", "order-17", false, true},
+		{"POST", "", true, true},
+	}
+	for _, tt := range tests {
+		okHits.Store(0)
+		handler := newHandler()
+		handler.SetRetryNonIdempotent(tt.nonIdempotent)
+
+		req := httptest.NewRequest(tt.method, "/orders", strings.NewReader("{}"))
+		if tt.key != "" {
+			req.Header.Set("Idempotency-Key", tt.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if retried := okHits.Load() == 1; retried != tt.wantRetry {
+			t.Errorf("%s (key %q, non_idempotent %v): expected retried=%v, got %v with status %d",
+				tt.method, tt.key, tt.nonIdempotent, tt.wantRetry, retried, rec.Code)
+		}
+	}
+}
+
+func TestHandler_ShutdownDrainsAndRefusesNewRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+
+	inFlight := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(inFlight, httptest.NewRequest("GET", "/slow", nil))
+		close(served)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- handler.Shutdown(ctx) }()
+
+	// Wait for shutdown to begin, then new requests are refused
+	for !handler.shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 during shutdown, got %d", rec.Code)
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("Shutdown did not drain: %v", err)
+	}
+	<-served
+	if inFlight.Code != http.StatusOK || inFlight.Body.String() != "done" {
+		t.Errorf("In-flight request was cut off: %d %q", inFlight.Code, inFlight.Body.String())
+	}
+}
+
+func TestHandler_ChunkedRequestBodies(t *testing.T) {
+	type seen struct {
+		chunked       bool
+		contentLength int64
+		body          string
+	}
+	got := make(chan seen, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- seen{
+			chunked:       len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked",
+			contentLength: r.ContentLength,
+			body:          string(body),
+		}
+	}))
+	defer backend.Close()
+
+	newChunkedRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/upload", io.NopCloser(strings.NewReader("part1part2")))
+		req.ContentLength = -1
+		req.TransferEncoding = []string{"chunked"}
+		return req
+	}
+
+	for _, stream := range []bool{false, true} {
+		handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+		handler.SetStreamChunked(stream)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newChunkedRequest())
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stream=%v: expected 200, got %d", stream, rec.Code)
+		}
+
+		s := <-got
+		if s.body != "part1part2" {
+			t.Errorf("stream=%v: unexpected body %q", stream, s.body)
+		}
+		if stream && (!s.chunked || s.contentLength != -1) {
+			t.Errorf("Expected chunked encoding preserved, got chunked=%v length=%d", s.chunked, s.contentLength)
+		}
+		if !stream && (s.chunked || s.contentLength != 10) {
+			t.Errorf("Expected buffered body with Content-Length 10, got chunked=%v length=%d", s.chunked, s.contentLength)
+		}
+	}
+}
+
+func TestHandler_UnbufferedForwardsBodiesWithoutRetry(t *testing.T) {
+	type seen struct {
+		chunked       bool
+		contentLength int64
+		body          string
+	}
+	got := make(chan seen, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- seen{
+			chunked:       len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked",
+			contentLength: r.ContentLength,
+			body:          string(body),
+		}
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetBuffering(false)
+
+	// The upload arrives in pieces, with no Content-Length
+	body, upload := io.Pipe()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	upload.Write([]byte("part1"))
+	upload.Write([]byte("part2"))
+	upload.Close()
+
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if s := <-got; s.body != "part1part2" || !s.chunked || s.contentLength != -1 {
+		t.Errorf("Expected the chunked upload passed through, got chunked=%v length=%d body %q", s.chunked, s.contentLength, s.body)
+	}
+
+	// A sized body keeps its Content-Length and is not retried on failure
+	var failingHits atomic.Int64
+	failing := newFailingBackend(t, &failingHits)
+	defer failing.Close()
+	handler = newTestHandler(strings.TrimPrefix(failing.URL, "http://"), strings.TrimPrefix(backend.URL, "http://"))
+	handler.SetBuffering(false)
+	handler.SetMaxRetries(1)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/object", strings.NewReader("payload")))
+	if rec.Code != http.StatusBadGateway || failingHits.Load() != 1 || len(got) != 0 {
+		t.Errorf("Expected a single attempt without retry, got %d after %d failed attempts", rec.Code, failingHits.Load())
+	}
+}
+
+func TestHandler_SpillsLargeBodiesToDisk(t *testing.T) {
+	spillDir := t.TempDir()
+	t.Setenv("TMPDIR", spillDir)
+	spilled := func() int {
+		entries, err := os.ReadDir(spillDir)
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		return len(entries)
+	}
+
+	var failingHits atomic.Int64
+	failing := newFailingBackend(t, &failingHits)
+	defer failing.Close()
+
+	var received []byte
+	var contentLength int64
+	var filesDuringRequest int
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		contentLength = r.ContentLength
+		filesDuringRequest = spilled()
+	}))
+	defer ok.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(failing.URL, "http://"), strings.TrimPrefix(ok.URL, "http://"))
+	handler.buffer = NewBuffer(1 << 20)
+	handler.SetBodyDiskThreshold(1024)
+	handler.SetMaxRetries(1)
+
+	for _, size := range []int{64 * 1024, 100} {
+		payload := bytes.Repeat([]byte("x"), size)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/upload", bytes.NewReader(payload)))
+
+		if rec.Code != http.StatusOK || !bytes.Equal(received, payload) || contentLength != int64(size) {
+			t.Fatalf("%d byte body: expected it replayed intact on retry, got %d with %d bytes (Content-Length %d)",
+				size, rec.Code, len(received), contentLength)
+		}
+		wantFiles := 0
+		if size > 1024 {
+			wantFiles = 1
+		}
+		if filesDuringRequest != wantFiles {
+			t.Errorf("%d byte body: expected %d spill files while proxying, found %d", size, wantFiles, filesDuringRequest)
+		}
+		if n := spilled(); n != 0 {
+			t.Errorf("%d byte body: expected spill file removed after the request, found %d", size, n)
+		}
+	}
+	if failingHits.Load() != 2 {
+		t.Errorf("Expected each request to fail over once, got %d failed attempts", failingHits.Load())
+	}
+}
+
+func TestHandler_ClientDisconnectAbortsBuffering(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+
+	// The upload stalls after its first chunk
+	body, upload := io.Pipe()
+	defer upload.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/upload", body).WithContext(ctx)
+	req.ContentLength = 512
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	upload.Write([]byte("partial"))
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Buffering continued after the client disconnected")
+	}
+
+	stats := handler.GetStats()
+	if stats["outcome_client_closed"] != 1 || stats["outcome_upstream_error"] != 0 {
+		t.Errorf("Expected a client_closed outcome, got %v", stats)
+	}
+	if hits.Load() != 0 {
+		t.Error("Abandoned request reached the backend")
+	}
+	if !handler.balancer.Backends()[0].IsHealthy() {
+		t.Error("Client disconnect counted against the backend")
+	}
+}
+
+func TestHandler_FairQueueDoesNotStarveLightClient(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	proceed := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		order = append(order, r.Header.Get("X-Tenant"))
+		mu.Unlock()
+		<-proceed
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(strings.TrimPrefix(backend.URL, "http://"))
+	queue, err := NewFairQueue(1, 0, 0, "X-Tenant", nil)
+	if err != nil {
+		t.Fatalf("NewFairQueue failed: %v", err)
+	}
+	handler.SetFairQueue(queue)
+
+	var wg sync.WaitGroup
+	send := func(tenant string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Tenant", tenant)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	waitQueued := func(n int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for queue.Queued() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d queued requests, got %d", n, queue.Queued())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The heavy client takes the only slot and queues ten more requests
+	// before the light client sends its one
+	for i := 0; i < 11; i++ {
+		send("heavy")
+	}
+	waitQueued(10)
+	send("light")
+	waitQueued(11)
+
+	for i := 0; i < 12; i++ {
+		proceed <- struct{}{}
+	}
+	wg.Wait()
+
+	light := slices.Index(order, "light")
+	if light < 0 || light > 2 {
+		t.Errorf("Light client was served at position %d of %v; FIFO would have starved it", light, order)
+	}
+}
+
+func TestHandler_RequestTimeoutSharedAcrossRetries(t *testing.T) {
+	// The first backend fails slowly, the second never answers
+	slowFailing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer slowFailing.Close()
+
+	cancelled := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer hanging.Close()
+
+	handler := newTestHandler(
+		strings.TrimPrefix(slowFailing.URL, "http://"),
+		strings.TrimPrefix(hanging.URL, "http://"),
+	)
+	handler.SetMaxRetries(1)
+	handler.SetRequestTimeout(300 * time.Millisecond)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504, got %d", rec.Code)
+	}
+	// A per-attempt budget would let the retry run a full 300ms on its own
+	if elapsed > 400*time.Millisecond {
+		t.Errorf("Request took %v; the budget should be shared across attempts", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Upstream call was not cancelled when the budget elapsed")
+	}
+}
+
+func TestBackoff_JitterStrategies(t *testing.T) {
+	none, _ := NewBackoff(100*time.Millisecond, 300*time.Millisecond, JitterNone)
+	if got := []time.Duration{none.Delay(1), none.Delay(2), none.Delay(3)}; got[0] != 100*time.Millisecond ||
+		got[1] != 200*time.Millisecond || got[2] != 300*time.Millisecond {
+		t.Errorf("Unexpected exponential delays: %v", got)
+	}
+
+	equal, _ := NewBackoff(100*time.Millisecond, time.Second, JitterEqual)
+	for i := 0; i < 100; i++ {
+		if d := equal.Delay(1); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("Equal jitter delay %v outside [50ms, 100ms]", d)
+		}
+	}
+
+	if _, err := NewBackoff(0, 0, "sometimes"); err == nil {
+		t.Error("Expected error for unknown jitter strategy")
+	}
+}
+
+func TestHandler_HashHeaderPinsRequestsWithMaglev(t *testing.T) {
+	backends := make([]*balancer.Backend, 3)
+	for i := range backends {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Host)
+		}))
+		defer server.Close()
+		backends[i] = balancer.NewBackend(strings.TrimPrefix(server.URL, "http://"), 1)
+	}
+	lb := balancer.NewMaglev(backends)
+	handler := NewHandler(lb, circuit.NewBreakerPool(100, 1, 30), health.NewPassiveMonitor(lb, 100), 1024)
+	handler.SetHashHeader("X-User-ID")
+
+	serve := func(user string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-ID", user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		first := serve(user)
+		for j := 0; j < 3; j++ {
+			if got := serve(user); got != first {
+				t.Fatalf("Expected %s pinned to %s, got %s", user, first, got)
+			}
+		}
+		seen[first] = true
+	}
+	if len(seen) != len(backends) {
+		t.Errorf("Expected users spread over all %d backends, got %d", len(backends), len(seen))
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// headerUsage tracks header bytes sent by a single client in the current window
+type headerUsage struct {
+	bytes       int64
+	windowStart time.Time
+}
+
+// HeaderLimiter accounts request header bytes per client IP
+type HeaderLimiter struct {
+	maxRequestBytes int64
+	maxWindowBytes  int64
+	window          time.Duration
+
+	clients   map[string]*headerUsage
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewHeaderLimiter creates a per-client header limiter. maxRequestBytes caps
+// a single request's headers and maxWindowBytes caps the total a client may
+// send per window; a zero value disables that limit.
+func NewHeaderLimiter(maxRequestBytes, maxWindowBytes int64, window time.Duration) *HeaderLimiter {
+	return &HeaderLimiter{
+		maxRequestBytes: maxRequestBytes,
+		maxWindowBytes:  maxWindowBytes,
+		window:          window,
+		clients:         make(map[string]*headerUsage),
+		lastSweep:       time.Now(),
+	}
+}
+
+// Allow records the header size of r for clientIP and reports whether the
+// request is within limits
+func (l *HeaderLimiter) Allow(clientIP string, r *http.Request) bool {
+	size := headerSize(r)
+	if l.maxRequestBytes > 0 && size > l.maxRequestBytes {
+		return false
+	}
+	if l.maxWindowBytes <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	usage, exists := l.clients[clientIP]
+	if !exists || now.Sub(usage.windowStart) >= l.window {
+		usage = &headerUsage{windowStart: now}
+		l.clients[clientIP] = usage
+	}
+
+	if usage.bytes+size > l.maxWindowBytes {
+		return false
+	}
+	usage.bytes += size
+	return true
+}
+
+// sweep drops clients whose window has expired, at most once per window.
+// Callers must hold l.mu.
+func (l *HeaderLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for ip, usage := range l.clients {
+		if now.Sub(usage.windowStart) >= l.window {
+			delete(l.clients, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// headerSize approximates the wire size of the request line and headers
+func headerSize(r *http.Request) int64 {
+	size := int64(len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4)
+	for key, values := range r.Header {
+		for _, value := range values {
+			size += int64(len(key) + len(value) + 4) // ": " and CRLF
+		}
+	}
+	return size
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// headerVariables are the placeholders accepted in header rule values
+var headerVariables = []string{"{backend}", "{client_ip}", "{host}", "{method}", "{path}", "{scheme}"}
+
+// HeaderRules edits a set of headers. Removals apply first, then sets, then
+// adds, so a header can be removed and replaced by the same rules. Values may
+// reference the request with {backend}, {client_ip}, {host}, {method},
+// {path} and {scheme}.
+type HeaderRules struct {
+	Remove []string
+	Set    map[string]string
+	Add    map[string]string
+}
+
+// IsZero reports whether the rules change nothing
+func (hr HeaderRules) IsZero() bool {
+	return len(hr.Remove) == 0 && len(hr.Set) == 0 && len(hr.Add) == 0
+}
+
+// Validate checks that every placeholder in the rule values is known
+func (hr HeaderRules) Validate() error {
+	for _, values := range []map[string]string{hr.Set, hr.Add} {
+		for name, value := range values {
+			rest := value
+			for {
+				start := strings.IndexByte(rest, '{')
+				if start < 0 {
+					break
+				}
+				end := strings.IndexByte(rest[start:], '}')
+				if end < 0 {
+					return fmt.Errorf("header %s: unterminated placeholder in %q", name, value)
+				}
+				placeholder := rest[start : start+end+1]
+				if !slices.Contains(headerVariables, placeholder) {
+					return fmt.Errorf("header %s: unknown placeholder %s", name, placeholder)
+				}
+				rest = rest[start+end+1:]
+			}
+		}
+	}
+	return nil
+}
+
+// apply edits h, expanding placeholders from r and the chosen backend
+func (hr HeaderRules) apply(h http.Header, r *http.Request, backend, clientIP string) {
+	for _, name := range hr.Remove {
+		h.Del(name)
+	}
+
+	var vars *strings.Replacer
+	expand := func(value string) string {
+		if !strings.Contains(value, "{") {
+			return value
+		}
+		if vars == nil {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			vars = strings.NewReplacer(
+				"{backend}", backend,
+				"{client_ip}", clientIP,
+				"{host}", r.Host,
+				"{method}", r.Method,
+				"{path}", r.URL.Path,
+				"{scheme}", scheme,
+			)
+		}
+		return vars.Replace(value)
+	}
+
+	for name, value := range hr.Set {
+		h.Set(name, expand(value))
+	}
+	for name, value := range hr.Add {
+		h.Add(name, expand(value))
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrorPageMaintenance is the error page key used while maintenance mode is
+// on; it falls back to the 503 page when not configured
+const ErrorPageMaintenance = "maintenance"
+
+// MaintenanceAllowlist lets selected paths and client IPs through while
+// maintenance mode is on
+type MaintenanceAllowlist struct {
+	paths    []string
+	networks []*net.IPNet
+}
+
+// NewMaintenanceAllowlist creates an allow-list from path prefixes and
+// client CIDRs or bare IPs
+func NewMaintenanceAllowlist(paths, ips []string) (*MaintenanceAllowlist, error) {
+	networks, err := parseNetworks(ips)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed IP: %w", err)
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("allowed path %q must start with /", p)
+		}
+	}
+	return &MaintenanceAllowlist{paths: paths, networks: networks}, nil
+}
+
+// allows reports whether a request bypasses maintenance mode
+func (a *MaintenanceAllowlist) allows(r *http.Request, clientIP string) bool {
+	for _, p := range a.paths {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	return containsIP(a.networks, clientIP)
+}
+
+// SetMaintenance turns maintenance mode on or off. While on, requests not
+// matched by the allow-list get a 503 without reaching a backend.
+func (h *Handler) SetMaintenance(enabled bool) {
+	h.maintenance.Store(enabled)
+}
+
+// InMaintenance reports whether maintenance mode is on
+func (h *Handler) InMaintenance() bool {
+	return h.maintenance.Load()
+}
+
+// SetMaintenanceAllowlist sets the requests let through during maintenance;
+// nil blocks every request
+func (h *Handler) SetMaintenanceAllowlist(a *MaintenanceAllowlist) {
+	h.maintenanceAllow = a
+}
+
+// blockedByMaintenance reports whether maintenance mode rejects r
+func (h *Handler) blockedByMaintenance(r *http.Request) bool {
+	if !h.maintenance.Load() {
+		return false
+	}
+	return h.maintenanceAllow == nil || !h.maintenanceAllow.allows(r, h.clientIP(r))
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Outcome classifies how a proxied request finished
+type Outcome int
+
+const (
+	// OutcomeSuccess means the first backend tried returned a response
+	OutcomeSuccess Outcome = iota
+	// OutcomeSuccessAfterRetry means a response arrived after failed attempts
+	OutcomeSuccessAfterRetry
+	// OutcomeCircuitSkipped means the last candidate backend had an open circuit
+	OutcomeCircuitSkipped
+	// OutcomeTimeout means the final attempt timed out
+	OutcomeTimeout
+	// OutcomeNoBackend means no healthy backend was available
+	OutcomeNoBackend
+	// OutcomeClientClosed means the client went away before a response
+	OutcomeClientClosed
+	// OutcomeUpstreamError means the final attempt failed for another reason
+	OutcomeUpstreamError
+	// OutcomeMalformedResponse means the backend sent an unparseable response
+	OutcomeMalformedResponse
+	// OutcomeCacheHit means the response was served from the response cache
+	OutcomeCacheHit
+	// OutcomeSaturated means every candidate backend was at its in-flight limit
+	OutcomeSaturated
+	// OutcomeOverloaded means the proxy's concurrency limit was reached and
+	// the request could not be queued or waited too long for a slot
+	OutcomeOverloaded
+
+	numOutcomes
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSuccess:
+		return "success"
+	case OutcomeSuccessAfterRetry:
+		return "success_after_retry"
+	case OutcomeCircuitSkipped:
+		return "circuit_skipped"
+	case OutcomeTimeout:
+		return "timeout"
+	case OutcomeNoBackend:
+		return "no_backend"
+	case OutcomeClientClosed:
+		return "client_closed"
+	case OutcomeUpstreamError:
+		return "upstream_error"
+	case OutcomeMalformedResponse:
+		return "malformed_response"
+	case OutcomeCacheHit:
+		return "cache_hit"
+	case OutcomeSaturated:
+		return "saturated"
+	case OutcomeOverloaded:
+		return "overloaded"
+	default:
+		return "unknown"
+	}
+}
+
+// failureResponse returns the status code and message sent to the client
+// when a request ends with this outcome. Only a backend that answered badly
+// or not at all is a 502; the proxy declining to send the request is a 503.
+func (o Outcome) failureResponse() (int, string) {
+	switch o {
+	case OutcomeNoBackend:
+		return http.StatusServiceUnavailable, "Service Unavailable: no healthy backends"
+	case OutcomeCircuitSkipped:
+		return http.StatusServiceUnavailable, "Service Unavailable: circuit breaker open"
+	case OutcomeSaturated:
+		return http.StatusServiceUnavailable, "Service Unavailable: backends at capacity"
+	case OutcomeOverloaded:
+		return http.StatusServiceUnavailable, "Service Unavailable: proxy overloaded"
+	case OutcomeTimeout:
+		return http.StatusGatewayTimeout, "Gateway Timeout"
+	default:
+		return http.StatusBadGateway, "Bad Gateway"
+	}
+}
+
+var (
+	errNoBackend   = errors.New("no healthy backends available")
+	errCircuitOpen = errors.New("circuit breaker open")
+	errSaturated   = errors.New("all backends at their in-flight limit")
+)
+
+// notSentError marks an attempt that failed before a connection to the
+// backend was established, so the backend cannot have seen the request
+type notSentError struct {
+	err error
+}
+
+func (e *notSentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notSentError) Unwrap() error {
+	return e.err
+}
+
+// requestNotSent reports whether a failed attempt never reached the backend
+func requestNotSent(err error) bool {
+	var notSent *notSentError
+	return errors.As(err, &notSent) || errors.Is(err, errCircuitOpen)
+}
+
+// isIdempotent reports whether repeating r has the same effect as sending
+// it once: an idempotent method per RFC 9110, or a request carrying an
+// idempotency key, as net/http also treats it
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != "" || r.Header.Get("X-Idempotency-Key") != ""
+}
+
+// classifyFailure maps the last attempt's error to an outcome
+func classifyFailure(r *http.Request, err error) Outcome {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		return OutcomeClientClosed
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errNoBackend):
+		return OutcomeNoBackend
+	case errors.Is(err, errCircuitOpen):
+		return OutcomeCircuitSkipped
+	case errors.Is(err, errSaturated):
+		return OutcomeSaturated
+	case isMalformedResponse(err):
+		return OutcomeMalformedResponse
+	case errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return OutcomeTimeout
+	default:
+		return OutcomeUpstreamError
+	}
+}
+
+// malformedMarkers are fragments of the errors net/http returns when a
+// response cannot be parsed; the transport does not export typed errors
+var malformedMarkers = []string{
+	"malformed HTTP",
+	"malformed MIME header",
+	"invalid byte in chunk length",
+	"bad Content-Length",
+	"invalid Trailer",
+}
+
+// isMalformedResponse reports whether err stems from an unparseable response
+func isMalformedResponse(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, marker := range malformedMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder captures the status code written to a ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
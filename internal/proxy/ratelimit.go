@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket refilled continuously at a fixed rate
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take consumes one token if available, otherwise returns how long until
+// one will be
+func (b *tokenBucket) take(now time.Time, rate, burst float64) (bool, time.Duration) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// RateLimiter applies token-bucket limits per client IP and, optionally,
+// across all clients
+type RateLimiter struct {
+	rate       float64
+	burst      float64
+	maxClients int
+
+	globalRate  float64
+	globalBurst float64
+	global      *tokenBucket
+
+	clients map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+// NewRateLimiter creates a limiter allowing rate requests/second with the
+// given burst per client. A globalRate of 0 disables the global limit.
+// At most maxClients buckets are kept; idle buckets are evicted first.
+func NewRateLimiter(rate float64, burst int, globalRate float64, globalBurst int, maxClients int) *RateLimiter {
+	l := &RateLimiter{
+		rate:        rate,
+		burst:       float64(burst),
+		maxClients:  maxClients,
+		globalRate:  globalRate,
+		globalBurst: float64(globalBurst),
+		clients:     make(map[string]*tokenBucket),
+	}
+	if globalRate > 0 {
+		l.global = &tokenBucket{tokens: l.globalBurst, last: time.Now()}
+	}
+	return l
+}
+
+// Allow reports whether a request from clientIP may proceed and, if not,
+// how long the client should wait before retrying
+func (l *RateLimiter) Allow(clientIP string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if l.rate > 0 {
+		bucket, exists := l.clients[clientIP]
+		if !exists {
+			l.makeRoom(now)
+			bucket = &tokenBucket{tokens: l.burst, last: now}
+			l.clients[clientIP] = bucket
+		}
+		if ok, wait := bucket.take(now, l.rate, l.burst); !ok {
+			return false, wait
+		}
+	}
+
+	if l.global != nil {
+		if ok, wait := l.global.take(now, l.globalRate, l.globalBurst); !ok {
+			return false, wait
+		}
+	}
+
+	return true, 0
+}
+
+// makeRoom keeps the bucket map within maxClients by dropping buckets that
+// have refilled completely (and so carry no state), then arbitrary ones.
+// Callers must hold l.mu.
+func (l *RateLimiter) makeRoom(now time.Time) {
+	if l.maxClients <= 0 || len(l.clients) < l.maxClients {
+		return
+	}
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for ip, bucket := range l.clients {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.clients, ip)
+		}
+	}
+
+	for ip := range l.clients {
+		if len(l.clients) < l.maxClients {
+			break
+		}
+		delete(l.clients, ip)
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+// parseRetryAfter reads a Retry-After header in either delta-seconds or
+// HTTP-date form and returns the wait it asks for relative to now. A date
+// in the past, a negative delay or an unparseable value yields false.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0, false
+		}
+		// Clamp before converting so huge values cannot overflow
+		if seconds > math.MaxInt64/int64(time.Second) {
+			return math.MaxInt64, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	wait := date.Sub(now)
+	if wait <= 0 {
+		return 0, false
+	}
+	return wait, true
+}
+
+// SetRetryAfter makes a 503 carrying Retry-After put its backend into a
+// soft-unhealthy backoff for the requested time, capped at max. Balancers
+// then route elsewhere until it expires. Zero disables the behavior.
+func (h *Handler) SetRetryAfter(max time.Duration) {
+	h.retryAfterMax = max
+}
+
+// honorRetryAfter backs the backend off if its response asks for it
+func (h *Handler) honorRetryAfter(backend *balancer.Backend, resp *http.Response) {
+	if h.retryAfterMax <= 0 || resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	now := time.Now()
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		return
+	}
+	if wait > h.retryAfterMax {
+		wait = h.retryAfterMax
+	}
+	backend.BackOff(now.Add(wait))
+	h.logger.Info("backend asked to retry later, backing off", "backend", backend.Address, "duration", wait.Round(time.Second))
+}
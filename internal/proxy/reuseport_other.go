@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package proxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ReusePortSupported reports whether SO_REUSEPORT is available here
+const ReusePortSupported = false
+
+// setReusePort fails because this platform has no SO_REUSEPORT
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
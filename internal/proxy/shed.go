@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// Optional features that can be shed under load
+const (
+	FeatureCompression = "compression"
+	FeatureAccessLog   = "access_log"
+	FeatureTracing     = "tracing"
+)
+
+// sheddableFeatures lists the feature names accepted by NewLoadShedder
+var sheddableFeatures = map[string]bool{
+	FeatureCompression: true,
+	FeatureAccessLog:   true,
+	FeatureTracing:     true,
+}
+
+// resumeRatio is the fraction of a threshold load must fall below before
+// shed features are turned back on, so they do not flap at the boundary
+const resumeRatio = 0.8
+
+// LoadShedder turns off optional features while active requests or process
+// CPU usage exceed their thresholds, and restores them once load subsides
+type LoadShedder struct {
+	maxActive int64   // 0 disables the active request check
+	maxCPU    float64 // fraction of all cores, 0 disables the CPU check
+	features  map[string]bool
+	shedding  atomic.Bool
+}
+
+// NewLoadShedder creates a shedder for the named features
+func NewLoadShedder(maxActive int64, maxCPU float64, features []string) (*LoadShedder, error) {
+	set := make(map[string]bool, len(features))
+	for _, f := range features {
+		if !sheddableFeatures[f] {
+			return nil, fmt.Errorf("unknown sheddable feature: %s", f)
+		}
+		set[f] = true
+	}
+	return &LoadShedder{
+		maxActive: maxActive,
+		maxCPU:    maxCPU,
+		features:  set,
+	}, nil
+}
+
+// Shed reports whether feature is currently turned off
+func (l *LoadShedder) Shed(feature string) bool {
+	return l.features[feature] && l.shedding.Load()
+}
+
+// Observe updates the shedding state from a load sample
+func (l *LoadShedder) Observe(active int64, cpu float64) {
+	over := (l.maxActive > 0 && active > l.maxActive) || (l.maxCPU > 0 && cpu > l.maxCPU)
+	under := (l.maxActive <= 0 || float64(active) < float64(l.maxActive)*resumeRatio) &&
+		(l.maxCPU <= 0 || cpu < l.maxCPU*resumeRatio)
+
+	switch {
+	case over && !l.shedding.Load():
+		l.shedding.Store(true)
+		logging.Default().Warn("load high, shedding optional features", "active", active, "cpu_percent", math.Round(cpu*100))
+	case under && l.shedding.Load():
+		l.shedding.Store(false)
+		logging.Default().Info("load subsided, restoring optional features", "active", active, "cpu_percent", math.Round(cpu*100))
+	}
+}
+
+// Start samples load every interval until ctx is done
+func (l *LoadShedder) Start(ctx context.Context, interval time.Duration, active func() int64) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastCPU, _ := processCPUTime()
+		lastWall := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				var usage float64
+				if cpu, ok := processCPUTime(); ok {
+					wall := now.Sub(lastWall)
+					usage = float64(cpu-lastCPU) / (float64(wall) * float64(runtime.NumCPU()))
+					lastCPU, lastWall = cpu, now
+				}
+				l.Observe(active(), usage)
+			}
+		}
+	}()
+}
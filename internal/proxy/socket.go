@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/logging"
+)
+
+// SocketOptions tunes TCP connections accepted from clients and dialed to
+// backends. Nil or zero fields keep the Go and OS defaults (Go enables
+// TCP_NODELAY on every TCP connection).
+//
+// The options are applied to each connection once it is established rather
+// than through a Control function, because net enables TCP_NODELAY after
+// Control runs and would override a setting made there.
+type SocketOptions struct {
+	NoDelay     *bool
+	ReadBuffer  int // SO_RCVBUF in bytes
+	WriteBuffer int // SO_SNDBUF in bytes
+
+	// Listener-only options. KeepAlive is the TCP keep-alive period of
+	// accepted connections: 0 keeps Go's 15s default, negative disables it.
+	// ReusePort sets SO_REUSEPORT so several processes can bind one port.
+	KeepAlive time.Duration
+	ReusePort bool
+}
+
+// tcpTuner is the subset of *net.TCPConn used to apply socket options
+type tcpTuner interface {
+	SetNoDelay(noDelay bool) error
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// IsZero reports whether no per-connection option is set
+func (o SocketOptions) IsZero() bool {
+	return o.NoDelay == nil && o.ReadBuffer == 0 && o.WriteBuffer == 0
+}
+
+// apply sets the configured options on conn if it is a TCP connection
+func (o SocketOptions) apply(conn net.Conn) error {
+	tc, ok := conn.(tcpTuner)
+	if !ok {
+		return nil
+	}
+	if o.NoDelay != nil {
+		if err := tc.SetNoDelay(*o.NoDelay); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Listen opens a TCP listener whose accepted connections carry the options
+func (o SocketOptions) Listen(ctx context.Context, address string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: o.KeepAlive}
+	if o.ReusePort {
+		lc.Control = setReusePort
+	}
+	ln, err := lc.Listen(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return o.Wrap(ln), nil
+}
+
+// Wrap applies the per-connection options to connections accepted from an
+// existing listener, such as one inherited from another process
+func (o SocketOptions) Wrap(ln net.Listener) net.Listener {
+	if o.IsZero() {
+		return ln
+	}
+	return &tunedListener{Listener: ln, opts: o}
+}
+
+// dialContext returns a dial function for upstream transports that applies
+// the options to each new connection
+func (o SocketOptions) dialContext() func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.apply(conn); err != nil {
+			logging.Default().Warn("failed to tune upstream socket", "backend", address, "error", err)
+		}
+		return conn, nil
+	}
+}
+
+// tunedListener applies socket options to every accepted connection
+type tunedListener struct {
+	net.Listener
+	opts SocketOptions
+}
+
+// Accept waits for a connection and tunes it. A failure to set an option is
+// logged rather than returned, since http.Server stops serving on Accept errors.
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.opts.apply(conn); err != nil {
+		logging.Default().Warn("failed to tune client socket", "remote", conn.RemoteAddr().String(), "error", err)
+	}
+	return conn, nil
+}
+
+// Unwrap returns the underlying listener
+func (l *tunedListener) Unwrap() net.Listener {
+	return l.Listener
+}
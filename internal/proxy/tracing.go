@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// Reasons a backend is passed over while selecting one for an attempt
+const (
+	SkipSaturated   = "saturated"
+	SkipCircuitOpen = "circuit_open"
+)
+
+// Tracer instruments proxied requests. The tracing package provides an
+// OpenTelemetry implementation; the handler makes no tracing calls when no
+// Tracer is set.
+type Tracer interface {
+	// StartRequest begins a span for an incoming request, continuing any trace
+	// in its headers. The returned context carries the span; end is called
+	// once with the final status and outcome.
+	StartRequest(r *http.Request) (ctx context.Context, end func(status int, outcome Outcome))
+
+	// StartAttempt begins a child span for one backend attempt and injects its
+	// trace context into the upstream request headers. It does nothing when
+	// ctx does not come from StartRequest, e.g. while tracing is shed.
+	StartAttempt(ctx context.Context, backend string, header http.Header) (end func(status int, err error))
+
+	// StartSelection begins a child span covering the choice of backend for
+	// one attempt, including its circuit breaker check. skip records a backend
+	// passed over and why; end is called once with the chosen backend, or ""
+	// if none could be used. Like StartAttempt it does nothing for an
+	// untraced ctx.
+	StartSelection(ctx context.Context) (skip func(backend, reason string), end func(backend string))
+}
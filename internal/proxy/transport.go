@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+)
+
+// upstreamClients holds one HTTP client per upstream protocol mode, since
+// the protocol set is fixed per transport
+type upstreamClients struct {
+	auto  *http.Client // HTTP/1.1, or HTTP/2 when negotiated via ALPN
+	http1 *http.Client // HTTP/1.1 only, used for upgrades and http1 backends
+	h2c   *http.Client // cleartext HTTP/2 with prior knowledge
+
+	// dial opens upstream connections; each one is counted against the
+	// backend returned by lookup for the connections load metric
+	dial   func(ctx context.Context, network, address string) (net.Conn, error)
+	lookup func(address string) *balancer.Backend
+
+	// Bounds an exchange that is not a stream, body included
+	timeout time.Duration
+}
+
+// newUpstreamClients creates the clients, attributing dialed connections to
+// the backend lookup returns for the dial address
+func newUpstreamClients(lookup func(address string) *balancer.Backend) *upstreamClients {
+	var auto, http1, h2c http.Protocols
+	auto.SetHTTP1(true)
+	auto.SetHTTP2(true)
+	http1.SetHTTP1(true)
+	h2c.SetUnencryptedHTTP2(true)
+
+	u := &upstreamClients{
+		auto:    newUpstreamClient(&auto),
+		http1:   newUpstreamClient(&http1),
+		h2c:     newUpstreamClient(&h2c),
+		dial:    SocketOptions{}.dialContext(),
+		lookup:  lookup,
+		timeout: upstreamTimeout,
+	}
+	for _, c := range []*http.Client{u.auto, u.http1, u.h2c} {
+		c.Transport.(*http.Transport).DialContext = u.dialTracked
+	}
+	return u
+}
+
+// PoolOptions tunes upstream connection pooling. Zero MaxIdleConns and
+// MaxConnsPerHost mean no limit.
+type PoolOptions struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+// DefaultPoolOptions returns the pooling used when none is configured
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// upstreamTimeout bounds an exchange with a backend, from sending the
+// request to the end of a response body that is not a stream. It matches the
+// wait for response headers.
+const upstreamTimeout = 30 * time.Second
+
+func newUpstreamClient(protocols *http.Protocols) *http.Client {
+	defaults := DefaultPoolOptions()
+	return &http.Client{
+		// Bound the wait for response headers here; the whole exchange is
+		// bounded per request by upstreamTimeout, which streams are exempt from
+		Transport: &http.Transport{
+			Protocols:             protocols,
+			MaxIdleConnsPerHost:   defaults.MaxIdleConnsPerHost,
+			IdleConnTimeout:       defaults.IdleConnTimeout,
+			ResponseHeaderTimeout: upstreamTimeout,
+			DisableCompression:    true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // Don't follow redirects
+		},
+	}
+}
+
+// setTLSConfig applies a TLS configuration for HTTPS backends to every client
+func (u *upstreamClients) setTLSConfig(cfg *tls.Config) {
+	for _, c := range []*http.Client{u.auto, u.http1, u.h2c} {
+		c.Transport.(*http.Transport).TLSClientConfig = cfg.Clone()
+	}
+}
+
+// setPoolOptions applies connection pool limits to every client
+func (u *upstreamClients) setPoolOptions(opts PoolOptions) {
+	for _, c := range []*http.Client{u.auto, u.http1, u.h2c} {
+		t := c.Transport.(*http.Transport)
+		t.MaxIdleConns = opts.MaxIdleConns
+		t.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+		t.MaxConnsPerHost = opts.MaxConnsPerHost
+		t.IdleConnTimeout = opts.IdleConnTimeout
+	}
+}
+
+// setSocketOptions makes every client dial upstream connections with opts
+func (u *upstreamClients) setSocketOptions(opts SocketOptions) {
+	u.dial = opts.dialContext()
+}
+
+// dialTracked dials an upstream connection and counts it as open on its
+// backend until it is closed
+func (u *upstreamClients) dialTracked(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := u.dial(ctx, network, address)
+	if err != nil || u.lookup == nil {
+		return conn, err
+	}
+	backend := u.lookup(address)
+	if backend == nil {
+		return conn, nil
+	}
+	backend.ConnOpened()
+	return &trackedConn{Conn: conn, backend: backend}, nil
+}
+
+// trackedConn decrements its backend's open connection count once closed
+type trackedConn struct {
+	net.Conn
+	backend *balancer.Backend
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.backend.ConnClosed)
+	return c.Conn.Close()
+}
+
+// forBackend picks the client matching the backend's protocol. Upgrade
+// requests (e.g. WebSocket) always use HTTP/1.1, which HTTP/2 cannot carry.
+func (u *upstreamClients) forBackend(backend *balancer.Backend, r *http.Request) *http.Client {
+	if isUpgrade(r) {
+		return u.http1
+	}
+
+	switch backend.Protocol {
+	case balancer.ProtocolHTTP1:
+		return u.http1
+	case balancer.ProtocolH2C:
+		return u.h2c
+	default:
+		return u.auto
+	}
+}
+
+// isUpgrade reports whether the request asks to switch protocols
+func isUpgrade(r *http.Request) bool {
+	if r == nil || r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
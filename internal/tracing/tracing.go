@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hermes-proxy/hermes/internal/proxy"
+)
+
+// instrumentationName identifies Hermes spans to the tracing backend
+const instrumentationName = "github.com/hermes-proxy/hermes"
+
+// Config selects where and how spans are exported
+type Config struct {
+	Endpoint    string  // OTLP/HTTP host:port, e.g. "localhost:4318"
+	Insecure    bool    // plain HTTP instead of HTTPS to the collector
+	ServiceName string  // reported as service.name
+	SampleRatio float64 // fraction of new traces recorded, 0-1
+}
+
+// Tracer implements proxy.Tracer on top of OpenTelemetry, propagating W3C
+// traceparent headers to and from backends
+type Tracer struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New creates a tracer exporting spans over OTLP/HTTP
+func New(ctx context.Context, cfg Config) (*Tracer, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	return newTracer(provider), nil
+}
+
+func newTracer(provider *sdktrace.TracerProvider) *Tracer {
+	return &Tracer{
+		provider:   provider,
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Shutdown flushes buffered spans and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// attemptsKey stores the per-request attempt counter in the span context
+type attemptsKey struct{}
+
+// StartRequest begins the server span for a proxied request
+func (t *Tracer) StartRequest(r *http.Request) (context.Context, func(status int, outcome proxy.Outcome)) {
+	ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := t.tracer.Start(ctx, "proxy "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+
+	attempts := new(int64)
+	ctx = context.WithValue(ctx, attemptsKey{}, attempts)
+
+	return ctx, func(status int, outcome proxy.Outcome) {
+		n := atomic.LoadInt64(attempts)
+		retries := n - 1
+		if retries < 0 {
+			retries = 0
+		}
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(status),
+			attribute.String("hermes.outcome", outcome.String()),
+			attribute.Int64("hermes.attempts", n),
+			attribute.Int64("hermes.retries", retries),
+		)
+		if status >= 500 {
+			span.SetStatus(codes.Error, outcome.String())
+		}
+		span.End()
+	}
+}
+
+// StartAttempt begins a client span for one backend attempt
+func (t *Tracer) StartAttempt(ctx context.Context, backend string, header http.Header) func(status int, err error) {
+	attempts, ok := ctx.Value(attemptsKey{}).(*int64)
+	if !ok {
+		return func(int, error) {}
+	}
+	attempt := atomic.AddInt64(attempts, 1)
+
+	ctx, span := t.tracer.Start(ctx, "upstream "+backend,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("hermes.backend", backend),
+			attribute.Int64("hermes.attempt", attempt),
+		),
+	)
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+
+	return func(status int, err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		}
+		span.End()
+	}
+}
+
+// StartSelection begins an internal span for choosing a backend, with an
+// event for each backend skipped
+func (t *Tracer) StartSelection(ctx context.Context) (func(backend, reason string), func(backend string)) {
+	if _, ok := ctx.Value(attemptsKey{}).(*int64); !ok {
+		return func(string, string) {}, func(string) {}
+	}
+
+	_, span := t.tracer.Start(ctx, "select backend", trace.WithSpanKind(trace.SpanKindInternal))
+
+	skip := func(backend, reason string) {
+		span.AddEvent("backend skipped", trace.WithAttributes(
+			attribute.String("hermes.backend", backend),
+			attribute.String("hermes.skip_reason", reason),
+		))
+	}
+	end := func(backend string) {
+		if backend != "" {
+			span.SetAttributes(attribute.String("hermes.backend", backend))
+		} else {
+			span.SetStatus(codes.Error, "no usable backend")
+		}
+		span.End()
+	}
+	return skip, end
+}
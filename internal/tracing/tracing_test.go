@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hermes-proxy/hermes/internal/balancer"
+	"github.com/hermes-proxy/hermes/internal/circuit"
+	"github.com/hermes-proxy/hermes/internal/health"
+	"github.com/hermes-proxy/hermes/internal/proxy"
+)
+
+func TestTracer_SpansAndPropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := newTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer failing.Close()
+
+	received := make(chan string, 1)
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+	}))
+	defer working.Close()
+
+	lb := balancer.NewRoundRobin([]*balancer.Backend{
+		balancer.NewBackend(strings.TrimPrefix(failing.URL, "http://"), 1),
+		balancer.NewBackend(strings.TrimPrefix(working.URL, "http://"), 1),
+	})
+	handler := proxy.NewHandler(lb, circuit.NewBreakerPool(100, 1, 30), health.NewPassiveMonitor(lb, 100), 1024)
+	handler.SetMaxRetries(1)
+	handler.SetTracer(tracer)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if upstream := <-received; !strings.Contains(upstream, traceID) || strings.Contains(upstream, "00f067aa0ba902b7") {
+		t.Errorf("Backend should receive the trace with a new parent span, got %q", upstream)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 5 {
+		t.Fatalf("Expected a server span and a selection and attempt span per attempt, got %d", len(spans))
+	}
+
+	var server tracetest.SpanStub
+	attempts := 0
+	for _, s := range spans {
+		if s.SpanContext.TraceID().String() != traceID {
+			t.Errorf("Span %q not part of the incoming trace", s.Name)
+		}
+		switch s.SpanKind {
+		case trace.SpanKindServer:
+			server = s
+		case trace.SpanKindClient:
+			attempts++
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempt spans, got %d", attempts)
+	}
+
+	attrs := make(map[string]string)
+	for _, kv := range server.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["hermes.retries"] != "1" || attrs["hermes.outcome"] != "success_after_retry" {
+		t.Errorf("Unexpected server span attributes: %v", attrs)
+	}
+}
+
+func TestTracer_SelectionSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := newTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	const tripped = "127.0.0.1:1"
+	working := strings.TrimPrefix(backend.URL, "http://")
+	lb := balancer.NewRoundRobin([]*balancer.Backend{
+		balancer.NewBackend(tripped, 1),
+		balancer.NewBackend(working, 1),
+	})
+	breakers := circuit.NewBreakerPool(1, 1, 30)
+	breakers.Get(tripped).RecordFailure()
+
+	handler := proxy.NewHandler(lb, breakers, health.NewPassiveMonitor(lb, 100), 1024)
+	handler.SetMaxRetries(1)
+	handler.SetTracer(tracer)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var selections []tracetest.SpanStub
+	for _, s := range exporter.GetSpans() {
+		if s.Name == "select backend" {
+			selections = append(selections, s)
+		}
+	}
+	if len(selections) != 2 {
+		t.Fatalf("Expected a selection span per attempt, got %d", len(selections))
+	}
+
+	skipped := selections[0]
+	if len(skipped.Events) != 1 || skipped.Events[0].Name != "backend skipped" {
+		t.Fatalf("Expected a skipped-backend event, got %v", skipped.Events)
+	}
+	event := make(map[string]string)
+	for _, kv := range skipped.Events[0].Attributes {
+		event[string(kv.Key)] = kv.Value.Emit()
+	}
+	if event["hermes.backend"] != tripped || event["hermes.skip_reason"] != proxy.SkipCircuitOpen {
+		t.Errorf("Unexpected skip event attributes: %v", event)
+	}
+
+	chosen := ""
+	for _, kv := range selections[1].Attributes {
+		if kv.Key == "hermes.backend" {
+			chosen = kv.Value.AsString()
+		}
+	}
+	if chosen != working {
+		t.Errorf("Expected selection span to record chosen backend %s, got %q", working, chosen)
+	}
+}
@@ -0,0 +1,171 @@
+// Package hermes embeds the Hermes reverse proxy in another Go program. A
+// Proxy built from a Config is an http.Handler that can be mounted on any
+// mux, next to the program's own routes; the program keeps its listeners,
+// TLS and signal handling. The server section of the configuration, other
+// than admin authentication, does not apply.
+//
+//	config := hermes.DefaultConfig()
+//	config.Backends = []hermes.BackendConfig{{Address: "10.0.0.1:8080"}}
+//	p, err := hermes.NewProxy(config)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	p.Start(ctx)
+//	mux.Handle("/api/", p)
+//	mux.Handle("/hermes/", http.StripPrefix("/hermes", p.AdminHandler()))
+package hermes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hermes-proxy/hermes/internal/core"
+	"github.com/hermes-proxy/hermes/internal/proxy"
+)
+
+// Config is the proxy configuration, with the fields of a Hermes
+// configuration file
+type Config = core.Config
+
+// BackendConfig is one entry of Config.Backends
+type BackendConfig = core.BackendConfig
+
+// ReloadSummary reports what Apply changed and what needs a new Proxy
+type ReloadSummary = core.ReloadSummary
+
+// ErrUnknownBackend is returned for an address that is not a backend
+var ErrUnknownBackend = errors.New("unknown backend")
+
+// DefaultConfig returns the configuration used for settings a file leaves out
+func DefaultConfig() *Config {
+	return core.DefaultConfig()
+}
+
+// LoadConfig reads and validates a Hermes configuration file
+func LoadConfig(path string) (*Config, error) {
+	return core.LoadConfig(path)
+}
+
+// Backend is a snapshot of one backend's state
+type Backend struct {
+	Address     string
+	Weight      int
+	Healthy     bool
+	Draining    bool
+	Ejected     bool  // out of rotation after outlier detection
+	Connections int64 // requests in flight
+}
+
+// Controller manages a running proxy
+type Controller interface {
+	// Backends returns the current backends and their state
+	Backends() []Backend
+
+	// SetBackendDraining stops (or resumes) sending new requests to a
+	// backend, letting those in flight complete
+	SetBackendDraining(address string, draining bool) error
+
+	// SetMaintenance turns maintenance mode on or off
+	SetMaintenance(enabled bool)
+
+	// Stats returns the proxy's request counters
+	Stats() map[string]int64
+
+	// Apply switches to config's backends; other changed sections are
+	// reported as needing a new Proxy
+	Apply(config *Config) (*ReloadSummary, error)
+}
+
+// Proxy is a configured Hermes proxy: an http.Handler proxying each request
+// to a backend, and the Controller managing it
+type Proxy struct {
+	server  *core.Server
+	handler *proxy.Handler
+}
+
+var _ Controller = (*Proxy)(nil)
+
+// NewProxy builds a proxy from config. Call Start before serving requests
+// so health checks and backend discovery run.
+func NewProxy(config *Config) (*Proxy, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	server, err := core.NewServer(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Proxy{server: server, handler: server.ProxyHandler()}, nil
+}
+
+// ServeHTTP proxies r to a backend
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+// Start runs health checks, outlier detection, backend discovery and the
+// other background work until ctx is done
+func (p *Proxy) Start(ctx context.Context) {
+	p.server.Start(ctx)
+}
+
+// Shutdown refuses new requests with 503 and waits until those in flight
+// finish or ctx is done. Cancel the context passed to Start as well.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	return p.server.Stop(ctx)
+}
+
+// AdminHandler returns the admin API (/backends, /stats, /health, ...),
+// protected by server.admin_auth when configured
+func (p *Proxy) AdminHandler() http.Handler {
+	return p.server.AdminHandler()
+}
+
+// Backends returns the current backends and their state
+func (p *Proxy) Backends() []Backend {
+	backends := p.server.Balancer().Backends()
+	out := make([]Backend, len(backends))
+	for i, b := range backends {
+		out[i] = Backend{
+			Address:     b.Address,
+			Weight:      b.GetWeight(),
+			Healthy:     b.IsHealthy(),
+			Draining:    b.IsDraining(),
+			Ejected:     b.IsEjected(),
+			Connections: b.GetConnections(),
+		}
+	}
+	return out
+}
+
+// SetBackendDraining stops (or resumes) sending new requests to the backend
+// at address, letting those in flight complete
+func (p *Proxy) SetBackendDraining(address string, draining bool) error {
+	for _, b := range p.server.Balancer().Backends() {
+		if b.Address == address {
+			b.SetDraining(draining)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownBackend, address)
+}
+
+// SetMaintenance turns maintenance mode on or off. While on, requests not
+// allowed by the maintenance section get 503.
+func (p *Proxy) SetMaintenance(enabled bool) {
+	p.handler.SetMaintenance(enabled)
+}
+
+// Stats returns the proxy's request counters, as served by /stats
+func (p *Proxy) Stats() map[string]int64 {
+	return p.handler.GetStats()
+}
+
+// Apply switches to config's backends, keeping the health and connections
+// of those that remain and draining those removed. Other changed sections
+// are listed in the summary's RestartRequired and need a new Proxy.
+func (p *Proxy) Apply(config *Config) (*ReloadSummary, error) {
+	return p.server.Apply(config)
+}
@@ -0,0 +1,133 @@
+package hermes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newBackendServer(t *testing.T, name string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + " " + r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxy_MountedOnOwnMux(t *testing.T) {
+	one := newBackendServer(t, "one")
+	two := newBackendServer(t, "two")
+
+	config := DefaultConfig()
+	config.HealthCheck.Enabled = false
+	config.Backends = []BackendConfig{{Address: strings.TrimPrefix(one.URL, "http://")}}
+	p, err := NewProxy(config)
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Start(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", p)
+	mux.Handle("/hermes/", http.StripPrefix("/hermes", p.AdminHandler()))
+	mux.HandleFunc("/own", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("own")) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := get("/api/users"); got != "one /api/users" {
+		t.Errorf("Expected the request proxied, got %q", got)
+	}
+	if got := get("/own"); got != "own" {
+		t.Errorf("Expected the program's own route served, got %q", got)
+	}
+	var backends []map[string]any
+	if err := json.Unmarshal([]byte(get("/hermes/backends")), &backends); err != nil || len(backends) != 1 {
+		t.Errorf("Expected the admin API mounted, got %v (%v)", backends, err)
+	}
+
+	// Switch backends in code
+	config = DefaultConfig()
+	config.HealthCheck.Enabled = false
+	config.Backends = []BackendConfig{{Address: strings.TrimPrefix(two.URL, "http://")}}
+	summary, err := p.Apply(config)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(summary.BackendsAdded) != 1 || len(summary.BackendsRemoved) != 1 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if got := get("/api/users"); got != "two /api/users" {
+		t.Errorf("Expected the applied backend to serve, got %q", got)
+	}
+	if stats := p.Stats(); stats["total_requests"] != 2 {
+		t.Errorf("Expected 2 proxied requests counted, got %v", stats)
+	}
+}
+
+func TestProxy_Control(t *testing.T) {
+	backend := newBackendServer(t, "one")
+	address := strings.TrimPrefix(backend.URL, "http://")
+
+	config := DefaultConfig()
+	config.HealthCheck.Enabled = false
+	config.Backends = []BackendConfig{{Address: address, Weight: 3}}
+	p, err := NewProxy(config)
+	if err != nil {
+		t.Fatalf("NewProxy failed: %v", err)
+	}
+
+	if err := p.SetBackendDraining(address, true); err != nil {
+		t.Fatalf("SetBackendDraining failed: %v", err)
+	}
+	backends := p.Backends()
+	if len(backends) != 1 || backends[0].Address != address || backends[0].Weight != 3 || !backends[0].Draining {
+		t.Errorf("Unexpected backends %+v", backends)
+	}
+	if err := p.SetBackendDraining("10.0.0.9:80", true); !errors.Is(err, ErrUnknownBackend) {
+		t.Errorf("Expected ErrUnknownBackend, got %v", err)
+	}
+	p.SetBackendDraining(address, false)
+
+	p.SetMaintenance(true)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 in maintenance, got %d", rec.Code)
+	}
+	p.SetMaintenance(false)
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after Shutdown, got %d", rec.Code)
+	}
+}
+
+func TestNewProxy_RejectsInvalidConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Backends = []BackendConfig{{Address: "http://10.0.0.1:80"}}
+	if _, err := NewProxy(config); err == nil {
+		t.Error("Expected an invalid backend address to be refused")
+	}
+}
@@ -1,37 +1,90 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
-var (
+// mockBackend serves a greeting and a health endpoint, with optional
+// simulated failures for failover and circuit breaker testing
+type mockBackend struct {
+	port           int
+	failRate       float64        // fraction of requests answered with 500
+	latency        time.Duration  // delay before each response to /
+	unhealthyAfter int64          // /health turns 503 after this many requests, 0 = never
+	echo           bool           // answer / with a description of the request
+	headers        http.Header    // added to every response
+	statuses       map[string]int // status returned by / per request path
+
 	requestCount int64
-)
+	healthy      atomic.Bool
+
+	rng   *rand.Rand
+	rngMu sync.Mutex
+}
 
 func main() {
 	port := flag.Int("port", 9001, "Port to listen on")
+	failRate := flag.Float64("fail-rate", 0, "Fraction of requests (0-1) answered with 500")
+	latency := flag.Duration("latency", 0, "Artificial delay added to each response")
+	unhealthyAfter := flag.Int64("unhealthy-after", 0, "Report unhealthy on /health after N requests (0 = never)")
+	seed := flag.Int64("seed", 0, "Random seed for -fail-rate, for repeatable runs (0 = time-based)")
+	echo := flag.Bool("echo", false, "Answer / with the request method, path, headers and body as JSON")
+	var headerFlags, statusFlags listFlag
+	flag.Var(&headerFlags, "header", `Response header added to every response, as "Name: value" (repeatable)`)
+	flag.Var(&statusFlags, "status", `Status code for a path, as "/path=code" (repeatable)`)
 	flag.Parse()
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		count := atomic.AddInt64(&requestCount, 1)
-		response := fmt.Sprintf("Hello from backend on port %d! Request #%d\n", *port, count)
-		w.Write([]byte(response))
-	})
+	headers, err := parseHeaders(headerFlags)
+	if err != nil {
+		log.Fatalf("-header: %v", err)
+	}
+	statuses, err := parseStatuses(statusFlags)
+	if err != nil {
+		log.Fatalf("-status: %v", err)
+	}
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
-	})
+	if *failRate < 0 || *failRate > 1 {
+		log.Fatalf("-fail-rate must be between 0 and 1")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	backend := &mockBackend{
+		port:           *port,
+		failRate:       *failRate,
+		latency:        *latency,
+		unhealthyAfter: *unhealthyAfter,
+		echo:           *echo,
+		headers:        headers,
+		statuses:       statuses,
+		rng:            rand.New(rand.NewSource(*seed)),
+	}
+	backend.healthy.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", backend.serveRoot)
+	mux.HandleFunc("/echo", backend.serveEcho)
+	mux.HandleFunc("/stream", backend.serveStream)
+	mux.HandleFunc("/health", backend.serveHealth)
+	mux.HandleFunc("/admin/health", backend.serveAdminHealth)
 
 	addr := fmt.Sprintf(":%d", *port)
-	server := &http.Server{Addr: addr}
+	server := &http.Server{Addr: addr, Handler: backend.withHeaders(mux)}
 
 	go func() {
 		sigChan := make(chan os.Signal, 1)
@@ -41,8 +94,195 @@ func main() {
 		server.Close()
 	}()
 
-	log.Printf("Mock backend server listening on %s", addr)
+	log.Printf("Mock backend server listening on %s (fail-rate %.2f, latency %v, unhealthy-after %d)",
+		addr, *failRate, *latency, *unhealthyAfter)
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+func (m *mockBackend) serveRoot(w http.ResponseWriter, r *http.Request) {
+	count := atomic.AddInt64(&m.requestCount, 1)
+	if m.unhealthyAfter > 0 && count == m.unhealthyAfter {
+		log.Printf("Reached %d requests, reporting unhealthy", count)
+		m.healthy.Store(false)
+	}
+
+	if m.latency > 0 {
+		time.Sleep(m.latency)
+	}
+
+	if m.shouldFail() {
+		http.Error(w, fmt.Sprintf("Simulated failure on port %d (request #%d)", m.port, count), http.StatusInternalServerError)
+		return
+	}
+
+	status, ok := m.statuses[r.URL.Path]
+	if !ok {
+		status = http.StatusOK
+	}
+	if m.echo {
+		m.writeEcho(w, r, status)
+		return
+	}
+	w.WriteHeader(status)
+
+	response := fmt.Sprintf("Hello from backend on port %d! Request #%d\n", m.port, count)
+	w.Write([]byte(response))
+}
+
+// serveEcho describes the request as JSON, after an optional ?delay=250ms
+// and with an optional ?status=code
+func (m *mockBackend) serveEcho(w http.ResponseWriter, r *http.Request) {
+	if delay, err := time.ParseDuration(r.URL.Query().Get("delay")); err == nil {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	status, err := strconv.Atoi(r.URL.Query().Get("status"))
+	if err != nil || status < 100 || status > 599 {
+		status = http.StatusOK
+	}
+	m.writeEcho(w, r, status)
+}
+
+// writeEcho writes, with the given status, the request method, path, Host, headers and body as JSON
+func (m *mockBackend) writeEcho(w http.ResponseWriter, r *http.Request, status int) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"port":    m.port,
+		"method":  r.Method,
+		"path":    r.URL.RequestURI(),
+		"host":    r.Host,
+		"headers": r.Header,
+		"body":    string(body),
+	})
+}
+
+// serveStream writes ?chunks=N lines (default 5), flushing each one and
+// pausing ?interval=100ms between them, for testing streamed responses
+func (m *mockBackend) serveStream(w http.ResponseWriter, r *http.Request) {
+	chunks, err := strconv.Atoi(r.URL.Query().Get("chunks"))
+	if err != nil || chunks <= 0 {
+		chunks = 5
+	}
+	interval, err := time.ParseDuration(r.URL.Query().Get("interval"))
+	if err != nil {
+		interval = 100 * time.Millisecond
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for i := 1; i <= chunks; i++ {
+		if i > 1 {
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprintf(w, "chunk %d of %d from port %d\n", i, chunks, m.port)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// withHeaders adds the configured response headers before calling next
+func (m *mockBackend) withHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range m.headers {
+			w.Header()[name] = append(w.Header()[name], values...)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shouldFail draws whether the current request gets a simulated 500
+func (m *mockBackend) shouldFail() bool {
+	if m.failRate <= 0 {
+		return false
+	}
+	m.rngMu.Lock()
+	defer m.rngMu.Unlock()
+	return m.rng.Float64() < m.failRate
+}
+
+func (m *mockBackend) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !m.healthy.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"unhealthy"}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// serveAdminHealth reports the health state on GET and sets it on POST with
+// ?healthy=true or ?healthy=false
+func (m *mockBackend) serveAdminHealth(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		switch r.URL.Query().Get("healthy") {
+		case "true":
+			m.healthy.Store(true)
+		case "false":
+			m.healthy.Store(false)
+		default:
+			http.Error(w, "healthy must be true or false", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Health set to %v via admin endpoint", m.healthy.Load())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintf(w, `{"healthy":%t}`, m.healthy.Load())
+}
+
+// listFlag collects the values of a repeatable flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseHeaders parses "Name: value" pairs
+func parseHeaders(values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("expected \"Name: value\", got %q", v)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// parseStatuses parses "/path=code" pairs
+func parseStatuses(values []string) (map[string]int, error) {
+	statuses := make(map[string]int, len(values))
+	for _, v := range values {
+		path, code, ok := strings.Cut(v, "=")
+		status, err := strconv.Atoi(code)
+		if !ok || !strings.HasPrefix(path, "/") || err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("expected \"/path=code\", got %q", v)
+		}
+		statuses[path] = status
+	}
+	return statuses, nil
+}